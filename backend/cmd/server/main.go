@@ -80,7 +80,8 @@ func main() {
 	}
 
 	tierService := services.NewTierService(cfg.TierCacheTTL)
-	authService := services.NewAuthService(cfg, jwtManager)
+	auditService := services.NewAuditService(tierService)
+	authService := services.NewAuthService(cfg, jwtManager, auditService)
 	if cfg.GoogleRedirectURL != "" {
 		log.Printf("🔐 Google OAuth redirect_uri (must match Google Console exactly): %s", cfg.GoogleRedirectURL)
 	}
@@ -91,17 +92,19 @@ func main() {
 	} else {
 		log.Printf("⚠️  SMTP email not configured, falling back to log sender: %v", smtpErr)
 	}
-	orgService := services.NewOrgService(tierService, emailSender, cfg.FrontendURL, cfg.InviteTokenTTLHours)
-	projectService := services.NewProjectService(tierService)
-	envService := services.NewEnvironmentService()
-	auditService := services.NewAuditService()
-	adminService := services.NewAdminService()
+	orgService := services.NewOrgService(tierService, auditService, emailSender, cfg.FrontendURL, cfg.InviteTokenTTLHours)
+	policyService := services.NewPolicyService(auditService)
+	projectService := services.NewProjectService(tierService, auditService)
+	envService := services.NewEnvironmentService(auditService)
+	adminService := services.NewAdminService(auditService)
 
 	// Initialize encryption: primary (KMS or local) + always local for decrypting mixed storage
 	localEncryptor := services.NewLocalEncryptionService(cfg.JWTSecret)
 	var encryptor services.Encryptor
+	var kmsService *services.KMSService
 	if cfg.AWSKMSKeyID != "" {
-		kmsService, kmsErr := services.NewKMSService(cfg)
+		var kmsErr error
+		kmsService, kmsErr = services.NewKMSService(cfg)
 		if kmsErr == nil {
 			checkCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			kmsErr = kmsService.TestConnection(checkCtx)
@@ -113,9 +116,13 @@ func main() {
 			}
 			log.Printf("⚠️  Warning: Failed to initialize KMS service: %v", kmsErr)
 			log.Println("⚠️  Falling back to local encryption (dev only, not for production!)")
+			kmsService = nil
 			encryptor = localEncryptor
 		} else {
 			log.Println("✅ KMS service initialized successfully")
+			if cfg.KMSDataKeyCacheEnabled {
+				log.Printf("🔑 KMS data key cache enabled: ttl=%s, max_entries=%d, max_uses=%d", cfg.KMSDataKeyCacheTTL, cfg.KMSDataKeyCacheMaxEntries, cfg.KMSDataKeyCacheMaxUses)
+			}
 			encryptor = kmsService
 		}
 	} else {
@@ -133,7 +140,7 @@ func main() {
 			cfg.RazorpayPlanStarter,
 			cfg.RazorpayPlanTeam,
 		)
-		billingService = services.NewBillingService(razorpayProvider, cfg.FrontendURL)
+		billingService = services.NewBillingService(razorpayProvider, auditService, cfg.FrontendURL)
 		log.Println("💳 Razorpay billing enabled (checkout + webhooks active)")
 	} else {
 		log.Println("⚠️  RAZORPAY_KEY_ID / RAZORPAY_KEY_SECRET not set — billing returns 503 until configured")
@@ -145,14 +152,51 @@ func main() {
 	orgHandler := handlers.NewOrgHandler(orgService)
 	projectHandler := handlers.NewProjectHandler(projectService)
 	envHandler := handlers.NewEnvironmentHandler(envService, projectService, tierService)
-	secretService := services.NewSecretService(encryptor, localEncryptor, tierService, auditService, cfg.SecretDecryptConcurrency)
+	secretService := services.NewSecretService(encryptor, localEncryptor, tierService, auditService, policyService, cfg.SecretDecryptConcurrency)
 	secretHandler := handlers.NewSecretHandler(secretService)
-	agentService := services.NewAgentService(auditService, cfg.AgentUsageWriteInterval)
-	agentHandler := handlers.NewAgentHandler(agentService, secretService, auditService)
-	platformService := services.NewPlatformService(encryptor, localEncryptor, secretService)
+	agentService := services.NewAgentService(auditService, policyService, cfg.AgentUsageWriteInterval)
+	allowPrivateDatabaseHosts := cfg.IsDevelopment() || cfg.AllowPrivateDatabaseHosts
+	var databaseCredentials *services.DatabaseCredentialService
+	if cfg.AgentDatabaseCredentialsEnabled {
+		databaseCredentials = services.NewDatabaseCredentialService(secretService, auditService, allowPrivateDatabaseHosts)
+	}
+	policyHandler := handlers.NewPolicyHandler(policyService)
+	agentHandler := handlers.NewAgentHandler(agentService, secretService, databaseCredentials, auditService, policyService)
+	accessRequestService := services.NewAccessRequestService(agentService, emailSender, cfg.FrontendURL, auditService)
+	accessRequestHandler := handlers.NewAccessRequestHandler(accessRequestService)
+	anomalyService := services.NewAgentAnomalyService(agentService, auditService, databaseCredentials, emailSender, cfg.FrontendURL, encryptor, localEncryptor, cfg.IsDevelopment())
+	anomalyHandler := handlers.NewAgentAnomalyHandler(anomalyService)
+	federationHandler := handlers.NewFederationHandler(services.NewFederationService(auditService, cfg.IsDevelopment()))
+	platformService := services.NewPlatformService(encryptor, localEncryptor, secretService, auditService)
 	platformHandler := handlers.NewPlatformHandler(platformService)
-	auditHandler := handlers.NewAuditHandler(auditService)
-	adminHandler := handlers.NewAdminHandler(adminService)
+	rotationService := services.NewRotationService(secretService, auditService, allowPrivateDatabaseHosts)
+	rotationHandler := handlers.NewRotationHandler(rotationService)
+	auditChain, err := services.NewAuditChainService(cfg.AuditSigningKey, cfg.JWTSecret)
+	if err != nil {
+		log.Fatalf("❌ Failed to load audit signing key: %v", err)
+	}
+	if cfg.AuditSigningKey == "" {
+		log.Printf("⚠️  Warning: AUDIT_SIGNING_KEY is not set; audit checkpoints are signed with a key derived from JWT_SECRET, which production refuses")
+	}
+	auditHandler := handlers.NewAuditHandler(auditService, auditChain)
+	var auditArchive *services.AuditArchive
+	if cfg.AuditArchiveS3Bucket != "" {
+		auditArchive = &services.AuditArchive{
+			Config: services.AuditSinkConfig{
+				Endpoint:    cfg.AuditArchiveS3Endpoint,
+				Region:      cfg.AuditArchiveS3Region,
+				Bucket:      cfg.AuditArchiveS3Bucket,
+				Prefix:      cfg.AuditArchiveS3Prefix,
+				PathStyle:   cfg.AuditArchiveS3PathStyle,
+				AccessKeyID: cfg.AuditArchiveS3AccessKeyID,
+			},
+			SecretAccessKey: cfg.AuditArchiveS3SecretAccessKey,
+		}
+	}
+	auditRetention := services.NewAuditRetentionService(auditService, auditChain, auditArchive)
+	auditForwarding := services.NewAuditForwardingService(auditService, encryptor, localEncryptor, cfg.IsDevelopment())
+	auditSinkHandler := handlers.NewAuditSinkHandler(auditForwarding)
+	adminHandler := handlers.NewAdminHandler(adminService, kmsService)
 
 	// Set Gin mode
 	if cfg.IsProduction() {
@@ -266,12 +310,33 @@ func main() {
 			protected.GET("/orgs/:id/agents", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.List)
 			protected.POST("/orgs/:id/agents", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.Create)
 			protected.PATCH("/orgs/:id/agents/:agentId", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.Update)
+			protected.PUT("/orgs/:id/agents/:agentId/quota", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.SetQuota)
 			protected.GET("/orgs/:id/agents/:agentId/credentials", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.ListCredentials)
 			protected.POST("/orgs/:id/agents/:agentId/credentials", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.CreateCredential)
 			protected.DELETE("/orgs/:id/agents/:agentId/credentials/:credentialId", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.RevokeCredential)
+			protected.POST("/orgs/:id/agents/:agentId/credentials/:credentialId/rotate", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.RotateCredential)
+			protected.PUT("/orgs/:id/agents/:agentId/credentials/:credentialId/quota", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.SetCredentialQuota)
 			protected.GET("/orgs/:id/agents/:agentId/grants", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.ListGrants)
 			protected.POST("/orgs/:id/agents/:agentId/grants", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.CreateGrant)
 			protected.DELETE("/orgs/:id/agents/:agentId/grants/:grantId", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.RevokeGrant)
+			protected.GET("/orgs/:id/agents/:agentId/trust-policies", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), federationHandler.ListTrustPolicies)
+			protected.POST("/orgs/:id/agents/:agentId/trust-policies", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), federationHandler.CreateTrustPolicy)
+			protected.DELETE("/orgs/:id/agents/:agentId/trust-policies/:policyId", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), federationHandler.DeleteTrustPolicy)
+			protected.GET("/orgs/:id/agent-leases", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.ListLeases)
+			protected.DELETE("/orgs/:id/agent-leases/:leaseId", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.RevokeLease)
+			protected.GET("/orgs/:id/agent-usage", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), agentHandler.Usage)
+			protected.GET("/orgs/:id/access-policies", middleware.RequireOrgPermission("id", models.PermissionOrgManage), policyHandler.List)
+			protected.POST("/orgs/:id/access-policies", middleware.RequireOrgPermission("id", models.PermissionOrgManage), policyHandler.Create)
+			protected.POST("/orgs/:id/access-policies/evaluate", middleware.RequireOrgPermission("id", models.PermissionOrgManage), policyHandler.Evaluate)
+			protected.PUT("/orgs/:id/access-policies/:policyId", middleware.RequireOrgPermission("id", models.PermissionOrgManage), policyHandler.Update)
+			protected.DELETE("/orgs/:id/access-policies/:policyId", middleware.RequireOrgPermission("id", models.PermissionOrgManage), policyHandler.Delete)
+			protected.GET("/orgs/:id/agent-anomaly-rules", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), anomalyHandler.ListRules)
+			protected.POST("/orgs/:id/agent-anomaly-rules", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), anomalyHandler.CreateRule)
+			protected.DELETE("/orgs/:id/agent-anomaly-rules/:ruleId", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), anomalyHandler.DeleteRule)
+			protected.GET("/orgs/:id/agent-anomaly-alerts", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), anomalyHandler.ListAlerts)
+			protected.GET("/orgs/:id/agent-access-requests", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), accessRequestHandler.List)
+			protected.POST("/orgs/:id/agent-access-requests/:requestId/approve", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), accessRequestHandler.Approve)
+			protected.POST("/orgs/:id/agent-access-requests/:requestId/deny", middleware.RequireOrgPermission("id", models.PermissionAgentsManage), accessRequestHandler.Deny)
 
 			// Projects (use :id for org to match GET /orgs/:id)
 			protected.GET("/orgs/:id/projects", projectHandler.ListOrgProjects)
@@ -279,6 +344,9 @@ func main() {
 			protected.GET("/projects/:id", projectHandler.GetProject)
 			protected.PATCH("/projects/:id", middleware.RequireProjectPermission("id", models.PermissionProjectsManage), projectHandler.UpdateProject)
 			protected.DELETE("/projects/:id", middleware.RequireProjectPermission("id", models.PermissionProjectsManage), projectHandler.DeleteProject)
+			protected.GET("/projects/:id/schema", projectHandler.GetSecretSchema)
+			protected.PUT("/projects/:id/schema", middleware.RequireProjectPermission("id", models.PermissionProjectsManage), projectHandler.UpdateSecretSchema)
+			protected.GET("/projects/:id/schema/report", middleware.RequireProjectPermission("id", models.PermissionSecretsRead), secretHandler.ValidateProjectSecrets)
 
 			// Environments (use :id for project to match GET /projects/:id)
 			protected.GET("/projects/:id/environments", envHandler.ListProjectEnvironments)
@@ -294,6 +362,12 @@ func main() {
 			protected.DELETE("/secrets/:id", middleware.RequireSecretPermission("id", models.PermissionSecretsDelete), secretHandler.DeleteSecret)
 			protected.DELETE("/secrets/:id/purge", middleware.RequireSecretPermission("id", models.PermissionSecretsDelete), secretHandler.PurgeSecret)
 
+			// Automatic rotation (the admin connection is another secret in the same org)
+			protected.GET("/secrets/:id/rotation", middleware.RequireSecretPermission("id", models.PermissionSecretsRead), rotationHandler.GetRotation)
+			protected.PUT("/secrets/:id/rotation", middleware.RequireSecretPermission("id", models.PermissionSecretsUpdate), rotationHandler.ConfigureRotation)
+			protected.DELETE("/secrets/:id/rotation", middleware.RequireSecretPermission("id", models.PermissionSecretsUpdate), rotationHandler.DisableRotation)
+			protected.POST("/secrets/:id/rotate", middleware.RequireSecretPermission("id", models.PermissionSecretsUpdate), rotationHandler.RotateSecret)
+
 			// Secrets export for CLI
 			exportHandlers := []gin.HandlerFunc{middleware.RequireEnvironmentPermission("id", models.PermissionSecretsRead), secretHandler.ExportEnvironmentSecrets}
 			if secretExportRateLimiter != nil {
@@ -312,8 +386,20 @@ func main() {
 			protected.POST("/platforms", platformHandler.CreateConnection)
 			protected.DELETE("/platforms/:id", platformHandler.DeleteConnection)
 
+			// Secrets past or near their expiry / rotation deadline (metadata only)
+			protected.GET("/orgs/:id/secrets/stale", middleware.RequireOrgPermission("id", models.PermissionSecretsRead), secretHandler.StaleSecrets)
+
+			// Encryption health (trial-decrypts every secret; never returns values)
+			protected.GET("/orgs/:id/encryption/health", middleware.RequireOrgPermission("id", models.PermissionEncryptionView), secretHandler.EncryptionHealth)
+
 			// Audit logs
 			protected.GET("/orgs/:id/audit-logs", middleware.RequireOrgPermission("id", models.PermissionAuditView), auditHandler.ListOrgAuditLogs)
+			protected.GET("/orgs/:id/audit-logs/export", middleware.RequireOrgPermission("id", models.PermissionAuditView), auditHandler.ExportOrgAuditLogs)
+			protected.GET("/orgs/:id/audit-logs/verify", middleware.RequireOrgPermission("id", models.PermissionAuditView), auditHandler.VerifyOrgAuditChain)
+			protected.GET("/orgs/:id/audit-sinks", middleware.RequireOrgPermission("id", models.PermissionOrgManage), auditSinkHandler.List)
+			protected.POST("/orgs/:id/audit-sinks", middleware.RequireOrgPermission("id", models.PermissionOrgManage), auditSinkHandler.Create)
+			protected.PATCH("/orgs/:id/audit-sinks/:sinkId", middleware.RequireOrgPermission("id", models.PermissionOrgManage), auditSinkHandler.Update)
+			protected.DELETE("/orgs/:id/audit-sinks/:sinkId", middleware.RequireOrgPermission("id", models.PermissionOrgManage), auditSinkHandler.Delete)
 
 			// Billing (protected)
 			protected.GET("/billing/status", billingHandler.Status)
@@ -331,9 +417,18 @@ func main() {
 			{
 				admin.GET("/users", adminHandler.ListUsers)
 				admin.PATCH("/users/:id/tier", adminHandler.UpdateUserTier)
+				admin.GET("/encryption/cache", adminHandler.EncryptionCacheStats)
 			}
 		}
 
+		// Workload identity federation (public — the external OIDC token is the
+		// credential). Registered outside agentAPI, which requires an agent token.
+		exchangeHandlers := []gin.HandlerFunc{federationHandler.Exchange}
+		if authRateLimiter != nil {
+			exchangeHandlers = append([]gin.HandlerFunc{authRateLimiter.Middleware(middleware.ClientIPRateLimitKey)}, exchangeHandlers...)
+		}
+		v1.POST("/agent/federation/exchange", exchangeHandlers...)
+
 		// Agent-only API. This authentication domain is intentionally isolated
 		// from human JWT routes.
 		agentAPI := v1.Group("/agent")
@@ -343,7 +438,14 @@ func main() {
 		}
 		{
 			agentAPI.GET("/me", agentHandler.Me)
+			agentAPI.GET("/access", agentHandler.Access)
+			agentAPI.GET("/access/keys", agentHandler.AccessKeys)
 			agentAPI.POST("/secrets/resolve", agentHandler.ResolveSecrets)
+			agentAPI.PUT("/secrets", agentHandler.WriteSecret)
+			agentAPI.POST("/leases/:leaseId/renew", agentHandler.RenewLease)
+			agentAPI.DELETE("/leases/:leaseId", agentHandler.ReleaseLease)
+			agentAPI.POST("/access-requests", accessRequestHandler.Create)
+			agentAPI.GET("/access-requests/:requestId", accessRequestHandler.Get)
 		}
 
 		// Billing webhook (public — Razorpay sends without our JWT)
@@ -375,6 +477,36 @@ func main() {
 
 	shutdownSignal, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stopSignals()
+	if cfg.SecretRotationRemindersEnabled {
+		rotationNotifier := services.NewSecretRotationNotifier(emailSender, cfg.FrontendURL, cfg.SecretRotationReminderLead)
+		go rotationNotifier.Run(shutdownSignal, cfg.SecretRotationCheckInterval)
+	}
+	if cfg.SecretAutoRotationEnabled {
+		go rotationService.Run(shutdownSignal, cfg.SecretRotationCheckInterval)
+	}
+	if cfg.AgentCredentialRotationEnabled {
+		credentialRotator := services.NewAgentCredentialRotator(auditService, emailSender, cfg.FrontendURL, cfg.AgentCredentialExpiryNoticeLead)
+		go credentialRotator.Run(shutdownSignal, cfg.AgentCredentialRotationCheckInterval)
+	}
+	if cfg.AgentUsageRollupEnabled {
+		go services.NewAgentUsageAggregator().Run(shutdownSignal, cfg.AgentUsageRollupInterval)
+	}
+	if cfg.AgentAnomalyDetectionEnabled {
+		go anomalyService.Run(shutdownSignal, cfg.AgentAnomalyCheckInterval)
+	}
+	if cfg.AuditForwardingEnabled {
+		go auditForwarding.Run(shutdownSignal, cfg.AuditForwardingInterval)
+	}
+	if cfg.AuditCheckpointEnabled {
+		go auditChain.Run(shutdownSignal, cfg.AuditCheckpointInterval)
+	}
+	if cfg.AuditRetentionEnabled {
+		go auditRetention.Run(shutdownSignal, cfg.AuditRetentionInterval)
+	}
+	if databaseCredentials != nil {
+		go databaseCredentials.Run(shutdownSignal, cfg.AgentDatabaseLeaseReapInterval)
+	}
+
 	serverErrors := make(chan error, 1)
 	go func() {
 		serverErrors <- server.ListenAndServe()
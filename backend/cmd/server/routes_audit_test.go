@@ -0,0 +1,301 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// unauditedRoutes are mutating routes that deliberately write no audit
+// entry, with the reason. Anything else registered with POST, PUT, PATCH or
+// DELETE must reach an AuditService write.
+var unauditedRoutes = map[string]string{
+	"POST /api/v1/orgs/:id/access-policies/evaluate": "dry run; changes nothing",
+	"POST /api/v1/billing/checkout":                  "starts a provider checkout; the tier change is audited",
+	"POST /api/v1/billing/portal":                    "opens the provider's billing portal; changes nothing",
+	"POST /api/v1/billing/orders":                    "creates a provider order; the tier change is audited",
+	"POST /api/v1/billing/verify-payment":            "checks a payment signature; changes nothing",
+}
+
+// route is a mutating route registration in main.go.
+type route struct {
+	key     string
+	handler string // Type.Method
+}
+
+// mutatingRoutes reads the POST, PUT, PATCH and DELETE registrations in
+// main.go, resolving group prefixes, handler variables, and handler chains
+// built as slices.
+func mutatingRoutes(t *testing.T) []route {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefixes := map[string]string{}
+	handlerTypes := map[string]string{}
+	chains := map[string]ast.Expr{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != len(assign.Rhs) {
+			return true
+		}
+		for i, lhs := range assign.Lhs {
+			name, ok := lhs.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			switch rhs := assign.Rhs[i].(type) {
+			case *ast.CallExpr:
+				sel, ok := rhs.Fun.(*ast.SelectorExpr)
+				if !ok {
+					continue
+				}
+				switch {
+				case sel.Sel.Name == "Group" && len(rhs.Args) == 1:
+					parent := ""
+					if x, ok := sel.X.(*ast.Ident); ok {
+						parent = prefixes[x.Name]
+					}
+					prefixes[name.Name] = parent + stringLit(rhs.Args[0])
+				case isPackage(sel.X, "handlers") && strings.HasPrefix(sel.Sel.Name, "New"):
+					handlerTypes[name.Name] = strings.TrimPrefix(sel.Sel.Name, "New")
+				}
+			case *ast.CompositeLit:
+				if len(rhs.Elts) > 0 {
+					chains[name.Name] = rhs.Elts[len(rhs.Elts)-1]
+				}
+			}
+		}
+		return true
+	})
+
+	var routes []route
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) < 2 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		switch sel.Sel.Name {
+		case "POST", "PUT", "PATCH", "DELETE":
+		default:
+			return true
+		}
+		group, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		key := sel.Sel.Name + " " + prefixes[group.Name] + stringLit(call.Args[0])
+		last := call.Args[len(call.Args)-1]
+		if id, ok := last.(*ast.Ident); ok && chains[id.Name] != nil {
+			last = chains[id.Name]
+		}
+		handler := ""
+		if h, ok := last.(*ast.SelectorExpr); ok {
+			if x, ok := h.X.(*ast.Ident); ok && handlerTypes[x.Name] != "" {
+				handler = handlerTypes[x.Name] + "." + h.Sel.Name
+			}
+		}
+		routes = append(routes, route{key: key, handler: handler})
+		return true
+	})
+	return routes
+}
+
+func stringLit(e ast.Expr) string {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	s, _ := strconv.Unquote(lit.Value)
+	return s
+}
+
+func isPackage(e ast.Expr, name string) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == name
+}
+
+// auditGraph answers whether a function can reach an AuditService write.
+// It resolves calls through struct fields by their declared types, which
+// is enough for the handler -> service -> AuditService shape of this code.
+type auditGraph struct {
+	funcs  map[string]*ast.FuncDecl     // "pkg.Type.Method" or "pkg.func"
+	fields map[string]map[string]string // "pkg.Type" -> field -> "pkg.Type"
+	memo   map[string]bool
+}
+
+func parsePackageDir(t *testing.T, g *auditGraph, pkg, dir string) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				name := pkg + "." + d.Name.Name
+				if d.Recv != nil && len(d.Recv.List) == 1 {
+					name = pkg + "." + typeName(pkg, d.Recv.List[0].Type) + "." + d.Name.Name
+				}
+				g.funcs[name] = d
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					st, ok := ts.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					fields := map[string]string{}
+					for _, f := range st.Fields.List {
+						for _, n := range f.Names {
+							fields[n.Name] = typeName(pkg, f.Type)
+						}
+					}
+					g.fields[pkg+"."+ts.Name.Name] = fields
+				}
+			}
+		}
+	}
+}
+
+// typeName qualifies a possibly pointer, possibly package-qualified type.
+func typeName(pkg string, e ast.Expr) string {
+	if star, ok := e.(*ast.StarExpr); ok {
+		e = star.X
+	}
+	switch t := e.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok {
+			return x.Name + "." + t.Sel.Name
+		}
+	}
+	return ""
+}
+
+func qualify(pkg, typ string) string {
+	if typ == "" || strings.Contains(typ, ".") {
+		return typ
+	}
+	return pkg + "." + typ
+}
+
+// audits reports whether the function named fn writes an audit entry,
+// directly or through the functions it calls.
+func (g *auditGraph) audits(fn string, depth int) bool {
+	if done, ok := g.memo[fn]; ok {
+		return done
+	}
+	decl := g.funcs[fn]
+	if decl == nil || decl.Body == nil || depth > 8 {
+		return false
+	}
+	g.memo[fn] = false // cycles count as not auditing
+	parts := strings.Split(fn, ".")
+	pkg := parts[0]
+	recvType, recvName := "", ""
+	if len(parts) == 3 && decl.Recv != nil && len(decl.Recv.List[0].Names) == 1 {
+		recvType, recvName = pkg+"."+parts[1], decl.Recv.List[0].Names[0].Name
+	}
+
+	found := false
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		var target string
+		switch f := call.Fun.(type) {
+		case *ast.Ident:
+			target = pkg + "." + f.Name
+		case *ast.SelectorExpr:
+			switch x := f.X.(type) {
+			case *ast.Ident:
+				if x.Name == recvName {
+					target = recvType + "." + f.Sel.Name
+				}
+			case *ast.SelectorExpr:
+				// recv.field.Method(...)
+				if id, ok := x.X.(*ast.Ident); ok && id.Name == recvName {
+					target = qualify(pkg, g.fields[recvType][x.Sel.Name]) + "." + f.Sel.Name
+				}
+			}
+		}
+		switch target {
+		case "services.AuditService.Log", "services.AuditService.LogAgent", "services.AuditService.LogActor", "services.AuditService.LogUser":
+			found = true
+		case "":
+		default:
+			found = g.audits(target, depth+1)
+		}
+		return !found
+	})
+	g.memo[fn] = found
+	return found
+}
+
+// TestMutatingRoutesAreAudited fails when a POST, PUT, PATCH or DELETE
+// route is registered whose handler never reaches an audit write. Audit
+// the change, or add the route to unauditedRoutes with the reason.
+func TestMutatingRoutesAreAudited(t *testing.T) {
+	g := &auditGraph{funcs: map[string]*ast.FuncDecl{}, fields: map[string]map[string]string{}, memo: map[string]bool{}}
+	parsePackageDir(t, g, "handlers", "../../internal/handlers")
+	parsePackageDir(t, g, "services", "../../internal/services")
+
+	routes := mutatingRoutes(t)
+	if len(routes) < 50 {
+		t.Fatalf("found only %d mutating routes in main.go; the route parser is out of date", len(routes))
+	}
+	var missing []string
+	seen := map[string]bool{}
+	for _, r := range routes {
+		seen[r.key] = true
+		if _, ok := unauditedRoutes[r.key]; ok {
+			continue
+		}
+		if r.handler == "" {
+			missing = append(missing, r.key+" (handler not resolved)")
+			continue
+		}
+		if !g.audits("handlers."+r.handler, 0) {
+			missing = append(missing, r.key+" -> "+r.handler)
+		}
+	}
+	sort.Strings(missing)
+	for _, m := range missing {
+		t.Errorf("mutating route writes no audit entry: %s", m)
+	}
+	for key := range unauditedRoutes {
+		if !seen[key] {
+			t.Errorf("unauditedRoutes lists %s, which is not registered", key)
+		}
+	}
+}
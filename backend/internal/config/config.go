@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/url"
 	"os"
@@ -55,6 +56,12 @@ type Config struct {
 	AWSSecretAccessKey               string
 	AllowLocalEncryptionInProduction bool
 
+	// KMS data-key cache (plaintext data keys only; never secret values)
+	KMSDataKeyCacheEnabled    bool
+	KMSDataKeyCacheTTL        time.Duration
+	KMSDataKeyCacheMaxEntries int
+	KMSDataKeyCacheMaxUses    int
+
 	// Frontend
 	FrontendURL string
 
@@ -75,6 +82,57 @@ type Config struct {
 
 	InviteTokenTTLHours int
 
+	// Secret rotation reminders
+	SecretRotationRemindersEnabled bool
+	SecretRotationCheckInterval    time.Duration
+	SecretRotationReminderLead     time.Duration
+
+	// Automatic secret rotation (shares SecretRotationCheckInterval)
+	SecretAutoRotationEnabled bool
+
+	// Dynamic database credentials for agents
+	AgentDatabaseCredentialsEnabled bool
+	AgentDatabaseLeaseReapInterval  time.Duration
+
+	// Rotation and database credentials may reach private PostgreSQL hosts
+	AllowPrivateDatabaseHosts bool
+
+	// Agent credential rotation (overlap revocation and max-age policy)
+	AgentCredentialRotationEnabled       bool
+	AgentCredentialRotationCheckInterval time.Duration
+	AgentCredentialExpiryNoticeLead      time.Duration
+
+	// Hourly agent usage rollups built from secret_read audit events
+	AgentUsageRollupEnabled  bool
+	AgentUsageRollupInterval time.Duration
+
+	// Agent anomaly rules evaluated against new agent resolves
+	AgentAnomalyDetectionEnabled bool
+	AgentAnomalyCheckInterval    time.Duration
+
+	// Forwarding of new audit events to configured audit sinks
+	AuditForwardingEnabled  bool
+	AuditForwardingInterval time.Duration
+
+	// Signed checkpoints of each organization's hash-chained audit log.
+	// AuditSigningKey is a base64 Ed25519 seed. It is required in
+	// production; elsewhere an empty key is derived from JWTSecret.
+	AuditSigningKey         string
+	AuditCheckpointEnabled  bool
+	AuditCheckpointInterval time.Duration
+
+	// Deletion of audit entries older than the org owner's tier retention,
+	// copied first to an S3-compatible archive bucket when one is set
+	AuditRetentionEnabled         bool
+	AuditRetentionInterval        time.Duration
+	AuditArchiveS3Bucket          string
+	AuditArchiveS3Region          string
+	AuditArchiveS3Endpoint        string
+	AuditArchiveS3Prefix          string
+	AuditArchiveS3PathStyle       bool
+	AuditArchiveS3AccessKeyID     string
+	AuditArchiveS3SecretAccessKey string
+
 	// Rate Limiting
 	RateLimitEnabled               bool
 	AuthRateLimitPerMinute         int
@@ -131,6 +189,11 @@ func Load() (*Config, error) {
 		AWSSecretAccessKey:               getEnv("AWS_SECRET_ACCESS_KEY", ""),
 		AllowLocalEncryptionInProduction: getEnvBool("ALLOW_LOCAL_ENCRYPTION_IN_PRODUCTION", false),
 
+		KMSDataKeyCacheEnabled:    getEnvBool("KMS_DATA_KEY_CACHE_ENABLED", true),
+		KMSDataKeyCacheTTL:        getEnvDuration("KMS_DATA_KEY_CACHE_TTL", 5*time.Minute),
+		KMSDataKeyCacheMaxEntries: getEnvInt("KMS_DATA_KEY_CACHE_MAX_ENTRIES", 1000),
+		KMSDataKeyCacheMaxUses:    getEnvInt("KMS_DATA_KEY_CACHE_MAX_USES", 1000),
+
 		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:3000"),
 
 		RazorpayKeyID:         getEnv("RAZORPAY_KEY_ID", ""),
@@ -148,6 +211,42 @@ func Load() (*Config, error) {
 
 		InviteTokenTTLHours: getEnvInt("INVITE_TOKEN_TTL_HOURS", 168),
 
+		SecretRotationRemindersEnabled: getEnvBool("SECRET_ROTATION_REMINDERS_ENABLED", true),
+		SecretRotationCheckInterval:    getEnvDuration("SECRET_ROTATION_CHECK_INTERVAL", time.Hour),
+		SecretRotationReminderLead:     getEnvDuration("SECRET_ROTATION_REMINDER_LEAD", 7*24*time.Hour),
+		SecretAutoRotationEnabled:      getEnvBool("SECRET_AUTO_ROTATION_ENABLED", true),
+
+		AgentDatabaseCredentialsEnabled: getEnvBool("AGENT_DATABASE_CREDENTIALS_ENABLED", true),
+		AgentDatabaseLeaseReapInterval:  getEnvDuration("AGENT_DATABASE_LEASE_REAP_INTERVAL", time.Minute),
+		AllowPrivateDatabaseHosts:       getEnvBool("ALLOW_PRIVATE_DATABASE_HOSTS", false),
+
+		AgentCredentialRotationEnabled:       getEnvBool("AGENT_CREDENTIAL_ROTATION_ENABLED", true),
+		AgentCredentialRotationCheckInterval: getEnvDuration("AGENT_CREDENTIAL_ROTATION_CHECK_INTERVAL", 5*time.Minute),
+		AgentCredentialExpiryNoticeLead:      getEnvDuration("AGENT_CREDENTIAL_EXPIRY_NOTICE_LEAD", 3*24*time.Hour),
+
+		AgentUsageRollupEnabled:  getEnvBool("AGENT_USAGE_ROLLUP_ENABLED", true),
+		AgentUsageRollupInterval: getEnvDuration("AGENT_USAGE_ROLLUP_INTERVAL", 5*time.Minute),
+
+		AgentAnomalyDetectionEnabled: getEnvBool("AGENT_ANOMALY_DETECTION_ENABLED", true),
+		AgentAnomalyCheckInterval:    getEnvDuration("AGENT_ANOMALY_CHECK_INTERVAL", time.Minute),
+
+		AuditForwardingEnabled:  getEnvBool("AUDIT_FORWARDING_ENABLED", true),
+		AuditForwardingInterval: getEnvDuration("AUDIT_FORWARDING_INTERVAL", 30*time.Second),
+
+		AuditSigningKey:         getEnv("AUDIT_SIGNING_KEY", ""),
+		AuditCheckpointEnabled:  getEnvBool("AUDIT_CHECKPOINT_ENABLED", true),
+		AuditCheckpointInterval: getEnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+
+		AuditRetentionEnabled:         getEnvBool("AUDIT_RETENTION_ENABLED", true),
+		AuditRetentionInterval:        getEnvDuration("AUDIT_RETENTION_INTERVAL", time.Hour),
+		AuditArchiveS3Bucket:          getEnv("AUDIT_ARCHIVE_S3_BUCKET", ""),
+		AuditArchiveS3Region:          getEnv("AUDIT_ARCHIVE_S3_REGION", ""),
+		AuditArchiveS3Endpoint:        getEnv("AUDIT_ARCHIVE_S3_ENDPOINT", ""),
+		AuditArchiveS3Prefix:          getEnv("AUDIT_ARCHIVE_S3_PREFIX", ""),
+		AuditArchiveS3PathStyle:       getEnvBool("AUDIT_ARCHIVE_S3_PATH_STYLE", false),
+		AuditArchiveS3AccessKeyID:     getEnv("AUDIT_ARCHIVE_S3_ACCESS_KEY_ID", ""),
+		AuditArchiveS3SecretAccessKey: getEnv("AUDIT_ARCHIVE_S3_SECRET_ACCESS_KEY", ""),
+
 		RateLimitEnabled:               getEnvBool("RATE_LIMIT_ENABLED", true),
 		AuthRateLimitPerMinute:         getEnvInt("AUTH_RATE_LIMIT_PER_MINUTE", 30),
 		SecretExportRateLimitPerMinute: getEnvInt("SECRET_EXPORT_RATE_LIMIT_PER_MINUTE", 30),
@@ -201,6 +300,51 @@ func (c *Config) Validate() error {
 	if c.TierCacheTTL <= 0 || c.SecretDecryptConcurrency <= 0 || c.SecretDecryptConcurrency > 64 || c.AgentUsageWriteInterval <= 0 {
 		return fmt.Errorf("performance settings are invalid")
 	}
+	if c.KMSDataKeyCacheEnabled && (c.KMSDataKeyCacheTTL <= 0 || c.KMSDataKeyCacheTTL > time.Hour || c.KMSDataKeyCacheMaxEntries <= 0 || c.KMSDataKeyCacheMaxUses <= 0) {
+		return fmt.Errorf("KMS data key cache settings are invalid")
+	}
+	if (c.SecretRotationRemindersEnabled || c.SecretAutoRotationEnabled) && c.SecretRotationCheckInterval < time.Minute {
+		return fmt.Errorf("secret rotation check interval must be at least 1m")
+	}
+	if c.AgentDatabaseCredentialsEnabled && (c.AgentDatabaseLeaseReapInterval < 10*time.Second || c.AgentDatabaseLeaseReapInterval > time.Hour) {
+		return fmt.Errorf("AGENT_DATABASE_LEASE_REAP_INTERVAL must be between 10s and 1h")
+	}
+	if c.AgentCredentialRotationEnabled && (c.AgentCredentialRotationCheckInterval < 10*time.Second || c.AgentCredentialRotationCheckInterval > time.Hour || c.AgentCredentialExpiryNoticeLead < 0) {
+		return fmt.Errorf("agent credential rotation settings are invalid")
+	}
+	if c.AgentUsageRollupEnabled && (c.AgentUsageRollupInterval < 10*time.Second || c.AgentUsageRollupInterval > time.Hour) {
+		return fmt.Errorf("AGENT_USAGE_ROLLUP_INTERVAL must be between 10s and 1h")
+	}
+	if c.AgentAnomalyDetectionEnabled && (c.AgentAnomalyCheckInterval < 10*time.Second || c.AgentAnomalyCheckInterval > time.Hour) {
+		return fmt.Errorf("AGENT_ANOMALY_CHECK_INTERVAL must be between 10s and 1h")
+	}
+	if c.AuditForwardingEnabled && (c.AuditForwardingInterval < 5*time.Second || c.AuditForwardingInterval > time.Hour) {
+		return fmt.Errorf("AUDIT_FORWARDING_INTERVAL must be between 5s and 1h")
+	}
+	if c.AuditSigningKey != "" {
+		if seed, err := base64.StdEncoding.DecodeString(c.AuditSigningKey); err != nil || len(seed) != 32 {
+			return fmt.Errorf("AUDIT_SIGNING_KEY must be a base64-encoded 32-byte Ed25519 seed")
+		}
+	}
+	if c.AuditCheckpointEnabled && (c.AuditCheckpointInterval < time.Minute || c.AuditCheckpointInterval > 24*time.Hour) {
+		return fmt.Errorf("AUDIT_CHECKPOINT_INTERVAL must be between 1m and 24h")
+	}
+	if c.AuditRetentionEnabled && (c.AuditRetentionInterval < time.Minute || c.AuditRetentionInterval > 24*time.Hour) {
+		return fmt.Errorf("AUDIT_RETENTION_INTERVAL must be between 1m and 24h")
+	}
+	if c.AuditArchiveS3Bucket != "" {
+		if c.AuditArchiveS3Region == "" || c.AuditArchiveS3AccessKeyID == "" || c.AuditArchiveS3SecretAccessKey == "" {
+			return fmt.Errorf("AUDIT_ARCHIVE_S3_BUCKET requires AUDIT_ARCHIVE_S3_REGION, AUDIT_ARCHIVE_S3_ACCESS_KEY_ID and AUDIT_ARCHIVE_S3_SECRET_ACCESS_KEY")
+		}
+		if c.AuditArchiveS3Endpoint != "" {
+			if u, err := url.Parse(c.AuditArchiveS3Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("AUDIT_ARCHIVE_S3_ENDPOINT must be an http(s) URL")
+			}
+		}
+	}
+	if c.SecretRotationRemindersEnabled && c.SecretRotationReminderLead < 0 {
+		return fmt.Errorf("secret rotation reminder settings are invalid")
+	}
 
 	if c.DBPassword == "" && c.Env == "production" && strings.TrimSpace(os.Getenv("DB_URL")) == "" {
 		return fmt.Errorf("DB_PASSWORD is required in production")
@@ -224,6 +368,9 @@ func (c *Config) Validate() error {
 		if err := requireHTTPSURL("GOOGLE_REDIRECT_URL", c.GoogleRedirectURL); err != nil {
 			return err
 		}
+		if c.AuditSigningKey == "" {
+			return fmt.Errorf("AUDIT_SIGNING_KEY is required in production so audit checkpoints are not signed with a key derived from JWT_SECRET")
+		}
 		if strings.TrimSpace(c.AWSKMSKeyID) == "" && !c.AllowLocalEncryptionInProduction {
 			return fmt.Errorf("AWS_KMS_KEY_ID is required in production unless ALLOW_LOCAL_ENCRYPTION_IN_PRODUCTION=true is explicitly set")
 		}
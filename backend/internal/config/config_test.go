@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/base64"
 	"strings"
 	"testing"
 	"time"
@@ -18,6 +19,7 @@ func validProductionConfig() *Config {
 		GoogleRedirectURL:              "https://api.example.com/api/v1/auth/google/callback",
 		FrontendURL:                    "https://app.example.com",
 		AWSKMSKeyID:                    "arn:aws:kms:region:account:key/id",
+		AuditSigningKey:                base64.StdEncoding.EncodeToString(make([]byte, 32)),
 		RateLimitEnabled:               true,
 		AuthRateLimitPerMinute:         30,
 		SecretExportRateLimitPerMinute: 30,
@@ -37,6 +39,7 @@ func validProductionConfig() *Config {
 		TierCacheTTL:                   5 * time.Minute,
 		SecretDecryptConcurrency:       8,
 		AgentUsageWriteInterval:        time.Minute,
+		AgentDatabaseLeaseReapInterval: time.Minute,
 	}
 }
 
@@ -48,6 +51,14 @@ func TestProductionConfigRejectsWeakJWTSecret(t *testing.T) {
 	}
 }
 
+func TestProductionConfigRequiresAuditSigningKey(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.AuditSigningKey = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AUDIT_SIGNING_KEY") {
+		t.Fatalf("Validate() error = %v, want AUDIT_SIGNING_KEY required", err)
+	}
+}
+
 func TestProductionConfigRequiresHTTPS(t *testing.T) {
 	cfg := validProductionConfig()
 	cfg.FrontendURL = "http://app.example.com"
@@ -88,3 +99,184 @@ func TestConfigRejectsUnsafePoolAndConcurrencySettings(t *testing.T) {
 		t.Fatalf("Validate() error = %v, want performance settings error", err)
 	}
 }
+
+func TestConfigRejectsUnboundedDataKeyCache(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.KMSDataKeyCacheEnabled = true
+	cfg.KMSDataKeyCacheTTL = 5 * time.Minute
+	cfg.KMSDataKeyCacheMaxEntries = 1000
+	cfg.KMSDataKeyCacheMaxUses = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "data key cache") {
+		t.Fatalf("Validate() error = %v, want data key cache error", err)
+	}
+
+	cfg.KMSDataKeyCacheMaxUses = 1000
+	cfg.KMSDataKeyCacheTTL = 24 * time.Hour
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "data key cache") {
+		t.Fatalf("Validate() error = %v, want data key cache TTL error", err)
+	}
+
+	cfg.KMSDataKeyCacheEnabled = false
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() with disabled cache returned %v", err)
+	}
+}
+
+func TestConfigRejectsTightRotationReminderLoop(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.SecretRotationRemindersEnabled = true
+	cfg.SecretRotationCheckInterval = time.Second
+	cfg.SecretRotationReminderLead = 24 * time.Hour
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "check interval") {
+		t.Fatalf("Validate() error = %v, want check interval error", err)
+	}
+
+	cfg.SecretRotationCheckInterval = time.Hour
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+
+	cfg.SecretRotationReminderLead = -time.Hour
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "rotation reminder") {
+		t.Fatalf("Validate() error = %v, want rotation reminder error", err)
+	}
+}
+
+func TestConfigAutoRotationNeedsSaneInterval(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.SecretRotationRemindersEnabled = false
+	cfg.SecretAutoRotationEnabled = true
+	cfg.SecretRotationCheckInterval = time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Validate() accepted a 1s rotation loop")
+	}
+
+	cfg.SecretAutoRotationEnabled = false
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() with rotation disabled returned %v", err)
+	}
+}
+
+func TestConfigBoundsDatabaseLeaseReaper(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.AgentDatabaseCredentialsEnabled = true
+	cfg.AgentDatabaseLeaseReapInterval = 2 * time.Hour
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AGENT_DATABASE_LEASE_REAP_INTERVAL") {
+		t.Fatalf("Validate() error = %v, want reap interval error", err)
+	}
+
+	cfg.AgentDatabaseLeaseReapInterval = time.Minute
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+}
+
+func TestConfigBoundsAgentCredentialRotation(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.AgentCredentialRotationEnabled = true
+	cfg.AgentCredentialRotationCheckInterval = time.Second
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Validate() accepted a 1s credential rotation loop")
+	}
+
+	cfg.AgentCredentialRotationCheckInterval = 5 * time.Minute
+	cfg.AgentCredentialExpiryNoticeLead = -time.Hour
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("Validate() accepted a negative expiry notice lead")
+	}
+
+	cfg.AgentCredentialExpiryNoticeLead = 72 * time.Hour
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+}
+
+func TestConfigBoundsAgentUsageRollup(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.AgentUsageRollupEnabled = true
+	cfg.AgentUsageRollupInterval = 2 * time.Hour
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AGENT_USAGE_ROLLUP_INTERVAL") {
+		t.Fatalf("Validate() error = %v, want rollup interval error", err)
+	}
+
+	cfg.AgentUsageRollupInterval = 5 * time.Minute
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+}
+
+func TestConfigBoundsAgentAnomalyDetection(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.AgentAnomalyDetectionEnabled = true
+	cfg.AgentAnomalyCheckInterval = time.Second
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AGENT_ANOMALY_CHECK_INTERVAL") {
+		t.Fatalf("Validate() error = %v, want anomaly check interval error", err)
+	}
+
+	cfg.AgentAnomalyCheckInterval = time.Minute
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+}
+
+func TestConfigBoundsAuditForwarding(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.AuditForwardingEnabled = true
+	cfg.AuditForwardingInterval = time.Second
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AUDIT_FORWARDING_INTERVAL") {
+		t.Fatalf("Validate() error = %v, want audit forwarding interval error", err)
+	}
+
+	cfg.AuditForwardingInterval = 30 * time.Second
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+}
+
+func TestConfigBoundsAuditCheckpoints(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.AuditCheckpointEnabled = true
+	cfg.AuditCheckpointInterval = time.Second
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AUDIT_CHECKPOINT_INTERVAL") {
+		t.Fatalf("Validate() error = %v, want audit checkpoint interval error", err)
+	}
+
+	cfg.AuditCheckpointInterval = time.Hour
+	cfg.AuditSigningKey = "not-a-key"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AUDIT_SIGNING_KEY") {
+		t.Fatalf("Validate() error = %v, want audit signing key error", err)
+	}
+
+	cfg.AuditSigningKey = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+}
+
+func TestConfigBoundsAuditRetention(t *testing.T) {
+	cfg := validProductionConfig()
+	cfg.AuditRetentionEnabled = true
+	cfg.AuditRetentionInterval = time.Second
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AUDIT_RETENTION_INTERVAL") {
+		t.Fatalf("Validate() error = %v, want audit retention interval error", err)
+	}
+
+	cfg.AuditRetentionInterval = time.Hour
+	cfg.AuditArchiveS3Bucket = "audit-archive"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AUDIT_ARCHIVE_S3_BUCKET") {
+		t.Fatalf("Validate() error = %v, want incomplete archive error", err)
+	}
+
+	cfg.AuditArchiveS3Region = "eu-west-1"
+	cfg.AuditArchiveS3AccessKeyID = "AKIA"
+	cfg.AuditArchiveS3SecretAccessKey = "secret"
+	cfg.AuditArchiveS3Endpoint = "minio:9000"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "AUDIT_ARCHIVE_S3_ENDPOINT") {
+		t.Fatalf("Validate() error = %v, want archive endpoint error", err)
+	}
+
+	cfg.AuditArchiveS3Endpoint = "https://minio.internal:9000"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() returned %v", err)
+	}
+}
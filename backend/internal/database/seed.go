@@ -43,6 +43,7 @@ func seedPermissions(db *gorm.DB) error {
 		{Name: models.PermissionAuditView, Description: "View audit logs"},
 		{Name: models.PermissionOrgManage, Description: "Edit organization settings and billing"},
 		{Name: models.PermissionAgentsManage, Description: "Create agents, credentials, and secret access grants"},
+		{Name: models.PermissionEncryptionView, Description: "View the workspace encryption health report"},
 	}
 
 	for _, perm := range permissions {
@@ -85,6 +86,7 @@ func seedSystemRoles(db *gorm.DB) error {
 			models.PermissionAuditView,
 			models.PermissionOrgManage,
 			models.PermissionAgentsManage,
+			models.PermissionEncryptionView,
 		},
 		models.RoleAdmin: {
 			models.PermissionSecretsRead,
@@ -97,6 +99,7 @@ func seedSystemRoles(db *gorm.DB) error {
 			models.PermissionMembersManage,
 			models.PermissionAuditView,
 			models.PermissionAgentsManage,
+			models.PermissionEncryptionView,
 		},
 		models.RoleSecretManager: {
 			models.PermissionSecretsRead,
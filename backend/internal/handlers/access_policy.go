@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/envo/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// PolicyHandler handles org access policies
+type PolicyHandler struct {
+	policies *services.PolicyService
+}
+
+// NewPolicyHandler creates a new access policy handler
+func NewPolicyHandler(policies *services.PolicyService) *PolicyHandler {
+	return &PolicyHandler{policies: policies}
+}
+
+// respondPolicyDenied answers a request an access policy refused with 403
+// naming the policy. It reports whether err was such a refusal.
+func respondPolicyDenied(c *gin.Context, err error) bool {
+	var denied *services.PolicyDeniedError
+	if !errors.As(err, &denied) {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":     err.Error(),
+		"policy_id": denied.PolicyID,
+		"policy":    denied.Policy,
+		"key":       denied.Key,
+	})
+	return true
+}
+
+func respondPolicyError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrAccessPolicyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access policy not found"})
+	case errors.Is(err, services.ErrInvalidAccessPolicy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondInternalError(c, message, err)
+	}
+}
+
+// accessPolicyRequest is the body of create and update.
+type accessPolicyRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Actions     []string `json:"actions"`
+	Condition   string   `json:"condition" binding:"required"`
+	Mode        string   `json:"mode"`
+	Enabled     *bool    `json:"enabled"`
+}
+
+func (r accessPolicyRequest) toInput() services.AccessPolicyInput {
+	return services.AccessPolicyInput{
+		Name:        r.Name,
+		Description: r.Description,
+		Actions:     r.Actions,
+		Condition:   r.Condition,
+		Mode:        r.Mode,
+		Enabled:     r.Enabled,
+	}
+}
+
+func policyRouteIDs(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	policyID, err := uuid.Parse(c.Param("policyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orgID, policyID, true
+}
+
+// List lists an org's access policies
+// GET /api/v1/orgs/:id/access-policies
+func (h *PolicyHandler) List(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	policies, err := h.policies.ListPolicies(c.Request.Context(), orgID)
+	if err != nil {
+		respondInternalError(c, "Failed to list access policies", err)
+		return
+	}
+	c.JSON(http.StatusOK, policies)
+}
+
+// Create adds an access policy
+// POST /api/v1/orgs/:id/access-policies
+func (h *PolicyHandler) Create(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	var req accessPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and condition are required"})
+		return
+	}
+	policy, err := h.policies.CreatePolicy(c.Request.Context(), userID, orgID, req.toInput(), c.ClientIP())
+	if err != nil {
+		respondPolicyError(c, "Failed to create access policy", err)
+		return
+	}
+	c.JSON(http.StatusCreated, policy)
+}
+
+// Update replaces an access policy
+// PUT /api/v1/orgs/:id/access-policies/:policyId
+func (h *PolicyHandler) Update(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, policyID, ok := policyRouteIDs(c)
+	if !ok {
+		return
+	}
+	var req accessPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and condition are required"})
+		return
+	}
+	policy, err := h.policies.UpdatePolicy(c.Request.Context(), userID, orgID, policyID, req.toInput(), c.ClientIP())
+	if err != nil {
+		respondPolicyError(c, "Failed to update access policy", err)
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// Delete removes an access policy
+// DELETE /api/v1/orgs/:id/access-policies/:policyId
+func (h *PolicyHandler) Delete(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, policyID, ok := policyRouteIDs(c)
+	if !ok {
+		return
+	}
+	if err := h.policies.DeletePolicy(c.Request.Context(), userID, orgID, policyID, c.ClientIP()); err != nil {
+		respondPolicyError(c, "Failed to delete access policy", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Access policy deleted"})
+}
+
+// Evaluate dry-runs a described request against the org's policies, or a draft condition
+// POST /api/v1/orgs/:id/access-policies/evaluate
+func (h *PolicyHandler) Evaluate(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	var req struct {
+		Condition   string                   `json:"condition"`
+		Action      string                   `json:"action" binding:"required"`
+		Principal   services.PolicyPrincipal `json:"principal"`
+		Project     string                   `json:"project"`
+		Environment string                   `json:"environment"`
+		Secret      services.PolicySecret    `json:"secret"`
+		IP          string                   `json:"ip"`
+		Purpose     string                   `json:"purpose"`
+		Time        *time.Time               `json:"time"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Action is required"})
+		return
+	}
+	input := services.PolicyRequest{
+		Principal:   req.Principal,
+		Action:      req.Action,
+		Project:     req.Project,
+		Environment: req.Environment,
+		IP:          req.IP,
+		Purpose:     req.Purpose,
+	}
+	if req.Time != nil {
+		input.Time = req.Time.UTC()
+	}
+	evaluation, err := h.policies.Evaluate(c.Request.Context(), orgID, input, req.Secret, req.Condition)
+	if err != nil {
+		respondPolicyError(c, "Failed to evaluate access policies", err)
+		return
+	}
+	c.JSON(http.StatusOK, evaluation)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 
+	"github.com/envo/backend/internal/middleware"
 	"github.com/envo/backend/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -10,10 +11,11 @@ import (
 
 type AdminHandler struct {
 	adminService *services.AdminService
+	kmsService   *services.KMSService // nil when local encryption is primary
 }
 
-func NewAdminHandler(adminService *services.AdminService) *AdminHandler {
-	return &AdminHandler{adminService: adminService}
+func NewAdminHandler(adminService *services.AdminService, kmsService *services.KMSService) *AdminHandler {
+	return &AdminHandler{adminService: adminService, kmsService: kmsService}
 }
 
 // GET /api/v1/admin/users?q=&limit=
@@ -40,10 +42,24 @@ func (h *AdminHandler) UpdateUserTier(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "tier is required"})
 		return
 	}
-	user, err := h.adminService.UpdateUserTier(userID, req.Tier)
+	adminID, err := middleware.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	user, err := h.adminService.UpdateUserTier(c.Request.Context(), adminID, userID, req.Tier, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, user)
 }
+
+// GET /api/v1/admin/encryption/cache
+func (h *AdminHandler) EncryptionCacheStats(c *gin.Context) {
+	if h.kmsService == nil {
+		c.JSON(http.StatusOK, services.DataKeyCacheStats{})
+		return
+	}
+	c.JSON(http.StatusOK, h.kmsService.CacheStats())
+}
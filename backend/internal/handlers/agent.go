@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,13 +22,15 @@ import (
 )
 
 type AgentHandler struct {
-	agents  *services.AgentService
-	secrets *services.SecretService
-	audit   *services.AuditService
+	agents    *services.AgentService
+	secrets   *services.SecretService
+	databases *services.DatabaseCredentialService
+	audit     *services.AuditService
+	policies  *services.PolicyService
 }
 
-func NewAgentHandler(agents *services.AgentService, secrets *services.SecretService, audit *services.AuditService) *AgentHandler {
-	return &AgentHandler{agents: agents, secrets: secrets, audit: audit}
+func NewAgentHandler(agents *services.AgentService, secrets *services.SecretService, databases *services.DatabaseCredentialService, audit *services.AuditService, policies *services.PolicyService) *AgentHandler {
+	return &AgentHandler{agents: agents, secrets: secrets, databases: databases, audit: audit, policies: policies}
 }
 
 func agentRouteIDs(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
@@ -99,13 +106,21 @@ func (h *AgentHandler) Update(c *gin.Context) {
 		return
 	}
 	var req struct {
-		Status string `json:"status" binding:"required"`
+		Status               string `json:"status"`
+		MaxCredentialAgeDays *int   `json:"max_credential_age_days"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Status is required"})
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Status == "" && req.MaxCredentialAgeDays == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status or max_credential_age_days is required"})
 		return
 	}
-	agent, err := h.agents.UpdateAgentStatus(c.Request.Context(), userID, orgID, agentID, req.Status, c.ClientIP())
+	var agent *models.AgentIdentity
+	var err error
+	if req.MaxCredentialAgeDays != nil {
+		agent, err = h.agents.SetMaxCredentialAge(c.Request.Context(), userID, orgID, agentID, *req.MaxCredentialAgeDays, c.ClientIP())
+	}
+	if err == nil && req.Status != "" {
+		agent, err = h.agents.UpdateAgentStatus(c.Request.Context(), services.UserActor(userID), orgID, agentID, req.Status, c.ClientIP(), nil)
+	}
 	if errors.Is(err, services.ErrAgentNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -114,6 +129,9 @@ func (h *AgentHandler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if agent.Status != models.AgentStatusActive && h.databases != nil {
+		h.databases.RevokeForAgent(context.WithoutCancel(c.Request.Context()), agentID, nil)
+	}
 	c.JSON(http.StatusOK, agent)
 }
 
@@ -140,14 +158,23 @@ func (h *AgentHandler) CreateCredential(c *gin.Context) {
 		return
 	}
 	var req struct {
-		Name      string     `json:"name" binding:"required"`
-		ExpiresAt *time.Time `json:"expires_at"`
+		Name         string                        `json:"name" binding:"required"`
+		ExpiresAt    *time.Time                    `json:"expires_at"`
+		AllowedCIDRs []string                      `json:"allowed_cidrs"`
+		TimeWindows  []models.CredentialTimeWindow `json:"time_windows"`
+		TimeZone     string                        `json:"time_zone"`
+		MaxUses      int                           `json:"max_uses"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Credential name is required"})
 		return
 	}
-	credential, raw, err := h.agents.CreateCredential(c.Request.Context(), userID, orgID, agentID, req.Name, req.ExpiresAt, c.ClientIP())
+	credential, raw, err := h.agents.CreateCredential(c.Request.Context(), userID, orgID, agentID, req.Name, req.ExpiresAt, services.CredentialConditions{
+		AllowedCIDRs: req.AllowedCIDRs,
+		TimeWindows:  req.TimeWindows,
+		TimeZone:     req.TimeZone,
+		MaxUses:      req.MaxUses,
+	}, c.ClientIP())
 	if errors.Is(err, services.ErrAgentNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -185,6 +212,52 @@ func (h *AgentHandler) RevokeCredential(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// RotateCredential issues a successor token and retires the old one after
+// an overlap (default one hour)
+// POST /api/v1/orgs/:id/agents/:agentId/credentials/:credentialId/rotate
+func (h *AgentHandler) RotateCredential(c *gin.Context) {
+	orgID, agentID, ok := agentRouteIDs(c)
+	if !ok {
+		return
+	}
+	credentialID, err := uuid.Parse(c.Param("credentialId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		OverlapSeconds *int `json:"overlap_seconds"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	overlap := services.DefaultCredentialRotationOverlap
+	if req.OverlapSeconds != nil {
+		overlap = time.Duration(*req.OverlapSeconds) * time.Second
+	}
+	credential, raw, err := h.agents.RotateCredential(c.Request.Context(), userID, orgID, agentID, credentialID, overlap, c.ClientIP())
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+		return
+	case errors.Is(err, services.ErrCredentialNotRotatable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Credential is revoked, expired, federated or already rotated"})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, gin.H{"credential": credential, "token": raw, "warning": "Copy this token now. Envo cannot show it again."})
+}
+
 func (h *AgentHandler) ListGrants(c *gin.Context) {
 	orgID, agentID, ok := agentRouteIDs(c)
 	if !ok {
@@ -208,16 +281,47 @@ func (h *AgentHandler) CreateGrant(c *gin.Context) {
 		return
 	}
 	var req struct {
-		EnvironmentID   uuid.UUID  `json:"environment_id" binding:"required"`
-		AllowedKeys     []string   `json:"allowed_keys"`
-		AllowAllSecrets bool       `json:"allow_all_secrets"`
-		ExpiresAt       *time.Time `json:"expires_at"`
+		EnvironmentID *uuid.UUID `json:"environment_id"`
+		// A project grant covers every environment of the project whose
+		// name matches EnvironmentPattern, including ones created later.
+		ProjectID          *uuid.UUID `json:"project_id"`
+		EnvironmentPattern string     `json:"environment_pattern"`
+		Capability         string     `json:"capability"`
+		AllowedKeys        []string   `json:"allowed_keys"`
+		DeniedKeys         []string   `json:"denied_keys"`
+		AllowAllSecrets    bool       `json:"allow_all_secrets"`
+		ExpiresAt          *time.Time `json:"expires_at"`
+		// database.credentials only
+		AdminSecretID        uuid.UUID `json:"admin_secret_id"`
+		CredentialKey        string    `json:"credential_key"`
+		GrantTemplate        string    `json:"grant_template"`
+		CredentialTTLSeconds int       `json:"credential_ttl_seconds"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid environment and access policy are required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid environment or project and access policy are required"})
+		return
+	}
+	target := services.GrantTarget{EnvironmentID: req.EnvironmentID, ProjectID: req.ProjectID, EnvironmentPattern: req.EnvironmentPattern}
+	var grant *models.AgentGrant
+	var err error
+	switch req.Capability {
+	case "", models.AgentCapabilitySecretsInject, models.AgentCapabilitySecretsWrite:
+		grant, err = h.agents.CreateGrant(c.Request.Context(), userID, orgID, agentID, req.Capability, target, services.KeyScope{All: req.AllowAllSecrets, Allow: req.AllowedKeys, Deny: req.DeniedKeys}, req.ExpiresAt, c.ClientIP())
+	case models.AgentCapabilityDatabaseCredentials:
+		grant, err = h.agents.CreateDatabaseGrant(c.Request.Context(), userID, orgID, agentID, target, services.DatabaseGrantInput{
+			AdminSecretID: req.AdminSecretID,
+			CredentialKey: req.CredentialKey,
+			GrantTemplate: req.GrantTemplate,
+			TTL:           time.Duration(req.CredentialTTLSeconds) * time.Second,
+		}, req.ExpiresAt, c.ClientIP())
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown capability"})
+		return
+	}
+	if errors.Is(err, services.ErrSecretReadForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		return
 	}
-	grant, err := h.agents.CreateGrant(c.Request.Context(), userID, orgID, agentID, req.EnvironmentID, req.AllowedKeys, req.AllowAllSecrets, req.ExpiresAt, c.ClientIP())
 	if errors.Is(err, services.ErrAgentNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
@@ -251,6 +355,9 @@ func (h *AgentHandler) RevokeGrant(c *gin.Context) {
 		respondInternalError(c, "Failed to revoke grant", err)
 		return
 	}
+	if h.databases != nil {
+		h.databases.RevokeForAgent(context.WithoutCancel(c.Request.Context()), agentID, &grantID)
+	}
 	c.Status(http.StatusNoContent)
 }
 
@@ -264,6 +371,61 @@ func (h *AgentHandler) Me(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"agent": agent, "credential_id": credential.ID, "credential_name": credential.Name})
 }
 
+// Access lists the calling agent's live grants
+// GET /api/v1/agent/access
+func (h *AgentHandler) Access(c *gin.Context) {
+	agent, _, ok := middleware.GetCurrentAgent(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Agent authorization required"})
+		return
+	}
+	grants, err := h.agents.ListAccess(c.Request.Context(), agent)
+	if err != nil {
+		respondInternalError(c, "Failed to list agent access", err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"agent_id": agent.ID, "grants": grants})
+}
+
+// AccessKeys lists the key names a resolve of one environment would return,
+// optionally checking that every key in the comma-separated keys parameter
+// is covered. It never decrypts or returns values.
+// GET /api/v1/agent/access/keys?project=&environment=&keys=
+func (h *AgentHandler) AccessKeys(c *gin.Context) {
+	agent, _, ok := middleware.GetCurrentAgent(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Agent authorization required"})
+		return
+	}
+	if c.Query("project") == "" || c.Query("environment") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project and environment are required"})
+		return
+	}
+	var keys []string
+	if raw := c.Query("keys"); raw != "" {
+		keys = strings.Split(raw, ",")
+	}
+	if len(keys) > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key list is too large"})
+		return
+	}
+	result, err := h.agents.EnvironmentKeys(c.Request.Context(), agent, c.Query("project"), c.Query("environment"), keys, c.ClientIP())
+	if errors.Is(err, services.ErrAgentForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Agent is not authorized for the requested project, environment, or secret keys",
+			"hint":  "Ask a human for time-boxed access with POST /api/v1/agent/access-requests (envo run --request-access)",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, result)
+}
+
 func (h *AgentHandler) ResolveSecrets(c *gin.Context) {
 	agent, credential, ok := middleware.GetCurrentAgent(c)
 	if !ok {
@@ -290,31 +452,136 @@ func (h *AgentHandler) ResolveSecrets(c *gin.Context) {
 	}
 	access, err := h.agents.AuthorizeResolve(c.Request.Context(), agent, req.Project, req.Environment, req.Keys)
 	if errors.Is(err, services.ErrAgentForbidden) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Agent is not authorized for the requested project, environment, or secret keys"})
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Agent is not authorized for the requested project, environment, or secret keys",
+			"hint":  "Ask a human for time-boxed access with POST /api/v1/agent/access-requests (envo run --request-access)",
+		})
 		return
 	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	secrets, orgID, err := h.secrets.DecryptEnvironmentSecrets(c.Request.Context(), access.Environment, access.AllowedKeys, access.AllowAll)
+	// Access policies run after the grants: keys asked for by name must all
+	// pass, otherwise denied keys are withheld unless that leaves nothing.
+	policy, err := h.policies.CheckEnvironment(c.Request.Context(), services.PolicyRequest{
+		Actor:     services.AgentActor(agent.ID),
+		Principal: services.AgentPrincipal(agent),
+		Action:    models.PolicyActionSecretsResolve,
+		IP:        c.ClientIP(),
+		Purpose:   strings.TrimSpace(req.Purpose),
+	}, access.Environment, access.AllowsKey)
+	if err == nil && len(req.Keys) > 0 {
+		err = policy.Err(req.Keys...)
+	}
+	if err == nil {
+		err = policy.ErrIfAllDenied()
+	}
+	if err != nil {
+		if !respondPolicyDenied(c, err) {
+			respondInternalError(c, "Failed to evaluate access policies", err)
+		}
+		return
+	}
+	// Grants that name exact keys are looked up directly; patterns need the
+	// whole environment.
+	exact, _ := access.ExactKeys()
+	secrets, skipped, orgID, err := h.secrets.DecryptEnvironmentSecrets(c.Request.Context(), access.Environment, func(key string) bool {
+		return access.AllowsKey(key) && policy.Allows(key)
+	}, exact)
 	if err != nil {
 		respondInternalError(c, "Failed to resolve secrets", err)
 		return
 	}
+	skipped = services.MergeSkipped(skipped, policy.Skipped())
+	if skipped == nil {
+		skipped = []services.SkippedSecret{}
+	}
+	quotaKeys := make([]string, 0, len(secrets)+len(access.DatabaseGrants))
+	for key := range secrets {
+		quotaKeys = append(quotaKeys, key)
+	}
+	for _, grant := range access.DatabaseGrants {
+		quotaKeys = append(quotaKeys, grant.CredentialKey)
+	}
+	if err := h.agents.CheckQuota(c.Request.Context(), agent, credential, access.Environment, quotaKeys, c.ClientIP()); err != nil {
+		respondQuotaError(c, err)
+		return
+	}
 	leaseID := uuid.New()
-	expiresAt := time.Now().UTC().Add(5 * time.Minute)
+	issuedAt := time.Now().UTC()
+	expiresAt := issuedAt.Add(services.AgentLeaseTTL)
 	if access.ExpiresAt != nil && access.ExpiresAt.Before(expiresAt) {
 		expiresAt = *access.ExpiresAt
 	}
-	metadataBytes, _ := json.Marshal(gin.H{
+	// Dynamic database credentials are bound to this lease. They are
+	// returned like secrets and the lease lasts as long as the
+	// shortest-lived role rather than the default.
+	dynamicKeys := []string{}
+	if len(access.DatabaseGrants) > 0 {
+		if h.databases == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Dynamic database credentials are not enabled"})
+			return
+		}
+		issued, err := h.databases.Issue(c.Request.Context(), agent, access, leaseID, c.ClientIP())
+		if err != nil {
+			respondInternalError(c, "Failed to issue database credentials", err)
+			return
+		}
+		if secrets == nil {
+			secrets = map[string]string{}
+		}
+		for i, cred := range issued {
+			secrets[cred.Key] = cred.Value
+			dynamicKeys = append(dynamicKeys, cred.Key)
+			if i == 0 || cred.ExpiresAt.Before(expiresAt) {
+				expiresAt = cred.ExpiresAt
+			}
+		}
+	}
+	deliveredKeys := make([]string, 0, len(secrets))
+	for key := range secrets {
+		deliveredKeys = append(deliveredKeys, key)
+	}
+	sort.Strings(deliveredKeys)
+	grantIDsJSON, _ := json.Marshal(access.GrantIDs)
+	keysJSON, _ := json.Marshal(deliveredKeys)
+	lease := &models.AgentLease{
+		ID:            leaseID,
+		OrgID:         agent.OrgID,
+		AgentID:       agent.ID,
+		CredentialID:  credential.ID,
+		EnvironmentID: access.Environment,
+		GrantIDs:      datatypes.JSON(grantIDsJSON),
+		Keys:          datatypes.JSON(keysJSON),
+		Purpose:       strings.TrimSpace(req.Purpose),
+		SessionID:     strings.TrimSpace(req.SessionID),
+		IPAddress:     c.ClientIP(),
+		TTLSeconds:    int(expiresAt.Sub(issuedAt).Round(time.Second) / time.Second),
+		ExpiresAt:     expiresAt,
+	}
+	if err := h.agents.RecordLease(c.Request.Context(), lease); err != nil {
+		if h.databases != nil && len(dynamicKeys) > 0 {
+			h.databases.RevokeLease(context.WithoutCancel(c.Request.Context()), leaseID)
+		}
+		respondInternalError(c, "Failed to record lease", err)
+		return
+	}
+	metadata := gin.H{
 		"credential_id": credential.ID,
 		"grant_ids":     access.GrantIDs,
 		"lease_id":      leaseID,
+		"keys":          deliveredKeys,
 		"secret_count":  len(secrets),
+		"dynamic_keys":  dynamicKeys,
+		"skipped_keys":  skipped,
 		"purpose":       strings.TrimSpace(req.Purpose),
 		"session_id":    strings.TrimSpace(req.SessionID),
-	})
+	}
+	if decision := policy.Decision(); decision != nil {
+		metadata["policy"] = decision
+	}
+	metadataBytes, _ := json.Marshal(metadata)
 	if h.audit != nil {
 		_ = h.audit.LogAgent(c.Request.Context(), agent.ID, orgID, access.Environment, models.ActionSecretRead, "environment", c.ClientIP(), datatypes.JSON(metadataBytes))
 	}
@@ -326,5 +593,318 @@ func (h *AgentHandler) ResolveSecrets(c *gin.Context) {
 		"lease_id":       leaseID,
 		"expires_at":     expiresAt,
 		"secrets":        secrets,
+		"dynamic_keys":   dynamicKeys,
+		"skipped_keys":   skipped,
 	})
 }
+
+// WriteSecret creates or overwrites one secret under a secrets.write grant.
+// The change is audited as the agent's.
+// PUT /api/v1/agent/secrets
+func (h *AgentHandler) WriteSecret(c *gin.Context) {
+	agent, _, ok := middleware.GetCurrentAgent(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Agent authorization required"})
+		return
+	}
+	// One key and value; certificates and keys fit well within this.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 64<<10)
+	var req struct {
+		Project     string `json:"project" binding:"required"`
+		Environment string `json:"environment" binding:"required"`
+		Key         string `json:"key" binding:"required"`
+		Value       string `json:"value" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project, environment, key and value are required"})
+		return
+	}
+	env, err := h.agents.AuthorizeWrite(c.Request.Context(), agent, req.Project, req.Environment, req.Key)
+	if errors.Is(err, services.ErrAgentForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Agent is not authorized to write this key in the requested project and environment"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, wasUpdate, err := h.secrets.CreateSecret(c.Request.Context(), services.AgentActor(agent.ID), env.ID, req.Key, req.Value, services.SecretMetadataInput{}, c.ClientIP())
+	if err != nil {
+		if respondSecretWriteError(c, err) {
+			return
+		}
+		respondInternalError(c, "Failed to write secret", err)
+		return
+	}
+	status := http.StatusCreated
+	if wasUpdate {
+		status = http.StatusOK
+	}
+	c.JSON(status, secret)
+}
+
+func respondLeaseError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrLeaseNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lease not found"})
+	case errors.Is(err, services.ErrLeaseInactive):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAgentForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "A grant behind this lease was revoked or expired; resolve again"})
+	default:
+		respondInternalError(c, message, err)
+	}
+}
+
+// respondQuotaError answers a resolve that would breach a quota with 429 and
+// the time until it would fit, in the shape of the rate limiter's response.
+func respondQuotaError(c *gin.Context, err error) {
+	var exceeded *services.QuotaExceededError
+	if !errors.As(err, &exceeded) {
+		respondInternalError(c, "Failed to check agent quota", err)
+		return
+	}
+	retryAfter := int(math.Ceil(exceeded.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "quota_exceeded",
+		"message":     fmt.Sprintf("The %s quota of %d %s is used up. Please retry later.", exceeded.Scope, exceeded.Max, strings.ReplaceAll(exceeded.Limit, "_", " ")),
+		"scope":       exceeded.Scope,
+		"limit":       exceeded.Limit,
+		"max":         exceeded.Max,
+		"retry_after": retryAfter,
+	})
+}
+
+// SetQuota replaces an agent's quota; zero limits are unlimited
+// PUT /api/v1/orgs/:id/agents/:agentId/quota
+func (h *AgentHandler) SetQuota(c *gin.Context) {
+	orgID, agentID, ok := agentRouteIDs(c)
+	if !ok {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var quota models.AgentQuota
+	if err := c.ShouldBindJSON(&quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quota"})
+		return
+	}
+	agent, err := h.agents.SetAgentQuota(c.Request.Context(), userID, orgID, agentID, quota, c.ClientIP())
+	if errors.Is(err, services.ErrAgentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, agent)
+}
+
+// SetCredentialQuota replaces one credential's quota, which applies on top
+// of the agent's
+// PUT /api/v1/orgs/:id/agents/:agentId/credentials/:credentialId/quota
+func (h *AgentHandler) SetCredentialQuota(c *gin.Context) {
+	orgID, agentID, ok := agentRouteIDs(c)
+	if !ok {
+		return
+	}
+	credentialID, err := uuid.Parse(c.Param("credentialId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var quota models.AgentQuota
+	if err := c.ShouldBindJSON(&quota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quota"})
+		return
+	}
+	credential, err := h.agents.SetCredentialQuota(c.Request.Context(), userID, orgID, agentID, credentialID, quota, c.ClientIP())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, credential)
+}
+
+// Usage returns agent resolves over time per agent, environment and purpose
+// GET /api/v1/orgs/:id/agent-usage?agent_id=&environment_id=&purpose=&from=&to=&granularity=hour|day
+func (h *AgentHandler) Usage(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	var q services.AgentUsageQuery
+	if raw := c.Query("agent_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid agent ID"})
+			return
+		}
+		q.AgentID = &id
+	}
+	if raw := c.Query("environment_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+			return
+		}
+		q.EnvironmentID = &id
+	}
+	if purpose, ok := c.GetQuery("purpose"); ok {
+		q.Purpose = &purpose
+	}
+	var ok bool
+	if q.From, ok = parseTimeQuery(c, "from"); !ok {
+		return
+	}
+	if q.To, ok = parseTimeQuery(c, "to"); !ok {
+		return
+	}
+	q.Granularity = c.Query("granularity")
+	points, err := h.agents.AgentUsage(c.Request.Context(), orgID, q)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"points": points})
+}
+
+// parseTimeQuery reads an optional RFC 3339 query parameter, answering 400
+// when it is malformed.
+func parseTimeQuery(c *gin.Context, name string) (time.Time, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an RFC 3339 time"})
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
+
+// ListLeases lists an org's agent leases: who currently holds what
+// GET /api/v1/orgs/:id/agent-leases?agent_id=&include_inactive=true&limit=
+func (h *AgentHandler) ListLeases(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	var agentID *uuid.UUID
+	if raw := c.Query("agent_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid agent ID"})
+			return
+		}
+		agentID = &id
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	leases, err := h.agents.ListLeases(c.Request.Context(), orgID, agentID, c.Query("include_inactive") == "true", limit)
+	if err != nil {
+		respondInternalError(c, "Failed to list agent leases", err)
+		return
+	}
+	c.JSON(http.StatusOK, leases)
+}
+
+// RevokeLease ends an agent lease and drops any database roles bound to it
+// DELETE /api/v1/orgs/:id/agent-leases/:leaseId
+func (h *AgentHandler) RevokeLease(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	leaseID, err := uuid.Parse(c.Param("leaseId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lease ID"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if _, err := h.agents.RevokeLease(c.Request.Context(), userID, orgID, leaseID, c.ClientIP()); err != nil {
+		respondLeaseError(c, "Failed to revoke lease", err)
+		return
+	}
+	if h.databases != nil {
+		// The lease is already revoked; finish dropping its roles even if
+		// the client goes away.
+		h.databases.RevokeLease(context.WithoutCancel(c.Request.Context()), leaseID)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RenewLease extends the caller's own lease while its grants are still live
+// POST /api/v1/agent/leases/:leaseId/renew
+func (h *AgentHandler) RenewLease(c *gin.Context) {
+	agent, _, ok := middleware.GetCurrentAgent(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Agent authorization required"})
+		return
+	}
+	leaseID, err := uuid.Parse(c.Param("leaseId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lease ID"})
+		return
+	}
+	var extend func(context.Context, time.Time) error
+	if h.databases != nil {
+		extend = func(ctx context.Context, expiresAt time.Time) error {
+			return h.databases.ExtendLease(ctx, leaseID, expiresAt)
+		}
+	}
+	lease, err := h.agents.RenewLease(c.Request.Context(), agent, leaseID, c.ClientIP(), extend)
+	if err != nil {
+		respondLeaseError(c, "Failed to renew lease", err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"lease_id": lease.ID, "expires_at": lease.ExpiresAt, "renew_count": lease.RenewCount})
+}
+
+// ReleaseLease ends the caller's own lease early
+// DELETE /api/v1/agent/leases/:leaseId
+func (h *AgentHandler) ReleaseLease(c *gin.Context) {
+	agent, _, ok := middleware.GetCurrentAgent(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Agent authorization required"})
+		return
+	}
+	leaseID, err := uuid.Parse(c.Param("leaseId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lease ID"})
+		return
+	}
+	lease, err := h.agents.ReleaseLease(c.Request.Context(), agent, leaseID, c.ClientIP())
+	if err != nil {
+		respondLeaseError(c, "Failed to release lease", err)
+		return
+	}
+	if h.databases != nil {
+		// The lease is already revoked; finish dropping its roles even if
+		// the client goes away.
+		h.databases.RevokeLease(context.WithoutCancel(c.Request.Context()), leaseID)
+	}
+	c.JSON(http.StatusOK, gin.H{"lease_id": lease.ID, "revoked_at": lease.RevokedAt})
+}
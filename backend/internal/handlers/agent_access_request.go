@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/envo/backend/internal/middleware"
+	"github.com/envo/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AccessRequestHandler handles just-in-time agent access requests
+type AccessRequestHandler struct {
+	requests *services.AccessRequestService
+}
+
+// NewAccessRequestHandler creates a new access request handler
+func NewAccessRequestHandler(requests *services.AccessRequestService) *AccessRequestHandler {
+	return &AccessRequestHandler{requests: requests}
+}
+
+func respondAccessRequestError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrAccessRequestNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+	case errors.Is(err, services.ErrAccessRequestDecided):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTooManyAccessRequests):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidAccessRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAgentNotFound):
+		c.JSON(http.StatusConflict, gin.H{"error": "The requesting agent was revoked"})
+	default:
+		respondInternalError(c, message, err)
+	}
+}
+
+func accessRequestRouteIDs(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	requestID, err := uuid.Parse(c.Param("requestId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid access request ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orgID, requestID, true
+}
+
+// Create lets an agent ask a human for access
+// POST /api/v1/agent/access-requests
+func (h *AccessRequestHandler) Create(c *gin.Context) {
+	agent, credential, ok := middleware.GetCurrentAgent(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Agent authorization required"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 64<<10)
+	var req struct {
+		Project         string   `json:"project" binding:"required"`
+		Environment     string   `json:"environment" binding:"required"`
+		Keys            []string `json:"keys"`
+		AllSecrets      bool     `json:"all_secrets"`
+		Purpose         string   `json:"purpose" binding:"required"`
+		DurationSeconds int      `json:"duration_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project, environment and purpose are required"})
+		return
+	}
+	request, created, err := h.requests.Create(c.Request.Context(), agent, credential, services.AccessRequestInput{
+		Project:     req.Project,
+		Environment: req.Environment,
+		Keys:        req.Keys,
+		AllSecrets:  req.AllSecrets,
+		Purpose:     req.Purpose,
+		Duration:    time.Duration(req.DurationSeconds) * time.Second,
+	}, c.ClientIP())
+	if err != nil {
+		respondAccessRequestError(c, "Failed to create access request", err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, request)
+}
+
+// Get returns the caller's own request so it can wait for a decision
+// GET /api/v1/agent/access-requests/:requestId
+func (h *AccessRequestHandler) Get(c *gin.Context) {
+	agent, _, ok := middleware.GetCurrentAgent(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Agent authorization required"})
+		return
+	}
+	requestID, err := uuid.Parse(c.Param("requestId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid access request ID"})
+		return
+	}
+	request, err := h.requests.GetForAgent(c.Request.Context(), agent, requestID)
+	if err != nil {
+		respondAccessRequestError(c, "Failed to load access request", err)
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
+
+// List lists an org's agent access requests
+// GET /api/v1/orgs/:id/agent-access-requests?status=pending&limit=
+func (h *AccessRequestHandler) List(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	requests, err := h.requests.List(c.Request.Context(), orgID, c.Query("status"), limit)
+	if err != nil {
+		respondInternalError(c, "Failed to list access requests", err)
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// Approve approves a pending request and creates a time-boxed grant
+// POST /api/v1/orgs/:id/agent-access-requests/:requestId/approve
+func (h *AccessRequestHandler) Approve(c *gin.Context) {
+	orgID, requestID, ok := accessRequestRouteIDs(c)
+	if !ok {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		DurationSeconds int    `json:"duration_seconds"`
+		Note            string `json:"note"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	request, err := h.requests.Approve(c.Request.Context(), userID, orgID, requestID, time.Duration(req.DurationSeconds)*time.Second, req.Note, c.ClientIP())
+	if err != nil {
+		respondAccessRequestError(c, "Failed to approve access request", err)
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
+
+// Deny denies a pending request
+// POST /api/v1/orgs/:id/agent-access-requests/:requestId/deny
+func (h *AccessRequestHandler) Deny(c *gin.Context) {
+	orgID, requestID, ok := accessRequestRouteIDs(c)
+	if !ok {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Note string `json:"note"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	request, err := h.requests.Deny(c.Request.Context(), userID, orgID, requestID, req.Note, c.ClientIP())
+	if err != nil {
+		respondAccessRequestError(c, "Failed to deny access request", err)
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
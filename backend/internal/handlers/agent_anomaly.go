@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/envo/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AgentAnomalyHandler handles agent anomaly rules and their alerts
+type AgentAnomalyHandler struct {
+	anomalies *services.AgentAnomalyService
+}
+
+// NewAgentAnomalyHandler creates a new agent anomaly handler
+func NewAgentAnomalyHandler(anomalies *services.AgentAnomalyService) *AgentAnomalyHandler {
+	return &AgentAnomalyHandler{anomalies: anomalies}
+}
+
+// ListRules lists an org's anomaly rules
+// GET /api/v1/orgs/:id/agent-anomaly-rules
+func (h *AgentAnomalyHandler) ListRules(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	rules, err := h.anomalies.ListRules(c.Request.Context(), orgID)
+	if err != nil {
+		respondInternalError(c, "Failed to list anomaly rules", err)
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// CreateRule adds an anomaly rule for one agent or for every agent in the org
+// POST /api/v1/orgs/:id/agent-anomaly-rules
+func (h *AgentAnomalyHandler) CreateRule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	var req struct {
+		AgentID       *uuid.UUID `json:"agent_id"`
+		Name          string     `json:"name" binding:"required"`
+		Kind          string     `json:"kind" binding:"required"`
+		LookbackDays  int        `json:"lookback_days"`
+		Threshold     int        `json:"threshold"`
+		WindowMinutes int        `json:"window_minutes"`
+		SpikeFactor   float64    `json:"spike_factor"`
+		TimeZone      string     `json:"time_zone"`
+		NotifyEmail   *bool      `json:"notify_email"`
+		WebhookURL    string     `json:"webhook_url"`
+		Suspend       bool       `json:"suspend"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and kind are required"})
+		return
+	}
+	notifyEmail := req.NotifyEmail == nil || *req.NotifyEmail
+	rule, secret, err := h.anomalies.CreateRule(c.Request.Context(), userID, orgID, services.AnomalyRuleInput{
+		AgentID:       req.AgentID,
+		Name:          req.Name,
+		Kind:          req.Kind,
+		LookbackDays:  req.LookbackDays,
+		Threshold:     req.Threshold,
+		WindowMinutes: req.WindowMinutes,
+		SpikeFactor:   req.SpikeFactor,
+		TimeZone:      req.TimeZone,
+		NotifyEmail:   notifyEmail,
+		WebhookURL:    req.WebhookURL,
+		Suspend:       req.Suspend,
+	}, c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAnomalyRule):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrAgentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		default:
+			respondInternalError(c, "Failed to create anomaly rule", err)
+		}
+		return
+	}
+	// The webhook signing secret is returned once, next to the rule's fields.
+	c.JSON(http.StatusCreated, struct {
+		*models.AgentAnomalyRule
+		SigningSecret string `json:"signing_secret,omitempty"`
+	}{rule, secret})
+}
+
+// DeleteRule deletes an anomaly rule; its alerts are kept
+// DELETE /api/v1/orgs/:id/agent-anomaly-rules/:ruleId
+func (h *AgentAnomalyHandler) DeleteRule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	ruleID, err := uuid.Parse(c.Param("ruleId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+	if err := h.anomalies.DeleteRule(c.Request.Context(), userID, orgID, ruleID, c.ClientIP()); err != nil {
+		if errors.Is(err, services.ErrAnomalyRuleNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Anomaly rule not found"})
+			return
+		}
+		respondInternalError(c, "Failed to delete anomaly rule", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Anomaly rule deleted"})
+}
+
+// ListAlerts lists an org's anomaly alerts, most recent first
+// GET /api/v1/orgs/:id/agent-anomaly-alerts?agent_id=&limit=
+func (h *AgentAnomalyHandler) ListAlerts(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	var agentID *uuid.UUID
+	if raw := c.Query("agent_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid agent ID"})
+			return
+		}
+		agentID = &id
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	alerts, err := h.anomalies.ListAlerts(c.Request.Context(), orgID, agentID, limit)
+	if err != nil {
+		respondInternalError(c, "Failed to list anomaly alerts", err)
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/envo/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// FederationHandler handles agent trust policies and OIDC token exchange
+type FederationHandler struct {
+	federation *services.FederationService
+}
+
+// NewFederationHandler creates a new federation handler
+func NewFederationHandler(federation *services.FederationService) *FederationHandler {
+	return &FederationHandler{federation: federation}
+}
+
+// ListTrustPolicies lists an agent's trust policies
+// GET /api/v1/orgs/:id/agents/:agentId/trust-policies
+func (h *FederationHandler) ListTrustPolicies(c *gin.Context) {
+	orgID, agentID, ok := agentRouteIDs(c)
+	if !ok {
+		return
+	}
+	policies, err := h.federation.ListTrustPolicies(c.Request.Context(), orgID, agentID)
+	if err != nil {
+		respondInternalError(c, "Failed to list trust policies", err)
+		return
+	}
+	c.JSON(http.StatusOK, policies)
+}
+
+// CreateTrustPolicy trusts tokens from an external OIDC issuer for an agent
+// POST /api/v1/orgs/:id/agents/:agentId/trust-policies
+func (h *FederationHandler) CreateTrustPolicy(c *gin.Context) {
+	orgID, agentID, ok := agentRouteIDs(c)
+	if !ok {
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name                 string            `json:"name" binding:"required"`
+		Issuer               string            `json:"issuer" binding:"required"`
+		Audience             string            `json:"audience" binding:"required"`
+		SubjectPattern       string            `json:"subject_pattern" binding:"required"`
+		ClaimConditions      map[string]string `json:"claim_conditions"`
+		CredentialTTLSeconds int               `json:"credential_ttl_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name, issuer, audience and subject pattern are required"})
+		return
+	}
+	policy, err := h.federation.CreateTrustPolicy(c.Request.Context(), userID, orgID, agentID, services.TrustPolicyInput{
+		Name:            req.Name,
+		Issuer:          req.Issuer,
+		Audience:        req.Audience,
+		SubjectPattern:  req.SubjectPattern,
+		ClaimConditions: req.ClaimConditions,
+		CredentialTTL:   time.Duration(req.CredentialTTLSeconds) * time.Second,
+	}, c.ClientIP())
+	if errors.Is(err, services.ErrAgentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, policy)
+}
+
+// DeleteTrustPolicy stops trusting a policy's tokens
+// DELETE /api/v1/orgs/:id/agents/:agentId/trust-policies/:policyId
+func (h *FederationHandler) DeleteTrustPolicy(c *gin.Context) {
+	orgID, agentID, ok := agentRouteIDs(c)
+	if !ok {
+		return
+	}
+	policyID, err := uuid.Parse(c.Param("policyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trust policy ID"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	if err := h.federation.DeleteTrustPolicy(c.Request.Context(), userID, orgID, agentID, policyID, c.ClientIP()); err != nil {
+		if errors.Is(err, services.ErrTrustPolicyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trust policy not found"})
+			return
+		}
+		respondInternalError(c, "Failed to delete trust policy", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Exchange trades an external OIDC token for a short-lived agent token. It
+// is public: the OIDC token is the credential.
+// POST /api/v1/agent/federation/exchange
+func (h *FederationHandler) Exchange(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 64<<10)
+	var req struct {
+		AgentID uuid.UUID `json:"agent_id" binding:"required"`
+		Token   string    `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Agent ID and token are required"})
+		return
+	}
+	credential, raw, err := h.federation.Exchange(c.Request.Context(), req.AgentID, req.Token, c.ClientIP())
+	if errors.Is(err, services.ErrFederationDenied) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is not trusted for this agent"})
+		return
+	}
+	if err != nil {
+		respondInternalError(c, "Failed to exchange token", err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"agent_id":      credential.AgentID,
+		"credential_id": credential.ID,
+		"token":         raw,
+		"expires_at":    credential.ExpiresAt,
+	})
+}
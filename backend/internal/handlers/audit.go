@@ -1,28 +1,95 @@
 package handlers
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/envo/backend/internal/middleware"
+	"github.com/envo/backend/internal/models"
 	"github.com/envo/backend/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 )
 
 // AuditHandler handles audit log endpoints
 type AuditHandler struct {
 	auditService *services.AuditService
+	auditChain   *services.AuditChainService
 }
 
 // NewAuditHandler creates a new audit handler
-func NewAuditHandler(auditService *services.AuditService) *AuditHandler {
+func NewAuditHandler(auditService *services.AuditService, auditChain *services.AuditChainService) *AuditHandler {
 	return &AuditHandler{
 		auditService: auditService,
+		auditChain:   auditChain,
 	}
 }
 
-// ListOrgAuditLogs lists recent audit logs for an organization
-// GET /api/v1/orgs/:orgId/audit-logs
+// parseUUIDQuery reads an optional UUID query parameter, answering 400 when
+// it is malformed.
+func parseUUIDQuery(c *gin.Context, name string) (*uuid.UUID, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return nil, true
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+		return nil, false
+	}
+	return &id, true
+}
+
+// auditLogFilter reads the search parameters of ListOrgAuditLogs.
+func auditLogFilter(c *gin.Context) (services.AuditLogFilter, bool) {
+	f := services.AuditLogFilter{
+		ActorType:    c.Query("actor_type"),
+		ResourceType: c.Query("resource_type"),
+		IP:           strings.TrimSpace(c.Query("ip")),
+		Query:        strings.TrimSpace(c.Query("q")),
+		Cursor:       c.Query("cursor"),
+	}
+	f.Limit, _ = strconv.Atoi(c.Query("limit"))
+	for _, raw := range c.QueryArray("action") {
+		for _, action := range strings.Split(raw, ",") {
+			if action = strings.TrimSpace(action); action != "" {
+				f.Actions = append(f.Actions, action)
+			}
+		}
+	}
+	var ok bool
+	if f.UserID, ok = parseUUIDQuery(c, "user_id"); !ok {
+		return f, false
+	}
+	if f.AgentID, ok = parseUUIDQuery(c, "agent_id"); !ok {
+		return f, false
+	}
+	if f.ResourceID, ok = parseUUIDQuery(c, "resource_id"); !ok {
+		return f, false
+	}
+	for name, dst := range map[string]**time.Time{"since": &f.Since, "until": &f.Until} {
+		t, ok := parseTimeQuery(c, name)
+		if !ok {
+			return f, false
+		}
+		if !t.IsZero() {
+			*dst = &t
+		}
+	}
+	return f, true
+}
+
+// ListOrgAuditLogs searches an organization's audit logs, most recent first.
+// The cursor for the next page is returned in the X-Next-Cursor header.
+// GET /api/v1/orgs/:orgId/audit-logs?actor_type=&user_id=&agent_id=&action=&resource_type=&resource_id=&since=&until=&ip=&q=&cursor=&limit=
 func (h *AuditHandler) ListOrgAuditLogs(c *gin.Context) {
 	orgID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
@@ -36,12 +103,143 @@ func (h *AuditHandler) ListOrgAuditLogs(c *gin.Context) {
 		return
 	}
 
-	logs, err := h.auditService.ListOrgLogs(orgID, 100)
+	filter, ok := auditLogFilter(c)
+	if !ok {
+		return
+	}
+	page, err := h.auditService.SearchOrgLogs(c.Request.Context(), orgID, filter)
+	if errors.Is(err, services.ErrInvalidAuditFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit logs"})
+		respondInternalError(c, "Failed to list audit logs", err)
+		return
+	}
+
+	if page.NextCursor != "" {
+		c.Header("X-Next-Cursor", page.NextCursor)
+	}
+	c.JSON(http.StatusOK, page.Logs)
+}
+
+// ExportOrgAuditLogs streams an organization's audit log, oldest first, as
+// JSON lines or CSV. It takes the same filters as ListOrgAuditLogs.
+// GET /api/v1/orgs/:orgId/audit-logs/export?format=jsonl|csv
+func (h *AuditHandler) ExportOrgAuditLogs(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "jsonl")
+	if format != "jsonl" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be jsonl or csv"})
+		return
+	}
+	filter, ok := auditLogFilter(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, logs)
+	// Headers are only committed with the first row, so a bad filter can
+	// still be answered with a JSON error.
+	var (
+		started bool
+		written int
+		csvOut  *csv.Writer
+		jsonOut *json.Encoder
+	)
+	begin := func() error {
+		started = true
+		// Large exports outlive the server's write timeout.
+		_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+		filename := fmt.Sprintf("audit-%s-%s.%s", orgID, time.Now().UTC().Format("20060102"), format)
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Header("Cache-Control", "no-store")
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Status(http.StatusOK)
+			csvOut = csv.NewWriter(c.Writer)
+			return csvOut.Write(services.AuditCSVHeader)
+		}
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+		jsonOut = json.NewEncoder(c.Writer)
+		return nil
+	}
+	emit := func(r services.AuditRecord) error {
+		if !started {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		if csvOut != nil {
+			if err := csvOut.Write(r.CSVRow()); err != nil {
+				return err
+			}
+		} else if err := jsonOut.Encode(r); err != nil {
+			return err
+		}
+		if written++; written%500 == 0 {
+			if csvOut != nil {
+				csvOut.Flush()
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	}
+
+	metadata, _ := json.Marshal(gin.H{"format": format, "query": c.Request.URL.RawQuery})
+	if err := h.auditService.Log(c.Request.Context(), userID, orgID, orgID, models.ActionAuditExport, "organization", c.ClientIP(), datatypes.JSON(metadata)); err != nil {
+		respondInternalError(c, "Failed to record audit export", err)
+		return
+	}
+
+	err = h.auditService.ExportOrgLogs(c.Request.Context(), orgID, filter, emit)
+	if err == nil && !started {
+		err = begin()
+	}
+	if err != nil {
+		if started {
+			// The response is already under way; drop the connection so
+			// the client sees a failed download rather than a clean end.
+			log.Printf("[envo] audit export for org %s stopped after %d rows: %v", orgID, written, err)
+			c.Abort()
+			if conn, _, hijackErr := http.NewResponseController(c.Writer).Hijack(); hijackErr == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, services.ErrInvalidAuditFilter) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondInternalError(c, "Failed to export audit logs", err)
+		return
+	}
+	if csvOut != nil {
+		csvOut.Flush()
+	}
 }
 
+// VerifyOrgAuditChain checks an organization's hash-chained audit log and
+// its signed checkpoints, reporting gaps, modified entries and broken links.
+// GET /api/v1/orgs/:orgId/audit-logs/verify
+func (h *AuditHandler) VerifyOrgAuditChain(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	report, err := h.auditChain.Verify(c.Request.Context(), orgID)
+	if err != nil {
+		respondInternalError(c, "Failed to verify audit log", err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/envo/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AuditSinkHandler handles the sinks audit events are forwarded to
+type AuditSinkHandler struct {
+	forwarding *services.AuditForwardingService
+}
+
+// NewAuditSinkHandler creates a new audit sink handler
+func NewAuditSinkHandler(forwarding *services.AuditForwardingService) *AuditSinkHandler {
+	return &AuditSinkHandler{forwarding: forwarding}
+}
+
+func respondAuditSinkError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrAuditSinkNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audit sink not found"})
+	case errors.Is(err, services.ErrInvalidAuditSink):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondInternalError(c, message, err)
+	}
+}
+
+func auditSinkRouteIDs(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	sinkID, err := uuid.Parse(c.Param("sinkId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sink ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orgID, sinkID, true
+}
+
+// List lists an org's audit sinks with their delivery health
+// GET /api/v1/orgs/:id/audit-sinks
+func (h *AuditSinkHandler) List(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	sinks, err := h.forwarding.ListSinks(c.Request.Context(), orgID)
+	if err != nil {
+		respondInternalError(c, "Failed to list audit sinks", err)
+		return
+	}
+	c.JSON(http.StatusOK, sinks)
+}
+
+// Create adds an audit sink; a generated webhook signing secret is returned once
+// POST /api/v1/orgs/:id/audit-sinks
+func (h *AuditSinkHandler) Create(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	var req struct {
+		Name   string                   `json:"name" binding:"required"`
+		Kind   string                   `json:"kind" binding:"required"`
+		Config services.AuditSinkConfig `json:"config"`
+		Secret string                   `json:"secret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and kind are required"})
+		return
+	}
+	sink, secret, err := h.forwarding.CreateSink(c.Request.Context(), userID, orgID, services.AuditSinkInput{
+		Name:   req.Name,
+		Kind:   req.Kind,
+		Config: req.Config,
+		Secret: req.Secret,
+	}, c.ClientIP())
+	if err != nil {
+		respondAuditSinkError(c, "Failed to create audit sink", err)
+		return
+	}
+	if secret != "" {
+		c.JSON(http.StatusCreated, gin.H{"sink": sink, "signing_secret": secret})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"sink": sink})
+}
+
+// Update pauses or resumes an audit sink
+// PATCH /api/v1/orgs/:id/audit-sinks/:sinkId
+func (h *AuditSinkHandler) Update(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, sinkID, ok := auditSinkRouteIDs(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled is required"})
+		return
+	}
+	sink, err := h.forwarding.SetSinkEnabled(c.Request.Context(), userID, orgID, sinkID, *req.Enabled, c.ClientIP())
+	if err != nil {
+		respondAuditSinkError(c, "Failed to update audit sink", err)
+		return
+	}
+	c.JSON(http.StatusOK, sink)
+}
+
+// Delete removes an audit sink
+// DELETE /api/v1/orgs/:id/audit-sinks/:sinkId
+func (h *AuditSinkHandler) Delete(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, sinkID, ok := auditSinkRouteIDs(c)
+	if !ok {
+		return
+	}
+	if err := h.forwarding.DeleteSink(c.Request.Context(), userID, orgID, sinkID, c.ClientIP()); err != nil {
+		respondAuditSinkError(c, "Failed to delete audit sink", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Audit sink deleted"})
+}
@@ -164,7 +164,7 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 	}
 
 	// Handle callback
-	user, accessToken, refreshToken, err := h.authService.HandleCallback(c.Request.Context(), code)
+	user, accessToken, refreshToken, err := h.authService.HandleCallback(c.Request.Context(), code, c.ClientIP())
 	if err != nil {
 		respondInternalError(c, "Failed to authenticate", err)
 		return
@@ -269,7 +269,7 @@ func (h *AuthHandler) CLIExchange(c *gin.Context) {
 	}
 
 	// Generate tokens (reuse existing auth service logic)
-	accessToken, refreshToken, err := h.authService.GenerateTokensForUser(&user)
+	accessToken, refreshToken, err := h.authService.GenerateTokensForUser(c.Request.Context(), &user, c.ClientIP())
 	if err != nil {
 		respondInternalError(c, "Failed to generate tokens", err)
 		return
@@ -295,7 +295,7 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	accessToken, err := h.authService.RefreshAccessToken(c.Request.Context(), req.RefreshToken)
+	accessToken, err := h.authService.RefreshAccessToken(c.Request.Context(), req.RefreshToken, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
 		return
@@ -320,7 +320,7 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 		return
 	}
 
-	if err := h.authService.Logout(c.Request.Context(), req.RefreshToken); err != nil {
+	if err := h.authService.Logout(c.Request.Context(), req.RefreshToken, c.ClientIP()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
 		return
 	}
@@ -121,7 +121,7 @@ func (h *BillingHandler) HandleWebhook(c *gin.Context) {
 		sig = c.GetHeader("Stripe-Signature")
 	}
 
-	if err := h.billingService.HandleWebhook(payload, sig); err != nil {
+	if err := h.billingService.HandleWebhook(c.Request.Context(), payload, sig, c.ClientIP()); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
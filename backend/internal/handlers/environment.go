@@ -124,7 +124,7 @@ func (h *EnvironmentHandler) CreateEnvironment(c *gin.Context) {
 		return
 	}
 
-	env, err := h.envService.CreateEnvironment(projectID, req.Name)
+	env, err := h.envService.CreateEnvironment(c.Request.Context(), user.ID, projectID, req.Name, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create environment"})
 		return
@@ -191,7 +191,7 @@ func (h *EnvironmentHandler) UpdateEnvironment(c *gin.Context) {
 		return
 	}
 
-	updated, err := h.envService.UpdateEnvironment(envID, req.Name)
+	updated, err := h.envService.UpdateEnvironment(c.Request.Context(), user.ID, envID, req.Name, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update environment"})
 		return
@@ -220,7 +220,7 @@ func (h *EnvironmentHandler) DeleteEnvironment(c *gin.Context) {
 		return
 	}
 
-	if err := h.envService.DeleteEnvironment(envID); err != nil {
+	if err := h.envService.DeleteEnvironment(c.Request.Context(), user.ID, envID, c.ClientIP()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete environment"})
 		return
 	}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/envo/backend/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // OrgHandler handles organization endpoints
@@ -40,7 +42,7 @@ func (h *OrgHandler) CreateOrganization(c *gin.Context) {
 		return
 	}
 
-	org, err := h.orgService.CreateOrganization(userID, req.Name)
+	org, err := h.orgService.CreateOrganization(c.Request.Context(), userID, req.Name, c.ClientIP())
 	if err != nil {
 		if err.Error() == "organization limit reached for your tier" {
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
@@ -116,7 +118,8 @@ func (h *OrgHandler) UpdateOrganization(c *gin.Context) {
 	}
 
 	var req struct {
-		Name string `json:"name" binding:"required"`
+		Name                  string `json:"name" binding:"required"`
+		ExcludeExpiredSecrets *bool  `json:"exclude_expired_secrets"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -134,7 +137,7 @@ func (h *OrgHandler) UpdateOrganization(c *gin.Context) {
 		return
 	}
 
-	org, err := h.orgService.UpdateOrganization(orgID, req.Name)
+	org, err := h.orgService.UpdateOrganization(c.Request.Context(), userID, orgID, req.Name, req.ExcludeExpiredSecrets, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organization"})
 		return
@@ -163,7 +166,7 @@ func (h *OrgHandler) DeleteOrganization(c *gin.Context) {
 		return
 	}
 
-	if err := h.orgService.DeleteOrganization(orgID); err != nil {
+	if err := h.orgService.DeleteOrganization(c.Request.Context(), userID, orgID, c.ClientIP()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization"})
 		return
 	}
@@ -210,7 +213,7 @@ func (h *OrgHandler) InviteMember(c *gin.Context) {
 		}
 		roleID = &parsed
 	}
-	invitation, inviteURL, emailWarning, err := h.orgService.InviteMember(orgID, userID, req.Email, roleID, req.Role)
+	invitation, inviteURL, emailWarning, err := h.orgService.InviteMember(c.Request.Context(), orgID, userID, req.Email, roleID, req.Role, c.ClientIP())
 	if err != nil {
 		if err.Error() == "member limit reached for this organization" {
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
@@ -272,7 +275,11 @@ func (h *OrgHandler) UpdateMemberRole(c *gin.Context) {
 		roleID = &parsed
 	}
 
-	member, err := h.orgService.UpdateMemberRole(memberID, roleID, req.Role)
+	member, err := h.orgService.UpdateMemberRole(c.Request.Context(), userID, orgID, memberID, roleID, req.Role, c.ClientIP())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -306,7 +313,11 @@ func (h *OrgHandler) RemoveMember(c *gin.Context) {
 		return
 	}
 
-	if err := h.orgService.RemoveMember(memberID); err != nil {
+	if err := h.orgService.RemoveMember(c.Request.Context(), userID, orgID, memberID, c.ClientIP()); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
 		return
 	}
@@ -346,7 +357,12 @@ func (h *OrgHandler) CreateRole(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
 		return
 	}
-	role, err := h.orgService.CreateRole(orgID, req.Name, req.PermissionNames)
+	userID, err := middleware.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	role, err := h.orgService.CreateRole(c.Request.Context(), userID, orgID, req.Name, req.PermissionNames, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -375,7 +391,12 @@ func (h *OrgHandler) UpdateRole(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
 		return
 	}
-	role, err := h.orgService.UpdateRole(orgID, roleID, req.Name, req.PermissionNames)
+	userID, err := middleware.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	role, err := h.orgService.UpdateRole(c.Request.Context(), userID, orgID, roleID, req.Name, req.PermissionNames, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -409,7 +430,12 @@ func (h *OrgHandler) DeleteRole(c *gin.Context) {
 		}
 		replacement = &parsed
 	}
-	if err := h.orgService.DeleteRole(orgID, roleID, replacement); err != nil {
+	userID, err := middleware.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	if err := h.orgService.DeleteRole(c.Request.Context(), userID, orgID, roleID, replacement, c.ClientIP()); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -450,7 +476,7 @@ func (h *OrgHandler) ResendInvitation(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
 		return
 	}
-	inviteURL, err := h.orgService.ResendInvitation(orgID, inviteID, userID)
+	inviteURL, err := h.orgService.ResendInvitation(c.Request.Context(), orgID, inviteID, userID, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -471,7 +497,12 @@ func (h *OrgHandler) RevokeInvitation(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
 		return
 	}
-	if err := h.orgService.RevokeInvitation(orgID, inviteID); err != nil {
+	userID, err := middleware.GetCurrentUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	if err := h.orgService.RevokeInvitation(c.Request.Context(), userID, orgID, inviteID, c.ClientIP()); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -493,7 +524,7 @@ func (h *OrgHandler) AcceptInvitation(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
 		return
 	}
-	member, err := h.orgService.AcceptInvitation(userID, req.Token)
+	member, err := h.orgService.AcceptInvitation(c.Request.Context(), userID, req.Token, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -530,7 +561,7 @@ func (h *OrgHandler) AcceptMyInvitation(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invite id"})
 		return
 	}
-	member, err := h.orgService.AcceptInvitationByID(userID, inviteID)
+	member, err := h.orgService.AcceptInvitationByID(c.Request.Context(), userID, inviteID, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
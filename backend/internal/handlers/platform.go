@@ -74,7 +74,7 @@ func (h *PlatformHandler) CreateConnection(c *gin.Context) {
 		Name:     req.Name,
 		Token:    req.Token,
 		Metadata: req.Metadata,
-	})
+	}, c.ClientIP())
 	if err != nil {
 		status := http.StatusInternalServerError
 		msg := strings.ToLower(err.Error())
@@ -112,7 +112,7 @@ func (h *PlatformHandler) DeleteConnection(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
 		return
 	}
-	if err := h.platformService.DeleteConnection(c.Request.Context(), userID, id); err != nil {
+	if err := h.platformService.DeleteConnection(c.Request.Context(), userID, id, c.ClientIP()); err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
@@ -162,6 +162,9 @@ func (h *PlatformHandler) SyncEnvironment(c *gin.Context) {
 		TargetProject: req.TargetProjectID,
 		TargetEnv:     req.TargetEnvironment,
 	}, c.ClientIP())
+	if respondPolicyDenied(c, err) {
+		return
+	}
 	if err != nil {
 		msg := strings.ToLower(err.Error())
 		code := http.StatusInternalServerError
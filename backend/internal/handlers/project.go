@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/envo/backend/internal/database"
@@ -9,6 +10,7 @@ import (
 	"github.com/envo/backend/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // ProjectHandler handles project endpoints
@@ -60,7 +62,7 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 		return
 	}
 
-	project, err := h.projectService.CreateProject(orgID, req.Name, req.Description)
+	project, err := h.projectService.CreateProject(c.Request.Context(), user.ID, orgID, req.Name, req.Description, c.ClientIP())
 	if err != nil {
 		if err.Error() == "project limit reached for this organization" {
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
@@ -169,7 +171,7 @@ func (h *ProjectHandler) UpdateProject(c *gin.Context) {
 		return
 	}
 
-	updated, err := h.projectService.UpdateProject(projectID, req.Name, req.Description)
+	updated, err := h.projectService.UpdateProject(c.Request.Context(), user.ID, projectID, req.Name, req.Description, c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
 		return
@@ -206,10 +208,89 @@ func (h *ProjectHandler) DeleteProject(c *gin.Context) {
 		return
 	}
 
-	if err := h.projectService.DeleteProject(projectID); err != nil {
+	if err := h.projectService.DeleteProject(c.Request.Context(), user.ID, projectID, c.ClientIP()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
 }
+
+// GetSecretSchema returns the project's secret schema
+// GET /api/v1/projects/:id/schema
+func (h *ProjectHandler) GetSecretSchema(c *gin.Context) {
+	projectID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	project, err := h.projectService.GetProject(projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	if !userHasAccessToOrg(user, project.OrgID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	schema, err := project.Schema()
+	if err != nil {
+		respondInternalError(c, "Failed to load secret schema", err)
+		return
+	}
+	if schema == nil {
+		schema = &models.SecretSchema{Keys: map[string]models.SecretSchemaRule{}}
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// UpdateSecretSchema replaces the project's secret schema
+// PUT /api/v1/projects/:id/schema
+func (h *ProjectHandler) UpdateSecretSchema(c *gin.Context) {
+	projectID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var schema models.SecretSchema
+	if err := c.ShouldBindJSON(&schema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	updated, err := h.projectService.UpdateSecretSchema(c.Request.Context(), user.ID, projectID, &schema, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSchema) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+		respondInternalError(c, "Failed to update secret schema", err)
+		return
+	}
+	if updated == nil {
+		updated = &models.SecretSchema{Keys: map[string]models.SecretSchemaRule{}}
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/envo/backend/internal/middleware"
+	"github.com/envo/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RotationHandler handles automatic secret rotation endpoints
+type RotationHandler struct {
+	rotationService *services.RotationService
+}
+
+// NewRotationHandler creates a new rotation handler
+func NewRotationHandler(rotationService *services.RotationService) *RotationHandler {
+	return &RotationHandler{
+		rotationService: rotationService,
+	}
+}
+
+func respondRotationError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrRotationNotConfigured):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRotationConfig):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRotationInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSecretReadForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+	default:
+		respondInternalError(c, message, err)
+	}
+}
+
+// GetRotation returns the rotation config for a secret
+// GET /api/v1/secrets/:id/rotation
+func (h *RotationHandler) GetRotation(c *gin.Context) {
+	secretID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret ID"})
+		return
+	}
+
+	cfg, err := h.rotationService.Get(c.Request.Context(), secretID)
+	if err != nil {
+		respondRotationError(c, "Failed to load rotation config", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cfg)
+}
+
+// ConfigureRotation creates or replaces the rotation config for a secret
+// PUT /api/v1/secrets/:id/rotation
+func (h *RotationHandler) ConfigureRotation(c *gin.Context) {
+	secretID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret ID"})
+		return
+	}
+
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var req struct {
+		Rotator           string `json:"rotator" binding:"required"`
+		Strategy          string `json:"strategy"`
+		AdminSecretID     string `json:"admin_secret_id" binding:"required"`
+		Username          string `json:"username" binding:"required"`
+		AlternateUsername string `json:"alternate_username"`
+		IntervalDays      int    `json:"interval_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	adminSecretID, err := uuid.Parse(req.AdminSecretID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid admin_secret_id"})
+		return
+	}
+
+	cfg, err := h.rotationService.Configure(c.Request.Context(), user.ID, secretID, services.RotationConfigInput{
+		Rotator:       req.Rotator,
+		Strategy:      req.Strategy,
+		AdminSecretID: adminSecretID,
+		Username:      req.Username,
+		AlternateUser: req.AlternateUsername,
+		IntervalDays:  req.IntervalDays,
+	}, c.ClientIP())
+	if err != nil {
+		respondRotationError(c, "Failed to configure rotation", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cfg)
+}
+
+// DisableRotation removes the rotation config for a secret
+// DELETE /api/v1/secrets/:id/rotation
+func (h *RotationHandler) DisableRotation(c *gin.Context) {
+	secretID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret ID"})
+		return
+	}
+
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	if err := h.rotationService.Disable(c.Request.Context(), user.ID, secretID, c.ClientIP()); err != nil {
+		respondRotationError(c, "Failed to disable rotation", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rotation disabled"})
+}
+
+// RotateSecret rotates a secret immediately
+// POST /api/v1/secrets/:id/rotate
+func (h *RotationHandler) RotateSecret(c *gin.Context) {
+	secretID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret ID"})
+		return
+	}
+
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	cfg, err := h.rotationService.RotateNow(c.Request.Context(), user.ID, secretID, c.ClientIP())
+	if err != nil {
+		if cfg != nil && !errors.Is(err, services.ErrRotationInProgress) && !errors.Is(err, services.ErrInvalidRotationConfig) {
+			// The rotation ran and failed; the config carries the recorded error.
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Rotation failed", "rotation": cfg})
+			return
+		}
+		respondRotationError(c, "Failed to rotate secret", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, cfg)
+}
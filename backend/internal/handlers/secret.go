@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/envo/backend/internal/database"
 	"github.com/envo/backend/internal/middleware"
@@ -9,6 +13,7 @@ import (
 	"github.com/envo/backend/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // SecretHandler handles secret endpoints
@@ -36,6 +41,80 @@ func userHasAccessToEnv(user *models.User, envID uuid.UUID) (uuid.UUID, bool) {
 	return uuid.Nil, false
 }
 
+// secretMetadataRequest holds the optional metadata fields shared by create
+// and update. An empty owner_user_id or expires_at, or a zero
+// rotation_interval_days, clears that setting.
+type secretMetadataRequest struct {
+	Description          *string   `json:"description"`
+	Tags                 *[]string `json:"tags"`
+	OwnerUserID          *string   `json:"owner_user_id"`
+	OwnerTeam            *string   `json:"owner_team"`
+	TypeHint             *string   `json:"type_hint"`
+	Sensitive            *bool     `json:"sensitive"`
+	ExpiresAt            *string   `json:"expires_at"`
+	RotationIntervalDays *int      `json:"rotation_interval_days"`
+}
+
+func (r secretMetadataRequest) empty() bool {
+	return r.Description == nil && r.Tags == nil && r.OwnerUserID == nil &&
+		r.OwnerTeam == nil && r.TypeHint == nil && r.Sensitive == nil &&
+		r.ExpiresAt == nil && r.RotationIntervalDays == nil
+}
+
+func (r secretMetadataRequest) toInput() (services.SecretMetadataInput, error) {
+	in := services.SecretMetadataInput{
+		Description:          r.Description,
+		Tags:                 r.Tags,
+		OwnerTeam:            r.OwnerTeam,
+		TypeHint:             r.TypeHint,
+		Sensitive:            r.Sensitive,
+		RotationIntervalDays: r.RotationIntervalDays,
+	}
+	if r.OwnerUserID != nil {
+		owner := uuid.Nil
+		if *r.OwnerUserID != "" {
+			parsed, err := uuid.Parse(*r.OwnerUserID)
+			if err != nil {
+				return in, fmt.Errorf("invalid owner_user_id")
+			}
+			owner = parsed
+		}
+		in.OwnerUserID = &owner
+	}
+	if r.ExpiresAt != nil {
+		var expiresAt time.Time
+		if *r.ExpiresAt != "" {
+			parsed, err := time.Parse(time.RFC3339, *r.ExpiresAt)
+			if err != nil {
+				return in, fmt.Errorf("invalid expires_at; use RFC 3339")
+			}
+			expiresAt = parsed
+		}
+		in.ExpiresAt = &expiresAt
+	}
+	return in, nil
+}
+
+// respondSecretWriteError answers client errors from CreateSecret and
+// UpdateSecret and reports whether it did.
+func respondSecretWriteError(c *gin.Context, err error) bool {
+	var schemaErr *services.SchemaValidationError
+	if respondPolicyDenied(c, err) {
+		return true
+	}
+	switch {
+	case errors.As(err, &schemaErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": services.ErrSchemaViolation.Error(), "violations": schemaErr.Violations})
+	case errors.Is(err, services.ErrInvalidSecretMetadata), errors.Is(err, services.ErrInvalidSchema):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSecretLimitReached):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
 // CreateSecret creates a new secret
 // POST /api/v1/environments/:envId/secrets
 func (h *SecretHandler) CreateSecret(c *gin.Context) {
@@ -59,6 +138,7 @@ func (h *SecretHandler) CreateSecret(c *gin.Context) {
 	var req struct {
 		Key   string `json:"key" binding:"required"`
 		Value string `json:"value" binding:"required"`
+		secretMetadataRequest
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -67,10 +147,14 @@ func (h *SecretHandler) CreateSecret(c *gin.Context) {
 	}
 
 	ip := c.ClientIP()
-	secret, wasUpdate, err := h.secretService.CreateSecret(c.Request.Context(), user.ID, envID, req.Key, req.Value, ip)
+	meta, err := req.toInput()
 	if err != nil {
-		if err.Error() == "secret limit reached for this environment" {
-			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, wasUpdate, err := h.secretService.CreateSecret(c.Request.Context(), services.UserActor(user.ID), envID, req.Key, req.Value, meta, ip)
+	if err != nil {
+		if respondSecretWriteError(c, err) {
 			return
 		}
 		respondInternalError(c, "Failed to create secret", err)
@@ -84,7 +168,9 @@ func (h *SecretHandler) CreateSecret(c *gin.Context) {
 	c.JSON(status, secret)
 }
 
-// ListSecrets lists secrets (metadata) for an environment
+// ListSecrets lists secrets for an environment. Secrets marked non-sensitive
+// come with their values, which secrets:read already allows; the service
+// audits those keys as a secret_read.
 // GET /api/v1/environments/:envId/secrets
 func (h *SecretHandler) ListSecrets(c *gin.Context) {
 	envID, err := uuid.Parse(c.Param("id"))
@@ -104,8 +190,12 @@ func (h *SecretHandler) ListSecrets(c *gin.Context) {
 		return
 	}
 
-	secrets, err := h.secretService.ListSecrets(c.Request.Context(), envID)
+	secrets, err := h.secretService.ListSecrets(c.Request.Context(), user.ID, envID, c.QueryArray("tag"), c.ClientIP())
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidSecretMetadata) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list secrets"})
 		return
 	}
@@ -144,6 +234,7 @@ func (h *SecretHandler) UpdateSecret(c *gin.Context) {
 	var req struct {
 		Key   *string `json:"key"`
 		Value *string `json:"value"`
+		secretMetadataRequest
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -151,14 +242,22 @@ func (h *SecretHandler) UpdateSecret(c *gin.Context) {
 		return
 	}
 
-	if req.Key == nil && req.Value == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one of key or value must be provided"})
+	if req.Key == nil && req.Value == nil && req.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one of key, value, or a metadata field must be provided"})
 		return
 	}
 
 	ip := c.ClientIP()
-	updated, err := h.secretService.UpdateSecret(c.Request.Context(), user.ID, secretID, req.Key, req.Value, ip)
+	meta, err := req.toInput()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated, err := h.secretService.UpdateSecret(c.Request.Context(), user.ID, secretID, req.Key, req.Value, meta, ip)
 	if err != nil {
+		if respondSecretWriteError(c, err) {
+			return
+		}
 		respondInternalError(c, "Failed to update secret", err)
 		return
 	}
@@ -196,6 +295,9 @@ func (h *SecretHandler) DeleteSecret(c *gin.Context) {
 
 	ip := c.ClientIP()
 	if err := h.secretService.DeleteSecret(c.Request.Context(), user.ID, secretID, ip); err != nil {
+		if respondPolicyDenied(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete secret"})
 		return
 	}
@@ -232,6 +334,9 @@ func (h *SecretHandler) PurgeSecret(c *gin.Context) {
 
 	ip := c.ClientIP()
 	if err := h.secretService.PurgeSecret(c.Request.Context(), user.ID, secretID, ip); err != nil {
+		if respondPolicyDenied(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge secret"})
 		return
 	}
@@ -260,15 +365,105 @@ func (h *SecretHandler) ExportEnvironmentSecrets(c *gin.Context) {
 	}
 
 	ip := c.ClientIP()
-	secrets, orgID, err := h.secretService.ExportEnvironmentSecrets(c.Request.Context(), user.ID, envID, ip)
+	secrets, skipped, orgID, err := h.secretService.ExportEnvironmentSecrets(c.Request.Context(), user.ID, envID, ip)
+	if respondPolicyDenied(c, err) {
+		return
+	}
 	if err != nil {
 		respondInternalError(c, "Failed to export secrets", err)
 		return
 	}
+	if skipped == nil {
+		skipped = []services.SkippedSecret{}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"org_id":         orgID,
 		"environment_id": envID,
 		"secrets":        secrets,
+		"skipped_keys":   skipped,
 	})
 }
+
+// EncryptionHealth reports which secrets in a workspace are stored under
+// which key and which currently fail to decrypt
+// GET /api/v1/orgs/:id/encryption/health
+func (h *SecretHandler) EncryptionHealth(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	report, err := h.secretService.EncryptionHealth(c.Request.Context(), user.ID, orgID, c.ClientIP())
+	if err != nil {
+		respondInternalError(c, "Failed to check encryption health", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ValidateProjectSecrets reports schema violations for every environment of a project
+// GET /api/v1/projects/:id/schema/report?environment=<id or name>
+func (h *SecretHandler) ValidateProjectSecrets(c *gin.Context) {
+	projectID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	user, err := middleware.GetCurrentUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	report, err := h.secretService.ValidateProjectSecrets(c.Request.Context(), user.ID, projectID, c.Query("environment"), c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSchemaNotDefined):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project or environment not found"})
+		default:
+			respondInternalError(c, "Failed to validate secrets", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// StaleSecrets lists secrets that are expired or due for rotation
+// GET /api/v1/orgs/:id/secrets/stale?within_days=30
+func (h *SecretHandler) StaleSecrets(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	withinDays := 30
+	if raw := c.Query("within_days"); raw != "" {
+		withinDays, err = strconv.Atoi(raw)
+		if err != nil || withinDays < 0 || withinDays > 365 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "within_days must be between 0 and 365"})
+			return
+		}
+	}
+
+	report, err := h.secretService.StaleSecrets(c.Request.Context(), orgID, withinDays)
+	if err != nil {
+		respondInternalError(c, "Failed to build stale secrets report", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/envo/backend/internal/models"
@@ -25,7 +26,14 @@ func AgentAuthMiddleware(agentService *services.AgentService) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		agent, credential, err := agentService.AuthenticateToken(c.Request.Context(), raw)
+		// ClientIP honours the router's trusted proxies, so forwarded headers
+		// from untrusted peers cannot satisfy a credential's network condition.
+		agent, credential, err := agentService.AuthenticateToken(c.Request.Context(), raw, c.ClientIP())
+		if errors.Is(err, services.ErrAgentCredentialRestricted) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired agent token"})
 			c.Abort()
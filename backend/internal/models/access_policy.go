@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Actions an access policy can apply to. Each is evaluated once per secret
+// the request touches.
+const (
+	// PolicyActionSecretsResolve is an agent resolving secrets.
+	PolicyActionSecretsResolve = "secrets.resolve"
+	// PolicyActionSecretsExport is a human exporting an environment's values.
+	PolicyActionSecretsExport = "secrets.export"
+	// PolicyActionSecretsWrite is a human or agent creating or changing a secret.
+	PolicyActionSecretsWrite = "secrets.write"
+	// PolicyActionSecretsDelete is a human deleting or purging a secret.
+	PolicyActionSecretsDelete = "secrets.delete"
+)
+
+// Access policy modes. An audit policy only records what it would have denied.
+const (
+	PolicyModeEnforce = "enforce"
+	PolicyModeAudit   = "audit"
+)
+
+// AccessPolicy is an org-defined deny rule written in CEL. It is evaluated
+// after the built-in permission and grant checks, and a request is denied
+// when Condition evaluates to true. Actions is a JSON string array; empty
+// applies the policy to every action.
+type AccessPolicy struct {
+	ID          uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID       uuid.UUID      `gorm:"type:uuid;not null;index" json:"org_id"`
+	Name        string         `gorm:"type:varchar(120);not null" json:"name"`
+	Description string         `gorm:"type:text;not null;default:''" json:"description"`
+	Actions     datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"actions"`
+	Condition   string         `gorm:"type:text;not null" json:"condition"`
+	Mode        string         `gorm:"type:varchar(20);not null;default:enforce" json:"mode"`
+	Enabled     bool           `gorm:"not null;default:true" json:"enabled"`
+	CreatedBy   uuid.UUID      `gorm:"type:uuid;not null" json:"created_by"`
+	UpdatedBy   uuid.UUID      `gorm:"type:uuid;not null" json:"updated_by"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (p *AccessPolicy) BeforeCreate(_ *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	if len(p.Actions) == 0 {
+		p.Actions = datatypes.JSON([]byte("[]"))
+	}
+	return nil
+}
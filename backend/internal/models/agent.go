@@ -14,6 +14,12 @@ const (
 	AgentStatusRevoked   = "revoked"
 
 	AgentCapabilitySecretsInject = "secrets.inject"
+	// AgentCapabilitySecretsWrite lets an agent create or overwrite the
+	// secrets its key scope covers, e.g. a rotation bot.
+	AgentCapabilitySecretsWrite = "secrets.write"
+	// AgentCapabilityDatabaseCredentials mints a short-lived PostgreSQL role
+	// per resolve lease instead of handing out a stored password.
+	AgentCapabilityDatabaseCredentials = "database.credentials"
 )
 
 // AgentIdentity is a non-human identity owned by an organization.
@@ -29,6 +35,13 @@ type AgentIdentity struct {
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 
+	// MaxCredentialAgeDays is the agent's rotation policy: a credential
+	// stops working this many days after it was issued and is then revoked.
+	// Zero means credentials live until they expire or are revoked.
+	MaxCredentialAgeDays int `gorm:"not null;default:0" json:"max_credential_age_days"`
+	// Quota caps resolves by any of the agent's credentials.
+	Quota AgentQuota `gorm:"embedded;embeddedPrefix:quota_" json:"quota"`
+
 	Organization Organization      `gorm:"foreignKey:OrgID" json:"organization,omitempty"`
 	Creator      User              `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
 	Credentials  []AgentCredential `gorm:"foreignKey:AgentID" json:"credentials,omitempty"`
@@ -57,36 +70,148 @@ type AgentCredential struct {
 	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
 	RevokedAt   *time.Time `gorm:"index" json:"revoked_at,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
+	// TrustPolicyID is set on short-lived credentials issued by exchanging
+	// an external OIDC token under that policy.
+	TrustPolicyID *uuid.UUID `gorm:"type:uuid;index" json:"trust_policy_id,omitempty"`
+	// SupersededBy is the successor issued when this credential was rotated.
+	// The credential keeps working until ExpiresAt, the end of the overlap,
+	// and is then revoked automatically.
+	SupersededBy *uuid.UUID `gorm:"type:uuid;index" json:"superseded_by,omitempty"`
+	// ExpiryNotifiedAt is when the agent's managers were warned that the
+	// rotation policy is about to retire the credential.
+	ExpiryNotifiedAt *time.Time `json:"-"`
+
+	// Optional use conditions, checked on every request. AllowedCIDRs is a
+	// JSON array of networks and TimeWindows a JSON array of
+	// CredentialTimeWindow in TimeZone; empty means unrestricted. UseCount is
+	// only tracked when MaxUses is set.
+	AllowedCIDRs datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"allowed_cidrs"`
+	TimeWindows  datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"time_windows"`
+	TimeZone     string         `gorm:"type:varchar(64);not null;default:'UTC'" json:"time_zone"`
+	MaxUses      *int           `json:"max_uses,omitempty"`
+	UseCount     int            `gorm:"not null;default:0" json:"use_count"`
+	// Quota caps resolves with this credential, on top of the agent's.
+	Quota AgentQuota `gorm:"embedded;embeddedPrefix:quota_" json:"quota"`
 
 	Agent AgentIdentity `gorm:"foreignKey:AgentID" json:"-"`
 }
 
+// AgentQuota caps secret resolves over rolling windows: resolves in the last
+// hour and day, and distinct environment keys delivered in the last day.
+// Zero means unlimited.
+type AgentQuota struct {
+	ResolvesPerHour    int `gorm:"not null;default:0" json:"resolves_per_hour"`
+	ResolvesPerDay     int `gorm:"not null;default:0" json:"resolves_per_day"`
+	DistinctKeysPerDay int `gorm:"not null;default:0" json:"distinct_keys_per_day"`
+}
+
+// CredentialTimeWindow is a recurring period in which a credential may be
+// used. Days are lowercase three-letter weekday names (every day when empty)
+// and Start and End are "HH:MM". A window whose End is not after its Start
+// runs past midnight.
+type CredentialTimeWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
 func (c *AgentCredential) BeforeCreate(_ *gorm.DB) error {
 	if c.ID == uuid.Nil {
 		c.ID = uuid.New()
 	}
+	if len(c.AllowedCIDRs) == 0 {
+		c.AllowedCIDRs = datatypes.JSON([]byte("[]"))
+	}
+	if len(c.TimeWindows) == 0 {
+		c.TimeWindows = datatypes.JSON([]byte("[]"))
+	}
+	if c.TimeZone == "" {
+		c.TimeZone = "UTC"
+	}
 	return nil
 }
 
-// AgentGrant authorizes one capability for one environment. AllowedKeys is a
-// JSON string array. AllowAllSecrets must be explicit; an empty key list never
-// grants access by itself.
-type AgentGrant struct {
+// AgentTrustPolicy lets a workload such as a CI job sign in as an agent with
+// a JWT from an external OIDC issuer instead of a stored token. The JWT must
+// be signed by a key in the issuer's JWKS, name Audience, and have a subject
+// matching SubjectPattern. ClaimConditions is a JSON object of claim names to
+// `*` glob patterns (e.g. {"repository": "acme/api", "ref": "refs/heads/main"}),
+// all of which must match. An exchange issues a credential that expires after
+// CredentialTTL seconds.
+type AgentTrustPolicy struct {
 	ID              uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID           uuid.UUID      `gorm:"type:uuid;not null;index" json:"org_id"`
 	AgentID         uuid.UUID      `gorm:"type:uuid;not null;index" json:"agent_id"`
-	EnvironmentID   uuid.UUID      `gorm:"type:uuid;not null;index" json:"environment_id"`
-	Capability      string         `gorm:"type:varchar(50);not null;default:secrets.inject" json:"capability"`
-	AllowedKeys     datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"allowed_keys"`
-	AllowAllSecrets bool           `gorm:"not null;default:false" json:"allow_all_secrets"`
-	ExpiresAt       *time.Time     `gorm:"index" json:"expires_at,omitempty"`
-	RevokedAt       *time.Time     `gorm:"index" json:"revoked_at,omitempty"`
+	Name            string         `gorm:"type:varchar(120);not null" json:"name"`
+	Issuer          string         `gorm:"type:varchar(500);not null;index" json:"issuer"`
+	Audience        string         `gorm:"type:varchar(255);not null" json:"audience"`
+	SubjectPattern  string         `gorm:"type:varchar(500);not null" json:"subject_pattern"`
+	ClaimConditions datatypes.JSON `gorm:"type:jsonb;not null;default:'{}'" json:"claim_conditions"`
+	CredentialTTL   int            `gorm:"not null;default:0" json:"credential_ttl_seconds"` // seconds
+	LastUsedAt      *time.Time     `json:"last_used_at,omitempty"`
 	CreatedBy       uuid.UUID      `gorm:"type:uuid;not null;index" json:"created_by"`
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 
+	Agent AgentIdentity `gorm:"foreignKey:AgentID" json:"-"`
+}
+
+func (p *AgentTrustPolicy) BeforeCreate(_ *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	if len(p.ClaimConditions) == 0 {
+		p.ClaimConditions = datatypes.JSON([]byte("{}"))
+	}
+	return nil
+}
+
+// AgentGrant authorizes one capability for one environment, or for every
+// environment of a project whose name matches EnvironmentPattern (a
+// case-insensitive `*` glob; all of them when empty), including environments
+// created later. Exactly one of EnvironmentID and ProjectID is set.
+//
+// AllowedKeys and DeniedKeys are JSON string arrays of key names or `*` glob
+// patterns; a key matching a deny pattern is never delivered by this grant.
+// AllowAllSecrets must be explicit; an empty key list never grants access by
+// itself.
+//
+// Database credential grants instead name an admin connection secret in the
+// same organization, the key the minted connection URL is returned under, a
+// SQL grant template run for each new role ({{role}} is replaced with the
+// quoted role name), and how long each role stays valid.
+type AgentGrant struct {
+	ID                 uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	AgentID            uuid.UUID      `gorm:"type:uuid;not null;index" json:"agent_id"`
+	EnvironmentID      *uuid.UUID     `gorm:"type:uuid;index" json:"environment_id,omitempty"`
+	ProjectID          *uuid.UUID     `gorm:"type:uuid;index" json:"project_id,omitempty"`
+	EnvironmentPattern string         `gorm:"type:varchar(100);not null;default:''" json:"environment_pattern,omitempty"`
+	Capability         string         `gorm:"type:varchar(50);not null;default:secrets.inject" json:"capability"`
+	AllowedKeys        datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"allowed_keys"`
+	DeniedKeys         datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"denied_keys"`
+	AllowAllSecrets    bool           `gorm:"not null;default:false" json:"allow_all_secrets"`
+	AdminSecretID      *uuid.UUID     `gorm:"type:uuid;index" json:"admin_secret_id,omitempty"`
+	CredentialKey      string         `gorm:"type:varchar(255);not null;default:''" json:"credential_key,omitempty"`
+	GrantTemplate      string         `gorm:"type:text;not null;default:''" json:"grant_template,omitempty"`
+	CredentialTTL      int            `gorm:"not null;default:0" json:"credential_ttl_seconds,omitempty"` // seconds
+	ExpiresAt          *time.Time     `gorm:"index" json:"expires_at,omitempty"`
+	RevokedAt          *time.Time     `gorm:"index" json:"revoked_at,omitempty"`
+	CreatedBy          uuid.UUID      `gorm:"type:uuid;not null;index" json:"created_by"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// MatchedKeys lists the environment's current keys the grant covers. It is
+	// filled in when grants are listed for review, never stored.
+	MatchedKeys []string `gorm:"-" json:"matched_keys,omitempty"`
+	// MatchedEnvironments names the environments a project grant currently
+	// covers. Like MatchedKeys it is only filled in for review.
+	MatchedEnvironments []string `gorm:"-" json:"matched_environments,omitempty"`
+
 	Agent       AgentIdentity `gorm:"foreignKey:AgentID" json:"-"`
-	Environment Environment   `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
+	Environment *Environment  `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
+	Project     *Project      `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
 	Creator     User          `gorm:"foreignKey:CreatedBy" json:"-"`
 }
 
@@ -100,5 +225,95 @@ func (g *AgentGrant) BeforeCreate(_ *gorm.DB) error {
 	if len(g.AllowedKeys) == 0 {
 		g.AllowedKeys = datatypes.JSON([]byte("[]"))
 	}
+	if len(g.DeniedKeys) == 0 {
+		g.DeniedKeys = datatypes.JSON([]byte("[]"))
+	}
+	return nil
+}
+
+// AgentLease records one resolve: which agent and credential received which
+// keys from which environment under which grants. The lease ID is returned
+// to the agent, which may renew it before ExpiresAt while its grants are
+// still live. Revoking a lease cannot recall values already delivered, but it
+// ends renewal and drops any dynamic database roles bound to it.
+type AgentLease struct {
+	ID            uuid.UUID      `gorm:"type:uuid;primaryKey" json:"id"`
+	OrgID         uuid.UUID      `gorm:"type:uuid;not null;index" json:"org_id"`
+	AgentID       uuid.UUID      `gorm:"type:uuid;not null;index" json:"agent_id"`
+	CredentialID  uuid.UUID      `gorm:"type:uuid;not null;index" json:"credential_id"`
+	EnvironmentID uuid.UUID      `gorm:"type:uuid;not null;index" json:"environment_id"`
+	GrantIDs      datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"grant_ids"`
+	Keys          datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"keys"`
+	Purpose       string         `gorm:"type:varchar(200);not null;default:''" json:"purpose,omitempty"`
+	SessionID     string         `gorm:"type:varchar(200);not null;default:''" json:"session_id,omitempty"`
+	IPAddress     string         `gorm:"type:varchar(45)" json:"ip_address,omitempty"`
+	TTLSeconds    int            `gorm:"not null" json:"ttl_seconds"`
+	ExpiresAt     time.Time      `gorm:"not null;index" json:"expires_at"`
+	RenewedAt     *time.Time     `json:"renewed_at,omitempty"`
+	RenewCount    int            `gorm:"not null;default:0" json:"renew_count"`
+	RevokedAt     *time.Time     `gorm:"index" json:"revoked_at,omitempty"`
+	RevokedBy     *uuid.UUID     `gorm:"type:uuid" json:"revoked_by,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+
+	Agent       AgentIdentity `gorm:"foreignKey:AgentID" json:"agent,omitempty"`
+	Environment Environment   `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
+}
+
+func (l *AgentLease) BeforeCreate(_ *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsActive reports whether the lease can still be renewed.
+func (l *AgentLease) IsActive(now time.Time) bool {
+	return l.RevokedAt == nil && l.ExpiresAt.After(now)
+}
+
+const (
+	AccessRequestStatusPending  = "pending"
+	AccessRequestStatusApproved = "approved"
+	AccessRequestStatusDenied   = "denied"
+	AccessRequestStatusExpired  = "expired"
+)
+
+// AgentAccessRequest is an agent asking a human for a time-boxed
+// secrets.inject grant. Approval creates the grant and links it here;
+// requests nobody decides on expire at ExpiresAt.
+type AgentAccessRequest struct {
+	ID              uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID           uuid.UUID      `gorm:"type:uuid;not null;index" json:"org_id"`
+	AgentID         uuid.UUID      `gorm:"type:uuid;not null;index" json:"agent_id"`
+	CredentialID    uuid.UUID      `gorm:"type:uuid;not null" json:"credential_id"`
+	EnvironmentID   uuid.UUID      `gorm:"type:uuid;not null;index" json:"environment_id"`
+	Keys            datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"keys"`
+	AllowAllSecrets bool           `gorm:"not null;default:false" json:"allow_all_secrets"`
+	Purpose         string         `gorm:"type:varchar(200);not null" json:"purpose"`
+	DurationSeconds int            `gorm:"not null" json:"duration_seconds"`
+	Status          string         `gorm:"type:varchar(20);not null;default:pending;index" json:"status"`
+	ExpiresAt       time.Time      `gorm:"not null;index" json:"expires_at"`
+	DecidedBy       *uuid.UUID     `gorm:"type:uuid" json:"decided_by,omitempty"`
+	DecidedAt       *time.Time     `json:"decided_at,omitempty"`
+	DecisionNote    string         `gorm:"type:varchar(500);not null;default:''" json:"decision_note,omitempty"`
+	GrantID         *uuid.UUID     `gorm:"type:uuid" json:"grant_id,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+
+	Agent       AgentIdentity `gorm:"foreignKey:AgentID" json:"agent,omitempty"`
+	Environment Environment   `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
+	Grant       *AgentGrant   `gorm:"foreignKey:GrantID" json:"grant,omitempty"`
+}
+
+func (r *AgentAccessRequest) BeforeCreate(_ *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	if r.Status == "" {
+		r.Status = AccessRequestStatusPending
+	}
+	if len(r.Keys) == 0 {
+		r.Keys = datatypes.JSON([]byte("[]"))
+	}
 	return nil
 }
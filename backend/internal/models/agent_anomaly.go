@@ -0,0 +1,104 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Anomaly rule kinds. Each compares one agent resolve against the agent's
+// own resolves over the rule's lookback.
+const (
+	// AnomalyNewIP fires on a resolve from an address the agent has not used.
+	AnomalyNewIP = "new_ip"
+	// AnomalyNewEnvironment fires on the agent's first resolve of an environment.
+	AnomalyNewEnvironment = "new_environment"
+	// AnomalyNewKeys fires when a resolve delivers keys the agent has not
+	// received from that environment before.
+	AnomalyNewKeys = "new_keys"
+	// AnomalyUnusualHour fires on a resolve in an hour of the day, in the
+	// rule's time zone, in which the agent has not been active.
+	AnomalyUnusualHour = "unusual_hour"
+	// AnomalyResolveSpike fires when resolves within a window reach a
+	// threshold and, optionally, a multiple of the agent's usual rate.
+	AnomalyResolveSpike = "resolve_spike"
+)
+
+// AgentAnomalyRule watches the resolves of one agent, or of every agent in
+// the organization when AgentID is nil. When it fires it emails the agent's
+// managers, posts to WebhookURL, and suspends the agent if Suspend is set.
+// Threshold, WindowMinutes and SpikeFactor apply to resolve_spike and
+// TimeZone to unusual_hour.
+type AgentAnomalyRule struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"org_id"`
+	AgentID       *uuid.UUID `gorm:"type:uuid;index" json:"agent_id,omitempty"`
+	Name          string     `gorm:"type:varchar(120);not null" json:"name"`
+	Kind          string     `gorm:"type:varchar(30);not null" json:"kind"`
+	LookbackDays  int        `gorm:"not null;default:30" json:"lookback_days"`
+	Threshold     int        `gorm:"not null;default:0" json:"threshold,omitempty"`
+	WindowMinutes int        `gorm:"not null;default:0" json:"window_minutes,omitempty"`
+	SpikeFactor   float64    `gorm:"not null;default:0" json:"spike_factor,omitempty"`
+	TimeZone      string     `gorm:"type:varchar(64);not null;default:'UTC'" json:"time_zone"`
+	NotifyEmail   bool       `gorm:"not null;default:true" json:"notify_email"`
+	WebhookURL    string     `gorm:"type:varchar(500);not null;default:''" json:"webhook_url,omitempty"`
+	// EncryptedWebhookSecret signs webhook deliveries; it is shown once, when
+	// the rule is created. WebhookKeyID names the key that encrypted it.
+	EncryptedWebhookSecret string         `gorm:"type:text;not null;default:''" json:"-"`
+	WebhookKeyID           string         `gorm:"type:varchar(120);not null;default:''" json:"-"`
+	Suspend                bool           `gorm:"not null;default:false" json:"suspend"`
+	CreatedBy              uuid.UUID      `gorm:"type:uuid;not null" json:"created_by"`
+	CreatedAt              time.Time      `json:"created_at"`
+	UpdatedAt              time.Time      `json:"updated_at"`
+	DeletedAt              gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (r *AgentAnomalyRule) BeforeCreate(_ *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	if r.TimeZone == "" {
+		r.TimeZone = "UTC"
+	}
+	return nil
+}
+
+// AgentAnomalyAlert records a rule firing on one audit event. Detail holds
+// what was unusual, such as the new address or keys.
+type AgentAnomalyAlert struct {
+	ID         uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID      uuid.UUID      `gorm:"type:uuid;not null;index" json:"org_id"`
+	AgentID    uuid.UUID      `gorm:"type:uuid;not null;index" json:"agent_id"`
+	RuleID     uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_agent_anomaly_alert_event,priority:1" json:"rule_id"`
+	AuditLogID uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_agent_anomaly_alert_event,priority:2" json:"audit_log_id"`
+	Kind       string         `gorm:"type:varchar(30);not null" json:"kind"`
+	Detail     datatypes.JSON `gorm:"type:jsonb;not null;default:'{}'" json:"detail"`
+	EventAt    time.Time      `gorm:"not null;index" json:"event_at"`
+	Suspended  bool           `gorm:"not null;default:false" json:"suspended"`
+	CreatedAt  time.Time      `json:"created_at"`
+
+	Agent AgentIdentity    `gorm:"foreignKey:AgentID" json:"agent,omitempty"`
+	Rule  AgentAnomalyRule `gorm:"foreignKey:RuleID" json:"rule,omitempty"`
+}
+
+func (a *AgentAnomalyAlert) BeforeCreate(_ *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	if len(a.Detail) == 0 {
+		a.Detail = datatypes.JSON([]byte("{}"))
+	}
+	return nil
+}
+
+// AgentAnomalyCursor is the anomaly detector's position in the audit log.
+// There is a single row; the detector locks it while it scans so only one
+// API instance evaluates each event.
+type AgentAnomalyCursor struct {
+	ID             int       `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	LastCreatedAt  time.Time `gorm:"not null" json:"last_created_at"`
+	LastAuditLogID uuid.UUID `gorm:"type:uuid;not null" json:"last_audit_log_id"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
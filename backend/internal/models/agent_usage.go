@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AgentUsageRollup counts one agent's resolves of one environment for one
+// purpose within a UTC hour. Rows are rebuilt from secret_read audit events,
+// so they can be recomputed at any time and outlive audit retention.
+type AgentUsageRollup struct {
+	ID               uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID            uuid.UUID `gorm:"type:uuid;not null;index:idx_agent_usage_org_bucket,priority:1" json:"org_id"`
+	AgentID          uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_agent_usage_bucket,priority:1" json:"agent_id"`
+	EnvironmentID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_agent_usage_bucket,priority:2" json:"environment_id"`
+	Purpose          string    `gorm:"type:varchar(200);not null;default:'';uniqueIndex:idx_agent_usage_bucket,priority:3" json:"purpose"`
+	BucketStart      time.Time `gorm:"not null;uniqueIndex:idx_agent_usage_bucket,priority:4;index:idx_agent_usage_org_bucket,priority:2" json:"bucket_start"`
+	Resolves         int       `gorm:"not null;default:0" json:"resolves"`
+	SecretsDelivered int       `gorm:"not null;default:0" json:"secrets_delivered"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
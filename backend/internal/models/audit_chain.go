@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditChainHead is the newest link of an organization's audit hash chain.
+// Appends lock this row, so entries are chained one at a time per
+// organization across API instances. PrunedSequence and PrunedHash are the
+// last entry removed by retention, where verification picks the chain up;
+// the retention job signs them with the checkpoint key at PrunedAt, so the
+// marker cannot be moved forward to hide deleted entries.
+type AuditChainHead struct {
+	OrgID           uuid.UUID  `gorm:"type:uuid;primaryKey" json:"org_id"`
+	Sequence        int64      `gorm:"not null;default:0" json:"sequence"`
+	Hash            string     `gorm:"type:varchar(64);not null;default:''" json:"hash"`
+	PrunedSequence  int64      `gorm:"not null;default:0" json:"pruned_sequence"`
+	PrunedHash      string     `gorm:"type:varchar(64);not null;default:''" json:"pruned_hash"`
+	PrunedAt        *time.Time `json:"pruned_at,omitempty"`
+	PrunedPublicKey string     `gorm:"type:varchar(64);not null;default:''" json:"pruned_public_key,omitempty"`
+	PrunedSignature string     `gorm:"type:varchar(128);not null;default:''" json:"pruned_signature,omitempty"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// AuditCheckpoint is a server-signed statement that an organization's
+// audit chain had Hash at Sequence. Signature is an Ed25519 signature by
+// the key whose public half is PublicKey, both base64.
+type AuditCheckpoint struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID     uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_audit_checkpoints_org_sequence" json:"org_id"`
+	Sequence  int64     `gorm:"not null;uniqueIndex:idx_audit_checkpoints_org_sequence" json:"sequence"`
+	Hash      string    `gorm:"type:varchar(64);not null" json:"hash"`
+	KeyID     string    `gorm:"type:varchar(64);not null" json:"key_id"`
+	PublicKey string    `gorm:"type:varchar(64);not null" json:"public_key"`
+	Signature string    `gorm:"type:varchar(128);not null" json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -21,6 +21,12 @@ type AuditLog struct {
 	Metadata     datatypes.JSON `gorm:"type:jsonb" json:"metadata,omitempty"` // Additional context
 	IPAddress    string         `gorm:"type:varchar(45)" json:"ip_address"`   // IPv4 or IPv6
 
+	// Hash chain. Sequence counts the organization's entries from 1; entries
+	// written before chaining have 0 and no hashes.
+	Sequence int64  `gorm:"not null;default:0" json:"sequence"`
+	PrevHash string `gorm:"type:varchar(64);not null;default:''" json:"prev_hash,omitempty"`
+	Hash     string `gorm:"type:varchar(64);not null;default:''" json:"hash,omitempty"`
+
 	// Timestamps
 	CreatedAt time.Time `json:"created_at"`
 
@@ -45,13 +51,15 @@ func (AuditLog) TableName() string {
 
 // Audit actions
 const (
-	AuditActorHuman = "human"
-	AuditActorAgent = "agent"
+	AuditActorHuman  = "human"
+	AuditActorAgent  = "agent"
+	AuditActorSystem = "system" // background jobs acting on a rule, with neither user nor agent
 
 	ActionSecretRead       = "secret_read"
 	ActionSecretCreate     = "secret_create"
 	ActionSecretUpdate     = "secret_update"
 	ActionSecretDelete     = "secret_delete"
+	ActionSecretPurge      = "secret.purge" // predates the snake_case names; stored rows use it
 	ActionProjectCreate    = "project_create"
 	ActionProjectUpdate    = "project_update"
 	ActionProjectDelete    = "project_delete"
@@ -67,4 +75,76 @@ const (
 	ActionAgentTokenRevoke = "agent_token_revoke"
 	ActionAgentGrantCreate = "agent_grant_create"
 	ActionAgentGrantRevoke = "agent_grant_revoke"
+	ActionAgentLeaseRenew  = "agent_lease_renew"
+	ActionAgentLeaseRevoke = "agent_lease_revoke"
+
+	ActionEncryptionHealthCheck = "encryption_health_check"
+	ActionSecretSchemaUpdate    = "secret_schema_update"
+	ActionSecretSchemaCheck     = "secret_schema_check"
+	ActionSecretRotationConfig  = "secret_rotation_config"
+	ActionSecretRotationStep    = "secret_rotation_step"
+	ActionDatabaseLeaseIssue    = "database_lease_issue"
+	ActionDatabaseLeaseRevoke   = "database_lease_revoke"
+	ActionAccessRequestCreate   = "access_request_create"
+	ActionAccessRequestApprove  = "access_request_approve"
+	ActionAccessRequestDeny     = "access_request_deny"
+
+	ActionAgentTrustPolicyCreate = "agent_trust_policy_create"
+	ActionAgentTrustPolicyDelete = "agent_trust_policy_delete"
+	ActionAgentTokenExchange     = "agent_token_exchange"
+	ActionAgentAuthDenied        = "agent_auth_denied"
+)
+
+const (
+	ActionAgentTokenRotate    = "agent_token_rotate"
+	ActionAgentTokenRetire    = "agent_token_retire"
+	ActionAgentRotationPolicy = "agent_rotation_policy"
+)
+
+const (
+	ActionAgentQuotaUpdate   = "agent_quota_update"
+	ActionAgentQuotaExceeded = "agent_quota_exceeded"
+)
+
+const (
+	ActionAgentAnomalyRuleCreate = "agent_anomaly_rule_create"
+	ActionAgentAnomalyRuleDelete = "agent_anomaly_rule_delete"
+	ActionAgentAnomalyDetected   = "agent_anomaly_detected"
+)
+
+const (
+	ActionAccessPolicyCreate = "access_policy_create"
+	ActionAccessPolicyUpdate = "access_policy_update"
+	ActionAccessPolicyDelete = "access_policy_delete"
+	ActionAccessPolicyDenied = "access_policy_denied"
+)
+
+const (
+	ActionAuditExport     = "audit_export"
+	ActionAuditSinkCreate = "audit_sink_create"
+	ActionAuditSinkUpdate = "audit_sink_update"
+	ActionAuditSinkDelete = "audit_sink_delete"
+)
+
+// Environment, platform, role, invitation and sign-in events.
+const (
+	ActionEnvironmentCreate = "environment_create"
+	ActionEnvironmentUpdate = "environment_update"
+	ActionEnvironmentDelete = "environment_delete"
+
+	ActionPlatformConnect    = "platform_connect"
+	ActionPlatformDisconnect = "platform_disconnect"
+	ActionPlatformSync       = "platform_sync"
+
+	ActionRoleCreate   = "role_create"
+	ActionRoleUpdate   = "role_update"
+	ActionRoleDelete   = "role_delete"
+	ActionInviteResend = "invite_resend"
+	ActionInviteRevoke = "invite_revoke"
+	ActionInviteAccept = "invite_accept"
+
+	ActionUserLogin        = "user_login"
+	ActionUserTokenRefresh = "user_token_refresh"
+	ActionUserLogout       = "user_logout"
+	ActionUserTierChange   = "user_tier_change"
 )
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DatabaseLease records a PostgreSQL role minted for an agent resolve lease.
+// The row is written before the role is created so a crash mid-issue still
+// leaves something for the reaper to drop. DroppedAt is set once the role is
+// gone; DropError holds the last failure while a drop is being retried.
+type DatabaseLease struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"org_id"`
+	AgentID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"agent_id"`
+	GrantID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"grant_id"`
+	LeaseID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"lease_id"`
+	EnvironmentID uuid.UUID  `gorm:"type:uuid;not null" json:"environment_id"`
+	AdminSecretID uuid.UUID  `gorm:"type:uuid;not null" json:"admin_secret_id"`
+	RoleName      string     `gorm:"type:varchar(63);not null;uniqueIndex" json:"role_name"`
+	ExpiresAt     time.Time  `gorm:"not null;index" json:"expires_at"`
+	DroppedAt     *time.Time `gorm:"index" json:"dropped_at,omitempty"`
+	DropError     string     `gorm:"type:text;not null;default:''" json:"drop_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (l *DatabaseLease) BeforeCreate(_ *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
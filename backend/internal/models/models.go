@@ -20,11 +20,24 @@ func AllModels() []interface{} {
 		&Project{},
 		&Environment{},
 		&Secret{},
+		&SecretRotation{},
 		&PlatformConnection{},
 		&TierLimit{},
 		&AgentIdentity{},
 		&AgentCredential{},
 		&AgentGrant{},
+		&AgentLease{},
+		&AgentAccessRequest{},
+		&AgentTrustPolicy{},
+		&AgentUsageRollup{},
+		&AgentAnomalyRule{},
+		&AgentAnomalyAlert{},
+		&AgentAnomalyCursor{},
+		&AccessPolicy{},
+		&AuditSink{},
+		&AuditChainHead{},
+		&AuditCheckpoint{},
+		&DatabaseLease{},
 		&AuditLog{},
 		&RefreshToken{},
 		&CLILoginCode{},
@@ -48,6 +61,18 @@ func RunCustomMigrations(db *gorm.DB) error {
 			return err
 		}
 	}
+	// Secrets written by an agent have no human creator.
+	if db.Migrator().HasTable(&Secret{}) {
+		if err := db.Exec(`ALTER TABLE secrets ALTER COLUMN created_by DROP NOT NULL`).Error; err != nil {
+			return err
+		}
+	}
+	// Project-scoped agent grants have no single environment.
+	if db.Migrator().HasTable(&AgentGrant{}) {
+		if err := db.Exec(`ALTER TABLE agent_grants ALTER COLUMN environment_id DROP NOT NULL`).Error; err != nil {
+			return err
+		}
+	}
 
 	indexes := []struct {
 		name string
@@ -61,6 +86,10 @@ func RunCustomMigrations(db *gorm.DB) error {
 			name: "idx_secrets_env_created",
 			sql:  `CREATE INDEX IF NOT EXISTS idx_secrets_env_created ON secrets (environment_id, created_at ASC) WHERE deleted_at IS NULL`,
 		},
+		{
+			name: "idx_secrets_tags",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_secrets_tags ON secrets USING GIN (tags) WHERE deleted_at IS NULL`,
+		},
 		{
 			name: "idx_projects_org_active",
 			sql:  `CREATE INDEX IF NOT EXISTS idx_projects_org_active ON projects (org_id) WHERE deleted_at IS NULL`,
@@ -77,14 +106,67 @@ func RunCustomMigrations(db *gorm.DB) error {
 			name: "idx_org_invitations_org_email_pending",
 			sql:  `CREATE UNIQUE INDEX IF NOT EXISTS idx_org_invitations_org_email_pending ON org_invitations (org_id, lower(email)) WHERE status = 'pending' AND deleted_at IS NULL`,
 		},
+		{
+			name: "idx_audit_logs_org_created_id",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_audit_logs_org_created_id ON audit_logs (org_id, created_at DESC, id DESC)`,
+		},
+		// Superseded by idx_audit_logs_org_created_id.
 		{
 			name: "idx_audit_logs_org_created",
-			sql:  `CREATE INDEX IF NOT EXISTS idx_audit_logs_org_created ON audit_logs (org_id, created_at DESC)`,
+			sql:  `DROP INDEX IF EXISTS idx_audit_logs_org_created`,
+		},
+		{
+			name: "idx_audit_logs_org_action_created",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_audit_logs_org_action_created ON audit_logs (org_id, action, created_at DESC, id DESC)`,
+		},
+		{
+			name: "idx_audit_logs_org_resource_created",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_audit_logs_org_resource_created ON audit_logs (org_id, resource_id, created_at DESC)`,
+		},
+		{
+			name: "idx_audit_logs_org_sequence",
+			sql:  `CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_logs_org_sequence ON audit_logs (org_id, sequence) WHERE sequence > 0`,
+		},
+		{
+			name: "idx_audit_logs_user_created",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_audit_logs_user_created ON audit_logs (user_id, created_at DESC) WHERE user_id IS NOT NULL`,
+		},
+		// Metadata text search uses a trigram index when the pg_trgm
+		// extension can be installed; without it the search still works.
+		{
+			name: "pg_trgm",
+			sql:  `CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		},
+		{
+			name: "idx_audit_logs_metadata_trgm",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_audit_logs_metadata_trgm ON audit_logs USING GIN ((metadata::text) gin_trgm_ops)`,
+		},
+		{
+			name: "idx_audit_logs_agent_created",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_audit_logs_agent_created ON audit_logs (agent_id, created_at) WHERE agent_id IS NOT NULL`,
+		},
+		{
+			// The anomaly scan walks agent resolves across every org in
+			// (created_at, id) order from its cursor.
+			name: "idx_audit_logs_agent_reads_created_id",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_audit_logs_agent_reads_created_id ON audit_logs (created_at, id) WHERE actor_type = 'agent' AND action = 'secret_read'`,
 		},
 		{
 			name: "idx_agent_grants_live_lookup",
 			sql:  `CREATE INDEX IF NOT EXISTS idx_agent_grants_live_lookup ON agent_grants (agent_id, environment_id, capability) WHERE revoked_at IS NULL AND deleted_at IS NULL`,
 		},
+		{
+			name: "idx_agent_grants_project_live_lookup",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_agent_grants_project_live_lookup ON agent_grants (agent_id, project_id, capability) WHERE environment_id IS NULL AND revoked_at IS NULL AND deleted_at IS NULL`,
+		},
+		{
+			name: "idx_agent_leases_agent_created",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_agent_leases_agent_created ON agent_leases (agent_id, created_at DESC)`,
+		},
+		{
+			name: "idx_agent_leases_credential_created",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_agent_leases_credential_created ON agent_leases (credential_id, created_at DESC)`,
+		},
 		{
 			name: "idx_orgs_owner_personal",
 			sql:  `CREATE UNIQUE INDEX IF NOT EXISTS idx_orgs_owner_personal ON organizations (owner_id) WHERE owner_type = 'personal' AND deleted_at IS NULL`,
@@ -101,6 +183,9 @@ func RunCustomMigrations(db *gorm.DB) error {
 	if err := ensureAgentManagementPermission(db); err != nil {
 		return err
 	}
+	if err := ensureSystemPermission(db, PermissionEncryptionView, "View the workspace encryption health report", RoleOwner, RoleAdmin); err != nil {
+		return err
+	}
 
 	if err := backfillPersonalWorkspaces(db); err != nil {
 		return err
@@ -116,12 +201,18 @@ func RunCustomMigrations(db *gorm.DB) error {
 // a schema migration on existing installations. A later full seed remains
 // authoritative for all system-role permission sets.
 func ensureAgentManagementPermission(db *gorm.DB) error {
-	permission := Permission{Name: PermissionAgentsManage, Description: "Create agents, credentials, and secret access grants"}
-	if err := db.Where("name = ?", PermissionAgentsManage).FirstOrCreate(&permission).Error; err != nil {
+	return ensureSystemPermission(db, PermissionAgentsManage, "Create agents, credentials, and secret access grants", RoleOwner, RoleAdmin)
+}
+
+// ensureSystemPermission creates a permission if missing and attaches it to
+// the named system roles.
+func ensureSystemPermission(db *gorm.DB, name, description string, roleNames ...string) error {
+	permission := Permission{Name: name, Description: description}
+	if err := db.Where("name = ?", name).FirstOrCreate(&permission).Error; err != nil {
 		return err
 	}
 	var roles []Role
-	if err := db.Where("is_system_role = ? AND name IN ?", true, []string{RoleOwner, RoleAdmin}).Find(&roles).Error; err != nil {
+	if err := db.Where("is_system_role = ? AND name IN ?", true, roleNames).Find(&roles).Error; err != nil {
 		return err
 	}
 	for i := range roles {
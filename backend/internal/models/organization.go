@@ -23,6 +23,10 @@ type Organization struct {
 	Name      string    `gorm:"type:varchar(255);not null" json:"name"`
 	OwnerType OwnerType `gorm:"type:varchar(20);not null;default:'org'" json:"owner_type"`
 
+	// ExcludeExpiredSecrets leaves secrets past their hard expiry out of
+	// exports and agent resolves instead of serving stale values.
+	ExcludeExpiredSecrets bool `gorm:"not null;default:false" json:"exclude_expired_secrets"`
+
 	// Timestamps
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
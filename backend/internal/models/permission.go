@@ -48,6 +48,7 @@ const (
 	PermissionAuditView          = "audit.view"
 	PermissionOrgManage          = "org.manage"
 	PermissionAgentsManage       = "agents.manage"
+	PermissionEncryptionView     = "encryption.view"
 )
 
 // Role represents a role with permissions
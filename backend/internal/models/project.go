@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -13,6 +14,9 @@ type Project struct {
 	OrgID       uuid.UUID `gorm:"type:uuid;not null;index" json:"org_id"`
 	Name        string    `gorm:"type:varchar(255);not null" json:"name"`
 	Description *string   `gorm:"type:text" json:"description,omitempty"`
+
+	// SecretSchema is an optional JSON-encoded SecretSchema enforced on writes.
+	SecretSchema datatypes.JSON `gorm:"type:jsonb" json:"secret_schema,omitempty"`
 	
 	// Timestamps
 	CreatedAt time.Time      `json:"created_at"`
@@ -1,12 +1,24 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
+// Secret type hints. They describe the expected shape of a value for the
+// dashboard and tooling; they are not enforced on write.
+const (
+	SecretTypeString           = "string"
+	SecretTypeURL              = "url"
+	SecretTypeJSON             = "json"
+	SecretTypePEM              = "pem"
+	SecretTypeConnectionString = "connection-string"
+)
+
 // Secret represents an encrypted secret
 type Secret struct {
 	ID             uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -14,16 +26,38 @@ type Secret struct {
 	Key            string    `gorm:"type:varchar(255);not null" json:"key"`
 	EncryptedValue string    `gorm:"type:text;not null" json:"-"` // Never expose in JSON
 	KMSKeyID       string    `gorm:"type:varchar(255);not null" json:"-"`
-	CreatedBy      uuid.UUID `gorm:"type:uuid;not null;index" json:"created_by"`
-	
+	// CreatedBy is the human who created the secret, or nil when an agent
+	// created it, in which case CreatedByAgentID is set.
+	CreatedBy        *uuid.UUID `gorm:"type:uuid;index" json:"created_by"`
+	CreatedByAgentID *uuid.UUID `gorm:"type:uuid;index" json:"created_by_agent_id,omitempty"`
+
+	// Metadata. Tags is a JSON string array. Sensitive=false marks plain
+	// configuration (e.g. LOG_LEVEL) whose value may be shown in listings.
+	Description string         `gorm:"type:text;not null;default:''" json:"description"`
+	Tags        datatypes.JSON `gorm:"type:jsonb;not null;default:'[]'" json:"tags"`
+	OwnerUserID *uuid.UUID     `gorm:"type:uuid;index" json:"owner_user_id,omitempty"`
+	OwnerTeam   string         `gorm:"type:varchar(100);not null;default:''" json:"owner_team,omitempty"`
+	TypeHint    string         `gorm:"type:varchar(30);not null;default:string" json:"type_hint"`
+	Sensitive   bool           `gorm:"not null;default:true" json:"sensitive"`
+
+	// Rotation. RotationDueAt is the earlier of ExpiresAt and ValueUpdatedAt
+	// plus the interval, kept in sync by SyncRotationDueAt so the reminder job
+	// can use an index. RotationNotifiedAt is cleared whenever the value changes.
+	ExpiresAt            *time.Time `gorm:"index" json:"expires_at,omitempty"`
+	RotationIntervalDays int        `gorm:"not null;default:0" json:"rotation_interval_days,omitempty"`
+	ValueUpdatedAt       *time.Time `json:"value_updated_at,omitempty"`
+	RotationDueAt        *time.Time `gorm:"index" json:"rotation_due_at,omitempty"`
+	RotationNotifiedAt   *time.Time `json:"-"`
+
 	// Timestamps
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"` // Soft delete for audit trail
-	
+
 	// Relationships
-	Environment Environment `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
-	Creator     User        `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
+	Environment  Environment    `gorm:"foreignKey:EnvironmentID" json:"environment,omitempty"`
+	Creator      *User          `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
+	CreatorAgent *AgentIdentity `gorm:"foreignKey:CreatedByAgentID" json:"creator_agent,omitempty"`
 }
 
 // BeforeCreate hook to generate UUID
@@ -31,32 +65,105 @@ func (s *Secret) BeforeCreate(tx *gorm.DB) error {
 	if s.ID == uuid.Nil {
 		s.ID = uuid.New()
 	}
+	if len(s.Tags) == 0 {
+		s.Tags = datatypes.JSON([]byte("[]"))
+	}
+	if s.TypeHint == "" {
+		s.TypeHint = SecretTypeString
+	}
 	return nil
 }
 
+// SyncRotationDueAt recomputes RotationDueAt from the expiry and interval.
+func (s *Secret) SyncRotationDueAt() {
+	var due *time.Time
+	if s.RotationIntervalDays > 0 {
+		base := s.CreatedAt
+		if s.ValueUpdatedAt != nil {
+			base = *s.ValueUpdatedAt
+		}
+		if base.IsZero() {
+			base = time.Now()
+		}
+		t := base.AddDate(0, 0, s.RotationIntervalDays)
+		due = &t
+	}
+	if s.ExpiresAt != nil && (due == nil || s.ExpiresAt.Before(*due)) {
+		t := *s.ExpiresAt
+		due = &t
+	}
+	s.RotationDueAt = due
+}
+
+// IsExpired reports whether the secret's hard expiry has passed.
+func (s *Secret) IsExpired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
 // TableName specifies the table name
 func (Secret) TableName() string {
 	return "secrets"
 }
 
-// SecretResponse is used for API responses (without encrypted value)
+// TagList decodes Tags, treating malformed or empty storage as no tags.
+func (s *Secret) TagList() []string {
+	tags := []string{}
+	if len(s.Tags) > 0 {
+		_ = json.Unmarshal(s.Tags, &tags)
+	}
+	return tags
+}
+
+// SecretResponse is used for API responses (without encrypted value).
+// Value is only populated for non-sensitive secrets.
 type SecretResponse struct {
-	ID            uuid.UUID `json:"id"`
-	EnvironmentID uuid.UUID `json:"environment_id"`
-	Key           string    `json:"key"`
-	CreatedBy     uuid.UUID `json:"created_by"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID            uuid.UUID  `json:"id"`
+	EnvironmentID uuid.UUID  `json:"environment_id"`
+	Key           string     `json:"key"`
+	Description   string     `json:"description"`
+	Tags          []string   `json:"tags"`
+	OwnerUserID   *uuid.UUID `json:"owner_user_id,omitempty"`
+	OwnerTeam     string     `json:"owner_team,omitempty"`
+	TypeHint      string     `json:"type_hint"`
+	Sensitive     bool       `json:"sensitive"`
+	Value         *string    `json:"value,omitempty"`
+
+	ExpiresAt            *time.Time `json:"expires_at,omitempty"`
+	RotationIntervalDays int        `json:"rotation_interval_days,omitempty"`
+	ValueUpdatedAt       *time.Time `json:"value_updated_at,omitempty"`
+	RotationDueAt        *time.Time `json:"rotation_due_at,omitempty"`
+
+	CreatedBy        *uuid.UUID `json:"created_by"`
+	CreatedByAgentID *uuid.UUID `json:"created_by_agent_id,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
 }
 
 // ToResponse converts Secret to SecretResponse
 func (s *Secret) ToResponse() SecretResponse {
+	typeHint := s.TypeHint
+	if typeHint == "" {
+		typeHint = SecretTypeString
+	}
 	return SecretResponse{
 		ID:            s.ID,
 		EnvironmentID: s.EnvironmentID,
 		Key:           s.Key,
-		CreatedBy:     s.CreatedBy,
-		CreatedAt:     s.CreatedAt,
-		UpdatedAt:     s.UpdatedAt,
+		Description:   s.Description,
+		Tags:          s.TagList(),
+		OwnerUserID:   s.OwnerUserID,
+		OwnerTeam:     s.OwnerTeam,
+		TypeHint:      typeHint,
+		Sensitive:     s.Sensitive,
+
+		ExpiresAt:            s.ExpiresAt,
+		RotationIntervalDays: s.RotationIntervalDays,
+		ValueUpdatedAt:       s.ValueUpdatedAt,
+		RotationDueAt:        s.RotationDueAt,
+
+		CreatedBy:        s.CreatedBy,
+		CreatedByAgentID: s.CreatedByAgentID,
+		CreatedAt:        s.CreatedAt,
+		UpdatedAt:        s.UpdatedAt,
 	}
 }
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	RotatorPostgres = "postgres"
+
+	// RotationStrategyPassword sets a new password on a single role.
+	RotationStrategyPassword = "password"
+	// RotationStrategyAlternateRoles switches between two roles so the
+	// previous credential keeps working until the next rotation.
+	RotationStrategyAlternateRoles = "alternate_roles"
+
+	RotationStatusNever     = "never"
+	RotationStatusSucceeded = "succeeded"
+	RotationStatusFailed    = "failed"
+)
+
+// SecretRotation configures automatic rotation of one secret. The admin
+// connection used to change the credential is itself an Envo secret in the
+// same organization, referenced by AdminSecretID, so it stays encrypted at
+// rest and is only decrypted for the duration of a rotation. Disabling
+// rotation deletes the row; the audit log keeps its history.
+type SecretRotation struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	OrgID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"org_id"`
+	SecretID       uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex" json:"secret_id"`
+	Rotator        string     `gorm:"type:varchar(30);not null" json:"rotator"`
+	Strategy       string     `gorm:"type:varchar(30);not null;default:password" json:"strategy"`
+	AdminSecretID  uuid.UUID  `gorm:"type:uuid;not null;index" json:"admin_secret_id"`
+	Username       string     `gorm:"type:varchar(63);not null" json:"username"`
+	AlternateUser  string     `gorm:"type:varchar(63);not null;default:''" json:"alternate_username,omitempty"`
+	ActiveUser     string     `gorm:"type:varchar(63);not null;default:''" json:"active_username,omitempty"`
+	IntervalDays   int        `gorm:"not null;default:0" json:"interval_days"`
+	NextRotationAt *time.Time `gorm:"index" json:"next_rotation_at,omitempty"`
+	LastRotatedAt  *time.Time `json:"last_rotated_at,omitempty"`
+	LastStatus     string     `gorm:"type:varchar(20);not null;default:never" json:"last_status"`
+	LastError      string     `gorm:"type:text;not null;default:''" json:"last_error,omitempty"`
+	LockedUntil    *time.Time `json:"-"`
+	CreatedBy      uuid.UUID  `gorm:"type:uuid;not null;index" json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+func (r *SecretRotation) BeforeCreate(_ *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	if r.Strategy == "" {
+		r.Strategy = RotationStrategyPassword
+	}
+	if r.LastStatus == "" {
+		r.LastStatus = RotationStatusNever
+	}
+	return nil
+}
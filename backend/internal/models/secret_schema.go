@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// Secret schema value types.
+const (
+	SchemaTypeString  = "string"
+	SchemaTypeInteger = "integer"
+	SchemaTypeNumber  = "number"
+	SchemaTypeBoolean = "boolean"
+	SchemaTypeURL     = "url"
+	SchemaTypeJSON    = "json"
+)
+
+// SecretSchema describes the variables every environment of a project is
+// expected to define. It is stored as JSON on Project.SecretSchema.
+type SecretSchema struct {
+	Keys map[string]SecretSchemaRule `json:"keys"`
+}
+
+// SecretSchemaRule constrains one key. Pattern is an RE2 expression matched
+// anywhere in the value (anchor it with ^ and $ for a full match); lengths
+// count characters.
+type SecretSchemaRule struct {
+	Required    bool     `json:"required,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	MinLength   *int     `json:"min_length,omitempty"`
+	MaxLength   *int     `json:"max_length,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Schema decodes the project's secret schema. It returns nil when none is set.
+func (p *Project) Schema() (*SecretSchema, error) {
+	if len(p.SecretSchema) == 0 || string(p.SecretSchema) == "null" {
+		return nil, nil
+	}
+	var schema SecretSchema
+	if err := json.Unmarshal(p.SecretSchema, &schema); err != nil {
+		return nil, err
+	}
+	if len(schema.Keys) == 0 {
+		return nil, nil
+	}
+	return &schema, nil
+}
@@ -0,0 +1,632 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/cel-go/cel"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAccessPolicyNotFound = errors.New("access policy not found")
+	ErrInvalidAccessPolicy  = errors.New("invalid access policy")
+	// ErrPolicyDenied is wrapped by PolicyDeniedError.
+	ErrPolicyDenied = errors.New("denied by access policy")
+)
+
+const (
+	maxAccessPoliciesPerOrg  = 100
+	maxPolicyConditionLength = 4000
+	// policyCostLimit bounds the work one evaluation may do, so a policy
+	// cannot make every request slow.
+	policyCostLimit = 10000
+	// maxCachedPolicyPrograms bounds the compiled-program cache; it is
+	// cleared when full.
+	maxCachedPolicyPrograms = 1000
+)
+
+var policyActions = []string{models.PolicyActionSecretsResolve, models.PolicyActionSecretsExport, models.PolicyActionSecretsWrite, models.PolicyActionSecretsDelete}
+
+// PolicyDeniedError names the policy that denied a request and the secret it
+// was evaluated for.
+type PolicyDeniedError struct {
+	PolicyID uuid.UUID
+	Policy   string
+	Key      string
+}
+
+func (e *PolicyDeniedError) Error() string {
+	return fmt.Sprintf("%s %q for %s", ErrPolicyDenied, e.Policy, e.Key)
+}
+
+func (e *PolicyDeniedError) Unwrap() error {
+	return ErrPolicyDenied
+}
+
+// PolicyPrincipal is who is asking. Type is "user" or "agent"; Role is the
+// user's role in the org and empty for agents.
+type PolicyPrincipal struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// UserPrincipal describes user as a policy principal within orgID.
+func UserPrincipal(user *models.User, orgID uuid.UUID) PolicyPrincipal {
+	principal := PolicyPrincipal{Type: "user", ID: user.ID.String(), Name: user.Name, Email: user.Email}
+	for _, membership := range user.OrgMemberships {
+		if membership.OrgID == orgID {
+			principal.Role = membership.Role.Name
+		}
+	}
+	return principal
+}
+
+// AgentPrincipal describes agent as a policy principal.
+func AgentPrincipal(agent *models.AgentIdentity) PolicyPrincipal {
+	return PolicyPrincipal{Type: "agent", ID: agent.ID.String(), Name: agent.Name}
+}
+
+// policyPrincipal loads actor as a policy principal within orgID. A user
+// who no longer exists is described by ID alone.
+func policyPrincipal(db *gorm.DB, actor Actor, orgID uuid.UUID) (PolicyPrincipal, error) {
+	if actor.IsAgent() {
+		var agent models.AgentIdentity
+		if err := db.Select("id", "name").First(&agent, actor.AgentID).Error; err != nil {
+			return PolicyPrincipal{}, err
+		}
+		return AgentPrincipal(&agent), nil
+	}
+	var user models.User
+	err := db.Preload("OrgMemberships", "org_id = ?", orgID).Preload("OrgMemberships.Role").First(&user, actor.UserID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return PolicyPrincipal{Type: "user", ID: actor.UserID.String()}, nil
+	}
+	if err != nil {
+		return PolicyPrincipal{}, err
+	}
+	return UserPrincipal(&user, orgID), nil
+}
+
+// PolicyRequest is one access decision to evaluate. Project and Environment
+// are names; the service fills them in from the environment being checked,
+// and loads Principal from Actor when it is not set.
+type PolicyRequest struct {
+	Actor       Actor           `json:"-"`
+	Principal   PolicyPrincipal `json:"principal"`
+	Action      string          `json:"action"`
+	Project     string          `json:"project"`
+	Environment string          `json:"environment"`
+	IP          string          `json:"ip"`
+	Purpose     string          `json:"purpose"`
+	Time        time.Time       `json:"time"`
+}
+
+// PolicySecret is the secret metadata a policy can see. Values never are.
+type PolicySecret struct {
+	Key       string   `json:"key"`
+	Tags      []string `json:"tags"`
+	Sensitive bool     `json:"sensitive"`
+	TypeHint  string   `json:"type_hint"`
+}
+
+// PolicySecretFrom describes an existing secret to policies.
+func PolicySecretFrom(secret *models.Secret) PolicySecret {
+	var tags []string
+	_ = json.Unmarshal(secret.Tags, &tags)
+	return PolicySecret{Key: secret.Key, Tags: tags, Sensitive: secret.Sensitive, TypeHint: secret.TypeHint}
+}
+
+// PolicyMatch is a policy whose condition held, or failed to evaluate.
+type PolicyMatch struct {
+	PolicyID uuid.UUID `json:"policy_id"`
+	Name     string    `json:"name"`
+	Mode     string    `json:"mode"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// denies reports whether the match blocks the request. Evaluation errors in
+// enforced policies fail closed.
+func (m PolicyMatch) denies() bool {
+	return m.Mode == models.PolicyModeEnforce
+}
+
+// SecretPolicyResult holds the policy decisions for the secrets of one
+// request. A nil result allows everything.
+type SecretPolicyResult struct {
+	// Denied maps each key an enforced policy denies to the first such policy.
+	Denied map[string]PolicyMatch
+	// Matches lists, per key, every policy that matched, audit mode included.
+	Matches map[string][]PolicyMatch
+
+	evaluated int
+}
+
+// Allows reports whether key may be used. It is a KeyFilter.
+func (r *SecretPolicyResult) Allows(key string) bool {
+	if r == nil {
+		return true
+	}
+	_, denied := r.Denied[key]
+	return !denied
+}
+
+// DeniedKeys returns the denied keys, sorted.
+func (r *SecretPolicyResult) DeniedKeys() []string {
+	if r == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(r.Denied))
+	for key := range r.Denied {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Err returns a *PolicyDeniedError for the first denied key of keys, or for
+// the first denied key overall when keys is empty.
+func (r *SecretPolicyResult) Err(keys ...string) error {
+	if r == nil {
+		return nil
+	}
+	if len(keys) == 0 {
+		keys = r.DeniedKeys()
+	}
+	for _, key := range keys {
+		if match, denied := r.Denied[key]; denied {
+			return &PolicyDeniedError{PolicyID: match.PolicyID, Policy: match.Name, Key: key}
+		}
+	}
+	return nil
+}
+
+// ErrIfAllDenied returns a *PolicyDeniedError when policies deny every
+// secret the request covers, so a bulk read that would come back empty is
+// refused outright.
+func (r *SecretPolicyResult) ErrIfAllDenied() error {
+	if r == nil || r.evaluated == 0 || len(r.Denied) < r.evaluated {
+		return nil
+	}
+	return r.Err()
+}
+
+// Skipped reports the denied keys as skipped secrets.
+func (r *SecretPolicyResult) Skipped() []SkippedSecret {
+	var skipped []SkippedSecret
+	for _, key := range r.DeniedKeys() {
+		skipped = append(skipped, SkippedSecret{Key: key, Reason: SkipReasonPolicyDenied})
+	}
+	return skipped
+}
+
+// Decision is the audit metadata recording the result, or nil when no
+// policy matched.
+func (r *SecretPolicyResult) Decision() map[string]any {
+	if r == nil || len(r.Matches) == 0 {
+		return nil
+	}
+	return map[string]any{"denied_keys": r.DeniedKeys(), "matches": r.Matches}
+}
+
+// PolicyService stores org access policies and evaluates them.
+type PolicyService struct {
+	audit *AuditService
+	env   *cel.Env
+
+	mu       sync.Mutex
+	programs map[string]cel.Program
+}
+
+// newPolicyEnv declares the variables a policy condition can use.
+func newPolicyEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("principal", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("action", cel.StringType),
+		cel.Variable("project", cel.StringType),
+		cel.Variable("environment", cel.StringType),
+		cel.Variable("secret", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+	)
+}
+
+// NewPolicyService creates a policy service.
+func NewPolicyService(audit *AuditService) *PolicyService {
+	env, err := newPolicyEnv()
+	if err != nil {
+		// The declarations are static; this only fails on a programming error.
+		panic(fmt.Sprintf("access policy environment: %v", err))
+	}
+	return &PolicyService{audit: audit, env: env, programs: map[string]cel.Program{}}
+}
+
+// compile returns the program for condition, compiling it on first use.
+func (s *PolicyService) compile(condition string) (cel.Program, error) {
+	s.mu.Lock()
+	program, ok := s.programs[condition]
+	s.mu.Unlock()
+	if ok {
+		return program, nil
+	}
+	ast, issues := s.env.Compile(condition)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if out := ast.OutputType(); !out.IsExactType(cel.BoolType) && !out.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("condition must evaluate to a bool, not %s", out)
+	}
+	program, err := s.env.Program(ast, cel.CostLimit(policyCostLimit))
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if len(s.programs) >= maxCachedPolicyPrograms {
+		s.programs = map[string]cel.Program{}
+	}
+	s.programs[condition] = program
+	s.mu.Unlock()
+	return program, nil
+}
+
+// AccessPolicyInput configures an access policy. A nil Enabled means true.
+type AccessPolicyInput struct {
+	Name        string
+	Description string
+	Actions     []string
+	Condition   string
+	Mode        string
+	Enabled     *bool
+}
+
+func (s *PolicyService) normalizePolicy(in AccessPolicyInput) (AccessPolicyInput, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || len(in.Name) > 120 {
+		return in, fmt.Errorf("%w: name must be between 1 and 120 characters", ErrInvalidAccessPolicy)
+	}
+	in.Description = strings.TrimSpace(in.Description)
+	if len(in.Description) > 1000 {
+		return in, fmt.Errorf("%w: description must be at most 1000 characters", ErrInvalidAccessPolicy)
+	}
+	actions := []string{}
+	for _, action := range in.Actions {
+		if !slices.Contains(policyActions, action) {
+			return in, fmt.Errorf("%w: action must be one of %s", ErrInvalidAccessPolicy, strings.Join(policyActions, ", "))
+		}
+		if !slices.Contains(actions, action) {
+			actions = append(actions, action)
+		}
+	}
+	in.Actions = actions
+	switch in.Mode {
+	case "":
+		in.Mode = models.PolicyModeEnforce
+	case models.PolicyModeEnforce, models.PolicyModeAudit:
+	default:
+		return in, fmt.Errorf("%w: mode must be enforce or audit", ErrInvalidAccessPolicy)
+	}
+	in.Condition = strings.TrimSpace(in.Condition)
+	if in.Condition == "" || len(in.Condition) > maxPolicyConditionLength {
+		return in, fmt.Errorf("%w: condition must be between 1 and %d characters", ErrInvalidAccessPolicy, maxPolicyConditionLength)
+	}
+	if _, err := s.compile(in.Condition); err != nil {
+		return in, fmt.Errorf("%w: %v", ErrInvalidAccessPolicy, err)
+	}
+	if in.Enabled == nil {
+		enabled := true
+		in.Enabled = &enabled
+	}
+	return in, nil
+}
+
+// CreatePolicy adds an access policy to the org.
+func (s *PolicyService) CreatePolicy(ctx context.Context, userID, orgID uuid.UUID, in AccessPolicyInput, ip string) (*models.AccessPolicy, error) {
+	in, err := s.normalizePolicy(in)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB().WithContext(ctx)
+	var existing int64
+	if err := db.Model(&models.AccessPolicy{}).Where("org_id = ?", orgID).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing >= maxAccessPoliciesPerOrg {
+		return nil, fmt.Errorf("%w: an organization can have at most %d access policies", ErrInvalidAccessPolicy, maxAccessPoliciesPerOrg)
+	}
+	actions, _ := json.Marshal(in.Actions)
+	policy := &models.AccessPolicy{
+		OrgID:       orgID,
+		Name:        in.Name,
+		Description: in.Description,
+		Actions:     datatypes.JSON(actions),
+		Condition:   in.Condition,
+		Mode:        in.Mode,
+		Enabled:     *in.Enabled,
+		CreatedBy:   userID,
+		UpdatedBy:   userID,
+	}
+	// Enabled defaults to true in the schema, so false must be written
+	// explicitly.
+	if err := db.Select("*").Omit("DeletedAt").Create(policy).Error; err != nil {
+		return nil, err
+	}
+	s.logChange(ctx, userID, policy, models.ActionAccessPolicyCreate, ip)
+	return policy, nil
+}
+
+// UpdatePolicy replaces an access policy.
+func (s *PolicyService) UpdatePolicy(ctx context.Context, userID, orgID, policyID uuid.UUID, in AccessPolicyInput, ip string) (*models.AccessPolicy, error) {
+	in, err := s.normalizePolicy(in)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB().WithContext(ctx)
+	var policy models.AccessPolicy
+	if err := db.Where("id = ? AND org_id = ?", policyID, orgID).First(&policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccessPolicyNotFound
+		}
+		return nil, err
+	}
+	actions, _ := json.Marshal(in.Actions)
+	updates := map[string]any{
+		"name":        in.Name,
+		"description": in.Description,
+		"actions":     datatypes.JSON(actions),
+		"condition":   in.Condition,
+		"mode":        in.Mode,
+		"enabled":     *in.Enabled,
+		"updated_by":  userID,
+	}
+	if err := db.Model(&policy).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if err := db.First(&policy, policy.ID).Error; err != nil {
+		return nil, err
+	}
+	s.logChange(ctx, userID, &policy, models.ActionAccessPolicyUpdate, ip)
+	return &policy, nil
+}
+
+// DeletePolicy removes an access policy.
+func (s *PolicyService) DeletePolicy(ctx context.Context, userID, orgID, policyID uuid.UUID, ip string) error {
+	result := database.GetDB().WithContext(ctx).Where("id = ? AND org_id = ?", policyID, orgID).Delete(&models.AccessPolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccessPolicyNotFound
+	}
+	if s.audit != nil {
+		_ = s.audit.Log(ctx, userID, orgID, policyID, models.ActionAccessPolicyDelete, "access_policy", ip, nil)
+	}
+	return nil
+}
+
+// ListPolicies lists an org's access policies, oldest first.
+func (s *PolicyService) ListPolicies(ctx context.Context, orgID uuid.UUID) ([]models.AccessPolicy, error) {
+	var policies []models.AccessPolicy
+	err := database.GetDB().WithContext(ctx).Where("org_id = ?", orgID).Order("created_at ASC").Find(&policies).Error
+	return policies, err
+}
+
+func (s *PolicyService) logChange(ctx context.Context, userID uuid.UUID, policy *models.AccessPolicy, action, ip string) {
+	if s.audit == nil {
+		return
+	}
+	metadata, _ := json.Marshal(map[string]any{
+		"name":      policy.Name,
+		"actions":   policy.Actions,
+		"condition": policy.Condition,
+		"mode":      policy.Mode,
+		"enabled":   policy.Enabled,
+	})
+	_ = s.audit.Log(ctx, userID, policy.OrgID, policy.ID, action, "access_policy", ip, datatypes.JSON(metadata))
+}
+
+// applicablePolicies returns the org's enabled policies covering action.
+func applicablePolicies(db *gorm.DB, orgID uuid.UUID, action string) ([]models.AccessPolicy, error) {
+	var policies []models.AccessPolicy
+	if err := db.Where("org_id = ? AND enabled = ?", orgID, true).Order("created_at ASC").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	applicable := policies[:0]
+	for _, policy := range policies {
+		var actions []string
+		_ = json.Unmarshal(policy.Actions, &actions)
+		if len(actions) == 0 || slices.Contains(actions, action) {
+			applicable = append(applicable, policy)
+		}
+	}
+	return applicable, nil
+}
+
+// policyVars is the activation for one request and secret.
+func policyVars(req PolicyRequest, secret PolicySecret) map[string]any {
+	tags := secret.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return map[string]any{
+		"principal": map[string]any{
+			"type":  req.Principal.Type,
+			"id":    req.Principal.ID,
+			"name":  req.Principal.Name,
+			"email": req.Principal.Email,
+			"role":  req.Principal.Role,
+		},
+		"action":      req.Action,
+		"project":     req.Project,
+		"environment": req.Environment,
+		"secret": map[string]any{
+			"key":       secret.Key,
+			"tags":      tags,
+			"sensitive": secret.Sensitive,
+			"type_hint": secret.TypeHint,
+		},
+		"request": map[string]any{
+			"ip":      req.IP,
+			"purpose": req.Purpose,
+			"time":    req.Time,
+		},
+	}
+}
+
+// evaluate runs policies against one request and secret and returns those
+// that matched.
+func (s *PolicyService) evaluate(policies []models.AccessPolicy, req PolicyRequest, secret PolicySecret) []PolicyMatch {
+	vars := policyVars(req, secret)
+	var matches []PolicyMatch
+	for _, policy := range policies {
+		match := PolicyMatch{PolicyID: policy.ID, Name: policy.Name, Mode: policy.Mode}
+		program, err := s.compile(policy.Condition)
+		if err == nil {
+			var out any
+			if val, _, evalErr := program.Eval(vars); evalErr != nil {
+				err = evalErr
+			} else {
+				out = val.Value()
+			}
+			if err == nil {
+				if held, ok := out.(bool); !ok || !held {
+					continue
+				}
+			}
+		}
+		if err != nil {
+			match.Error = err.Error()
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// check evaluates req against secrets, filling in the names of envID. It
+// returns nil when no enabled policy covers the action.
+func (s *PolicyService) check(ctx context.Context, req PolicyRequest, envID uuid.UUID, load func(db *gorm.DB) ([]PolicySecret, error)) (*SecretPolicyResult, error) {
+	db := database.GetDB().WithContext(ctx)
+	var env models.Environment
+	if err := db.Preload("Project").First(&env, envID).Error; err != nil {
+		return nil, err
+	}
+	policies, err := applicablePolicies(db, env.Project.OrgID, req.Action)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+	secrets, err := load(db)
+	if err != nil {
+		return nil, err
+	}
+	if req.Principal.Type == "" {
+		if req.Principal, err = policyPrincipal(db, req.Actor, env.Project.OrgID); err != nil {
+			return nil, err
+		}
+	}
+	req.Project, req.Environment = env.Project.Name, env.Name
+	if req.Time.IsZero() {
+		req.Time = time.Now().UTC()
+	}
+	result := &SecretPolicyResult{Denied: map[string]PolicyMatch{}, Matches: map[string][]PolicyMatch{}, evaluated: len(secrets)}
+	for _, secret := range secrets {
+		matches := s.evaluate(policies, req, secret)
+		if len(matches) == 0 {
+			continue
+		}
+		result.Matches[secret.Key] = matches
+		for _, match := range matches {
+			if match.denies() {
+				result.Denied[secret.Key] = match
+				break
+			}
+		}
+	}
+	if len(result.Denied) > 0 && s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"action": req.Action, "policy": result.Decision()})
+		_ = s.audit.LogActor(ctx, req.Actor, env.Project.OrgID, envID, models.ActionAccessPolicyDenied, "environment", req.IP, datatypes.JSON(metadata))
+	}
+	return result, nil
+}
+
+// CheckEnvironment evaluates req against every secret in envID accepted by
+// filter (all when nil). Denials are audited as access_policy_denied.
+func (s *PolicyService) CheckEnvironment(ctx context.Context, req PolicyRequest, envID uuid.UUID, filter KeyFilter) (*SecretPolicyResult, error) {
+	return s.check(ctx, req, envID, func(db *gorm.DB) ([]PolicySecret, error) {
+		var secrets []models.Secret
+		if err := db.Select("key", "tags", "sensitive", "type_hint").Where("environment_id = ?", envID).Find(&secrets).Error; err != nil {
+			return nil, err
+		}
+		described := make([]PolicySecret, 0, len(secrets))
+		for i := range secrets {
+			if filter == nil || filter(secrets[i].Key) {
+				described = append(described, PolicySecretFrom(&secrets[i]))
+			}
+		}
+		return described, nil
+	})
+}
+
+// CheckSecret evaluates req against one secret of envID and returns a
+// *PolicyDeniedError when an enforced policy denies it, alongside the result
+// for decision logging.
+func (s *PolicyService) CheckSecret(ctx context.Context, req PolicyRequest, envID uuid.UUID, secret PolicySecret) (*SecretPolicyResult, error) {
+	result, err := s.check(ctx, req, envID, func(*gorm.DB) ([]PolicySecret, error) {
+		return []PolicySecret{secret}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, result.Err()
+}
+
+// PolicyEvaluation is the outcome of a dry run.
+type PolicyEvaluation struct {
+	Allowed bool          `json:"allowed"`
+	Matches []PolicyMatch `json:"matches"`
+}
+
+// Evaluate dry-runs a request against the org's enabled policies, or only
+// against condition when it is set, without enforcing or auditing anything.
+// Nothing is loaded from the request; it is evaluated as given.
+func (s *PolicyService) Evaluate(ctx context.Context, orgID uuid.UUID, req PolicyRequest, secret PolicySecret, condition string) (*PolicyEvaluation, error) {
+	if !slices.Contains(policyActions, req.Action) {
+		return nil, fmt.Errorf("%w: action must be one of %s", ErrInvalidAccessPolicy, strings.Join(policyActions, ", "))
+	}
+	if req.Time.IsZero() {
+		req.Time = time.Now().UTC()
+	}
+	var policies []models.AccessPolicy
+	if condition = strings.TrimSpace(condition); condition != "" {
+		if _, err := s.compile(condition); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAccessPolicy, err)
+		}
+		policies = []models.AccessPolicy{{Name: "draft", Condition: condition, Mode: models.PolicyModeEnforce}}
+	} else {
+		var err error
+		if policies, err = applicablePolicies(database.GetDB().WithContext(ctx), orgID, req.Action); err != nil {
+			return nil, err
+		}
+	}
+	evaluation := &PolicyEvaluation{Allowed: true, Matches: s.evaluate(policies, req, secret)}
+	if evaluation.Matches == nil {
+		evaluation.Matches = []PolicyMatch{}
+	}
+	for _, match := range evaluation.Matches {
+		if match.denies() {
+			evaluation.Allowed = false
+		}
+	}
+	return evaluation, nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+const prodDBOffHours = `principal.type == "agent" && "prod-db" in secret.tags &&
+	(request.time.getHours("Europe/Berlin") < 9 || request.time.getHours("Europe/Berlin") >= 18)`
+
+func TestNormalizePolicy(t *testing.T) {
+	s := NewPolicyService(nil)
+	in, err := s.normalizePolicy(AccessPolicyInput{
+		Name:      " No prod-db off hours ",
+		Actions:   []string{models.PolicyActionSecretsResolve, models.PolicyActionSecretsResolve},
+		Condition: prodDBOffHours,
+	})
+	if err != nil {
+		t.Fatalf("normalizePolicy() returned %v", err)
+	}
+	if in.Name != "No prod-db off hours" || in.Mode != models.PolicyModeEnforce || !*in.Enabled || !reflect.DeepEqual(in.Actions, []string{models.PolicyActionSecretsResolve}) {
+		t.Fatalf("normalizePolicy() = %+v", in)
+	}
+
+	for name, bad := range map[string]AccessPolicyInput{
+		"syntax":      {Name: "x", Condition: `principal.type ==`},
+		"not bool":    {Name: "x", Condition: `secret.key + "x"`},
+		"unknown var": {Name: "x", Condition: `user.role == "Developer"`},
+		"action":      {Name: "x", Condition: `true`, Actions: []string{"secrets.fly"}},
+		"mode":        {Name: "x", Condition: `true`, Mode: "warn"},
+		"no name":     {Condition: `true`},
+	} {
+		if _, err := s.normalizePolicy(bad); !errors.Is(err, ErrInvalidAccessPolicy) {
+			t.Errorf("%s: normalizePolicy() error = %v, want ErrInvalidAccessPolicy", name, err)
+		}
+	}
+}
+
+func TestEvaluatePolicies(t *testing.T) {
+	s := NewPolicyService(nil)
+	enforce := models.AccessPolicy{ID: uuid.New(), Name: "prod-db hours", Condition: prodDBOffHours, Mode: models.PolicyModeEnforce}
+	audit := models.AccessPolicy{ID: uuid.New(), Name: "watch exports", Condition: `action == "secrets.export" && environment == "production"`, Mode: models.PolicyModeAudit}
+	policies := []models.AccessPolicy{enforce, audit}
+
+	agent := PolicyPrincipal{Type: "agent", ID: uuid.NewString(), Name: "deploy-bot"}
+	night := time.Date(2026, 3, 2, 21, 0, 0, 0, time.UTC) // 22:00 in Berlin
+	day := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	tagged := PolicySecret{Key: "DATABASE_URL", Tags: []string{"prod-db"}}
+
+	matches := s.evaluate(policies, PolicyRequest{Principal: agent, Action: models.PolicyActionSecretsResolve, Time: night}, tagged)
+	if len(matches) != 1 || matches[0].PolicyID != enforce.ID || !matches[0].denies() {
+		t.Fatalf("evaluate(night) = %+v, want the enforced policy", matches)
+	}
+	if matches := s.evaluate(policies, PolicyRequest{Principal: agent, Action: models.PolicyActionSecretsResolve, Time: day}, tagged); len(matches) != 0 {
+		t.Fatalf("evaluate(day) = %+v, want none", matches)
+	}
+	if matches := s.evaluate(policies, PolicyRequest{Principal: agent, Action: models.PolicyActionSecretsResolve, Time: night}, PolicySecret{Key: "LOG_LEVEL"}); len(matches) != 0 {
+		t.Fatalf("evaluate(untagged) = %+v, want none", matches)
+	}
+
+	user := PolicyPrincipal{Type: "user", Role: "Developer"}
+	matches = s.evaluate(policies, PolicyRequest{Principal: user, Action: models.PolicyActionSecretsExport, Environment: "production", Time: day}, PolicySecret{Key: "API_KEY"})
+	if len(matches) != 1 || matches[0].PolicyID != audit.ID || matches[0].denies() {
+		t.Fatalf("evaluate(export) = %+v, want only the audit-mode policy", matches)
+	}
+}
+
+func TestEvaluatePolicyErrorFailsClosed(t *testing.T) {
+	s := NewPolicyService(nil)
+	broken := models.AccessPolicy{ID: uuid.New(), Name: "broken", Condition: `secret.owner == "team-a"`, Mode: models.PolicyModeEnforce}
+	matches := s.evaluate([]models.AccessPolicy{broken}, PolicyRequest{Time: time.Now()}, PolicySecret{Key: "A"})
+	if len(matches) != 1 || matches[0].Error == "" || !matches[0].denies() {
+		t.Fatalf("evaluate() = %+v, want a denying match with an error", matches)
+	}
+}
+
+func TestEvaluateDraftCondition(t *testing.T) {
+	s := NewPolicyService(nil)
+	req := PolicyRequest{
+		Principal:   PolicyPrincipal{Type: "user", Role: "Developer"},
+		Action:      models.PolicyActionSecretsExport,
+		Environment: "production",
+	}
+	condition := `principal.role == "Developer" && action == "secrets.export" && environment == "production"`
+	evaluation, err := s.Evaluate(context.Background(), uuid.New(), req, PolicySecret{Key: "API_KEY"}, condition)
+	if err != nil {
+		t.Fatalf("Evaluate() returned %v", err)
+	}
+	if evaluation.Allowed || len(evaluation.Matches) != 1 {
+		t.Fatalf("Evaluate() = %+v, want a denial", evaluation)
+	}
+
+	req.Principal.Role = "Admin"
+	if evaluation, err = s.Evaluate(context.Background(), uuid.New(), req, PolicySecret{Key: "API_KEY"}, condition); err != nil || !evaluation.Allowed {
+		t.Fatalf("Evaluate(admin) = %+v, %v, want allowed", evaluation, err)
+	}
+
+	if _, err := s.Evaluate(context.Background(), uuid.New(), PolicyRequest{Action: "secrets.fly"}, PolicySecret{}, condition); !errors.Is(err, ErrInvalidAccessPolicy) {
+		t.Fatalf("Evaluate(bad action) error = %v, want ErrInvalidAccessPolicy", err)
+	}
+}
+
+func TestSecretPolicyResult(t *testing.T) {
+	var none *SecretPolicyResult
+	if !none.Allows("A") || none.Err() != nil || none.ErrIfAllDenied() != nil || none.Decision() != nil || none.Skipped() != nil {
+		t.Fatal("a nil result must allow everything")
+	}
+
+	policyID := uuid.New()
+	deny := PolicyMatch{PolicyID: policyID, Name: "no prod-db", Mode: models.PolicyModeEnforce}
+	result := &SecretPolicyResult{
+		Denied:    map[string]PolicyMatch{"DB_URL": deny},
+		Matches:   map[string][]PolicyMatch{"DB_URL": {deny}},
+		evaluated: 2,
+	}
+	if result.Allows("DB_URL") || !result.Allows("API_KEY") {
+		t.Fatal("Allows() disagrees with Denied")
+	}
+	if err := result.Err("API_KEY"); err != nil {
+		t.Fatalf("Err(API_KEY) = %v, want nil", err)
+	}
+	var denied *PolicyDeniedError
+	if err := result.Err("API_KEY", "DB_URL"); !errors.As(err, &denied) || denied.PolicyID != policyID || denied.Key != "DB_URL" || !errors.Is(err, ErrPolicyDenied) {
+		t.Fatalf("Err() = %v", err)
+	}
+	if err := result.ErrIfAllDenied(); err != nil {
+		t.Fatalf("ErrIfAllDenied() = %v with one of two keys allowed", err)
+	}
+	if got := result.Skipped(); !reflect.DeepEqual(got, []SkippedSecret{{Key: "DB_URL", Reason: SkipReasonPolicyDenied}}) {
+		t.Fatalf("Skipped() = %+v", got)
+	}
+	result.evaluated = 1
+	if err := result.ErrIfAllDenied(); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatalf("ErrIfAllDenied() = %v with every key denied", err)
+	}
+}
+
+func TestMergeSkipped(t *testing.T) {
+	got := MergeSkipped([]SkippedSecret{{Key: "B", Reason: SkipReasonExpired}}, nil, []SkippedSecret{{Key: "A", Reason: SkipReasonPolicyDenied}})
+	want := []SkippedSecret{{Key: "A", Reason: SkipReasonPolicyDenied}, {Key: "B", Reason: SkipReasonExpired}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("MergeSkipped() = %+v, want %+v", got, want)
+	}
+	if MergeSkipped(nil, nil) != nil {
+		t.Fatal("MergeSkipped() of nothing must stay nil")
+	}
+}
@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -9,9 +11,13 @@ import (
 	"github.com/google/uuid"
 )
 
-type AdminService struct{}
+type AdminService struct {
+	auditService *AuditService
+}
 
-func NewAdminService() *AdminService { return &AdminService{} }
+func NewAdminService(auditService *AuditService) *AdminService {
+	return &AdminService{auditService: auditService}
+}
 
 func (s *AdminService) ListUsers(query string, limit int) ([]models.User, error) {
 	db := database.GetDB()
@@ -31,7 +37,7 @@ func (s *AdminService) ListUsers(query string, limit int) ([]models.User, error)
 	return users, nil
 }
 
-func (s *AdminService) UpdateUserTier(userID uuid.UUID, tier string) (*models.User, error) {
+func (s *AdminService) UpdateUserTier(ctx context.Context, adminID, userID uuid.UUID, tier string, ip string) (*models.User, error) {
 	tier = strings.TrimSpace(strings.ToLower(tier))
 	if tier != "free" && tier != "starter" && tier != "team" {
 		return nil, fmt.Errorf("invalid tier")
@@ -41,6 +47,7 @@ func (s *AdminService) UpdateUserTier(userID uuid.UUID, tier string) (*models.Us
 	if err := db.First(&user, userID).Error; err != nil {
 		return nil, err
 	}
+	previous := user.SubscriptionTier
 	user.SubscriptionTier = tier
 	if tier == "free" {
 		user.SubscriptionStatus = string(models.StatusActive)
@@ -48,6 +55,10 @@ func (s *AdminService) UpdateUserTier(userID uuid.UUID, tier string) (*models.Us
 	if err := db.Save(&user).Error; err != nil {
 		return nil, err
 	}
+	if s.auditService != nil {
+		metadata, _ := json.Marshal(map[string]any{"tier": tier, "previous_tier": previous, "source": "admin"})
+		_ = s.auditService.LogUser(ctx, adminID, user.ID, models.ActionUserTierChange, ip, metadata)
+	}
 	return &user, nil
 }
 
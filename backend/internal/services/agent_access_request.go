@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAccessRequestNotFound = errors.New("access request not found")
+	ErrAccessRequestDecided  = errors.New("access request was already decided or has expired")
+	ErrInvalidAccessRequest  = errors.New("invalid access request")
+	ErrTooManyAccessRequests = errors.New("too many pending access requests")
+)
+
+const (
+	DefaultAccessRequestDuration = time.Hour
+	MaxAccessRequestDuration     = 24 * time.Hour
+	// accessRequestPendingTTL is how long a request waits for a decision.
+	accessRequestPendingTTL  = 24 * time.Hour
+	maxPendingAccessRequests = 5
+)
+
+// AccessRequestInput is what an agent asks for. Keys and AllSecrets follow
+// the same rules as a grant: all secrets must be asked for explicitly.
+type AccessRequestInput struct {
+	Project     string
+	Environment string
+	Keys        []string
+	AllSecrets  bool
+	Purpose     string
+	Duration    time.Duration
+}
+
+// AccessRequestService lets agents ask for just-in-time access. Humans with
+// agents.manage are notified and approve or deny; approval creates a
+// secrets.inject grant that expires after the approved duration.
+type AccessRequestService struct {
+	agents      *AgentService
+	emailSender EmailSender
+	frontendURL string
+	audit       *AuditService
+}
+
+func NewAccessRequestService(agents *AgentService, emailSender EmailSender, frontendURL string, audit *AuditService) *AccessRequestService {
+	if emailSender == nil {
+		emailSender = &LogEmailSender{}
+	}
+	return &AccessRequestService{agents: agents, emailSender: emailSender, frontendURL: frontendURL, audit: audit}
+}
+
+func normalizeAccessRequestDuration(d time.Duration) (time.Duration, error) {
+	if d == 0 {
+		return DefaultAccessRequestDuration, nil
+	}
+	if d < time.Minute || d > MaxAccessRequestDuration {
+		return 0, fmt.Errorf("%w: duration must be between 1m and %s", ErrInvalidAccessRequest, MaxAccessRequestDuration)
+	}
+	return d.Round(time.Second), nil
+}
+
+// expirePending marks undecided requests past their deadline as expired.
+func expirePending(db *gorm.DB, where string, args ...any) {
+	_ = db.Model(&models.AgentAccessRequest{}).
+		Where("status = ? AND expires_at <= ?", models.AccessRequestStatusPending, time.Now().UTC()).
+		Where(where, args...).
+		Update("status", models.AccessRequestStatusExpired).Error
+}
+
+// Create records a pending request and notifies the org's approvers. An
+// identical request that is still pending is returned instead of creating a
+// new one, so an agent retrying does not page humans twice; created reports
+// which happened.
+func (s *AccessRequestService) Create(ctx context.Context, agent *models.AgentIdentity, credential *models.AgentCredential, in AccessRequestInput, ip string) (*models.AgentAccessRequest, bool, error) {
+	in.Purpose = strings.TrimSpace(in.Purpose)
+	if in.Purpose == "" || len(in.Purpose) > 200 {
+		return nil, false, fmt.Errorf("%w: purpose must be between 1 and 200 characters", ErrInvalidAccessRequest)
+	}
+	keys, err := normalizeKeys(in.Keys)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidAccessRequest, err)
+	}
+	if len(keys) > 500 {
+		return nil, false, fmt.Errorf("%w: too many keys", ErrInvalidAccessRequest)
+	}
+	if !in.AllSecrets && len(keys) == 0 {
+		return nil, false, fmt.Errorf("%w: request at least one secret key or explicitly ask for all secrets", ErrInvalidAccessRequest)
+	}
+	if in.AllSecrets {
+		keys = []string{}
+	}
+	duration, err := normalizeAccessRequestDuration(in.Duration)
+	if err != nil {
+		return nil, false, err
+	}
+
+	db := database.GetDB().WithContext(ctx)
+	env, err := resolveSelector(db, agent, in.Project, in.Environment)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: unknown project or environment", ErrInvalidAccessRequest)
+	}
+	encoded, err := json.Marshal(keys)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now().UTC()
+	expirePending(db, "agent_id = ?", agent.ID)
+	var pending []models.AgentAccessRequest
+	if err := db.Where("agent_id = ? AND status = ?", agent.ID, models.AccessRequestStatusPending).Find(&pending).Error; err != nil {
+		return nil, false, err
+	}
+	for i := range pending {
+		existing := &pending[i]
+		if existing.EnvironmentID == env.ID && existing.AllowAllSecrets == in.AllSecrets && string(existing.Keys) == string(encoded) {
+			return existing, false, nil
+		}
+	}
+	if len(pending) >= maxPendingAccessRequests {
+		return nil, false, ErrTooManyAccessRequests
+	}
+
+	request := &models.AgentAccessRequest{
+		OrgID:           agent.OrgID,
+		AgentID:         agent.ID,
+		CredentialID:    credential.ID,
+		EnvironmentID:   env.ID,
+		Keys:            datatypes.JSON(encoded),
+		AllowAllSecrets: in.AllSecrets,
+		Purpose:         in.Purpose,
+		DurationSeconds: int(duration / time.Second),
+		ExpiresAt:       now.Add(accessRequestPendingTTL),
+	}
+	if err := db.Create(request).Error; err != nil {
+		return nil, false, err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{
+			"environment_id":   env.ID,
+			"keys":             keys,
+			"all_secrets":      in.AllSecrets,
+			"purpose":          in.Purpose,
+			"duration_seconds": request.DurationSeconds,
+		})
+		_ = s.audit.LogAgent(ctx, agent.ID, agent.OrgID, request.ID, models.ActionAccessRequestCreate, "agent_access_request", ip, datatypes.JSON(metadata))
+	}
+
+	notice := AgentAccessRequestNotice{
+		AgentName:       agent.Name,
+		EnvironmentName: env.Name,
+		Keys:            keys,
+		AllSecrets:      in.AllSecrets,
+		Purpose:         in.Purpose,
+		Duration:        duration,
+	}
+	go s.notify(context.WithoutCancel(ctx), request, env.ProjectID, notice)
+	return request, true, nil
+}
+
+// approverEmails returns the org owner and every member whose role carries
+// agents.manage.
+func approverEmails(db *gorm.DB, org *models.Organization) ([]string, error) {
+	var emails []string
+	if err := db.Model(&models.User{}).
+		Joins("JOIN org_members ON org_members.user_id = users.id").
+		Joins("JOIN role_permissions ON role_permissions.role_id = org_members.role_id").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("org_members.org_id = ? AND permissions.name = ?", org.ID, models.PermissionAgentsManage).
+		Pluck("users.email", &emails).Error; err != nil {
+		return nil, err
+	}
+	if org.Owner.Email != "" {
+		emails = append(emails, org.Owner.Email)
+	}
+	slices.Sort(emails)
+	return slices.Compact(emails), nil
+}
+
+func (s *AccessRequestService) notify(ctx context.Context, request *models.AgentAccessRequest, projectID uuid.UUID, notice AgentAccessRequestNotice) {
+	db := database.GetDB().WithContext(ctx)
+	var org models.Organization
+	if err := db.Preload("Owner").First(&org, request.OrgID).Error; err != nil {
+		log.Printf("[envo] access request %s: load organization: %v", request.ID, err)
+		return
+	}
+	var project models.Project
+	if err := db.First(&project, projectID).Error; err == nil {
+		notice.ProjectName = project.Name
+	}
+	emails, err := approverEmails(db, &org)
+	if err != nil {
+		log.Printf("[envo] access request %s: find approvers: %v", request.ID, err)
+		return
+	}
+	reviewURL := fmt.Sprintf("%s/orgs/%s", s.frontendURL, org.ID)
+	for _, email := range emails {
+		if err := s.emailSender.SendAgentAccessRequest(email, org.Name, notice, reviewURL); err != nil {
+			log.Printf("[envo] access request %s: notify %s: %v", request.ID, email, err)
+		}
+	}
+}
+
+// GetForAgent returns one of the agent's own requests so it can poll for a
+// decision.
+func (s *AccessRequestService) GetForAgent(ctx context.Context, agent *models.AgentIdentity, requestID uuid.UUID) (*models.AgentAccessRequest, error) {
+	db := database.GetDB().WithContext(ctx)
+	expirePending(db, "id = ?", requestID)
+	var request models.AgentAccessRequest
+	if err := db.Preload("Grant").Where("id = ? AND agent_id = ?", requestID, agent.ID).First(&request).Error; err != nil {
+		return nil, ErrAccessRequestNotFound
+	}
+	return &request, nil
+}
+
+// List returns an org's requests, newest first, optionally filtered by status.
+func (s *AccessRequestService) List(ctx context.Context, orgID uuid.UUID, status string, limit int) ([]models.AgentAccessRequest, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	db := database.GetDB().WithContext(ctx)
+	expirePending(db, "org_id = ?", orgID)
+	q := db.Preload("Agent").Preload("Environment.Project").Where("org_id = ?", orgID)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var requests []models.AgentAccessRequest
+	err := q.Order("created_at DESC").Limit(limit).Find(&requests).Error
+	return requests, err
+}
+
+// decide moves a pending request to status. Only one decision wins when
+// several approvers act at once.
+func decide(db *gorm.DB, orgID, requestID, userID uuid.UUID, status, note string) (*models.AgentAccessRequest, error) {
+	var request models.AgentAccessRequest
+	if err := db.Where("id = ? AND org_id = ?", requestID, orgID).First(&request).Error; err != nil {
+		return nil, ErrAccessRequestNotFound
+	}
+	now := time.Now().UTC()
+	result := db.Model(&models.AgentAccessRequest{}).
+		Where("id = ? AND status = ? AND expires_at > ?", requestID, models.AccessRequestStatusPending, now).
+		Updates(map[string]any{"status": status, "decided_by": userID, "decided_at": now, "decision_note": note})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrAccessRequestDecided
+	}
+	request.Status = status
+	request.DecidedBy = &userID
+	request.DecidedAt = &now
+	request.DecisionNote = note
+	return &request, nil
+}
+
+func normalizeDecisionNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if len(note) > 500 {
+		return "", fmt.Errorf("%w: note must be at most 500 characters", ErrInvalidAccessRequest)
+	}
+	return note, nil
+}
+
+// approvedAccessDuration lets an approver shorten, but never extend, the
+// duration an agent asked for.
+func approvedAccessDuration(requested, override time.Duration) time.Duration {
+	if override <= 0 || override > requested {
+		override = requested
+	}
+	if override < time.Minute {
+		override = time.Minute
+	}
+	return override
+}
+
+// Approve grants what the agent asked for, for the requested duration or a
+// shorter one chosen by the approver. A failed grant puts the request back to
+// pending.
+func (s *AccessRequestService) Approve(ctx context.Context, userID, orgID, requestID uuid.UUID, duration time.Duration, note, ip string) (*models.AgentAccessRequest, error) {
+	note, err := normalizeDecisionNote(note)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB().WithContext(ctx)
+	request, err := decide(db, orgID, requestID, userID, models.AccessRequestStatusApproved, note)
+	if err != nil {
+		return nil, err
+	}
+	duration = approvedAccessDuration(time.Duration(request.DurationSeconds)*time.Second, duration)
+
+	var keys []string
+	if err := json.Unmarshal(request.Keys, &keys); err != nil {
+		return nil, fmt.Errorf("invalid stored access request: %w", err)
+	}
+	expiresAt := time.Now().UTC().Add(duration)
+	grant, err := s.agents.CreateGrant(ctx, userID, orgID, request.AgentID, models.AgentCapabilitySecretsInject, EnvironmentTarget(request.EnvironmentID), KeyScope{All: request.AllowAllSecrets, Allow: keys}, &expiresAt, ip)
+	if err != nil {
+		// Put the request back so it can be approved again or denied.
+		_ = db.Model(&models.AgentAccessRequest{}).Where("id = ?", request.ID).
+			Updates(map[string]any{"status": models.AccessRequestStatusPending, "decided_by": nil, "decided_at": nil, "decision_note": ""}).Error
+		return nil, err
+	}
+	if err := db.Model(&models.AgentAccessRequest{}).Where("id = ?", request.ID).Update("grant_id", grant.ID).Error; err != nil {
+		return nil, err
+	}
+	request.GrantID = &grant.ID
+	request.Grant = grant
+
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{
+			"agent_id":   request.AgentID,
+			"grant_id":   grant.ID,
+			"expires_at": expiresAt,
+			"note":       note,
+		})
+		_ = s.audit.Log(ctx, userID, orgID, request.ID, models.ActionAccessRequestApprove, "agent_access_request", ip, datatypes.JSON(metadata))
+	}
+	return request, nil
+}
+
+// Deny closes a pending request without granting anything.
+func (s *AccessRequestService) Deny(ctx context.Context, userID, orgID, requestID uuid.UUID, note, ip string) (*models.AgentAccessRequest, error) {
+	note, err := normalizeDecisionNote(note)
+	if err != nil {
+		return nil, err
+	}
+	request, err := decide(database.GetDB().WithContext(ctx), orgID, requestID, userID, models.AccessRequestStatusDenied, note)
+	if err != nil {
+		return nil, err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"agent_id": request.AgentID, "note": note})
+		_ = s.audit.Log(ctx, userID, orgID, request.ID, models.ActionAccessRequestDeny, "agent_access_request", ip, datatypes.JSON(metadata))
+	}
+	return request, nil
+}
@@ -0,0 +1,677 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAnomalyRuleNotFound = errors.New("anomaly rule not found")
+	ErrInvalidAnomalyRule  = errors.New("invalid anomaly rule")
+)
+
+// Detector tuning. Tests shorten them.
+var (
+	// anomalySettleDelay holds back the newest audit events so entries
+	// committed slightly out of order are not skipped by the cursor.
+	anomalySettleDelay = 10 * time.Second
+	// minAnomalyHistory is how many resolves an agent needs in a rule's
+	// lookback before "never seen before" rules apply, so a new agent does
+	// not alert on everything it does.
+	minAnomalyHistory = 20
+)
+
+const (
+	anomalyBatchSize          = 500
+	maxAnomalyRulesPerOrg     = 50
+	defaultAnomalyLookbackDay = 30
+	maxAnomalyLookbackDays    = 365
+	maxAnomalyWindowMinutes   = 24 * 60
+)
+
+var anomalyKinds = []string{models.AnomalyNewIP, models.AnomalyNewEnvironment, models.AnomalyNewKeys, models.AnomalyUnusualHour, models.AnomalyResolveSpike}
+
+// AnomalyRuleInput configures an anomaly rule.
+type AnomalyRuleInput struct {
+	AgentID       *uuid.UUID
+	Name          string
+	Kind          string
+	LookbackDays  int
+	Threshold     int
+	WindowMinutes int
+	SpikeFactor   float64
+	TimeZone      string
+	NotifyEmail   bool
+	WebhookURL    string
+	Suspend       bool
+}
+
+// normalizeWebhookURL accepts https URLs, and plain http for loopback hosts
+// only when allowLoopbackHTTP is set, so local development can run a receiver.
+func normalizeWebhookURL(raw string, allowLoopbackHTTP bool) (string, error) {
+	return normalizeEndpointURL(raw, "webhook URL", allowLoopbackHTTP)
+}
+
+// normalizeEndpointURL applies the webhook URL rules to an outbound
+// endpoint; what names it in errors.
+func normalizeEndpointURL(raw, what string, allowLoopbackHTTP bool) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil || u.Fragment != "" {
+		return "", fmt.Errorf("%s must be an absolute URL without credentials or fragment", what)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); !allowLoopbackHTTP || (host != "localhost" && (ip == nil || !ip.IsLoopback())) {
+			return "", fmt.Errorf("%s must use https", what)
+		}
+	default:
+		return "", fmt.Errorf("%s must use https", what)
+	}
+	if len(raw) > 500 {
+		return "", fmt.Errorf("%s must be at most 500 characters", what)
+	}
+	return raw, nil
+}
+
+func normalizeAnomalyRule(in AnomalyRuleInput, allowLoopbackHTTP bool) (AnomalyRuleInput, error) {
+	var err error
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || len(in.Name) > 120 {
+		return in, fmt.Errorf("rule name must be between 1 and 120 characters")
+	}
+	if !slices.Contains(anomalyKinds, in.Kind) {
+		return in, fmt.Errorf("kind must be one of %s", strings.Join(anomalyKinds, ", "))
+	}
+	if in.LookbackDays == 0 {
+		in.LookbackDays = defaultAnomalyLookbackDay
+	}
+	if in.LookbackDays < 1 || in.LookbackDays > maxAnomalyLookbackDays {
+		return in, fmt.Errorf("lookback must be between 1 and %d days", maxAnomalyLookbackDays)
+	}
+	if in.Kind == models.AnomalyResolveSpike {
+		if in.Threshold < 1 {
+			return in, fmt.Errorf("a resolve spike rule needs a threshold of at least 1")
+		}
+		if in.WindowMinutes < 1 || in.WindowMinutes > maxAnomalyWindowMinutes {
+			return in, fmt.Errorf("window must be between 1 and %d minutes", maxAnomalyWindowMinutes)
+		}
+		if in.SpikeFactor < 0 {
+			return in, fmt.Errorf("spike factor cannot be negative")
+		}
+		if time.Duration(in.WindowMinutes)*time.Minute >= time.Duration(in.LookbackDays)*24*time.Hour {
+			return in, fmt.Errorf("window must be shorter than the lookback")
+		}
+	} else {
+		in.Threshold, in.WindowMinutes, in.SpikeFactor = 0, 0, 0
+	}
+	in.TimeZone = strings.TrimSpace(in.TimeZone)
+	if in.TimeZone == "" {
+		in.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(in.TimeZone); err != nil || len(in.TimeZone) > 64 {
+		return in, fmt.Errorf("unknown time zone %q", in.TimeZone)
+	}
+	if in.WebhookURL, err = normalizeWebhookURL(in.WebhookURL, allowLoopbackHTTP); err != nil {
+		return in, err
+	}
+	if !in.NotifyEmail && in.WebhookURL == "" && !in.Suspend {
+		return in, fmt.Errorf("a rule must email, call a webhook or suspend the agent")
+	}
+	return in, nil
+}
+
+// AgentAnomalyService manages anomaly rules and evaluates them against agent
+// resolves in the audit log. Delivered values cannot be recalled, so rules
+// exist to warn early and, if asked, to suspend the agent before it resolves
+// again.
+type AgentAnomalyService struct {
+	agents         *AgentService
+	audit          *AuditService
+	databases      *DatabaseCredentialService
+	emailSender    EmailSender
+	frontendURL    string
+	encryptor      Encryptor
+	localEncryptor Encryptor
+	egress         egressGuard
+	httpClient     *http.Client
+	now            func() time.Time
+}
+
+// NewAgentAnomalyService creates an anomaly service. databases may be nil
+// when dynamic database credentials are disabled. Rule webhooks may only
+// point at public addresses unless allowPrivateEndpoints is set, which is
+// meant for development.
+func NewAgentAnomalyService(agents *AgentService, audit *AuditService, databases *DatabaseCredentialService, emailSender EmailSender, frontendURL string, encryptor, localEncryptor Encryptor, allowPrivateEndpoints bool) *AgentAnomalyService {
+	if emailSender == nil {
+		emailSender = &LogEmailSender{}
+	}
+	egress := egressGuard{allowPrivate: allowPrivateEndpoints}
+	return &AgentAnomalyService{
+		agents:         agents,
+		audit:          audit,
+		databases:      databases,
+		emailSender:    emailSender,
+		frontendURL:    frontendURL,
+		encryptor:      encryptor,
+		localEncryptor: localEncryptor,
+		egress:         egress,
+		httpClient:     egress.httpClient(10 * time.Second),
+		now:            time.Now,
+	}
+}
+
+// CreateRule adds an anomaly rule for one agent of the org, or for all of
+// them when in.AgentID is nil. For a rule with a webhook it returns the
+// generated signing secret, which is not shown again.
+func (s *AgentAnomalyService) CreateRule(ctx context.Context, userID, orgID uuid.UUID, in AnomalyRuleInput, ip string) (*models.AgentAnomalyRule, string, error) {
+	in, err := normalizeAnomalyRule(in, s.egress.allowPrivate)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidAnomalyRule, err)
+	}
+	if in.WebhookURL != "" {
+		u, _ := url.Parse(in.WebhookURL)
+		if err := s.egress.checkHost(ctx, u.Hostname()); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidAnomalyRule, err)
+		}
+	}
+	db := database.GetDB().WithContext(ctx)
+	if in.AgentID != nil {
+		var count int64
+		if err := db.Model(&models.AgentIdentity{}).Where("id = ? AND org_id = ?", *in.AgentID, orgID).Count(&count).Error; err != nil {
+			return nil, "", err
+		}
+		if count == 0 {
+			return nil, "", ErrAgentNotFound
+		}
+	}
+	var existing int64
+	if err := db.Model(&models.AgentAnomalyRule{}).Where("org_id = ?", orgID).Count(&existing).Error; err != nil {
+		return nil, "", err
+	}
+	if existing >= maxAnomalyRulesPerOrg {
+		return nil, "", fmt.Errorf("%w: an organization can have at most %d anomaly rules", ErrInvalidAnomalyRule, maxAnomalyRulesPerOrg)
+	}
+	rule := &models.AgentAnomalyRule{
+		OrgID:         orgID,
+		AgentID:       in.AgentID,
+		Name:          in.Name,
+		Kind:          in.Kind,
+		LookbackDays:  in.LookbackDays,
+		Threshold:     in.Threshold,
+		WindowMinutes: in.WindowMinutes,
+		SpikeFactor:   in.SpikeFactor,
+		TimeZone:      in.TimeZone,
+		NotifyEmail:   in.NotifyEmail,
+		WebhookURL:    in.WebhookURL,
+		Suspend:       in.Suspend,
+		CreatedBy:     userID,
+	}
+	var secret string
+	if rule.WebhookURL != "" {
+		if s.encryptor == nil {
+			return nil, "", fmt.Errorf("secret encryption is not configured")
+		}
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, "", err
+		}
+		secret = "whsec_" + hex.EncodeToString(raw)
+		if rule.EncryptedWebhookSecret, err = s.encryptor.Encrypt(ctx, secret, orgID.String()); err != nil {
+			return nil, "", fmt.Errorf("failed to encrypt webhook secret: %w", err)
+		}
+		rule.WebhookKeyID = s.encryptor.KeyID()
+	}
+	// NotifyEmail defaults to true in the schema, so a false value must be
+	// written explicitly.
+	if err := db.Select("*").Omit("DeletedAt").Create(rule).Error; err != nil {
+		return nil, "", err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"name": rule.Name, "kind": rule.Kind, "agent_id": rule.AgentID, "suspend": rule.Suspend})
+		_ = s.audit.Log(ctx, userID, orgID, rule.ID, models.ActionAgentAnomalyRuleCreate, "agent_anomaly_rule", ip, datatypes.JSON(metadata))
+	}
+	return rule, secret, nil
+}
+
+// ListRules lists an org's anomaly rules, oldest first.
+func (s *AgentAnomalyService) ListRules(ctx context.Context, orgID uuid.UUID) ([]models.AgentAnomalyRule, error) {
+	var rules []models.AgentAnomalyRule
+	err := database.GetDB().WithContext(ctx).Where("org_id = ?", orgID).Order("created_at ASC").Find(&rules).Error
+	return rules, err
+}
+
+// DeleteRule stops evaluating a rule. Its past alerts are kept.
+func (s *AgentAnomalyService) DeleteRule(ctx context.Context, userID, orgID, ruleID uuid.UUID, ip string) error {
+	result := database.GetDB().WithContext(ctx).Where("id = ? AND org_id = ?", ruleID, orgID).Delete(&models.AgentAnomalyRule{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAnomalyRuleNotFound
+	}
+	if s.audit != nil {
+		_ = s.audit.Log(ctx, userID, orgID, ruleID, models.ActionAgentAnomalyRuleDelete, "agent_anomaly_rule", ip, nil)
+	}
+	return nil
+}
+
+// ListAlerts lists an org's anomaly alerts, most recent first.
+func (s *AgentAnomalyService) ListAlerts(ctx context.Context, orgID uuid.UUID, agentID *uuid.UUID, limit int) ([]models.AgentAnomalyAlert, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	q := database.GetDB().WithContext(ctx).Preload("Agent").Preload("Rule", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Where("org_id = ?", orgID)
+	if agentID != nil {
+		q = q.Where("agent_id = ?", *agentID)
+	}
+	var alerts []models.AgentAnomalyAlert
+	err := q.Order("event_at DESC").Limit(limit).Find(&alerts).Error
+	return alerts, err
+}
+
+// Run evaluates new agent resolves every interval until ctx is cancelled.
+func (s *AgentAnomalyService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if alerts, err := s.RunOnce(ctx); err != nil {
+			log.Printf("[envo] agent anomaly detection: %v", err)
+		} else if alerts > 0 {
+			log.Printf("[envo] agent anomaly detection: %d alerts", alerts)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// firedAlert is an alert recorded during a scan, acted on once the scan
+// commits.
+type firedAlert struct {
+	alert models.AgentAnomalyAlert
+	rule  models.AgentAnomalyRule
+	event models.AuditLog
+}
+
+// RunOnce evaluates the next batch of agent resolves past the cursor and
+// returns how many alerts fired. Only one instance scans at a time; the
+// others skip the locked cursor. Alerts are recorded in the same transaction
+// that advances the cursor, so each event is evaluated once, and then acted
+// on. A crash between the two loses the notification but not the alert.
+func (s *AgentAnomalyService) RunOnce(ctx context.Context) (int, error) {
+	db := database.GetDB().WithContext(ctx)
+	upTo := s.now().UTC().Add(-anomalySettleDelay)
+	// A new installation starts watching from now rather than alerting on
+	// its whole history.
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.AgentAnomalyCursor{ID: 1, LastCreatedAt: upTo}).Error; err != nil {
+		return 0, err
+	}
+
+	var fired []firedAlert
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var cursor models.AgentAnomalyCursor
+		locked := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("id = ?", 1).Find(&cursor)
+		if locked.Error != nil || locked.RowsAffected == 0 {
+			return locked.Error // another instance is scanning
+		}
+		var events []models.AuditLog
+		if err := tx.Where("actor_type = ? AND action = ? AND agent_id IS NOT NULL", models.AuditActorAgent, models.ActionSecretRead).
+			Where("(created_at, id) > (?, ?) AND created_at <= ?", cursor.LastCreatedAt, cursor.LastAuditLogID, upTo).
+			Order("created_at ASC, id ASC").Limit(anomalyBatchSize).Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		rulesByOrg := map[uuid.UUID][]models.AgentAnomalyRule{}
+		for i := range events {
+			event := &events[i]
+			rules, ok := rulesByOrg[event.OrgID]
+			if !ok {
+				if err := tx.Where("org_id = ?", event.OrgID).Order("created_at ASC").Find(&rules).Error; err != nil {
+					return err
+				}
+				rulesByOrg[event.OrgID] = rules
+			}
+			for _, rule := range rules {
+				if rule.AgentID != nil && *rule.AgentID != *event.AgentID {
+					continue
+				}
+				detail, err := evaluateAnomalyRule(tx, &rule, event)
+				if err != nil {
+					return err
+				}
+				if detail == nil {
+					continue
+				}
+				encoded, _ := json.Marshal(detail)
+				alert := models.AgentAnomalyAlert{
+					OrgID:      event.OrgID,
+					AgentID:    *event.AgentID,
+					RuleID:     rule.ID,
+					AuditLogID: event.ID,
+					Kind:       rule.Kind,
+					Detail:     datatypes.JSON(encoded),
+					EventAt:    event.CreatedAt,
+				}
+				created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&alert)
+				if created.Error != nil {
+					return created.Error
+				}
+				if created.RowsAffected > 0 {
+					fired = append(fired, firedAlert{alert: alert, rule: rule, event: *event})
+				}
+			}
+		}
+		last := events[len(events)-1]
+		return tx.Model(&cursor).Updates(map[string]any{"last_created_at": last.CreatedAt, "last_audit_log_id": last.ID}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i := range fired {
+		s.act(ctx, &fired[i])
+	}
+	return len(fired), nil
+}
+
+// agentHistory selects the agent's resolves in [from, to).
+func agentHistory(tx *gorm.DB, event *models.AuditLog, from, to time.Time) *gorm.DB {
+	return tx.Model(&models.AuditLog{}).
+		Where("agent_id = ? AND actor_type = ? AND action = ?", *event.AgentID, models.AuditActorAgent, models.ActionSecretRead).
+		Where("created_at >= ? AND created_at < ?", from, to)
+}
+
+// evaluateAnomalyRule returns what was unusual about event under rule, or
+// nil when the rule does not fire.
+func evaluateAnomalyRule(tx *gorm.DB, rule *models.AgentAnomalyRule, event *models.AuditLog) (map[string]any, error) {
+	since := event.CreatedAt.Add(-time.Duration(rule.LookbackDays) * 24 * time.Hour)
+	if rule.Kind == models.AnomalyResolveSpike {
+		return evaluateResolveSpike(tx, rule, event, since)
+	}
+
+	var history int64
+	if err := agentHistory(tx, event, since, event.CreatedAt).Count(&history).Error; err != nil {
+		return nil, err
+	}
+	if history < int64(minAnomalyHistory) {
+		return nil, nil
+	}
+	switch rule.Kind {
+	case models.AnomalyNewIP:
+		if event.IPAddress == "" {
+			return nil, nil
+		}
+		var seen int64
+		if err := agentHistory(tx, event, since, event.CreatedAt).Where("ip_address = ?", event.IPAddress).Limit(1).Count(&seen).Error; err != nil || seen > 0 {
+			return nil, err
+		}
+		return map[string]any{"ip_address": event.IPAddress}, nil
+	case models.AnomalyNewEnvironment:
+		var seen int64
+		if err := agentHistory(tx, event, since, event.CreatedAt).Where("resource_id = ?", event.ResourceID).Limit(1).Count(&seen).Error; err != nil || seen > 0 {
+			return nil, err
+		}
+		return map[string]any{"environment_id": event.ResourceID}, nil
+	case models.AnomalyNewKeys:
+		var metadata struct {
+			Keys []string `json:"keys"`
+		}
+		if json.Unmarshal(event.Metadata, &metadata) != nil || len(metadata.Keys) == 0 {
+			return nil, nil
+		}
+		var known []string
+		if err := tx.Raw(`SELECT DISTINCT k.key FROM audit_logs, jsonb_array_elements_text(COALESCE(audit_logs.metadata->'keys', '[]'::jsonb)) AS k(key)
+WHERE audit_logs.agent_id = ? AND audit_logs.actor_type = ? AND audit_logs.action = ? AND audit_logs.resource_id = ? AND audit_logs.created_at >= ? AND audit_logs.created_at < ?`,
+			*event.AgentID, models.AuditActorAgent, models.ActionSecretRead, event.ResourceID, since, event.CreatedAt).Scan(&known).Error; err != nil {
+			return nil, err
+		}
+		if fresh := unseenKeys(metadata.Keys, known); len(fresh) > 0 {
+			return map[string]any{"environment_id": event.ResourceID, "keys": fresh}, nil
+		}
+		return nil, nil
+	case models.AnomalyUnusualHour:
+		loc, err := time.LoadLocation(rule.TimeZone)
+		if err != nil {
+			loc = time.UTC
+		}
+		hour := event.CreatedAt.In(loc).Hour()
+		var seen int64
+		if err := agentHistory(tx, event, since, event.CreatedAt).
+			Where("EXTRACT(HOUR FROM created_at AT TIME ZONE ?) = ?", loc.String(), hour).Limit(1).Count(&seen).Error; err != nil || seen > 0 {
+			return nil, err
+		}
+		return map[string]any{"hour": hour, "time_zone": loc.String()}, nil
+	}
+	return nil, nil
+}
+
+// evaluateResolveSpike fires when the resolves in the window ending with
+// event reach the threshold and exceed SpikeFactor times the agent's average
+// for a window of that length over the rest of the lookback. It fires at
+// most once per window.
+func evaluateResolveSpike(tx *gorm.DB, rule *models.AgentAnomalyRule, event *models.AuditLog, since time.Time) (map[string]any, error) {
+	window := time.Duration(rule.WindowMinutes) * time.Minute
+	windowStart := event.CreatedAt.Add(-window)
+	var count int64
+	if err := agentHistory(tx, event, windowStart, event.CreatedAt).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	count++ // the event itself
+	if count < int64(rule.Threshold) {
+		return nil, nil
+	}
+	var baseline int64
+	if rule.SpikeFactor > 0 {
+		if err := agentHistory(tx, event, since, windowStart).Count(&baseline).Error; err != nil {
+			return nil, err
+		}
+	}
+	average := spikeBaseline(baseline, event.CreatedAt.Sub(since), window)
+	if rule.SpikeFactor > 0 && float64(count) <= rule.SpikeFactor*average {
+		return nil, nil
+	}
+	var recent int64
+	if err := tx.Model(&models.AgentAnomalyAlert{}).
+		Where("rule_id = ? AND agent_id = ? AND event_at > ?", rule.ID, *event.AgentID, windowStart).
+		Limit(1).Count(&recent).Error; err != nil || recent > 0 {
+		return nil, err
+	}
+	return map[string]any{"resolves": count, "window_minutes": rule.WindowMinutes, "baseline": average}, nil
+}
+
+// spikeBaseline is the average number of resolves per window over the part
+// of the lookback before the current window.
+func spikeBaseline(resolves int64, lookback, window time.Duration) float64 {
+	windows := float64(lookback-window) / float64(window)
+	if windows <= 0 {
+		return 0
+	}
+	return float64(resolves) / windows
+}
+
+// unseenKeys returns the keys not in known, in their original order.
+func unseenKeys(keys, known []string) []string {
+	seen := make(map[string]bool, len(known))
+	for _, key := range known {
+		seen[key] = true
+	}
+	var fresh []string
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			fresh = append(fresh, key)
+		}
+	}
+	return fresh
+}
+
+// describeAnomaly is a one-line summary of an alert for people.
+func describeAnomaly(kind string, detail map[string]any, environment string) string {
+	switch kind {
+	case models.AnomalyNewIP:
+		return fmt.Sprintf("Resolved secrets from a new address, %v.", detail["ip_address"])
+	case models.AnomalyNewEnvironment:
+		return fmt.Sprintf("Resolved secrets from %s for the first time.", environment)
+	case models.AnomalyNewKeys:
+		return fmt.Sprintf("Received keys from %s it has not received before: %v.", environment, detail["keys"])
+	case models.AnomalyUnusualHour:
+		return fmt.Sprintf("Resolved secrets at an hour it is not usually active (%v:00 %v).", detail["hour"], detail["time_zone"])
+	case models.AnomalyResolveSpike:
+		return fmt.Sprintf("Resolved secrets %v times in %v minutes.", detail["resolves"], detail["window_minutes"])
+	}
+	return "Unusual activity."
+}
+
+// act audits an alert, suspends the agent if the rule says so, and notifies.
+// Failures are logged; the alert itself is already recorded.
+func (s *AgentAnomalyService) act(ctx context.Context, f *firedAlert) {
+	db := database.GetDB().WithContext(ctx)
+	var agent models.AgentIdentity
+	if err := db.Preload("Organization.Owner").First(&agent, f.alert.AgentID).Error; err != nil {
+		log.Printf("[envo] anomaly alert %s: load agent: %v", f.alert.ID, err)
+		return
+	}
+	var detail map[string]any
+	_ = json.Unmarshal(f.alert.Detail, &detail)
+
+	if f.rule.Suspend && agent.Status == models.AgentStatusActive {
+		reason := map[string]any{
+			"trigger":   "anomaly_rule",
+			"rule_id":   f.rule.ID,
+			"rule_name": f.rule.Name,
+			"kind":      f.rule.Kind,
+			"alert_id":  f.alert.ID,
+		}
+		if _, err := s.agents.UpdateAgentStatus(ctx, SystemActor(), agent.OrgID, agent.ID, models.AgentStatusSuspended, "", reason); err != nil {
+			log.Printf("[envo] anomaly alert %s: suspend agent %s: %v", f.alert.ID, agent.ID, err)
+		} else {
+			f.alert.Suspended = true
+			_ = db.Model(&f.alert).UpdateColumn("suspended", true).Error
+			if s.databases != nil {
+				s.databases.RevokeForAgent(ctx, agent.ID, nil)
+			}
+		}
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{
+			"alert_id":     f.alert.ID,
+			"rule_id":      f.rule.ID,
+			"rule_name":    f.rule.Name,
+			"kind":         f.rule.Kind,
+			"audit_log_id": f.event.ID,
+			"detail":       detail,
+			"suspended":    f.alert.Suspended,
+		})
+		_ = s.audit.LogAgent(ctx, agent.ID, agent.OrgID, agent.ID, models.ActionAgentAnomalyDetected, "agent", f.event.IPAddress, datatypes.JSON(metadata))
+	}
+
+	environment := f.event.ResourceID.String()
+	var env models.Environment
+	if err := db.Preload("Project").First(&env, f.event.ResourceID).Error; err == nil {
+		environment = env.Project.Name + "/" + env.Name
+	}
+	summary := describeAnomaly(f.rule.Kind, detail, environment)
+	manageURL := fmt.Sprintf("%s/orgs/%s/agents", s.frontendURL, agent.OrgID)
+	if f.rule.NotifyEmail {
+		emails, err := approverEmails(db, &agent.Organization)
+		if err != nil {
+			log.Printf("[envo] anomaly alert %s: find recipients: %v", f.alert.ID, err)
+		}
+		notice := AgentAnomalyNotice{
+			AgentName: agent.Name,
+			RuleName:  f.rule.Name,
+			Summary:   summary,
+			IPAddress: f.event.IPAddress,
+			EventAt:   f.event.CreatedAt,
+			Suspended: f.alert.Suspended,
+		}
+		for _, email := range emails {
+			if err := s.emailSender.SendAgentAnomalyAlert(email, agent.Organization.Name, notice, manageURL); err != nil {
+				log.Printf("[envo] anomaly alert %s: email %s: %v", f.alert.ID, email, err)
+			}
+		}
+	}
+	if f.rule.WebhookURL != "" {
+		payload := map[string]any{
+			"type":         "agent.anomaly",
+			"alert_id":     f.alert.ID,
+			"org_id":       agent.OrgID,
+			"agent_id":     agent.ID,
+			"agent_name":   agent.Name,
+			"rule_id":      f.rule.ID,
+			"rule_name":    f.rule.Name,
+			"kind":         f.rule.Kind,
+			"summary":      summary,
+			"detail":       detail,
+			"ip_address":   f.event.IPAddress,
+			"event_at":     f.event.CreatedAt,
+			"audit_log_id": f.event.ID,
+			"suspended":    f.alert.Suspended,
+		}
+		if err := s.postWebhook(ctx, &f.rule, payload); err != nil {
+			log.Printf("[envo] anomaly alert %s: webhook: %v", f.alert.ID, err)
+		}
+	}
+}
+
+// postWebhook delivers payload to the rule's webhook, signed like audit sink
+// webhooks with X-Envo-Signature.
+func (s *AgentAnomalyService) postWebhook(ctx context.Context, rule *models.AgentAnomalyRule, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rule.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Envo-Webhook/1")
+	if rule.EncryptedWebhookSecret != "" {
+		secret, err := decryptWithFallback(ctx, s.encryptor, s.localEncryptor, rule.WebhookKeyID, rule.EncryptedWebhookSecret, rule.OrgID.String())
+		if err != nil {
+			return fmt.Errorf("failed to decrypt webhook secret")
+		}
+		req.Header.Set("X-Envo-Signature", signAuditWebhook(secret, s.now().Unix(), body))
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// Why the rotation job revoked a credential, recorded on agent_token_retire.
+const (
+	RetireReasonOverlapEnded = "rotation_overlap_ended"
+	RetireReasonMaxAge       = "max_credential_age"
+)
+
+const credentialRotationBatchSize = 500
+
+// credentialRetirementSQL is when the agent's rotation policy retires a
+// credential, for queries joining agent_identities.
+const credentialRetirementSQL = "agent_credentials.created_at + make_interval(days => agent_identities.max_credential_age_days)"
+
+// credentialRetiresAt returns when the agent's rotation policy retires
+// credential. ok is false when the agent has no policy.
+func credentialRetiresAt(credential *models.AgentCredential) (time.Time, bool) {
+	days := credential.Agent.MaxCredentialAgeDays
+	if days <= 0 {
+		return time.Time{}, false
+	}
+	return credential.CreatedAt.AddDate(0, 0, days), true
+}
+
+func credentialRetired(credential *models.AgentCredential, now time.Time) bool {
+	retiresAt, ok := credentialRetiresAt(credential)
+	return ok && !retiresAt.After(now)
+}
+
+// AgentCredentialRotator enforces credential rotation in the background. It
+// revokes rotated credentials once their overlap ends, revokes credentials
+// older than their agent's maximum age, and warns the agent's managers lead
+// ahead of that forced expiry. Each credential is warned once; rotating it or
+// changing the policy re-arms the warning. Claims are made with a conditional
+// update so several API instances can run it concurrently.
+type AgentCredentialRotator struct {
+	audit       *AuditService
+	emailSender EmailSender
+	frontendURL string
+	lead        time.Duration
+	now         func() time.Time
+}
+
+// NewAgentCredentialRotator creates a rotator that warns lead ahead of each
+// forced expiry.
+func NewAgentCredentialRotator(audit *AuditService, emailSender EmailSender, frontendURL string, lead time.Duration) *AgentCredentialRotator {
+	if emailSender == nil {
+		emailSender = &LogEmailSender{}
+	}
+	return &AgentCredentialRotator{
+		audit:       audit,
+		emailSender: emailSender,
+		frontendURL: frontendURL,
+		lead:        lead,
+		now:         time.Now,
+	}
+}
+
+// Run enforces rotation every interval until ctx is cancelled.
+func (r *AgentCredentialRotator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if retired, notified, err := r.RunOnce(ctx); err != nil {
+			log.Printf("[envo] agent credential rotation: %v", err)
+		} else if retired > 0 || notified > 0 {
+			log.Printf("[envo] agent credential rotation: retired %d credentials, warned about %d", retired, notified)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce processes one batch and returns how many credentials were revoked
+// and how many were included in delivered warnings.
+func (r *AgentCredentialRotator) RunOnce(ctx context.Context) (int, int, error) {
+	now := r.now().UTC()
+	retired, err := r.retire(ctx, now)
+	if err != nil {
+		return retired, 0, err
+	}
+	notified, err := r.notify(ctx, now)
+	return retired, notified, err
+}
+
+func (r *AgentCredentialRotator) retire(ctx context.Context, now time.Time) (int, error) {
+	db := database.GetDB().WithContext(ctx)
+	var due []models.AgentCredential
+	if err := db.Preload("Agent").
+		Joins("JOIN agent_identities ON agent_identities.id = agent_credentials.agent_id").
+		Where("agent_credentials.revoked_at IS NULL").
+		Where("(agent_credentials.superseded_by IS NOT NULL AND agent_credentials.expires_at <= ?) OR (agent_identities.max_credential_age_days > 0 AND "+credentialRetirementSQL+" <= ?)", now, now).
+		Order("agent_credentials.created_at ASC").
+		Limit(credentialRotationBatchSize).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	retired := 0
+	for i := range due {
+		credential := &due[i]
+		reason := RetireReasonMaxAge
+		if credential.SupersededBy != nil && credential.ExpiresAt != nil && !credential.ExpiresAt.After(now) {
+			reason = RetireReasonOverlapEnded
+		}
+		claim := db.Model(&models.AgentCredential{}).
+			Where("id = ? AND revoked_at IS NULL", credential.ID).
+			UpdateColumn("revoked_at", now)
+		if claim.Error != nil {
+			return retired, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue // another instance got it
+		}
+		retired++
+		if r.audit != nil {
+			metadata, _ := json.Marshal(map[string]any{"reason": reason, "trigger": "schedule"})
+			_ = r.audit.LogAgent(ctx, credential.AgentID, credential.Agent.OrgID, credential.ID, models.ActionAgentTokenRetire, "agent_credential", "", datatypes.JSON(metadata))
+		}
+	}
+	return retired, nil
+}
+
+func (r *AgentCredentialRotator) notify(ctx context.Context, now time.Time) (int, error) {
+	db := database.GetDB().WithContext(ctx)
+	var due []models.AgentCredential
+	if err := db.Preload("Agent.Organization.Owner").
+		Joins("JOIN agent_identities ON agent_identities.id = agent_credentials.agent_id AND agent_identities.deleted_at IS NULL").
+		Where("agent_identities.max_credential_age_days > 0 AND agent_identities.status <> ?", models.AgentStatusRevoked).
+		Where("agent_credentials.revoked_at IS NULL AND agent_credentials.superseded_by IS NULL AND agent_credentials.trust_policy_id IS NULL").
+		Where("agent_credentials.expiry_notified_at IS NULL AND (agent_credentials.expires_at IS NULL OR agent_credentials.expires_at > ?)", now).
+		Where(credentialRetirementSQL+" <= ?", now.Add(r.lead)).
+		Order("agent_credentials.created_at ASC").
+		Limit(credentialRotationBatchSize).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	byOrg := map[uuid.UUID][]*models.AgentCredential{}
+	for i := range due {
+		credential := &due[i]
+		claim := db.Model(&models.AgentCredential{}).
+			Where("id = ? AND expiry_notified_at IS NULL", credential.ID).
+			UpdateColumn("expiry_notified_at", now)
+		if claim.Error != nil {
+			return 0, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue // another instance got it
+		}
+		byOrg[credential.Agent.OrgID] = append(byOrg[credential.Agent.OrgID], credential)
+	}
+
+	notified := 0
+	var firstErr error
+	for _, claimed := range byOrg {
+		org := claimed[0].Agent.Organization
+		notices := make([]AgentCredentialExpiryNotice, 0, len(claimed))
+		ids := make([]uuid.UUID, 0, len(claimed))
+		for _, credential := range claimed {
+			retiresAt, _ := credentialRetiresAt(credential)
+			notices = append(notices, AgentCredentialExpiryNotice{
+				AgentName:      credential.Agent.Name,
+				CredentialName: credential.Name,
+				TokenPrefix:    credential.TokenPrefix,
+				RetiresAt:      retiresAt,
+			})
+			ids = append(ids, credential.ID)
+		}
+		sort.Slice(notices, func(i, j int) bool { return notices[i].RetiresAt.Before(notices[j].RetiresAt) })
+
+		emails, err := approverEmails(db, &org)
+		delivered := false
+		if err == nil {
+			manageURL := fmt.Sprintf("%s/orgs/%s", r.frontendURL, org.ID)
+			for _, email := range emails {
+				if sendErr := r.emailSender.SendAgentCredentialExpiry(email, org.Name, notices, manageURL); sendErr != nil {
+					err = fmt.Errorf("failed to send credential expiry warning to %s: %w", email, sendErr)
+					continue
+				}
+				delivered = true
+			}
+		}
+		if delivered {
+			notified += len(claimed)
+		}
+		if err != nil && !delivered {
+			// Release the claims so the next run retries.
+			_ = db.Model(&models.AgentCredential{}).Where("id IN ?", ids).UpdateColumn("expiry_notified_at", nil).Error
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return notified, firstErr
+}
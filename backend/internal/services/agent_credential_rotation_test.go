@@ -0,0 +1,196 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestCredentialRetiresAtFollowsAgentPolicy(t *testing.T) {
+	issued := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+	credential := &models.AgentCredential{CreatedAt: issued, Agent: models.AgentIdentity{Status: models.AgentStatusActive}}
+	if _, ok := credentialRetiresAt(credential); ok {
+		t.Fatal("credential retires without a rotation policy")
+	}
+
+	credential.Agent.MaxCredentialAgeDays = 30
+	retiresAt, ok := credentialRetiresAt(credential)
+	if !ok || !retiresAt.Equal(issued.AddDate(0, 0, 30)) {
+		t.Fatalf("credentialRetiresAt() = %v, %v", retiresAt, ok)
+	}
+	if got := credentialDenial(credential, "192.0.2.1", retiresAt.Add(-time.Second)); got != "" {
+		t.Fatalf("credentialDenial() before max age = %q", got)
+	}
+	if got := credentialDenial(credential, "192.0.2.1", retiresAt); got != DenialExpired {
+		t.Fatalf("credentialDenial() at max age = %q, want %q", got, DenialExpired)
+	}
+}
+
+// credentialDB serves credential and its agent to lookups by id.
+func credentialDB(t *testing.T, credential *models.AgentCredential) *fakeDB {
+	t.Helper()
+	db := useFakeDB(t)
+	var expiresAt, supersededBy driver.Value
+	if credential.ExpiresAt != nil {
+		expiresAt = *credential.ExpiresAt
+	}
+	if credential.SupersededBy != nil {
+		supersededBy = credential.SupersededBy.String()
+	}
+	db.on([]string{`FROM "agent_credentials"`}, []string{"id", "agent_id", "name", "token_hash", "token_prefix", "created_at", "expires_at", "superseded_by"},
+		[]driver.Value{credential.ID.String(), credential.AgentID.String(), credential.Name, credential.TokenHash, credential.TokenPrefix, credential.CreatedAt, expiresAt, supersededBy})
+	db.on([]string{`FROM "agent_identities"`}, []string{"id", "org_id", "name", "status", "max_credential_age_days"},
+		[]driver.Value{credential.AgentID.String(), credential.Agent.OrgID.String(), "deploy-bot", models.AgentStatusActive, int64(credential.Agent.MaxCredentialAgeDays)})
+	return db
+}
+
+func TestRotateCredentialKeepsPredecessorForOverlap(t *testing.T) {
+	s := NewAgentService(nil, nil, time.Minute)
+	orgID := uuid.New()
+	issued := time.Now().UTC().Add(-10 * 24 * time.Hour)
+	rotatable := func(expiresAt time.Time) *models.AgentCredential {
+		return &models.AgentCredential{ID: uuid.New(), AgentID: uuid.New(), Name: "ci", CreatedAt: issued, ExpiresAt: &expiresAt, Agent: models.AgentIdentity{OrgID: orgID}}
+	}
+	rotate := func(t *testing.T, predecessor *models.AgentCredential, overlap time.Duration) (*fakeDB, *models.AgentCredential, error) {
+		t.Helper()
+		db := credentialDB(t, predecessor)
+		successor, _, err := s.RotateCredential(t.Context(), uuid.New(), orgID, predecessor.AgentID, predecessor.ID, overlap, "")
+		return db, successor, err
+	}
+	predecessorExpiry := func(t *testing.T, db *fakeDB) time.Time {
+		t.Helper()
+		claims := db.statements(`UPDATE "agent_credentials"`, "superseded_by")
+		if len(claims) != 1 {
+			t.Fatalf("predecessor updates = %v, want one", claims)
+		}
+		expiresAt, ok := claims[0].set("expires_at")
+		if !ok {
+			t.Fatalf("predecessor update %q does not set expires_at", claims[0].SQL)
+		}
+		return expiresAt.(time.Time)
+	}
+
+	t.Run("overlap", func(t *testing.T) {
+		before := time.Now().UTC()
+		db, successor, err := rotate(t, rotatable(issued.Add(30*24*time.Hour)), time.Hour)
+		if err != nil {
+			t.Fatalf("RotateCredential() error = %v", err)
+		}
+		after := time.Now().UTC()
+		if got := predecessorExpiry(t, db); got.Before(before.Add(time.Hour)) || got.After(after.Add(time.Hour)) {
+			t.Fatalf("predecessor expires at %v, want an hour after rotating", got)
+		}
+		if successor.Name != "ci" || successor.ExpiresAt == nil ||
+			successor.ExpiresAt.Before(before.Add(30*24*time.Hour)) || successor.ExpiresAt.After(after.Add(30*24*time.Hour)) {
+			t.Fatalf("successor = %+v, want the predecessor's name and 30-day lifetime", successor)
+		}
+		if inserts := db.statements(`INSERT INTO "agent_credentials"`); len(inserts) != 1 {
+			t.Fatalf("successor inserts = %v, want one", inserts)
+		}
+	})
+	t.Run("never extends the predecessor", func(t *testing.T) {
+		expiresAt := time.Now().UTC().Add(10 * time.Minute).Truncate(time.Microsecond)
+		db, _, err := rotate(t, rotatable(expiresAt), time.Hour)
+		if err != nil {
+			t.Fatalf("RotateCredential() error = %v", err)
+		}
+		if got := predecessorExpiry(t, db); !got.Equal(expiresAt) {
+			t.Fatalf("predecessor expires at %v, want its own expiry %v", got, expiresAt)
+		}
+	})
+	t.Run("already rotated", func(t *testing.T) {
+		predecessor := rotatable(issued.Add(30 * 24 * time.Hour))
+		successorID := uuid.New()
+		predecessor.SupersededBy = &successorID
+		db, _, err := rotate(t, predecessor, time.Hour)
+		if !errors.Is(err, ErrCredentialNotRotatable) {
+			t.Fatalf("RotateCredential() error = %v, want ErrCredentialNotRotatable", err)
+		}
+		if writes := db.statements(`INSERT INTO "agent_credentials"`); len(writes) != 0 {
+			t.Fatalf("rotating twice issued another credential: %v", writes)
+		}
+	})
+	t.Run("rotated concurrently", func(t *testing.T) {
+		predecessor := rotatable(issued.Add(30 * 24 * time.Hour))
+		db := credentialDB(t, predecessor)
+		db.on([]string{`UPDATE "agent_credentials"`, "superseded_by"}, nil).affected = 0
+		_, _, err := s.RotateCredential(t.Context(), uuid.New(), orgID, predecessor.AgentID, predecessor.ID, time.Hour, "")
+		if !errors.Is(err, ErrCredentialNotRotatable) {
+			t.Fatalf("RotateCredential() error = %v, want ErrCredentialNotRotatable", err)
+		}
+		if writes := db.statements(`INSERT INTO "agent_credentials"`); len(writes) != 0 {
+			t.Fatalf("losing the claim still issued a credential: %v", writes)
+		}
+	})
+	t.Run("overlap out of range", func(t *testing.T) {
+		for _, overlap := range []time.Duration{-time.Second, maxCredentialRotationOverlap + time.Second} {
+			db, _, err := rotate(t, rotatable(issued.Add(30*24*time.Hour)), overlap)
+			if err == nil || len(db.statements()) != 0 {
+				t.Errorf("RotateCredential(overlap=%s) error = %v after %d statements", overlap, err, len(db.statements()))
+			}
+		}
+	})
+}
+
+func TestMaxCredentialAgeExpiresOldCredentials(t *testing.T) {
+	s := NewAgentService(nil, nil, time.Minute)
+	id, raw, hash, prefix, err := GenerateAgentToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedDaysAgo := func(days int) *models.AgentCredential {
+		return &models.AgentCredential{ID: id, AgentID: uuid.New(), Name: "ci", TokenHash: hash, TokenPrefix: prefix,
+			CreatedAt: time.Now().UTC().AddDate(0, 0, -days), Agent: models.AgentIdentity{OrgID: uuid.New(), MaxCredentialAgeDays: 30}}
+	}
+
+	t.Run("authentication", func(t *testing.T) {
+		credentialDB(t, issuedDaysAgo(29))
+		if _, _, err := s.AuthenticateToken(t.Context(), raw, "192.0.2.1"); err != nil {
+			t.Fatalf("AuthenticateToken() of a 29-day-old credential error = %v", err)
+		}
+		db := credentialDB(t, issuedDaysAgo(31))
+		if _, _, err := s.AuthenticateToken(t.Context(), raw, "192.0.2.1"); !errors.Is(err, ErrAgentUnauthorized) {
+			t.Fatalf("AuthenticateToken() of a 31-day-old credential error = %v, want ErrAgentUnauthorized", err)
+		}
+		if writes := db.statements("UPDATE"); len(writes) != 0 {
+			t.Fatalf("an expired credential was recorded as used: %v", writes)
+		}
+	})
+	t.Run("rotation job", func(t *testing.T) {
+		old := issuedDaysAgo(31)
+		db := credentialDB(t, old)
+		rotator := NewAgentCredentialRotator(nil, nil, "", 0)
+		retired, err := rotator.retire(t.Context(), time.Now().UTC())
+		if err != nil || retired != 1 {
+			t.Fatalf("retire() = %d, %v, want 1 credential", retired, err)
+		}
+		revokes := db.statements(`UPDATE "agent_credentials"`, "revoked_at")
+		if len(revokes) != 1 || revokes[0].Args[len(revokes[0].Args)-1] != old.ID.String() {
+			t.Fatalf("revocations = %v, want the old credential", revokes)
+		}
+	})
+	t.Run("changing the limit re-arms warnings", func(t *testing.T) {
+		db := credentialDB(t, issuedDaysAgo(1))
+		agentID := uuid.New()
+		if _, err := s.SetMaxCredentialAge(t.Context(), uuid.New(), uuid.New(), agentID, 7, ""); err != nil {
+			t.Fatalf("SetMaxCredentialAge() error = %v", err)
+		}
+		if updates := db.statements(`UPDATE "agent_identities"`, "max_credential_age_days"); len(updates) != 1 {
+			t.Fatalf("policy updates = %v, want one", updates)
+		} else if days, _ := updates[0].set("max_credential_age_days"); days != int64(7) {
+			t.Fatalf("max_credential_age_days set to %v, want 7", days)
+		}
+		if resets := db.statements(`UPDATE "agent_credentials"`, "expiry_notified_at"); len(resets) != 1 {
+			t.Fatalf("warning resets = %v, want one", resets)
+		}
+		for _, days := range []int{-1, maxCredentialAgeDays + 1} {
+			if _, err := s.SetMaxCredentialAge(t.Context(), uuid.New(), uuid.New(), agentID, days, ""); err == nil {
+				t.Errorf("SetMaxCredentialAge(%d) accepted", days)
+			}
+		}
+	})
+}
@@ -0,0 +1,541 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTrustPolicyNotFound = errors.New("trust policy not found")
+	// ErrFederationDenied is deliberately vague: callers learn only that the
+	// token was not accepted, not which check failed.
+	ErrFederationDenied = errors.New("token is not trusted for this agent")
+)
+
+const (
+	DefaultFederatedCredentialTTL = 15 * time.Minute
+	MaxFederatedCredentialTTL     = time.Hour
+	maxTrustPolicyClaims          = 10
+	maxJWKSBytes                  = 1 << 20
+)
+
+// JWKS caching. A token signed with an unknown key ID triggers a refetch, but
+// at most once per jwksRefreshInterval so bad tokens cannot hammer the issuer.
+// Failed fetches count too, so an issuer that is down is not retried on
+// every exchange.
+var (
+	jwksCacheTTL        = 10 * time.Minute
+	jwksRefreshInterval = time.Minute
+)
+
+// federatedSigningMethods are the JWT algorithms accepted from issuers.
+var federatedSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// FederationService exchanges OIDC tokens from trusted external issuers,
+// such as CI providers, for short-lived agent credentials.
+type FederationService struct {
+	audit      *AuditService
+	httpClient *http.Client
+	now        func() time.Time
+	// allowLoopbackHTTP lets development point trust policies at a stand-in
+	// issuer on localhost over plain http.
+	allowLoopbackHTTP bool
+
+	mu   sync.Mutex
+	jwks map[string]*issuerKeys
+}
+
+// issuerKeys is the last JWKS fetched from an issuer. attemptedAt and err
+// record the latest fetch, which may have failed after keys were fetched.
+type issuerKeys struct {
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	err         error
+}
+
+// NewFederationService creates a new federation service. allowLoopbackHTTP
+// accepts http issuers on loopback hosts and is only meant for development;
+// elsewhere discovery and JWKS requests are kept off internal addresses.
+func NewFederationService(audit *AuditService, allowLoopbackHTTP bool) *FederationService {
+	return &FederationService{
+		audit:             audit,
+		httpClient:        egressGuard{allowPrivate: allowLoopbackHTTP}.httpClient(10 * time.Second),
+		now:               time.Now,
+		allowLoopbackHTTP: allowLoopbackHTTP,
+		jwks:              map[string]*issuerKeys{},
+	}
+}
+
+// TrustPolicyInput configures a trust policy.
+type TrustPolicyInput struct {
+	Name            string
+	Issuer          string
+	Audience        string
+	SubjectPattern  string
+	ClaimConditions map[string]string
+	CredentialTTL   time.Duration
+}
+
+// normalizeIssuer accepts https issuer URLs, and plain http for loopback
+// hosts only when allowLoopbackHTTP is set, so local development and tests
+// can run a stand-in issuer.
+func normalizeIssuer(issuer string, allowLoopbackHTTP bool) (string, error) {
+	issuer = strings.TrimSpace(issuer)
+	u, err := url.Parse(issuer)
+	if err != nil || u.Host == "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("issuer must be an absolute URL without query or fragment")
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); !allowLoopbackHTTP || (host != "localhost" && (ip == nil || !ip.IsLoopback())) {
+			return "", fmt.Errorf("issuer must use https")
+		}
+	default:
+		return "", fmt.Errorf("issuer must use https")
+	}
+	if len(issuer) > 500 {
+		return "", fmt.Errorf("issuer must be at most 500 characters")
+	}
+	return issuer, nil
+}
+
+// normalizeTrustPolicy validates a policy. The subject pattern may not be a
+// bare wildcard: an issuer such as a public CI provider signs tokens for
+// every one of its customers, so the subject is what pins the policy to ours.
+func normalizeTrustPolicy(in TrustPolicyInput, allowLoopbackHTTP bool) (TrustPolicyInput, error) {
+	var err error
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || len(in.Name) > 120 {
+		return in, fmt.Errorf("trust policy name must be between 1 and 120 characters")
+	}
+	if in.Issuer, err = normalizeIssuer(in.Issuer, allowLoopbackHTTP); err != nil {
+		return in, err
+	}
+	in.Audience = strings.TrimSpace(in.Audience)
+	if in.Audience == "" || len(in.Audience) > 255 {
+		return in, fmt.Errorf("audience must be between 1 and 255 characters")
+	}
+	in.SubjectPattern = strings.TrimSpace(in.SubjectPattern)
+	if strings.Trim(in.SubjectPattern, "*") == "" || len(in.SubjectPattern) > 500 || strings.ContainsAny(in.SubjectPattern, "?[]\\") {
+		return in, fmt.Errorf("subject pattern must name a subject; only * is a wildcard and it cannot match every subject")
+	}
+	if len(in.ClaimConditions) > maxTrustPolicyClaims {
+		return in, fmt.Errorf("at most %d claim conditions are allowed", maxTrustPolicyClaims)
+	}
+	claims := make(map[string]string, len(in.ClaimConditions))
+	for name, pattern := range in.ClaimConditions {
+		name, pattern = strings.TrimSpace(name), strings.TrimSpace(pattern)
+		if name == "" || len(name) > 100 || pattern == "" || len(pattern) > 500 || strings.ContainsAny(pattern, "?[]\\") {
+			return in, fmt.Errorf("claim condition %q must name a claim and a pattern using only * as a wildcard", name)
+		}
+		switch name {
+		case "iss", "aud", "sub", "exp", "nbf", "iat":
+			return in, fmt.Errorf("claim %q is checked by the policy itself", name)
+		}
+		claims[name] = pattern
+	}
+	in.ClaimConditions = claims
+	if in.CredentialTTL <= 0 {
+		in.CredentialTTL = DefaultFederatedCredentialTTL
+	}
+	if in.CredentialTTL < time.Minute || in.CredentialTTL > MaxFederatedCredentialTTL {
+		return in, fmt.Errorf("credential TTL must be between 1 minute and %s", MaxFederatedCredentialTTL)
+	}
+	return in, nil
+}
+
+// CreateTrustPolicy lets tokens matching in sign in as the agent.
+func (s *FederationService) CreateTrustPolicy(ctx context.Context, userID, orgID, agentID uuid.UUID, in TrustPolicyInput, ip string) (*models.AgentTrustPolicy, error) {
+	in, err := normalizeTrustPolicy(in, s.allowLoopbackHTTP)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB().WithContext(ctx)
+	var count int64
+	if err := db.Model(&models.AgentIdentity{}).Where("id = ? AND org_id = ? AND status <> ?", agentID, orgID, models.AgentStatusRevoked).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrAgentNotFound
+	}
+	conditions, err := json.Marshal(in.ClaimConditions)
+	if err != nil {
+		return nil, err
+	}
+	policy := &models.AgentTrustPolicy{
+		OrgID:           orgID,
+		AgentID:         agentID,
+		Name:            in.Name,
+		Issuer:          in.Issuer,
+		Audience:        in.Audience,
+		SubjectPattern:  in.SubjectPattern,
+		ClaimConditions: datatypes.JSON(conditions),
+		CredentialTTL:   int(in.CredentialTTL / time.Second),
+		CreatedBy:       userID,
+	}
+	if err := db.Create(policy).Error; err != nil {
+		return nil, err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"agent_id": agentID, "issuer": policy.Issuer, "subject_pattern": policy.SubjectPattern})
+		_ = s.audit.Log(ctx, userID, orgID, policy.ID, models.ActionAgentTrustPolicyCreate, "agent_trust_policy", ip, datatypes.JSON(metadata))
+	}
+	return policy, nil
+}
+
+func (s *FederationService) ListTrustPolicies(ctx context.Context, orgID, agentID uuid.UUID) ([]models.AgentTrustPolicy, error) {
+	var policies []models.AgentTrustPolicy
+	err := database.GetDB().WithContext(ctx).Where("agent_id = ? AND org_id = ?", agentID, orgID).
+		Order("created_at DESC").Find(&policies).Error
+	return policies, err
+}
+
+// DeleteTrustPolicy stops accepting new exchanges under the policy.
+// Credentials it already issued stay valid until they expire or are revoked.
+func (s *FederationService) DeleteTrustPolicy(ctx context.Context, userID, orgID, agentID, policyID uuid.UUID, ip string) error {
+	result := database.GetDB().WithContext(ctx).Where("id = ? AND agent_id = ? AND org_id = ?", policyID, agentID, orgID).
+		Delete(&models.AgentTrustPolicy{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTrustPolicyNotFound
+	}
+	if s.audit != nil {
+		_ = s.audit.Log(ctx, userID, orgID, policyID, models.ActionAgentTrustPolicyDelete, "agent_trust_policy", ip, nil)
+	}
+	return nil
+}
+
+// Exchange validates an external OIDC token against the agent's trust
+// policies and issues a short-lived credential under the first policy it
+// satisfies. Only issuers named by one of the agent's policies are contacted.
+func (s *FederationService) Exchange(ctx context.Context, agentID uuid.UUID, rawToken, ip string) (*models.AgentCredential, string, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(rawToken, jwt.MapClaims{})
+	if err != nil {
+		return nil, "", ErrFederationDenied
+	}
+	issuer, err := unverified.Claims.GetIssuer()
+	if err != nil || issuer == "" {
+		return nil, "", ErrFederationDenied
+	}
+	db := database.GetDB().WithContext(ctx)
+	var agent models.AgentIdentity
+	if err := db.Where("id = ? AND status = ?", agentID, models.AgentStatusActive).First(&agent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrFederationDenied
+		}
+		return nil, "", err
+	}
+	var policies []models.AgentTrustPolicy
+	if err := db.Where("agent_id = ? AND org_id = ? AND issuer = ?", agent.ID, agent.OrgID, issuer).
+		Order("created_at ASC").Find(&policies).Error; err != nil {
+		return nil, "", err
+	}
+	if len(policies) == 0 {
+		return nil, "", ErrFederationDenied
+	}
+	policy, claims, err := s.verify(ctx, rawToken, issuer, policies)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := s.now().UTC()
+	ttl := time.Duration(policy.CredentialTTL) * time.Second
+	if ttl <= 0 {
+		ttl = DefaultFederatedCredentialTTL
+	}
+	expiresAt := now.Add(ttl)
+	id, raw, hash, prefix, err := GenerateAgentToken()
+	if err != nil {
+		return nil, "", err
+	}
+	credential := &models.AgentCredential{
+		ID:            id,
+		AgentID:       agent.ID,
+		Name:          "oidc: " + policy.Name,
+		TokenHash:     hash,
+		TokenPrefix:   prefix,
+		ExpiresAt:     &expiresAt,
+		TrustPolicyID: &policy.ID,
+	}
+	if err := db.Create(credential).Error; err != nil {
+		return nil, "", err
+	}
+	_ = db.Model(&models.AgentTrustPolicy{}).Where("id = ?", policy.ID).Update("last_used_at", now).Error
+	if s.audit != nil {
+		subject, _ := claims.GetSubject()
+		metadata, _ := json.Marshal(map[string]any{
+			"credential_id":   credential.ID,
+			"trust_policy_id": policy.ID,
+			"issuer":          issuer,
+			"subject":         subject,
+			"expires_at":      expiresAt,
+		})
+		_ = s.audit.LogAgent(ctx, agent.ID, agent.OrgID, credential.ID, models.ActionAgentTokenExchange, "agent_credential", ip, datatypes.JSON(metadata))
+	}
+	return credential, raw, nil
+}
+
+// verify checks the token's signature and lifetime against the issuer's JWKS
+// and returns the first policy whose audience, subject and claim conditions
+// it satisfies.
+func (s *FederationService) verify(ctx context.Context, rawToken, issuer string, policies []models.AgentTrustPolicy) (*models.AgentTrustPolicy, jwt.MapClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(federatedSigningMethods),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(30*time.Second),
+		jwt.WithTimeFunc(s.now),
+	)
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.signingKey(ctx, issuer, kid)
+	})
+	if err != nil {
+		return nil, nil, ErrFederationDenied
+	}
+	for i := range policies {
+		if trustPolicyMatches(&policies[i], claims) {
+			return &policies[i], claims, nil
+		}
+	}
+	return nil, nil, ErrFederationDenied
+}
+
+// trustPolicyMatches checks a verified token's claims against one policy.
+func trustPolicyMatches(policy *models.AgentTrustPolicy, claims jwt.MapClaims) bool {
+	audiences, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+	audienceOK := false
+	for _, aud := range audiences {
+		if aud == policy.Audience {
+			audienceOK = true
+			break
+		}
+	}
+	subject, err := claims.GetSubject()
+	if !audienceOK || err != nil || subject == "" || !matchKeyPattern(policy.SubjectPattern, subject) {
+		return false
+	}
+	conditions := map[string]string{}
+	if len(policy.ClaimConditions) > 0 {
+		if err := json.Unmarshal(policy.ClaimConditions, &conditions); err != nil {
+			return false
+		}
+	}
+	for name, pattern := range conditions {
+		value, ok := claimString(claims[name])
+		if !ok || !matchKeyPattern(pattern, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimString renders scalar claims for pattern matching. Objects and arrays
+// never match a condition.
+func claimString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return fmt.Sprint(v), true
+	case float64:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
+
+// signingKey returns the issuer's key with the given ID, fetching the JWKS
+// through OIDC discovery when it is not cached or the key is unknown.
+func (s *FederationService) signingKey(ctx context.Context, issuer, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	cached := s.jwks[issuer]
+	now := s.now()
+	if cached != nil {
+		if key, ok := cached.keys[kid]; ok && now.Sub(cached.fetchedAt) < jwksCacheTTL {
+			s.mu.Unlock()
+			return key, nil
+		}
+		if now.Sub(cached.attemptedAt) < jwksRefreshInterval {
+			err := cached.err
+			s.mu.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+	s.mu.Unlock()
+
+	keys, err := s.fetchJWKS(ctx, issuer)
+	if err != nil {
+		// A cancelled caller says nothing about the issuer.
+		if ctx.Err() == nil {
+			s.mu.Lock()
+			failed := &issuerKeys{attemptedAt: now, err: err}
+			if cached != nil {
+				failed.keys, failed.fetchedAt = cached.keys, cached.fetchedAt
+			}
+			s.jwks[issuer] = failed
+			s.mu.Unlock()
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	s.jwks[issuer] = &issuerKeys{keys: keys, fetchedAt: now, attemptedAt: now}
+	s.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (s *FederationService) getJSON(ctx context.Context, rawURL string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(out)
+}
+
+func (s *FederationService) fetchJWKS(ctx context.Context, issuer string) (map[string]crypto.PublicKey, error) {
+	// Policies stored by a development server are not trusted elsewhere.
+	if _, err := normalizeIssuer(issuer, s.allowLoopbackHTTP); err != nil {
+		return nil, err
+	}
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := s.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if discovery.Issuer != issuer || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document does not match issuer %s", issuer)
+	}
+	if _, err := normalizeIssuer(discovery.JWKSURI, s.allowLoopbackHTTP); err != nil {
+		return nil, fmt.Errorf("jwks_uri: %w", err)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := s.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("JWKS fetch failed: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys this service cannot use are skipped rather than failing the
+		// whole set, so an issuer adding a new key type does not break us.
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// jsonWebKey is the subset of RFC 7517 needed for RSA and EC signing keys.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 || key.E < 3 {
+			return nil, fmt.Errorf("RSA key is too weak")
+		}
+		return key, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) > size || len(y) > size {
+			return nil, fmt.Errorf("EC coordinates are too long")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		copy(point[1+size-len(x):1+size], x)
+		copy(point[1+2*size-len(y):], y)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
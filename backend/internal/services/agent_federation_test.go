@@ -0,0 +1,265 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/datatypes"
+)
+
+// standInIssuer is a minimal OIDC issuer serving discovery and a JWKS.
+type standInIssuer struct {
+	server    *httptest.Server
+	rsaKey    *rsa.PrivateKey
+	ecKey     *ecdsa.PrivateKey
+	jwksFetch atomic.Int32
+}
+
+func newStandInIssuer(t *testing.T) *standInIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &standInIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	b64 := base64.RawURLEncoding.EncodeToString
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": iss.server.URL, "jwks_uri": iss.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.jwksFetch.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+func (iss *standInIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	base := jwt.MapClaims{
+		"iss": iss.server.URL,
+		"aud": "https://envo.example",
+		"sub": "repo:acme/api:ref:refs/heads/main",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(base, k)
+			continue
+		}
+		base[k] = v
+	}
+	var token *jwt.Token
+	var key any
+	if strings.HasPrefix(kid, "ec") {
+		token, key = jwt.NewWithClaims(jwt.SigningMethodES256, base), iss.ecKey
+	} else {
+		token, key = jwt.NewWithClaims(jwt.SigningMethodRS256, base), iss.rsaKey
+	}
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func testTrustPolicy(issuer string, conditions map[string]string) models.AgentTrustPolicy {
+	encoded, _ := json.Marshal(conditions)
+	return models.AgentTrustPolicy{
+		Name:            "ci",
+		Issuer:          issuer,
+		Audience:        "https://envo.example",
+		SubjectPattern:  "repo:acme/api:*",
+		ClaimConditions: datatypes.JSON(encoded),
+	}
+}
+
+func TestFederationVerifyAcceptsTrustedTokens(t *testing.T) {
+	iss := newStandInIssuer(t)
+	s := NewFederationService(nil, true)
+	policies := []models.AgentTrustPolicy{testTrustPolicy(iss.server.URL, map[string]string{"repository": "acme/api", "ref": "refs/heads/main"})}
+
+	for _, kid := range []string{"rsa-1", "ec-1"} {
+		raw := iss.sign(t, kid, jwt.MapClaims{"repository": "acme/api", "ref": "refs/heads/main"})
+		policy, claims, err := s.verify(t.Context(), raw, iss.server.URL, policies)
+		if err != nil {
+			t.Fatalf("verify(%s) error = %v", kid, err)
+		}
+		if policy.Name != "ci" || claims["repository"] != "acme/api" {
+			t.Fatalf("verify(%s) = %v, %v", kid, policy, claims)
+		}
+	}
+	if got := iss.jwksFetch.Load(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1 (cached)", got)
+	}
+}
+
+func TestFederationVerifyRejectsUntrustedTokens(t *testing.T) {
+	iss := newStandInIssuer(t)
+	other := newStandInIssuer(t)
+	s := NewFederationService(nil, true)
+	policies := []models.AgentTrustPolicy{testTrustPolicy(iss.server.URL, map[string]string{"ref": "refs/heads/main"})}
+
+	cases := map[string]string{
+		"wrong audience":  iss.sign(t, "rsa-1", jwt.MapClaims{"aud": "https://other.example", "ref": "refs/heads/main"}),
+		"wrong subject":   iss.sign(t, "rsa-1", jwt.MapClaims{"sub": "repo:acme/other:ref:refs/heads/main", "ref": "refs/heads/main"}),
+		"claim mismatch":  iss.sign(t, "rsa-1", jwt.MapClaims{"ref": "refs/heads/feature"}),
+		"claim missing":   iss.sign(t, "rsa-1", nil),
+		"expired":         iss.sign(t, "rsa-1", jwt.MapClaims{"ref": "refs/heads/main", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"no expiry":       iss.sign(t, "rsa-1", jwt.MapClaims{"ref": "refs/heads/main", "exp": nil}),
+		"wrong key":       other.sign(t, "rsa-1", jwt.MapClaims{"iss": iss.server.URL, "ref": "refs/heads/main"}),
+		"unknown key":     iss.sign(t, "rsa-2", jwt.MapClaims{"ref": "refs/heads/main"}),
+		"wrong issuer":    other.sign(t, "rsa-1", jwt.MapClaims{"ref": "refs/heads/main"}),
+		"unsigned":        unsignedToken(t, iss.server.URL),
+		"not a jwt token": "envo_agent_not_a_jwt",
+	}
+	for name, raw := range cases {
+		if _, _, err := s.verify(t.Context(), raw, iss.server.URL, policies); !errors.Is(err, ErrFederationDenied) {
+			t.Errorf("%s: verify() error = %v, want ErrFederationDenied", name, err)
+		}
+	}
+}
+
+func unsignedToken(t *testing.T, issuer string) string {
+	t.Helper()
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"iss": issuer, "aud": "https://envo.example", "sub": "repo:acme/api:ref:refs/heads/main",
+		"ref": "refs/heads/main", "exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestFederationRefetchesJWKSForUnknownKeysAtMostOncePerInterval(t *testing.T) {
+	iss := newStandInIssuer(t)
+	s := NewFederationService(nil, true)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	if _, err := s.signingKey(t.Context(), iss.server.URL, "rsa-1"); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := s.signingKey(t.Context(), iss.server.URL, "rotated"); err == nil {
+			t.Fatal("expected unknown key error")
+		}
+	}
+	if got := iss.jwksFetch.Load(); got != 1 {
+		t.Fatalf("JWKS fetched %d times within the refresh interval, want 1", got)
+	}
+	now = now.Add(jwksRefreshInterval)
+	_, _ = s.signingKey(t.Context(), iss.server.URL, "rotated")
+	if got := iss.jwksFetch.Load(); got != 2 {
+		t.Fatalf("JWKS fetched %d times after the refresh interval, want 2", got)
+	}
+}
+
+func TestFederationRetriesFailingIssuersAtMostOncePerInterval(t *testing.T) {
+	var discoveries atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		discoveries.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	s := NewFederationService(nil, true)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	for range 3 {
+		if _, err := s.signingKey(t.Context(), server.URL, "rsa-1"); err == nil || !strings.Contains(err.Error(), "HTTP 503") {
+			t.Fatalf("signingKey() error = %v, want the discovery failure", err)
+		}
+	}
+	if got := discoveries.Load(); got != 1 {
+		t.Fatalf("failing issuer contacted %d times within the refresh interval, want 1", got)
+	}
+	now = now.Add(jwksRefreshInterval)
+	_, _ = s.signingKey(t.Context(), server.URL, "rsa-1")
+	if got := discoveries.Load(); got != 2 {
+		t.Fatalf("failing issuer contacted %d times after the refresh interval, want 2", got)
+	}
+}
+
+func TestFederationKeepsIssuerFetchesOffInternalAddresses(t *testing.T) {
+	s := NewFederationService(nil, false)
+	if err := s.getJSON(t.Context(), "https://127.0.0.1:1/.well-known/openid-configuration", &struct{}{}); !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("getJSON() error = %v, want ErrPrivateAddress", err)
+	}
+}
+
+func TestFederationRefusesLoopbackIssuersOutsideDevelopment(t *testing.T) {
+	iss := newStandInIssuer(t)
+	s := NewFederationService(nil, false)
+	if _, err := s.signingKey(t.Context(), iss.server.URL, "rsa-1"); err == nil {
+		t.Fatal("signingKey() trusted a plain-http loopback issuer")
+	}
+	if got := iss.jwksFetch.Load(); got != 0 {
+		t.Fatalf("JWKS fetched %d times from a refused issuer", got)
+	}
+}
+
+func TestNormalizeTrustPolicy(t *testing.T) {
+	valid := TrustPolicyInput{
+		Name:            "deploy",
+		Issuer:          "https://token.actions.githubusercontent.com",
+		Audience:        "https://envo.example",
+		SubjectPattern:  "repo:acme/api:ref:refs/heads/main",
+		ClaimConditions: map[string]string{"repository": "acme/api"},
+	}
+	got, err := normalizeTrustPolicy(valid, false)
+	if err != nil || got.CredentialTTL != DefaultFederatedCredentialTTL {
+		t.Fatalf("normalizeTrustPolicy(valid) = %+v, %v", got, err)
+	}
+	local := TrustPolicyInput{Name: "local", Issuer: "http://127.0.0.1:8080", Audience: "a", SubjectPattern: "ci"}
+	if _, err := normalizeTrustPolicy(local, true); err != nil {
+		t.Fatalf("loopback http issuer rejected in development: %v", err)
+	}
+	for _, issuer := range []string{"http://127.0.0.1:8080", "http://localhost:8080", "http://[::1]:8080"} {
+		local.Issuer = issuer
+		if _, err := normalizeTrustPolicy(local, false); err == nil {
+			t.Errorf("loopback http issuer %s accepted outside development", issuer)
+		}
+	}
+
+	invalid := map[string]func(*TrustPolicyInput){
+		"http issuer":       func(in *TrustPolicyInput) { in.Issuer = "http://issuer.example" },
+		"issuer with query": func(in *TrustPolicyInput) { in.Issuer = "https://issuer.example?x=1" },
+		"wildcard subject":  func(in *TrustPolicyInput) { in.SubjectPattern = "**" },
+		"no audience":       func(in *TrustPolicyInput) { in.Audience = " " },
+		"reserved claim":    func(in *TrustPolicyInput) { in.ClaimConditions = map[string]string{"aud": "x"} },
+		"bad claim pattern": func(in *TrustPolicyInput) { in.ClaimConditions = map[string]string{"ref": "refs/heads/?"} },
+		"ttl too long":      func(in *TrustPolicyInput) { in.CredentialTTL = 2 * time.Hour },
+	}
+	for name, mutate := range invalid {
+		in := valid
+		mutate(&in)
+		if _, err := normalizeTrustPolicy(in, true); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// AgentGrantSummary is one live grant as its agent sees it: where it
+// applies, which keys it covers, and until when. Review-only details such
+// as the database admin secret and grant template are left out.
+type AgentGrantSummary struct {
+	ID                 uuid.UUID  `json:"id"`
+	Capability         string     `json:"capability"`
+	Project            string     `json:"project"`
+	Environment        string     `json:"environment,omitempty"`
+	EnvironmentPattern string     `json:"environment_pattern,omitempty"`
+	Environments       []string   `json:"environments"`
+	AllowAllSecrets    bool       `json:"allow_all_secrets"`
+	AllowedKeys        []string   `json:"allowed_keys"`
+	DeniedKeys         []string   `json:"denied_keys"`
+	CredentialKey      string     `json:"credential_key,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+}
+
+// AgentEnvironmentKeys are the key names a resolve of one environment would
+// return, found without decrypting anything. DeniedKeys are covered by a
+// grant but withheld by an access policy.
+type AgentEnvironmentKeys struct {
+	Project       string     `json:"project"`
+	Environment   string     `json:"environment"`
+	EnvironmentID uuid.UUID  `json:"environment_id"`
+	Keys          []string   `json:"keys"`
+	DynamicKeys   []string   `json:"dynamic_keys"`
+	ExpiredKeys   []string   `json:"expired_keys"`
+	DeniedKeys    []string   `json:"denied_keys"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// ListAccess summarizes the agent's live grants, oldest first. Project grants
+// list the environments they cover now.
+func (s *AgentService) ListAccess(ctx context.Context, agent *models.AgentIdentity) ([]AgentGrantSummary, error) {
+	db := database.GetDB().WithContext(ctx)
+	var grants []models.AgentGrant
+	if err := db.Preload("Environment.Project").Preload("Project").
+		Where("agent_id = ? AND revoked_at IS NULL", agent.ID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC()).
+		Order("created_at ASC").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+
+	var projectIDs []uuid.UUID
+	for _, grant := range grants {
+		if grant.ProjectID != nil {
+			projectIDs = append(projectIDs, *grant.ProjectID)
+		}
+	}
+	var projectEnvs []models.Environment
+	if len(projectIDs) > 0 {
+		if err := db.Where("project_id IN ?", projectIDs).Order("name ASC").Find(&projectEnvs).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	summaries := make([]AgentGrantSummary, 0, len(grants))
+	for i := range grants {
+		grant := &grants[i]
+		scope, err := grantKeyScope(grant)
+		if err != nil {
+			return nil, err
+		}
+		summary := AgentGrantSummary{
+			ID:                 grant.ID,
+			Capability:         grant.Capability,
+			EnvironmentPattern: grant.EnvironmentPattern,
+			Environments:       []string{},
+			AllowAllSecrets:    scope.All,
+			AllowedKeys:        scope.Allow,
+			DeniedKeys:         scope.Deny,
+			CredentialKey:      grant.CredentialKey,
+			ExpiresAt:          grant.ExpiresAt,
+		}
+		if summary.AllowedKeys == nil {
+			summary.AllowedKeys = []string{}
+		}
+		if summary.DeniedKeys == nil {
+			summary.DeniedKeys = []string{}
+		}
+		switch {
+		case grant.Environment != nil:
+			summary.Environment = grant.Environment.Name
+			summary.Environments = append(summary.Environments, grant.Environment.Name)
+			summary.Project = grant.Environment.Project.Name
+		case grant.Project != nil:
+			summary.Project = grant.Project.Name
+			for j := range projectEnvs {
+				if grantCoversEnvironment(grant, &projectEnvs[j]) {
+					summary.Environments = append(summary.Environments, projectEnvs[j].Name)
+				}
+			}
+		default:
+			continue // its environment or project was deleted
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// EnvironmentKeys lists the keys a resolve of the selected environment would
+// deliver, using the same grants, access policies and filters as the resolve
+// itself. With requestedKeys it fails with ErrAgentForbidden unless every key
+// is covered, exactly like a resolve; keys a policy denies are listed rather
+// than failing, so callers can tell the two apart.
+func (s *AgentService) EnvironmentKeys(ctx context.Context, agent *models.AgentIdentity, project, environment string, requestedKeys []string, ip string) (*AgentEnvironmentKeys, error) {
+	access, err := s.AuthorizeResolve(ctx, agent, project, environment, requestedKeys)
+	if err != nil {
+		return nil, err
+	}
+	var policy *SecretPolicyResult
+	if s.policies != nil {
+		policy, err = s.policies.CheckEnvironment(ctx, PolicyRequest{
+			Actor:     AgentActor(agent.ID),
+			Principal: AgentPrincipal(agent),
+			Action:    models.PolicyActionSecretsResolve,
+			IP:        ip,
+		}, access.Environment, access.AllowsKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	db := database.GetDB().WithContext(ctx)
+	var env models.Environment
+	if err := db.Preload("Project.Organization").First(&env, access.Environment).Error; err != nil {
+		return nil, err
+	}
+	var secrets []models.Secret
+	if err := db.Select("key", "expires_at").Where("environment_id = ?", env.ID).Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+
+	result := &AgentEnvironmentKeys{
+		Project:       env.Project.Name,
+		EnvironmentID: env.ID,
+		Environment:   env.Name,
+		Keys:          []string{},
+		DynamicKeys:   []string{},
+		ExpiredKeys:   []string{},
+		DeniedKeys:    []string{},
+		ExpiresAt:     access.ExpiresAt,
+	}
+	excludeExpired := env.Project.Organization.ExcludeExpiredSecrets
+	now := time.Now()
+	for _, sec := range secrets {
+		if !access.AllowsKey(sec.Key) {
+			continue
+		}
+		if !policy.Allows(sec.Key) {
+			result.DeniedKeys = append(result.DeniedKeys, sec.Key)
+			continue
+		}
+		if excludeExpired && sec.IsExpired(now) {
+			result.ExpiredKeys = append(result.ExpiredKeys, sec.Key)
+			continue
+		}
+		result.Keys = append(result.Keys, sec.Key)
+	}
+	for _, grant := range access.DatabaseGrants {
+		result.DynamicKeys = append(result.DynamicKeys, grant.CredentialKey)
+	}
+	sort.Strings(result.Keys)
+	sort.Strings(result.DynamicKeys)
+	sort.Strings(result.ExpiredKeys)
+	sort.Strings(result.DeniedKeys)
+	return result, nil
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+var (
+	ErrLeaseNotFound = errors.New("lease not found")
+	ErrLeaseInactive = errors.New("lease has expired or was revoked")
+)
+
+// AgentLeaseTTL is how long a resolve lease lasts when no dynamic
+// credential or grant expiry shortens it.
+const AgentLeaseTTL = 5 * time.Minute
+
+// RecordLease persists a resolve lease. The caller sets the ID it returned to
+// the agent and the expiry it promised.
+func (s *AgentService) RecordLease(ctx context.Context, lease *models.AgentLease) error {
+	if lease.TTLSeconds <= 0 {
+		lease.TTLSeconds = int(time.Until(lease.ExpiresAt).Round(time.Second) / time.Second)
+		if lease.TTLSeconds <= 0 {
+			lease.TTLSeconds = int(AgentLeaseTTL / time.Second)
+		}
+	}
+	return database.GetDB().WithContext(ctx).Create(lease).Error
+}
+
+// ListLeases lists an org's leases, most recent first. Only active leases are
+// returned unless includeInactive is set.
+func (s *AgentService) ListLeases(ctx context.Context, orgID uuid.UUID, agentID *uuid.UUID, includeInactive bool, limit int) ([]models.AgentLease, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	q := database.GetDB().WithContext(ctx).Preload("Agent").Preload("Environment.Project").
+		Where("agent_leases.org_id = ?", orgID)
+	if agentID != nil {
+		q = q.Where("agent_leases.agent_id = ?", *agentID)
+	}
+	if !includeInactive {
+		q = q.Where("agent_leases.revoked_at IS NULL AND agent_leases.expires_at > ?", time.Now().UTC())
+	}
+	var leases []models.AgentLease
+	err := q.Order("agent_leases.created_at DESC").Limit(limit).Find(&leases).Error
+	return leases, err
+}
+
+func (s *AgentService) revokeLease(ctx context.Context, orgID, leaseID uuid.UUID, agentID, userID *uuid.UUID) (*models.AgentLease, error) {
+	db := database.GetDB().WithContext(ctx)
+	q := db.Where("id = ? AND org_id = ?", leaseID, orgID)
+	if agentID != nil {
+		q = q.Where("agent_id = ?", *agentID)
+	}
+	var lease models.AgentLease
+	if err := q.First(&lease).Error; err != nil {
+		return nil, ErrLeaseNotFound
+	}
+	if lease.RevokedAt != nil {
+		return nil, ErrLeaseInactive
+	}
+	now := time.Now().UTC()
+	result := db.Model(&models.AgentLease{}).Where("id = ? AND revoked_at IS NULL", leaseID).
+		Updates(map[string]any{"revoked_at": now, "revoked_by": userID})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrLeaseInactive
+	}
+	lease.RevokedAt = &now
+	lease.RevokedBy = userID
+	return &lease, nil
+}
+
+// RevokeLease ends a lease on behalf of a human. Values already delivered
+// cannot be recalled; the lease can no longer be renewed.
+func (s *AgentService) RevokeLease(ctx context.Context, userID, orgID, leaseID uuid.UUID, ip string) (*models.AgentLease, error) {
+	lease, err := s.revokeLease(ctx, orgID, leaseID, nil, &userID)
+	if err != nil {
+		return nil, err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"agent_id": lease.AgentID})
+		_ = s.audit.Log(ctx, userID, orgID, leaseID, models.ActionAgentLeaseRevoke, "agent_lease", ip, datatypes.JSON(metadata))
+	}
+	return lease, nil
+}
+
+// ReleaseLease lets an agent end its own lease early, e.g. when the process
+// that received the values exits.
+func (s *AgentService) ReleaseLease(ctx context.Context, agent *models.AgentIdentity, leaseID uuid.UUID, ip string) (*models.AgentLease, error) {
+	lease, err := s.revokeLease(ctx, agent.OrgID, leaseID, &agent.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"released": true})
+		_ = s.audit.LogAgent(ctx, agent.ID, agent.OrgID, leaseID, models.ActionAgentLeaseRevoke, "agent_lease", ip, datatypes.JSON(metadata))
+	}
+	return lease, nil
+}
+
+// RenewLease extends an active lease by its original TTL. Every grant the
+// lease was issued under must still be live; otherwise the agent has to
+// resolve again and get whatever its current grants allow. extend, when
+// set, moves anything issued under the lease to the new expiry first, so a
+// failure leaves the lease as it was.
+func (s *AgentService) RenewLease(ctx context.Context, agent *models.AgentIdentity, leaseID uuid.UUID, ip string, extend func(ctx context.Context, expiresAt time.Time) error) (*models.AgentLease, error) {
+	db := database.GetDB().WithContext(ctx)
+	now := time.Now().UTC()
+
+	var lease models.AgentLease
+	if err := db.Where("id = ? AND agent_id = ?", leaseID, agent.ID).First(&lease).Error; err != nil {
+		return nil, ErrLeaseNotFound
+	}
+	if !lease.IsActive(now) {
+		return nil, ErrLeaseInactive
+	}
+
+	var grantIDs []uuid.UUID
+	if err := json.Unmarshal(lease.GrantIDs, &grantIDs); err != nil {
+		return nil, fmt.Errorf("invalid stored lease: %w", err)
+	}
+	var grants []models.AgentGrant
+	if err := db.Where("id IN ? AND agent_id = ? AND revoked_at IS NULL", grantIDs, agent.ID).
+		Where("expires_at IS NULL OR expires_at > ?", now).Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	if len(grantIDs) == 0 || len(grants) != len(grantIDs) {
+		return nil, ErrAgentForbidden
+	}
+
+	expiresAt := now.Add(time.Duration(lease.TTLSeconds) * time.Second)
+	for _, grant := range grants {
+		if grant.ExpiresAt != nil && grant.ExpiresAt.Before(expiresAt) {
+			expiresAt = *grant.ExpiresAt
+		}
+	}
+	if extend != nil {
+		if err := extend(ctx, expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to extend database credentials: %w", err)
+		}
+	}
+	result := db.Model(&models.AgentLease{}).Where("id = ? AND revoked_at IS NULL", lease.ID).Updates(map[string]any{
+		"expires_at":  expiresAt,
+		"renewed_at":  now,
+		"renew_count": lease.RenewCount + 1,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrLeaseInactive
+	}
+	lease.ExpiresAt = expiresAt
+	lease.RenewedAt = &now
+	lease.RenewCount++
+
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"expires_at": expiresAt, "renew_count": lease.RenewCount})
+		_ = s.audit.LogAgent(ctx, agent.ID, agent.OrgID, lease.ID, models.ActionAgentLeaseRenew, "agent_lease", ip, datatypes.JSON(metadata))
+	}
+	return &lease, nil
+}
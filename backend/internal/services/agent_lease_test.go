@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestRenewLeaseExtendsCredentialsBeforeCommitting(t *testing.T) {
+	agent := &models.AgentIdentity{ID: uuid.New(), OrgID: uuid.New()}
+	leaseID, grantID := uuid.New(), uuid.New()
+	leaseDB := func(t *testing.T) *fakeDB {
+		db := useFakeDB(t)
+		db.on([]string{`FROM "agent_leases"`}, []string{"id", "agent_id", "grant_ids", "ttl_seconds", "expires_at"},
+			[]driver.Value{leaseID.String(), agent.ID.String(), `["` + grantID.String() + `"]`, int64(600), time.Now().Add(time.Minute)})
+		db.on([]string{`FROM "agent_grants"`}, []string{"id", "agent_id"}, []driver.Value{grantID.String(), agent.ID.String()})
+		return db
+	}
+	s := &AgentService{}
+
+	t.Run("failure leaves the lease", func(t *testing.T) {
+		db := leaseDB(t)
+		_, err := s.RenewLease(context.Background(), agent, leaseID, "", func(context.Context, time.Time) error {
+			return errors.New("server unreachable")
+		})
+		if err == nil {
+			t.Fatal("RenewLease() succeeded although the credentials were not extended")
+		}
+		if got := db.statements(`UPDATE "agent_leases"`); len(got) != 0 {
+			t.Fatalf("lease renewed anyway: %+v", got)
+		}
+	})
+
+	t.Run("extends first", func(t *testing.T) {
+		db := leaseDB(t)
+		var extendedTo time.Time
+		extendedAt := -1
+		lease, err := s.RenewLease(context.Background(), agent, leaseID, "", func(_ context.Context, expiresAt time.Time) error {
+			extendedTo, extendedAt = expiresAt, len(db.statements())
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("RenewLease() returned %v", err)
+		}
+		if update := db.index(`UPDATE "agent_leases"`); extendedAt < 0 || update < extendedAt {
+			t.Fatalf("lease updated at statement %d, credentials extended at %d", update, extendedAt)
+		}
+		if !extendedTo.Equal(lease.ExpiresAt) || lease.RenewCount != 1 {
+			t.Fatalf("extended to %v, lease = %+v", extendedTo, lease)
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// ErrAgentQuotaExceeded is wrapped by QuotaExceededError.
+var ErrAgentQuotaExceeded = errors.New("agent quota exceeded")
+
+// Quota scopes and limits, as reported on agent_quota_exceeded.
+const (
+	QuotaScopeAgent      = "agent"
+	QuotaScopeCredential = "credential"
+
+	QuotaResolvesPerHour    = "resolves_per_hour"
+	QuotaResolvesPerDay     = "resolves_per_day"
+	QuotaDistinctKeysPerDay = "distinct_keys_per_day"
+)
+
+const maxAgentQuota = 1000000
+
+// QuotaExceededError says which quota a resolve would breach and how long
+// until it fits again.
+type QuotaExceededError struct {
+	Scope      string
+	Limit      string
+	Max        int
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: %s %s limit of %d", ErrAgentQuotaExceeded, e.Scope, e.Limit, e.Max)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrAgentQuotaExceeded
+}
+
+func validateQuota(quota models.AgentQuota) error {
+	for _, v := range []int{quota.ResolvesPerHour, quota.ResolvesPerDay, quota.DistinctKeysPerDay} {
+		if v < 0 || v > maxAgentQuota {
+			return fmt.Errorf("quota limits must be between 0 (unlimited) and %d", maxAgentQuota)
+		}
+	}
+	return nil
+}
+
+// SetAgentQuota replaces the quota shared by all of an agent's credentials.
+func (s *AgentService) SetAgentQuota(ctx context.Context, userID, orgID, agentID uuid.UUID, quota models.AgentQuota, ip string) (*models.AgentIdentity, error) {
+	if err := validateQuota(quota); err != nil {
+		return nil, err
+	}
+	db := database.GetDB().WithContext(ctx)
+	var agent models.AgentIdentity
+	if err := db.Where("id = ? AND org_id = ?", agentID, orgID).First(&agent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, err
+	}
+	if err := db.Model(&agent).Select("quota_resolves_per_hour", "quota_resolves_per_day", "quota_distinct_keys_per_day").
+		Updates(&models.AgentIdentity{Quota: quota}).Error; err != nil {
+		return nil, err
+	}
+	agent.Quota = quota
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"quota": quota})
+		_ = s.audit.Log(ctx, userID, orgID, agentID, models.ActionAgentQuotaUpdate, "agent", ip, datatypes.JSON(metadata))
+	}
+	return &agent, nil
+}
+
+// SetCredentialQuota replaces one credential's own quota. The agent's quota
+// still applies.
+func (s *AgentService) SetCredentialQuota(ctx context.Context, userID, orgID, agentID, credentialID uuid.UUID, quota models.AgentQuota, ip string) (*models.AgentCredential, error) {
+	if err := validateQuota(quota); err != nil {
+		return nil, err
+	}
+	db := database.GetDB().WithContext(ctx)
+	var credential models.AgentCredential
+	if err := db.Joins("JOIN agent_identities ON agent_identities.id = agent_credentials.agent_id").
+		Where("agent_credentials.id = ? AND agent_credentials.agent_id = ? AND agent_identities.org_id = ?", credentialID, agentID, orgID).
+		First(&credential).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&credential).Select("quota_resolves_per_hour", "quota_resolves_per_day", "quota_distinct_keys_per_day").
+		Updates(&models.AgentCredential{Quota: quota}).Error; err != nil {
+		return nil, err
+	}
+	credential.Quota = quota
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"agent_id": agentID, "quota": quota})
+		_ = s.audit.Log(ctx, userID, orgID, credentialID, models.ActionAgentQuotaUpdate, "agent_credential", ip, datatypes.JSON(metadata))
+	}
+	return &credential, nil
+}
+
+// CheckQuota reports whether a resolve delivering keys from environmentID
+// fits the agent's and the credential's quotas, counting the leases recorded
+// for earlier resolves. A breach is audited and returned as a
+// *QuotaExceededError. Concurrent resolves are counted independently, so a
+// burst can overshoot a limit by the number of requests in flight.
+func (s *AgentService) CheckQuota(ctx context.Context, agent *models.AgentIdentity, credential *models.AgentCredential, environmentID uuid.UUID, keys []string, ip string) error {
+	db := database.GetDB().WithContext(ctx)
+	now := time.Now().UTC()
+	exceeded, err := quotaBreach(db, QuotaScopeAgent, "agent_id", agent.ID, agent.Quota, environmentID, keys, now)
+	if err == nil && exceeded == nil {
+		exceeded, err = quotaBreach(db, QuotaScopeCredential, "credential_id", credential.ID, credential.Quota, environmentID, keys, now)
+	}
+	if err != nil || exceeded == nil {
+		return err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{
+			"credential_id":       credential.ID,
+			"scope":               exceeded.Scope,
+			"limit":               exceeded.Limit,
+			"max":                 exceeded.Max,
+			"retry_after_seconds": int(exceeded.RetryAfter.Round(time.Second) / time.Second),
+		})
+		_ = s.audit.LogAgent(ctx, agent.ID, agent.OrgID, environmentID, models.ActionAgentQuotaExceeded, "environment", ip, datatypes.JSON(metadata))
+	}
+	return exceeded
+}
+
+// quotaBreach checks one quota against the leases whose column equals id.
+// column is agent_id or credential_id.
+func quotaBreach(db *gorm.DB, scope, column string, id uuid.UUID, quota models.AgentQuota, environmentID uuid.UUID, keys []string, now time.Time) (*QuotaExceededError, error) {
+	windows := []struct {
+		limit  string
+		max    int
+		window time.Duration
+	}{
+		{QuotaResolvesPerHour, quota.ResolvesPerHour, time.Hour},
+		{QuotaResolvesPerDay, quota.ResolvesPerDay, 24 * time.Hour},
+	}
+	for _, w := range windows {
+		if w.max <= 0 {
+			continue
+		}
+		// With max leases in the window, the oldest of the most recent max
+		// has to age out before another resolve fits.
+		var blocking []time.Time
+		if err := db.Model(&models.AgentLease{}).
+			Where(column+" = ? AND created_at > ?", id, now.Add(-w.window)).
+			Order("created_at DESC").Offset(w.max-1).Limit(1).
+			Pluck("created_at", &blocking).Error; err != nil {
+			return nil, err
+		}
+		if len(blocking) > 0 {
+			return &QuotaExceededError{Scope: scope, Limit: w.limit, Max: w.max, RetryAfter: blocking[0].Add(w.window).Sub(now)}, nil
+		}
+	}
+
+	if quota.DistinctKeysPerDay <= 0 || len(keys) == 0 {
+		return nil, nil
+	}
+	since := now.Add(-24 * time.Hour)
+	var used []string
+	if err := db.Raw(`SELECT DISTINCT agent_leases.environment_id::text || '/' || k.key FROM agent_leases, jsonb_array_elements_text(agent_leases.keys) AS k(key) WHERE agent_leases.`+column+` = ? AND agent_leases.created_at > ?`, id, since).
+		Scan(&used).Error; err != nil {
+		return nil, err
+	}
+	if !distinctKeysExceeded(used, environmentID, keys, quota.DistinctKeysPerDay) {
+		return nil, nil
+	}
+	// The earliest a key can drop out of the window.
+	var oldest []time.Time
+	if err := db.Model(&models.AgentLease{}).
+		Where(column+" = ? AND created_at > ?", id, since).
+		Order("created_at ASC").Limit(1).
+		Pluck("created_at", &oldest).Error; err != nil {
+		return nil, err
+	}
+	retryAfter := 24 * time.Hour
+	if len(oldest) > 0 {
+		retryAfter = oldest[0].Add(24 * time.Hour).Sub(now)
+	}
+	return &QuotaExceededError{Scope: scope, Limit: QuotaDistinctKeysPerDay, Max: quota.DistinctKeysPerDay, RetryAfter: retryAfter}, nil
+}
+
+// distinctKeysExceeded reports whether delivering keys from environmentID
+// would take the distinct environment keys past max. used holds the
+// "environment/key" pairs already delivered in the window. Keys delivered
+// before never count twice.
+func distinctKeysExceeded(used []string, environmentID uuid.UUID, keys []string, max int) bool {
+	seen := make(map[string]bool, len(used)+len(keys))
+	for _, pair := range used {
+		seen[pair] = true
+	}
+	fresh := 0
+	for _, key := range keys {
+		pair := environmentID.String() + "/" + key
+		if !seen[pair] {
+			seen[pair] = true
+			fresh++
+		}
+	}
+	return fresh > 0 && len(used)+fresh > max
+}
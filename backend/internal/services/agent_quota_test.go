@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestQuotaExceededErrorWrapsSentinel(t *testing.T) {
+	var err error = &QuotaExceededError{Scope: QuotaScopeAgent, Limit: QuotaResolvesPerHour, Max: 10, RetryAfter: time.Minute}
+	if !errors.Is(err, ErrAgentQuotaExceeded) {
+		t.Fatalf("errors.Is(%v, ErrAgentQuotaExceeded) = false", err)
+	}
+	var exceeded *QuotaExceededError
+	if !errors.As(err, &exceeded) || exceeded.Limit != QuotaResolvesPerHour {
+		t.Fatalf("errors.As() = %+v", exceeded)
+	}
+}
+
+func TestValidateQuota(t *testing.T) {
+	if err := validateQuota(models.AgentQuota{ResolvesPerHour: 100, DistinctKeysPerDay: 20}); err != nil {
+		t.Fatalf("validateQuota() returned %v", err)
+	}
+	if err := validateQuota(models.AgentQuota{ResolvesPerDay: -1}); err == nil {
+		t.Fatal("validateQuota() accepted a negative limit")
+	}
+	if err := validateQuota(models.AgentQuota{DistinctKeysPerDay: maxAgentQuota + 1}); err == nil {
+		t.Fatal("validateQuota() accepted a limit above the maximum")
+	}
+}
+
+func TestDistinctKeysExceededCountsOnlyNewKeys(t *testing.T) {
+	env := uuid.New()
+	other := uuid.New()
+	used := []string{env.String() + "/A", env.String() + "/B"}
+
+	if distinctKeysExceeded(used, env, []string{"A", "B"}, 2) {
+		t.Fatal("re-reading delivered keys counted against the quota")
+	}
+	if !distinctKeysExceeded(used, env, []string{"A", "C"}, 2) {
+		t.Fatal("a third key fit a quota of two")
+	}
+	if !distinctKeysExceeded(used, other, []string{"A"}, 2) {
+		t.Fatal("the same key in another environment was not counted")
+	}
+	if distinctKeysExceeded(used, env, []string{"C", "C"}, 3) {
+		t.Fatal("a repeated key was counted twice")
+	}
+}
@@ -26,20 +26,27 @@ var (
 	ErrAgentForbidden    = errors.New("agent is not authorized for the requested secrets")
 	ErrAgentNotFound     = errors.New("agent not found")
 	ErrGrantNotFound     = errors.New("agent grant not found")
+	// ErrAgentCredentialRestricted is a genuine token used from a network,
+	// at a time, or more often than its conditions allow.
+	ErrAgentCredentialRestricted = errors.New("agent credential conditions not met")
+	// ErrCredentialNotRotatable is returned for revoked, expired, already
+	// rotated and federated credentials.
+	ErrCredentialNotRotatable = errors.New("credential cannot be rotated")
 )
 
 const agentTokenPrefix = "envo_agent_"
 
 type AgentService struct {
 	audit              *AuditService
+	policies           *PolicyService
 	usageWriteInterval time.Duration
 }
 
-func NewAgentService(audit *AuditService, usageWriteInterval time.Duration) *AgentService {
+func NewAgentService(audit *AuditService, policies *PolicyService, usageWriteInterval time.Duration) *AgentService {
 	if usageWriteInterval <= 0 {
 		usageWriteInterval = time.Minute
 	}
-	return &AgentService{audit: audit, usageWriteInterval: usageWriteInterval}
+	return &AgentService{audit: audit, policies: policies, usageWriteInterval: usageWriteInterval}
 }
 
 func (s *AgentService) ListAgents(ctx context.Context, orgID uuid.UUID) ([]models.AgentIdentity, error) {
@@ -63,7 +70,10 @@ func (s *AgentService) CreateAgent(ctx context.Context, userID, orgID uuid.UUID,
 	return agent, nil
 }
 
-func (s *AgentService) UpdateAgentStatus(ctx context.Context, userID, orgID, agentID uuid.UUID, status, ip string) (*models.AgentIdentity, error) {
+// UpdateAgentStatus activates, suspends or revokes an agent on behalf of
+// actor. metadata, such as what triggered an automatic suspension, is
+// recorded with the audit entry.
+func (s *AgentService) UpdateAgentStatus(ctx context.Context, actor Actor, orgID, agentID uuid.UUID, status, ip string, metadata map[string]any) (*models.AgentIdentity, error) {
 	if status != models.AgentStatusActive && status != models.AgentStatusSuspended && status != models.AgentStatusRevoked {
 		return nil, fmt.Errorf("status must be active, suspended, or revoked")
 	}
@@ -95,8 +105,12 @@ func (s *AgentService) UpdateAgentStatus(ctx context.Context, userID, orgID, age
 	}
 	agent.Status = status
 	if s.audit != nil {
-		metadata, _ := json.Marshal(map[string]string{"status": status})
-		_ = s.audit.Log(ctx, userID, orgID, agentID, models.ActionAgentUpdate, "agent", ip, datatypes.JSON(metadata))
+		fields := map[string]any{"status": status}
+		for k, v := range metadata {
+			fields[k] = v
+		}
+		encoded, _ := json.Marshal(fields)
+		_ = s.audit.LogActor(ctx, actor, orgID, agentID, models.ActionAgentUpdate, "agent", ip, datatypes.JSON(encoded))
 	}
 	return &agent, nil
 }
@@ -133,7 +147,9 @@ func credentialIDFromToken(raw string) (uuid.UUID, error) {
 	return id, nil
 }
 
-func (s *AgentService) CreateCredential(ctx context.Context, userID, orgID, agentID uuid.UUID, name string, expiresAt *time.Time, ip string) (*models.AgentCredential, string, error) {
+// CreateCredential issues a token for the agent, optionally restricted by
+// conditions. The raw token is returned once and never stored.
+func (s *AgentService) CreateCredential(ctx context.Context, userID, orgID, agentID uuid.UUID, name string, expiresAt *time.Time, conditions CredentialConditions, ip string) (*models.AgentCredential, string, error) {
 	name = strings.TrimSpace(name)
 	if name == "" || len(name) > 120 {
 		return nil, "", fmt.Errorf("credential name must be between 1 and 120 characters")
@@ -154,6 +170,9 @@ func (s *AgentService) CreateCredential(ctx context.Context, userID, orgID, agen
 		return nil, "", err
 	}
 	credential := &models.AgentCredential{ID: id, AgentID: agentID, Name: name, TokenHash: hash, TokenPrefix: prefix, ExpiresAt: expiresAt}
+	if err := applyCredentialConditions(credential, conditions); err != nil {
+		return nil, "", err
+	}
 	if err := db.Create(credential).Error; err != nil {
 		return nil, "", err
 	}
@@ -188,25 +207,131 @@ func (s *AgentService) RevokeCredential(ctx context.Context, userID, orgID, agen
 	return nil
 }
 
-func normalizeKeys(keys []string) []string {
-	set := make(map[string]struct{}, len(keys))
-	for _, key := range keys {
-		key = strings.TrimSpace(key)
-		if key != "" {
-			set[key] = struct{}{}
+const (
+	DefaultCredentialRotationOverlap = time.Hour
+	maxCredentialRotationOverlap     = 7 * 24 * time.Hour
+	maxCredentialAgeDays             = 3650
+)
+
+// RotateCredential issues a successor with the predecessor's name, use
+// conditions, quota and lifetime. The predecessor keeps working for overlap
+// so deployments can switch tokens, then expires and is revoked by the
+// credential rotation job. The raw token is returned once and never stored.
+func (s *AgentService) RotateCredential(ctx context.Context, userID, orgID, agentID, credentialID uuid.UUID, overlap time.Duration, ip string) (*models.AgentCredential, string, error) {
+	if overlap < 0 || overlap > maxCredentialRotationOverlap {
+		return nil, "", fmt.Errorf("overlap must be between 0s and %s", maxCredentialRotationOverlap)
+	}
+	db := database.GetDB().WithContext(ctx)
+	var predecessor models.AgentCredential
+	if err := db.Joins("JOIN agent_identities ON agent_identities.id = agent_credentials.agent_id").
+		Where("agent_credentials.id = ? AND agent_credentials.agent_id = ? AND agent_identities.org_id = ? AND agent_identities.status <> ?", credentialID, agentID, orgID, models.AgentStatusRevoked).
+		First(&predecessor).Error; err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	if predecessor.RevokedAt != nil || predecessor.SupersededBy != nil || predecessor.TrustPolicyID != nil ||
+		(predecessor.ExpiresAt != nil && !predecessor.ExpiresAt.After(now)) {
+		return nil, "", ErrCredentialNotRotatable
+	}
+
+	id, raw, hash, prefix, err := GenerateAgentToken()
+	if err != nil {
+		return nil, "", err
+	}
+	successor := &models.AgentCredential{
+		ID:           id,
+		AgentID:      agentID,
+		Name:         predecessor.Name,
+		TokenHash:    hash,
+		TokenPrefix:  prefix,
+		AllowedCIDRs: predecessor.AllowedCIDRs,
+		TimeWindows:  predecessor.TimeWindows,
+		TimeZone:     predecessor.TimeZone,
+		Quota:        predecessor.Quota,
+	}
+	if predecessor.ExpiresAt != nil {
+		expiresAt := now.Add(predecessor.ExpiresAt.Sub(predecessor.CreatedAt))
+		successor.ExpiresAt = &expiresAt
+	}
+	if predecessor.MaxUses != nil {
+		maxUses := *predecessor.MaxUses
+		successor.MaxUses = &maxUses
+	}
+	overlapEnds := now.Add(overlap)
+	if predecessor.ExpiresAt != nil && predecessor.ExpiresAt.Before(overlapEnds) {
+		overlapEnds = *predecessor.ExpiresAt
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		claim := tx.Model(&models.AgentCredential{}).
+			Where("id = ? AND revoked_at IS NULL AND superseded_by IS NULL", predecessor.ID).
+			Updates(map[string]any{"superseded_by": successor.ID, "expires_at": overlapEnds})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return ErrCredentialNotRotatable // rotated or revoked concurrently
+		}
+		return tx.Create(successor).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"predecessor_id": predecessor.ID, "predecessor_expires_at": overlapEnds})
+		_ = s.audit.Log(ctx, userID, orgID, successor.ID, models.ActionAgentTokenRotate, "agent_credential", ip, datatypes.JSON(metadata))
+	}
+	return successor, raw, nil
+}
+
+// SetMaxCredentialAge sets the agent's rotation policy. Zero removes it.
+// Credentials already older than the new limit stop working immediately.
+func (s *AgentService) SetMaxCredentialAge(ctx context.Context, userID, orgID, agentID uuid.UUID, days int, ip string) (*models.AgentIdentity, error) {
+	if days < 0 || days > maxCredentialAgeDays {
+		return nil, fmt.Errorf("maximum credential age must be between 0 and %d days", maxCredentialAgeDays)
+	}
+	db := database.GetDB().WithContext(ctx)
+	var agent models.AgentIdentity
+	if err := db.Where("id = ? AND org_id = ?", agentID, orgID).First(&agent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAgentNotFound
 		}
+		return nil, err
+	}
+	if err := db.Model(&agent).Update("max_credential_age_days", days).Error; err != nil {
+		return nil, err
 	}
-	out := make([]string, 0, len(set))
-	for key := range set {
-		out = append(out, key)
+	// A changed limit moves every deadline, so warn again.
+	if err := db.Model(&models.AgentCredential{}).Where("agent_id = ? AND expiry_notified_at IS NOT NULL", agentID).
+		Update("expiry_notified_at", nil).Error; err != nil {
+		return nil, err
+	}
+	agent.MaxCredentialAgeDays = days
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"max_credential_age_days": days})
+		_ = s.audit.Log(ctx, userID, orgID, agentID, models.ActionAgentRotationPolicy, "agent", ip, datatypes.JSON(metadata))
 	}
-	sort.Strings(out)
-	return out
+	return &agent, nil
 }
 
-func (s *AgentService) CreateGrant(ctx context.Context, userID, orgID, agentID, envID uuid.UUID, keys []string, allowAll bool, expiresAt *time.Time, ip string) (*models.AgentGrant, error) {
-	keys = normalizeKeys(keys)
-	if !allowAll && len(keys) == 0 {
+// CreateGrant creates a secrets.inject or secrets.write grant for the keys in
+// scope. Allow and deny entries may be exact names or `*` patterns.
+func (s *AgentService) CreateGrant(ctx context.Context, userID, orgID, agentID uuid.UUID, capability string, target GrantTarget, scope KeyScope, expiresAt *time.Time, ip string) (*models.AgentGrant, error) {
+	if capability == "" {
+		capability = models.AgentCapabilitySecretsInject
+	}
+	if capability != models.AgentCapabilitySecretsInject && capability != models.AgentCapabilitySecretsWrite {
+		return nil, fmt.Errorf("unknown capability %q", capability)
+	}
+	keys, err := normalizeKeys(scope.Allow)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := normalizeKeys(scope.Deny)
+	if err != nil {
+		return nil, err
+	}
+	if !scope.All && len(keys) == 0 {
 		return nil, fmt.Errorf("select at least one secret key or explicitly allow all secrets")
 	}
 	if expiresAt != nil && !expiresAt.After(time.Now()) {
@@ -220,16 +345,19 @@ func (s *AgentService) CreateGrant(ctx context.Context, userID, orgID, agentID,
 		}
 		return nil, err
 	}
-	var env models.Environment
-	if err := db.Joins("JOIN projects ON projects.id = environments.project_id").
-		Where("environments.id = ? AND projects.org_id = ?", envID, orgID).First(&env).Error; err != nil {
-		return nil, fmt.Errorf("environment does not belong to this organization")
+	target, err = checkGrantTarget(db, orgID, target)
+	if err != nil {
+		return nil, err
 	}
 	encoded, err := json.Marshal(keys)
 	if err != nil {
 		return nil, err
 	}
-	grant := &models.AgentGrant{AgentID: agentID, EnvironmentID: envID, Capability: models.AgentCapabilitySecretsInject, AllowedKeys: datatypes.JSON(encoded), AllowAllSecrets: allowAll, ExpiresAt: expiresAt, CreatedBy: userID}
+	encodedDenied, err := json.Marshal(denied)
+	if err != nil {
+		return nil, err
+	}
+	grant := &models.AgentGrant{AgentID: agentID, EnvironmentID: target.EnvironmentID, ProjectID: target.ProjectID, EnvironmentPattern: target.EnvironmentPattern, Capability: capability, AllowedKeys: datatypes.JSON(encoded), DeniedKeys: datatypes.JSON(encodedDenied), AllowAllSecrets: scope.All, ExpiresAt: expiresAt, CreatedBy: userID}
 	if err := db.Create(grant).Error; err != nil {
 		return nil, err
 	}
@@ -239,13 +367,177 @@ func (s *AgentService) CreateGrant(ctx context.Context, userID, orgID, agentID,
 	return grant, nil
 }
 
+// DatabaseGrantInput configures a database.credentials grant.
+type DatabaseGrantInput struct {
+	AdminSecretID uuid.UUID
+	CredentialKey string
+	GrantTemplate string
+	TTL           time.Duration
+}
+
+const (
+	defaultDatabaseCredentialTTL = 15 * time.Minute
+	maxDatabaseCredentialTTL     = 24 * time.Hour
+)
+
+// CreateDatabaseGrant lets an agent mint short-lived PostgreSQL roles in the
+// targeted environments. The admin connection secret must belong to the same
+// organization and be readable by the caller; its value is only read when a
+// role is issued.
+func (s *AgentService) CreateDatabaseGrant(ctx context.Context, userID, orgID, agentID uuid.UUID, target GrantTarget, in DatabaseGrantInput, expiresAt *time.Time, ip string) (*models.AgentGrant, error) {
+	in.CredentialKey = strings.TrimSpace(in.CredentialKey)
+	in.GrantTemplate = strings.TrimSpace(in.GrantTemplate)
+	if in.CredentialKey == "" || len(in.CredentialKey) > 255 {
+		return nil, fmt.Errorf("credential key must be between 1 and 255 characters")
+	}
+	if in.TTL == 0 {
+		in.TTL = defaultDatabaseCredentialTTL
+	}
+	if in.TTL < time.Minute || in.TTL > maxDatabaseCredentialTTL {
+		return nil, fmt.Errorf("credential TTL must be between 1m and %s", maxDatabaseCredentialTTL)
+	}
+	if !strings.Contains(in.GrantTemplate, grantTemplateRolePlaceholder) {
+		return nil, fmt.Errorf("grant template must reference %s", grantTemplateRolePlaceholder)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("grant expiry must be in the future")
+	}
+	db := database.GetDB().WithContext(ctx)
+	var agent models.AgentIdentity
+	if err := db.Where("id = ? AND org_id = ? AND status <> ?", agentID, orgID, models.AgentStatusRevoked).First(&agent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, err
+	}
+	target, err := checkGrantTarget(db, orgID, target)
+	if err != nil {
+		return nil, err
+	}
+	var adminSecrets int64
+	if err := db.Model(&models.Secret{}).
+		Joins("JOIN environments ON environments.id = secrets.environment_id").
+		Joins("JOIN projects ON projects.id = environments.project_id").
+		Where("secrets.id = ? AND projects.org_id = ?", in.AdminSecretID, orgID).Count(&adminSecrets).Error; err != nil {
+		return nil, err
+	}
+	if adminSecrets == 0 {
+		return nil, fmt.Errorf("admin connection secret does not belong to this organization")
+	}
+	// The grant puts the admin connection to work on the agent's behalf, so
+	// the granting user has to be able to read it themselves.
+	if err := checkSecretReadable(db, userID, orgID); err != nil {
+		return nil, err
+	}
+
+	adminSecretID := in.AdminSecretID
+	grant := &models.AgentGrant{
+		AgentID:            agentID,
+		EnvironmentID:      target.EnvironmentID,
+		ProjectID:          target.ProjectID,
+		EnvironmentPattern: target.EnvironmentPattern,
+		Capability:         models.AgentCapabilityDatabaseCredentials,
+		AdminSecretID:      &adminSecretID,
+		CredentialKey:      in.CredentialKey,
+		GrantTemplate:      in.GrantTemplate,
+		CredentialTTL:      int(in.TTL / time.Second),
+		ExpiresAt:          expiresAt,
+		CreatedBy:          userID,
+	}
+	if err := db.Create(grant).Error; err != nil {
+		return nil, err
+	}
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"capability": grant.Capability, "credential_key": grant.CredentialKey, "admin_secret_id": adminSecretID})
+		_ = s.audit.Log(ctx, userID, orgID, grant.ID, models.ActionAgentGrantCreate, "agent_grant", ip, datatypes.JSON(metadata))
+	}
+	return grant, nil
+}
+
+// ListGrants lists an agent's grants. Project grants carry the names of the
+// environments they currently cover, and each secrets.inject grant carries
+// the current keys it matches, so reviewers see the effective scope.
 func (s *AgentService) ListGrants(ctx context.Context, orgID, agentID uuid.UUID) ([]models.AgentGrant, error) {
+	db := database.GetDB().WithContext(ctx)
 	var grants []models.AgentGrant
-	err := database.GetDB().WithContext(ctx).Preload("Environment.Project").
+	if err := db.Preload("Environment.Project").Preload("Project").
 		Joins("JOIN agent_identities ON agent_identities.id = agent_grants.agent_id").
 		Where("agent_grants.agent_id = ? AND agent_identities.org_id = ?", agentID, orgID).
-		Order("agent_grants.created_at DESC").Find(&grants).Error
-	return grants, err
+		Order("agent_grants.created_at DESC").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+
+	var projectIDs []uuid.UUID
+	for _, grant := range grants {
+		if grant.ProjectID != nil {
+			projectIDs = append(projectIDs, *grant.ProjectID)
+		}
+	}
+	var projectEnvs []models.Environment
+	if len(projectIDs) > 0 {
+		if err := db.Where("project_id IN ?", projectIDs).Order("name ASC").Find(&projectEnvs).Error; err != nil {
+			return nil, err
+		}
+	}
+	covered := make([][]uuid.UUID, len(grants))
+	var envIDs []uuid.UUID
+	for i := range grants {
+		grant := &grants[i]
+		if grant.EnvironmentID != nil {
+			covered[i] = []uuid.UUID{*grant.EnvironmentID}
+		} else {
+			grant.MatchedEnvironments = []string{}
+			for j := range projectEnvs {
+				if grantCoversEnvironment(grant, &projectEnvs[j]) {
+					covered[i] = append(covered[i], projectEnvs[j].ID)
+					grant.MatchedEnvironments = append(grant.MatchedEnvironments, projectEnvs[j].Name)
+				}
+			}
+		}
+		if grant.Capability != models.AgentCapabilityDatabaseCredentials {
+			envIDs = append(envIDs, covered[i]...)
+		}
+	}
+	if len(envIDs) == 0 {
+		return grants, nil
+	}
+
+	var rows []struct {
+		EnvironmentID uuid.UUID
+		Key           string
+	}
+	if err := db.Model(&models.Secret{}).Select("environment_id, key").
+		Where("environment_id IN ?", envIDs).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	keysByEnv := map[uuid.UUID][]string{}
+	for _, row := range rows {
+		keysByEnv[row.EnvironmentID] = append(keysByEnv[row.EnvironmentID], row.Key)
+	}
+	for i := range grants {
+		grant := &grants[i]
+		if grant.Capability == models.AgentCapabilityDatabaseCredentials {
+			continue
+		}
+		scope, err := grantKeyScope(grant)
+		if err != nil {
+			return nil, err
+		}
+		matched := map[string]struct{}{}
+		for _, envID := range covered[i] {
+			for _, key := range keysByEnv[envID] {
+				if scope.Matches(key) {
+					matched[key] = struct{}{}
+				}
+			}
+		}
+		grant.MatchedKeys = make([]string, 0, len(matched))
+		for key := range matched {
+			grant.MatchedKeys = append(grant.MatchedKeys, key)
+		}
+		sort.Strings(grant.MatchedKeys)
+	}
+	return grants, nil
 }
 
 func (s *AgentService) RevokeGrant(ctx context.Context, userID, orgID, agentID, grantID uuid.UUID, ip string) error {
@@ -266,8 +558,9 @@ func (s *AgentService) RevokeGrant(ctx context.Context, userID, orgID, agentID,
 }
 
 // AuthenticateToken validates a credential without ever loading or comparing a
-// plaintext token from storage.
-func (s *AgentService) AuthenticateToken(ctx context.Context, raw string) (*models.AgentIdentity, *models.AgentCredential, error) {
+// plaintext token from storage, then checks its use conditions against the
+// client IP. Refusals of a genuine token are audited with the reason.
+func (s *AgentService) AuthenticateToken(ctx context.Context, raw, ip string) (*models.AgentIdentity, *models.AgentCredential, error) {
 	id, err := credentialIDFromToken(raw)
 	if err != nil {
 		return nil, nil, ErrAgentUnauthorized
@@ -283,8 +576,19 @@ func (s *AgentService) AuthenticateToken(ctx context.Context, raw string) (*mode
 		return nil, nil, ErrAgentUnauthorized
 	}
 	now := time.Now().UTC()
-	if credential.RevokedAt != nil || (credential.ExpiresAt != nil && !credential.ExpiresAt.After(now)) || credential.Agent.Status != models.AgentStatusActive {
-		return nil, nil, ErrAgentUnauthorized
+	if reason := credentialDenial(&credential, ip, now); reason != "" {
+		return nil, nil, s.denyCredential(ctx, &credential, ip, reason)
+	}
+	if credential.MaxUses != nil {
+		result := db.Model(&models.AgentCredential{}).Where("id = ? AND use_count < ?", credential.ID, *credential.MaxUses).
+			Update("use_count", gorm.Expr("use_count + 1"))
+		if result.Error != nil {
+			return nil, nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, nil, s.denyCredential(ctx, &credential, ip, DenialMaxUsesExceeded)
+		}
+		credential.UseCount++
 	}
 	// Last-used timestamps are observability metadata, not an authorization
 	// input. Throttling these writes removes two database updates from every
@@ -299,12 +603,31 @@ func (s *AgentService) AuthenticateToken(ctx context.Context, raw string) (*mode
 	return &credential.Agent, &credential, nil
 }
 
+// denyCredential audits a refused credential and returns the error for it.
+// Revoked and expired tokens get the same answer as unknown ones; condition
+// failures name the condition so the operator can tell what to fix.
+func (s *AgentService) denyCredential(ctx context.Context, credential *models.AgentCredential, ip, reason string) error {
+	if s.audit != nil {
+		metadata, _ := json.Marshal(map[string]any{"reason": reason})
+		_ = s.audit.LogAgent(ctx, credential.AgentID, credential.Agent.OrgID, credential.ID, models.ActionAgentAuthDenied, "agent_credential", ip, datatypes.JSON(metadata))
+	}
+	switch reason {
+	case DenialRevoked, DenialExpired, DenialAgentInactive:
+		return ErrAgentUnauthorized
+	default:
+		return fmt.Errorf("%w: %s", ErrAgentCredentialRestricted, reason)
+	}
+}
+
 type AgentAccess struct {
 	Environment uuid.UUID
-	AllowedKeys map[string]struct{}
-	AllowAll    bool
-	ExpiresAt   *time.Time
-	GrantIDs    []uuid.UUID
+	// Scopes are the key scopes of the live secrets.inject grants, or the
+	// exact requested keys once a request has been checked against them.
+	Scopes    []KeyScope
+	ExpiresAt *time.Time
+	GrantIDs  []uuid.UUID
+	// DatabaseGrants are database.credentials grants to issue roles for.
+	DatabaseGrants []models.AgentGrant
 }
 
 func resolveSelector(db *gorm.DB, agent *models.AgentIdentity, projectSelector, envSelector string) (*models.Environment, error) {
@@ -331,6 +654,75 @@ func resolveSelector(db *gorm.DB, agent *models.AgentIdentity, projectSelector,
 	return &env, nil
 }
 
+// AllowsKey reports whether any scope covers key.
+func (a *AgentAccess) AllowsKey(key string) bool {
+	for _, scope := range a.Scopes {
+		if scope.Matches(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExactKeys returns the key names the scopes allow when none of them uses a
+// pattern or allows all keys, so resolves can load only those secrets. It
+// returns nil, false otherwise.
+func (a *AgentAccess) ExactKeys() ([]string, bool) {
+	return exactKeys(a.Scopes)
+}
+
+// liveGrants loads the agent's unexpired, unrevoked grants with one of
+// capabilities that cover env, oldest first.
+func liveGrants(db *gorm.DB, agent *models.AgentIdentity, env *models.Environment, capabilities ...string) ([]models.AgentGrant, error) {
+	var grants []models.AgentGrant
+	if err := db.Where("agent_id = ? AND capability IN ? AND revoked_at IS NULL", agent.ID, capabilities).
+		Where("environment_id = ? OR (environment_id IS NULL AND project_id = ?)", env.ID, env.ProjectID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC()).Order("created_at ASC").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	live := grants[:0]
+	for i := range grants {
+		if grantCoversEnvironment(&grants[i], env) {
+			live = append(live, grants[i])
+		}
+	}
+	return live, nil
+}
+
+// AuthorizeWrite checks that a live secrets.write grant covers key in the
+// selected environment and returns that environment.
+func (s *AgentService) AuthorizeWrite(ctx context.Context, agent *models.AgentIdentity, project, environment, key string) (*models.Environment, error) {
+	if strings.TrimSpace(project) == "" || strings.TrimSpace(environment) == "" {
+		return nil, fmt.Errorf("project and environment are required")
+	}
+	keys, err := normalizeKeys([]string{key})
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 || keys[0] != key || isKeyPattern(key) {
+		return nil, fmt.Errorf("key must be an exact secret name")
+	}
+	db := database.GetDB().WithContext(ctx)
+	env, err := resolveSelector(db, agent, project, environment)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := liveGrants(db, agent, env, models.AgentCapabilitySecretsWrite)
+	if err != nil {
+		return nil, err
+	}
+	for i := range grants {
+		scope, err := grantKeyScope(&grants[i])
+		if err != nil {
+			return nil, err
+		}
+		if scope.Matches(key) {
+			return env, nil
+		}
+	}
+	return nil, ErrAgentForbidden
+}
+
 // AuthorizeResolve evaluates the current live grants on every request.
 func (s *AgentService) AuthorizeResolve(ctx context.Context, agent *models.AgentIdentity, project, environment string, requestedKeys []string) (*AgentAccess, error) {
 	if strings.TrimSpace(project) == "" || strings.TrimSpace(environment) == "" {
@@ -341,45 +733,73 @@ func (s *AgentService) AuthorizeResolve(ctx context.Context, agent *models.Agent
 	if err != nil {
 		return nil, err
 	}
-	now := time.Now().UTC()
-	var grants []models.AgentGrant
-	if err := db.Where("agent_id = ? AND environment_id = ? AND capability = ? AND revoked_at IS NULL", agent.ID, env.ID, models.AgentCapabilitySecretsInject).
-		Where("expires_at IS NULL OR expires_at > ?", now).Find(&grants).Error; err != nil {
+	grants, err := liveGrants(db, agent, env, models.AgentCapabilitySecretsInject, models.AgentCapabilityDatabaseCredentials)
+	if err != nil {
 		return nil, err
 	}
 	if len(grants) == 0 {
 		return nil, ErrAgentForbidden
 	}
-	access := &AgentAccess{Environment: env.ID, AllowedKeys: map[string]struct{}{}}
+	access := &AgentAccess{Environment: env.ID}
+	dynamicKeys := map[string]struct{}{}
 	for _, grant := range grants {
 		access.GrantIDs = append(access.GrantIDs, grant.ID)
-		if grant.AllowAllSecrets {
-			access.AllowAll = true
-		}
-		var keys []string
-		if err := json.Unmarshal(grant.AllowedKeys, &keys); err != nil {
-			return nil, fmt.Errorf("invalid stored grant policy: %w", err)
-		}
-		for _, key := range keys {
-			access.AllowedKeys[key] = struct{}{}
-		}
 		if grant.ExpiresAt != nil && (access.ExpiresAt == nil || grant.ExpiresAt.Before(*access.ExpiresAt)) {
 			expiry := *grant.ExpiresAt
 			access.ExpiresAt = &expiry
 		}
+		if grant.Capability == models.AgentCapabilityDatabaseCredentials {
+			// One role per credential key and lease, even if grants overlap.
+			if _, dup := dynamicKeys[grant.CredentialKey]; !dup {
+				dynamicKeys[grant.CredentialKey] = struct{}{}
+				access.DatabaseGrants = append(access.DatabaseGrants, grant)
+			}
+			continue
+		}
+		scope, err := grantKeyScope(&grant)
+		if err != nil {
+			return nil, err
+		}
+		access.Scopes = append(access.Scopes, scope)
+	}
+	// A database credential replaces any stored secret of the same name, so
+	// the stored one is never decrypted.
+	for i := range access.Scopes {
+		for key := range dynamicKeys {
+			access.Scopes[i].Deny = append(access.Scopes[i].Deny, key)
+		}
+	}
+	requestedKeys, err = normalizeKeys(requestedKeys)
+	if err != nil {
+		return nil, err
 	}
-	requestedKeys = normalizeKeys(requestedKeys)
 	if len(requestedKeys) > 0 {
+		requested := make(map[string]struct{}, len(requestedKeys))
+		static := make([]string, 0, len(requestedKeys))
 		for _, key := range requestedKeys {
-			if _, ok := access.AllowedKeys[key]; !ok && !access.AllowAll {
+			if isKeyPattern(key) {
+				return nil, fmt.Errorf("requested keys must be exact names, not patterns")
+			}
+			_, dynamic := dynamicKeys[key]
+			if !dynamic && !access.AllowsKey(key) {
 				return nil, ErrAgentForbidden
 			}
+			requested[key] = struct{}{}
+			if !dynamic {
+				static = append(static, key)
+			}
 		}
-		access.AllowedKeys = make(map[string]struct{}, len(requestedKeys))
-		for _, key := range requestedKeys {
-			access.AllowedKeys[key] = struct{}{}
+		access.Scopes = nil
+		if len(static) > 0 {
+			access.Scopes = []KeyScope{{Allow: static}}
+		}
+		selected := access.DatabaseGrants[:0]
+		for _, grant := range access.DatabaseGrants {
+			if _, ok := requested[grant.CredentialKey]; ok {
+				selected = append(selected, grant)
+			}
 		}
-		access.AllowAll = false
+		access.DatabaseGrants = selected
 	}
 	return access, nil
 }
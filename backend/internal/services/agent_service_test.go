@@ -2,10 +2,14 @@ package services
 
 import (
 	"crypto/sha256"
+	"database/sql/driver"
 	"encoding/hex"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/envo/backend/internal/models"
 	"github.com/google/uuid"
 )
 
@@ -54,9 +58,245 @@ func TestCredentialIDFromTokenRejectsMalformedValues(t *testing.T) {
 }
 
 func TestNormalizeKeysTrimsDeduplicatesAndSorts(t *testing.T) {
-	got := normalizeKeys([]string{" Z_KEY ", "A_KEY", "", "A_KEY"})
-	want := []string{"A_KEY", "Z_KEY"}
-	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+	got, err := normalizeKeys([]string{" Z_KEY ", "A_KEY", "", "A_KEY", "STRIPE_*"})
+	want := []string{"A_KEY", "STRIPE_*", "Z_KEY"}
+	if err != nil || len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
 		t.Fatalf("normalizeKeys() = %v, want %v", got, want)
 	}
 }
+
+func TestApprovedAccessDurationOnlyShortens(t *testing.T) {
+	requested := 2 * time.Hour
+	cases := map[time.Duration]time.Duration{
+		0:                requested,
+		30 * time.Minute: 30 * time.Minute,
+		5 * time.Hour:    requested,
+		time.Second:      time.Minute,
+	}
+	for override, want := range cases {
+		if got := approvedAccessDuration(requested, override); got != want {
+			t.Errorf("approvedAccessDuration(%s, %s) = %s, want %s", requested, override, got, want)
+		}
+	}
+}
+
+func TestNormalizeAccessRequestDuration(t *testing.T) {
+	if got, err := normalizeAccessRequestDuration(0); err != nil || got != DefaultAccessRequestDuration {
+		t.Fatalf("default duration = %s, %v", got, err)
+	}
+	for _, d := range []time.Duration{time.Second, MaxAccessRequestDuration + time.Minute} {
+		if _, err := normalizeAccessRequestDuration(d); !errors.Is(err, ErrInvalidAccessRequest) {
+			t.Errorf("normalizeAccessRequestDuration(%s) error = %v", d, err)
+		}
+	}
+}
+
+// agentGrantDB serves one project and environment to resolveSelector and
+// the given grants, as column name to value, to liveGrants.
+func agentGrantDB(t *testing.T, env *models.Environment, grants ...map[string]driver.Value) *fakeDB {
+	t.Helper()
+	db := useFakeDB(t)
+	db.on([]string{`FROM "projects"`}, []string{"id", "name"}, []driver.Value{env.ProjectID.String(), "api"})
+	db.on([]string{`FROM "environments"`}, []string{"id", "project_id", "name"}, []driver.Value{env.ID.String(), env.ProjectID.String(), env.Name})
+	columns := []string{"id", "environment_id", "capability", "allowed_keys", "denied_keys", "allow_all_secrets", "credential_key"}
+	var rows [][]driver.Value
+	for _, grant := range grants {
+		row := []driver.Value{uuid.New().String(), env.ID.String(), models.AgentCapabilitySecretsInject, "[]", "[]", false, ""}
+		for i, column := range columns {
+			if v, ok := grant[column]; ok {
+				row[i] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+	db.on([]string{`FROM "agent_grants"`}, columns, rows...)
+	return db
+}
+
+func TestAuthorizeResolveKeepsDatabaseCredentialsOutOfStaticScope(t *testing.T) {
+	env := &models.Environment{ID: uuid.New(), ProjectID: uuid.New(), Name: "production"}
+	agent := &models.AgentIdentity{ID: uuid.New(), OrgID: uuid.New()}
+	s := &AgentService{}
+	grants := []map[string]driver.Value{
+		{"allow_all_secrets": true},
+		{"capability": models.AgentCapabilityDatabaseCredentials, "credential_key": "DATABASE_URL"},
+	}
+
+	agentGrantDB(t, env, grants...)
+	access, err := s.AuthorizeResolve(t.Context(), agent, "api", "production", []string{"DATABASE_URL"})
+	if err != nil {
+		t.Fatalf("AuthorizeResolve() returned %v", err)
+	}
+	if access.AllowsKey("DATABASE_URL") || len(access.DatabaseGrants) != 1 {
+		t.Fatalf("requested credential key decrypted as a secret: scopes %+v", access.Scopes)
+	}
+
+	agentGrantDB(t, env, grants...)
+	access, err = s.AuthorizeResolve(t.Context(), agent, "api", "production", []string{"DATABASE_URL", "API_KEY"})
+	if err != nil {
+		t.Fatalf("AuthorizeResolve() returned %v", err)
+	}
+	if access.AllowsKey("DATABASE_URL") || !access.AllowsKey("API_KEY") {
+		t.Fatalf("scopes = %+v, want only API_KEY decrypted", access.Scopes)
+	}
+
+	agentGrantDB(t, env, grants...)
+	access, err = s.AuthorizeResolve(t.Context(), agent, "api", "production", nil)
+	if err != nil {
+		t.Fatalf("AuthorizeResolve() returned %v", err)
+	}
+	if access.AllowsKey("DATABASE_URL") || !access.AllowsKey("API_KEY") {
+		t.Fatalf("scopes = %+v, want every key but the credential key", access.Scopes)
+	}
+}
+
+func TestAuthorizeWriteChecksWriteGrants(t *testing.T) {
+	env := &models.Environment{ID: uuid.New(), ProjectID: uuid.New(), Name: "production"}
+	agent := &models.AgentIdentity{ID: uuid.New(), OrgID: uuid.New()}
+	s := &AgentService{}
+	stripe := map[string]driver.Value{"capability": models.AgentCapabilitySecretsWrite, "allowed_keys": `["STRIPE_*"]`, "denied_keys": `["STRIPE_LIVE_*"]`}
+
+	for _, tc := range []struct {
+		key  string
+		want error
+	}{
+		{"STRIPE_TEST_KEY", nil},
+		{"STRIPE_LIVE_KEY", ErrAgentForbidden},
+		{"DATABASE_URL", ErrAgentForbidden},
+	} {
+		agentGrantDB(t, env, stripe)
+		got, err := s.AuthorizeWrite(t.Context(), agent, "api", "production", tc.key)
+		if !errors.Is(err, tc.want) || (tc.want == nil && got.ID != env.ID) {
+			t.Errorf("AuthorizeWrite(%q) = %v, %v, want %v", tc.key, got, err, tc.want)
+		}
+	}
+
+	db := agentGrantDB(t, env)
+	if _, err := s.AuthorizeWrite(t.Context(), agent, "api", "production", "STRIPE_TEST_KEY"); !errors.Is(err, ErrAgentForbidden) {
+		t.Fatalf("AuthorizeWrite() without grants error = %v, want ErrAgentForbidden", err)
+	}
+	lookups := db.statements(`FROM "agent_grants"`)
+	if len(lookups) != 1 {
+		t.Fatalf("grant lookups = %v, want one", lookups)
+	}
+	for _, arg := range lookups[0].Args {
+		if arg == models.AgentCapabilitySecretsInject {
+			t.Fatalf("secrets.inject grants were considered for a write: %v", lookups[0].Args)
+		}
+	}
+
+	for _, key := range []string{"", "STRIPE_*", " PADDED ", "BAD?KEY"} {
+		db := agentGrantDB(t, env, map[string]driver.Value{"capability": models.AgentCapabilitySecretsWrite, "allow_all_secrets": true})
+		if _, err := s.AuthorizeWrite(t.Context(), agent, "api", "production", key); err == nil || errors.Is(err, ErrAgentForbidden) {
+			t.Errorf("AuthorizeWrite(%q) error = %v, want a validation error", key, err)
+		}
+		if reads := db.statements(); len(reads) != 0 {
+			t.Errorf("AuthorizeWrite(%q) queried grants for an invalid key", key)
+		}
+	}
+}
+
+func TestCreateGrantScopesWrites(t *testing.T) {
+	env := &models.Environment{ID: uuid.New(), ProjectID: uuid.New(), Name: "production"}
+	agent := &models.AgentIdentity{ID: uuid.New(), OrgID: uuid.New()}
+	s := &AgentService{}
+
+	db := useFakeDB(t)
+	db.on([]string{`FROM "agent_identities"`}, []string{"id", "org_id", "status"}, []driver.Value{agent.ID.String(), agent.OrgID.String(), models.AgentStatusActive})
+	db.on([]string{`FROM "environments"`}, []string{"id", "project_id", "name"}, []driver.Value{env.ID.String(), env.ProjectID.String(), env.Name})
+	grant, err := s.CreateGrant(t.Context(), uuid.New(), agent.OrgID, agent.ID, models.AgentCapabilitySecretsWrite, EnvironmentTarget(env.ID),
+		KeyScope{Allow: []string{" STRIPE_* ", "STRIPE_*"}, Deny: []string{"STRIPE_LIVE_*"}}, nil, "")
+	if err != nil {
+		t.Fatalf("CreateGrant() error = %v", err)
+	}
+	inserts := db.statements(`INSERT INTO "agent_grants"`)
+	if len(inserts) != 1 {
+		t.Fatalf("grant inserts = %v, want one", inserts)
+	}
+	if capability, _ := inserts[0].inserted("capability"); capability != models.AgentCapabilitySecretsWrite {
+		t.Fatalf("stored capability = %v, want %s", capability, models.AgentCapabilitySecretsWrite)
+	}
+
+	// The stored grant authorizes exactly the writes its scope covers.
+	stored := map[string]driver.Value{"capability": grant.Capability, "allowed_keys": string(grant.AllowedKeys), "denied_keys": string(grant.DeniedKeys)}
+	agentGrantDB(t, env, stored)
+	if _, err := s.AuthorizeWrite(t.Context(), agent, "api", "production", "STRIPE_TEST_KEY"); err != nil {
+		t.Fatalf("AuthorizeWrite() in scope error = %v", err)
+	}
+	agentGrantDB(t, env, stored)
+	if _, err := s.AuthorizeWrite(t.Context(), agent, "api", "production", "STRIPE_LIVE_KEY"); !errors.Is(err, ErrAgentForbidden) {
+		t.Fatalf("AuthorizeWrite() of a denied key error = %v, want ErrAgentForbidden", err)
+	}
+
+	if _, err := s.CreateGrant(t.Context(), uuid.New(), agent.OrgID, agent.ID, "secrets.delete", EnvironmentTarget(env.ID), KeyScope{All: true}, nil, ""); err == nil || !strings.Contains(err.Error(), "unknown capability") {
+		t.Fatalf("CreateGrant() error = %v, want unknown capability", err)
+	}
+}
+
+func TestCreateDatabaseGrantRequiresReadAccessToAdminSecret(t *testing.T) {
+	env := &models.Environment{ID: uuid.New(), ProjectID: uuid.New(), Name: "production"}
+	agent := &models.AgentIdentity{ID: uuid.New(), OrgID: uuid.New()}
+	s := &AgentService{}
+	in := DatabaseGrantInput{AdminSecretID: uuid.New(), CredentialKey: "DATABASE_URL", GrantTemplate: "GRANT app_read TO {{role}}"}
+	grantDB := func(t *testing.T, readable int64) *fakeDB {
+		db := useFakeDB(t)
+		db.on([]string{`FROM "agent_identities"`}, []string{"id", "org_id", "status"}, []driver.Value{agent.ID.String(), agent.OrgID.String(), models.AgentStatusActive})
+		db.on([]string{`FROM "environments"`}, []string{"id", "project_id", "name"}, []driver.Value{env.ID.String(), env.ProjectID.String(), env.Name})
+		db.on([]string{`FROM "secrets"`}, []string{"count"}, []driver.Value{int64(1)})
+		db.on([]string{`FROM "org_members"`, "permissions.name"}, []string{"count"}, []driver.Value{readable})
+		return db
+	}
+
+	// The admin connection lives in the org, but the caller's role cannot
+	// read secrets, so it cannot hand the connection to an agent either.
+	db := grantDB(t, 0)
+	if _, err := s.CreateDatabaseGrant(t.Context(), uuid.New(), agent.OrgID, agent.ID, EnvironmentTarget(env.ID), in, nil, ""); !errors.Is(err, ErrSecretReadForbidden) {
+		t.Fatalf("CreateDatabaseGrant() error = %v, want ErrSecretReadForbidden", err)
+	}
+	if inserts := db.statements(`INSERT INTO "agent_grants"`); len(inserts) != 0 {
+		t.Fatalf("denied grant was stored: %v", inserts)
+	}
+
+	db = grantDB(t, 1)
+	if _, err := s.CreateDatabaseGrant(t.Context(), uuid.New(), agent.OrgID, agent.ID, EnvironmentTarget(env.ID), in, nil, ""); err != nil {
+		t.Fatalf("CreateDatabaseGrant() error = %v", err)
+	}
+	if inserts := db.statements(`INSERT INTO "agent_grants"`); len(inserts) != 1 {
+		t.Fatalf("grant inserts = %v, want one", inserts)
+	}
+}
+
+func TestEnvironmentKeysListsPolicyDeniedKeys(t *testing.T) {
+	env := &models.Environment{ID: uuid.New(), ProjectID: uuid.New(), Name: "production"}
+	agent := &models.AgentIdentity{ID: uuid.New(), OrgID: uuid.New()}
+	s := &AgentService{policies: NewPolicyService(nil)}
+	keysDB := func(t *testing.T) *fakeDB {
+		db := agentGrantDB(t, env, map[string]driver.Value{"allow_all_secrets": true})
+		db.on([]string{`FROM "secrets"`}, []string{"key", "tags", "sensitive", "type_hint"},
+			[]driver.Value{"API_KEY", `[]`, false, "string"},
+			[]driver.Value{"PROD_DB_URL", `["prod"]`, true, "string"})
+		db.on([]string{`FROM "access_policies"`}, []string{"id", "org_id", "name", "actions", "condition", "mode", "enabled"},
+			[]driver.Value{uuid.NewString(), agent.OrgID.String(), "protect prod", `["secrets.resolve"]`, `"prod" in secret.tags`, models.PolicyModeEnforce, true})
+		return db
+	}
+
+	keysDB(t)
+	keys, err := s.EnvironmentKeys(t.Context(), agent, "api", "production", nil, "")
+	if err != nil {
+		t.Fatalf("EnvironmentKeys() error = %v", err)
+	}
+	if strings.Join(keys.Keys, ",") != "API_KEY" || strings.Join(keys.DeniedKeys, ",") != "PROD_DB_URL" {
+		t.Fatalf("keys = %v, denied = %v, want the tagged key moved to denied_keys", keys.Keys, keys.DeniedKeys)
+	}
+
+	// A key asked for by name is covered by the grant, so it is reported as
+	// denied rather than failing the check.
+	keysDB(t)
+	keys, err = s.EnvironmentKeys(t.Context(), agent, "api", "production", []string{"PROD_DB_URL"}, "")
+	if err != nil {
+		t.Fatalf("EnvironmentKeys() with a denied key error = %v", err)
+	}
+	if len(keys.Keys) != 0 || strings.Join(keys.DeniedKeys, ",") != "PROD_DB_URL" {
+		t.Fatalf("keys = %v, denied = %v", keys.Keys, keys.DeniedKeys)
+	}
+}
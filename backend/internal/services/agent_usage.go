@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// agentUsageBackfill is how far back the first rollup reaches into the
+// audit log. Tests shorten it.
+var agentUsageBackfill = 30 * 24 * time.Hour
+
+// Usage queries span at most maxAgentUsageRange, or maxHourlyAgentUsageRange
+// at hourly granularity.
+const (
+	maxAgentUsageRange       = 90 * 24 * time.Hour
+	maxHourlyAgentUsageRange = 14 * 24 * time.Hour
+	defaultAgentUsageRange   = 7 * 24 * time.Hour
+)
+
+// agentUsageRollupSQL rebuilds every hourly bucket from ? onwards out of the
+// agents' secret_read audit events. Buckets are overwritten rather than
+// incremented, so re-running it, or running it on several instances, gives
+// the same result.
+const agentUsageRollupSQL = `
+INSERT INTO agent_usage_rollups (org_id, agent_id, environment_id, purpose, bucket_start, resolves, secrets_delivered, updated_at)
+SELECT org_id, agent_id, resource_id, left(COALESCE(metadata->>'purpose', ''), 200), date_trunc('hour', created_at, 'UTC'),
+	count(*), COALESCE(sum((metadata->>'secret_count')::int), 0), now()
+FROM audit_logs
+WHERE action = ? AND actor_type = ? AND agent_id IS NOT NULL AND created_at >= ?
+GROUP BY 1, 2, 3, 4, 5
+ON CONFLICT (agent_id, environment_id, purpose, bucket_start) DO UPDATE
+SET resolves = EXCLUDED.resolves, secrets_delivered = EXCLUDED.secrets_delivered, updated_at = EXCLUDED.updated_at`
+
+// AgentUsageAggregator rolls agent resolves up into hourly usage buckets in
+// the background. Each run recomputes the latest two buckets, which covers
+// audit entries committed after the previous run, and catches up on any
+// buckets missed while no instance was running.
+type AgentUsageAggregator struct {
+	now func() time.Time
+}
+
+// NewAgentUsageAggregator creates an aggregator.
+func NewAgentUsageAggregator() *AgentUsageAggregator {
+	return &AgentUsageAggregator{now: time.Now}
+}
+
+// Run aggregates every interval until ctx is cancelled.
+func (a *AgentUsageAggregator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := a.RunOnce(ctx); err != nil {
+			log.Printf("[envo] agent usage rollup: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce rebuilds the buckets that may have changed and returns how many
+// were written.
+func (a *AgentUsageAggregator) RunOnce(ctx context.Context) (int64, error) {
+	db := database.GetDB().WithContext(ctx)
+	var latest []time.Time
+	if err := db.Model(&models.AgentUsageRollup{}).Order("bucket_start DESC").Limit(1).Pluck("bucket_start", &latest).Error; err != nil {
+		return 0, err
+	}
+	since := agentUsageRollupStart(latest, a.now().UTC())
+	result := db.Exec(agentUsageRollupSQL, models.ActionSecretRead, models.AuditActorAgent, since)
+	return result.RowsAffected, result.Error
+}
+
+// agentUsageRollupStart is the first bucket to rebuild: the one before the
+// latest bucket written, or the start of the backfill on the first run.
+func agentUsageRollupStart(latest []time.Time, now time.Time) time.Time {
+	if len(latest) == 0 {
+		return now.Add(-agentUsageBackfill).Truncate(time.Hour)
+	}
+	return latest[0].UTC().Truncate(time.Hour).Add(-time.Hour)
+}
+
+// AgentUsageQuery selects usage buckets. Granularity is "hour" or "day";
+// zero From and To select the last week.
+type AgentUsageQuery struct {
+	AgentID       *uuid.UUID
+	EnvironmentID *uuid.UUID
+	Purpose       *string
+	From          time.Time
+	To            time.Time
+	Granularity   string
+}
+
+// AgentUsagePoint is the resolves by one agent of one environment for one
+// purpose in one bucket.
+type AgentUsagePoint struct {
+	BucketStart      time.Time `json:"bucket_start"`
+	AgentID          uuid.UUID `json:"agent_id"`
+	AgentName        string    `json:"agent_name"`
+	EnvironmentID    uuid.UUID `json:"environment_id"`
+	Environment      string    `json:"environment"`
+	Project          string    `json:"project"`
+	Purpose          string    `json:"purpose"`
+	Resolves         int       `json:"resolves"`
+	SecretsDelivered int       `json:"secrets_delivered"`
+}
+
+// normalize fills in defaults and checks the range.
+func (q *AgentUsageQuery) normalize(now time.Time) error {
+	switch q.Granularity {
+	case "":
+		q.Granularity = "day"
+	case "hour", "day":
+	default:
+		return fmt.Errorf("granularity must be hour or day")
+	}
+	if q.To.IsZero() {
+		q.To = now
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-defaultAgentUsageRange)
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	maxRange := maxAgentUsageRange
+	if q.Granularity == "hour" {
+		maxRange = maxHourlyAgentUsageRange
+	}
+	if q.To.Sub(q.From) > maxRange {
+		return fmt.Errorf("%s usage can cover at most %d days", q.Granularity, int(maxRange/(24*time.Hour)))
+	}
+	return nil
+}
+
+// AgentUsage returns the org's resolves over time per agent, environment and
+// purpose, oldest bucket first. Buckets are UTC and lag the audit log by up
+// to the rollup interval.
+func (s *AgentService) AgentUsage(ctx context.Context, orgID uuid.UUID, q AgentUsageQuery) ([]AgentUsagePoint, error) {
+	if err := q.normalize(time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	query := database.GetDB().WithContext(ctx).Table("agent_usage_rollups AS r").
+		Select(fmt.Sprintf("date_trunc('%s', r.bucket_start, 'UTC') AS bucket_start, r.agent_id, COALESCE(a.name, '') AS agent_name, r.environment_id, COALESCE(e.name, '') AS environment, COALESCE(p.name, '') AS project, r.purpose, SUM(r.resolves) AS resolves, SUM(r.secrets_delivered) AS secrets_delivered", q.Granularity)).
+		Joins("LEFT JOIN agent_identities a ON a.id = r.agent_id").
+		Joins("LEFT JOIN environments e ON e.id = r.environment_id").
+		Joins("LEFT JOIN projects p ON p.id = e.project_id").
+		Where("r.org_id = ? AND r.bucket_start >= ? AND r.bucket_start < ?", orgID, q.From, q.To)
+	if q.AgentID != nil {
+		query = query.Where("r.agent_id = ?", *q.AgentID)
+	}
+	if q.EnvironmentID != nil {
+		query = query.Where("r.environment_id = ?", *q.EnvironmentID)
+	}
+	if q.Purpose != nil {
+		query = query.Where("r.purpose = ?", *q.Purpose)
+	}
+	points := []AgentUsagePoint{}
+	err := query.Group("1, 2, 3, 4, 5, 6, 7").Order("1 ASC, 3 ASC, 6 ASC, 5 ASC, 7 ASC").Scan(&points).Error
+	return points, err
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAgentUsageRollupStart(t *testing.T) {
+	now := time.Date(2026, 3, 10, 14, 25, 0, 0, time.UTC)
+	if got, want := agentUsageRollupStart(nil, now), now.Add(-agentUsageBackfill).Truncate(time.Hour); !got.Equal(want) {
+		t.Fatalf("first run starts at %v, want %v", got, want)
+	}
+	latest := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+	if got, want := agentUsageRollupStart([]time.Time{latest}, now), latest.Add(-time.Hour); !got.Equal(want) {
+		t.Fatalf("later run starts at %v, want %v", got, want)
+	}
+}
+
+func TestAgentUsageQueryNormalize(t *testing.T) {
+	now := time.Date(2026, 3, 10, 14, 25, 0, 0, time.UTC)
+
+	q := AgentUsageQuery{}
+	if err := q.normalize(now); err != nil {
+		t.Fatalf("normalize() returned %v", err)
+	}
+	if q.Granularity != "day" || !q.To.Equal(now) || !q.From.Equal(now.Add(-defaultAgentUsageRange)) {
+		t.Fatalf("defaults = %+v", q)
+	}
+
+	for name, q := range map[string]AgentUsageQuery{
+		"unknown granularity": {Granularity: "minute"},
+		"reversed range":      {From: now, To: now.Add(-time.Hour)},
+		"hourly too long":     {Granularity: "hour", From: now.Add(-30 * 24 * time.Hour), To: now},
+		"daily too long":      {From: now.Add(-100 * 24 * time.Hour), To: now},
+	} {
+		if err := q.normalize(now); err == nil {
+			t.Errorf("%s: normalize() accepted %+v", name, q)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Audit chain problem kinds reported by verification.
+const (
+	// ChainProblemGap is a run of missing sequence numbers: deleted entries.
+	ChainProblemGap = "gap"
+	// ChainProblemModified is an entry whose contents no longer match its hash.
+	ChainProblemModified = "modified"
+	// ChainProblemBrokenLink is an entry whose previous hash does not match
+	// the entry before it.
+	ChainProblemBrokenLink = "broken_link"
+	// ChainProblemTruncated means entries after the last one are missing.
+	ChainProblemTruncated = "truncated"
+	// ChainProblemHeadMismatch is a chain head that disagrees with the last entry.
+	ChainProblemHeadMismatch = "head_mismatch"
+	// ChainProblemCheckpointMismatch is a signed checkpoint whose hash does
+	// not match the entry it covers: the chain was rewritten after it.
+	ChainProblemCheckpointMismatch = "checkpoint_mismatch"
+	// ChainProblemBadSignature is a checkpoint whose signature is invalid.
+	ChainProblemBadSignature = "bad_signature"
+	// ChainProblemUnknownKey is a checkpoint signed by a key other than the
+	// server's.
+	ChainProblemUnknownKey = "unknown_key"
+	// ChainProblemUnsignedPrune is a pruned marker on the chain head that
+	// the retention job did not sign: entries were deleted outside it.
+	ChainProblemUnsignedPrune = "unsigned_prune"
+)
+
+// maxChainProblems bounds a verification report.
+const maxChainProblems = 100
+
+// AuditEntryHash is the hash chaining an entry: SHA-256 over a JSON array of
+// the chain version, organization, sequence, previous hash, and every stored
+// field of the entry, with metadata in canonical form.
+func AuditEntryHash(e *models.AuditLog) (string, error) {
+	metadata, err := canonicalJSON(e.Metadata)
+	if err != nil {
+		return "", err
+	}
+	optional := func(id *uuid.UUID) string {
+		if id == nil {
+			return ""
+		}
+		return id.String()
+	}
+	fields, err := json.Marshal([]string{
+		"v1",
+		e.OrgID.String(),
+		strconv.FormatInt(e.Sequence, 10),
+		e.PrevHash,
+		e.ID.String(),
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.ActorType,
+		optional(e.UserID),
+		optional(e.AgentID),
+		e.Action,
+		e.ResourceType,
+		e.ResourceID.String(),
+		e.IPAddress,
+		metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalJSON re-encodes a JSON document so that what was written and
+// what jsonb returns hash the same: object keys sorted, no insignificant
+// whitespace, and numbers in one notation. Empty and null become "".
+func canonicalJSON(raw []byte) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("invalid audit metadata: %w", err)
+	}
+	v, err := canonicalNumbers(v)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(buf.Bytes(), "\n")), nil
+}
+
+func canonicalNumbers(v any) (any, error) {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := strconv.ParseFloat(t.String(), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid audit metadata number %q", t)
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case map[string]any:
+		for k, item := range t {
+			canonical, err := canonicalNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = canonical
+		}
+	case []any:
+		for i, item := range t {
+			canonical, err := canonicalNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = canonical
+		}
+	}
+	return v, nil
+}
+
+// appendEntry chains entry onto its organization's audit chain and writes
+// it. The chain head row is locked for the append, so concurrent writers,
+// on any instance, take turns.
+func (s *AuditService) appendEntry(ctx context.Context, entry *models.AuditLog) error {
+	return database.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.AuditChainHead{OrgID: entry.OrgID}).Error; err != nil {
+			return err
+		}
+		var head models.AuditChainHead
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("org_id = ?", entry.OrgID).First(&head).Error; err != nil {
+			return err
+		}
+		if entry.ID == uuid.Nil {
+			entry.ID = uuid.New()
+		}
+		// Postgres keeps microseconds; hash what will be read back.
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.Sequence = head.Sequence + 1
+		entry.PrevHash = head.Hash
+		hash, err := AuditEntryHash(entry)
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		return tx.Model(&head).Updates(map[string]any{"sequence": entry.Sequence, "hash": entry.Hash}).Error
+	})
+}
+
+// AuditChainService signs periodic checkpoints of each organization's
+// audit chain and verifies chains against them.
+type AuditChainService struct {
+	key   ed25519.PrivateKey
+	keyID string
+	now   func() time.Time
+}
+
+// NewAuditChainService creates the checkpoint signer. signingKey is a
+// base64 Ed25519 seed; when empty the key is derived from fallbackSecret.
+func NewAuditChainService(signingKey, fallbackSecret string) (*AuditChainService, error) {
+	var seed []byte
+	if signingKey != "" {
+		decoded, err := base64.StdEncoding.DecodeString(signingKey)
+		if err != nil || len(decoded) != ed25519.SeedSize {
+			return nil, errors.New("audit signing key must be a base64 32-byte Ed25519 seed")
+		}
+		seed = decoded
+	} else {
+		sum := sha256.Sum256([]byte("envo-audit-checkpoint:" + fallbackSecret))
+		seed = sum[:]
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	return &AuditChainService{key: key, keyID: auditKeyID(key.Public().(ed25519.PublicKey)), now: time.Now}, nil
+}
+
+// auditKeyID names a checkpoint key by the start of its public key's hash.
+func auditKeyID(public ed25519.PublicKey) string {
+	sum := sha256.Sum256(public)
+	return hex.EncodeToString(sum[:8])
+}
+
+// PublicKey is the base64 public half of the checkpoint key.
+func (s *AuditChainService) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+func checkpointMessage(orgID uuid.UUID, sequence int64, hash string, at time.Time) []byte {
+	return fmt.Appendf(nil, "envo-audit-checkpoint/v1\n%s\n%d\n%s\n%s", orgID, sequence, hash, at.UTC().Format(time.RFC3339Nano))
+}
+
+func (s *AuditChainService) sign(orgID uuid.UUID, sequence int64, hash string) models.AuditCheckpoint {
+	at := s.now().UTC().Truncate(time.Microsecond)
+	return models.AuditCheckpoint{
+		ID:        uuid.New(),
+		OrgID:     orgID,
+		Sequence:  sequence,
+		Hash:      hash,
+		KeyID:     s.keyID,
+		PublicKey: s.PublicKey(),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, checkpointMessage(orgID, sequence, hash, at))),
+		CreatedAt: at,
+	}
+}
+
+func pruneMessage(orgID uuid.UUID, sequence int64, hash string, at time.Time) []byte {
+	return fmt.Appendf(nil, "envo-audit-prune/v1\n%s\n%d\n%s\n%s", orgID, sequence, hash, at.UTC().Format(time.RFC3339Nano))
+}
+
+// signPrune returns the chain head columns recording that retention removed
+// every entry up to sequence, whose hash is hash.
+func (s *AuditChainService) signPrune(orgID uuid.UUID, sequence int64, hash string) map[string]any {
+	at := s.now().UTC().Truncate(time.Microsecond)
+	return map[string]any{
+		"pruned_sequence":   sequence,
+		"pruned_hash":       hash,
+		"pruned_at":         at,
+		"pruned_public_key": s.PublicKey(),
+		"pruned_signature":  base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, pruneMessage(orgID, sequence, hash, at))),
+	}
+}
+
+// Run signs checkpoints every interval until ctx is cancelled.
+func (s *AuditChainService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if signed, err := s.RunOnce(ctx); err != nil {
+			log.Printf("[envo] audit checkpoints: %v", err)
+		} else if signed > 0 {
+			log.Printf("[envo] audit checkpoints: %d signed", signed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce signs a checkpoint of every chain that grew since its last one.
+func (s *AuditChainService) RunOnce(ctx context.Context) (int, error) {
+	db := database.GetDB().WithContext(ctx)
+	var heads []models.AuditChainHead
+	if err := db.Where(`sequence > COALESCE((SELECT MAX(c.sequence) FROM audit_checkpoints c WHERE c.org_id = audit_chain_heads.org_id), 0)`).
+		Find(&heads).Error; err != nil {
+		return 0, err
+	}
+	signed := 0
+	for _, head := range heads {
+		checkpoint := s.sign(head.OrgID, head.Sequence, head.Hash)
+		created := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&checkpoint)
+		if created.Error != nil {
+			return signed, created.Error
+		}
+		signed += int(created.RowsAffected)
+	}
+	return signed, nil
+}
+
+// AuditChainProblem is one defect found by verification.
+type AuditChainProblem struct {
+	Sequence int64  `json:"sequence"`
+	Kind     string `json:"kind"`
+	Detail   string `json:"detail"`
+}
+
+// AuditChainReport is the result of verifying an organization's audit chain.
+type AuditChainReport struct {
+	Valid            bool                    `json:"valid"`
+	Entries          int64                   `json:"entries"`
+	UnchainedEntries int64                   `json:"unchained_entries"`
+	FirstSequence    int64                   `json:"first_sequence"`
+	LastSequence     int64                   `json:"last_sequence"`
+	HeadSequence     int64                   `json:"head_sequence"`
+	PrunedSequence   int64                   `json:"pruned_sequence"`
+	Checkpoints      int                     `json:"checkpoints"`
+	LastCheckpoint   *models.AuditCheckpoint `json:"last_checkpoint,omitempty"`
+	KeyID            string                  `json:"key_id"`
+	PublicKey        string                  `json:"public_key"`
+	Problems         []AuditChainProblem     `json:"problems,omitempty"`
+	ProblemsOmitted  int                     `json:"problems_omitted,omitempty"`
+}
+
+// chainVerifier checks entries fed to it in sequence order, starting after
+// the last entry retention pruned.
+type chainVerifier struct {
+	report   *AuditChainReport
+	pruned   int64
+	expected int64
+	prevHash string
+	wanted   map[int64]bool
+	hashes   map[int64]string
+}
+
+func newChainVerifier(report *AuditChainReport, head models.AuditChainHead, checkpoints []models.AuditCheckpoint) *chainVerifier {
+	v := &chainVerifier{
+		report:   report,
+		pruned:   head.PrunedSequence,
+		expected: head.PrunedSequence + 1,
+		prevHash: head.PrunedHash,
+		wanted:   map[int64]bool{},
+		hashes:   map[int64]string{},
+	}
+	for _, c := range checkpoints {
+		v.wanted[c.Sequence] = true
+	}
+	if head.PrunedSequence > 0 {
+		v.hashes[head.PrunedSequence] = head.PrunedHash
+	}
+	return v
+}
+
+func (v *chainVerifier) problem(sequence int64, kind, format string, args ...any) {
+	if len(v.report.Problems) >= maxChainProblems {
+		v.report.ProblemsOmitted++
+		return
+	}
+	v.report.Problems = append(v.report.Problems, AuditChainProblem{Sequence: sequence, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (v *chainVerifier) add(e *models.AuditLog) {
+	r := v.report
+	if r.Entries == 0 {
+		r.FirstSequence = e.Sequence
+	}
+	r.Entries++
+	r.LastSequence = e.Sequence
+
+	switch {
+	case e.Sequence > v.expected:
+		if e.Sequence == v.expected+1 {
+			v.problem(v.expected, ChainProblemGap, "entry %d is missing", v.expected)
+		} else {
+			v.problem(v.expected, ChainProblemGap, "entries %d to %d are missing", v.expected, e.Sequence-1)
+		}
+	case e.PrevHash != v.prevHash:
+		v.problem(e.Sequence, ChainProblemBrokenLink, "previous hash does not match entry %d", e.Sequence-1)
+	}
+	if hash, err := AuditEntryHash(e); err != nil || hash != e.Hash {
+		v.problem(e.Sequence, ChainProblemModified, "entry %s does not match its hash", e.ID)
+	}
+	if v.wanted[e.Sequence] {
+		v.hashes[e.Sequence] = e.Hash
+	}
+	// Continue from the stored hash so one edited entry is reported once
+	// rather than breaking every link after it.
+	v.prevHash = e.Hash
+	v.expected = e.Sequence + 1
+}
+
+func (v *chainVerifier) finish(head models.AuditChainHead, checkpoints []models.AuditCheckpoint, publicKey string) {
+	r := v.report
+	r.HeadSequence = head.Sequence
+	r.PrunedSequence = head.PrunedSequence
+	last := v.expected - 1
+	switch {
+	case last < head.Sequence:
+		v.problem(last+1, ChainProblemTruncated, "entries %d to %d are missing", last+1, head.Sequence)
+	case last > head.Sequence:
+		v.problem(head.Sequence, ChainProblemHeadMismatch, "chain head is at %d but entries run to %d", head.Sequence, last)
+	case v.prevHash != head.Hash:
+		v.problem(head.Sequence, ChainProblemHeadMismatch, "chain head hash does not match entry %d", head.Sequence)
+	}
+
+	if head.PrunedSequence > 0 {
+		public, err := base64.StdEncoding.DecodeString(head.PrunedPublicKey)
+		signature, sigErr := base64.StdEncoding.DecodeString(head.PrunedSignature)
+		switch {
+		case head.PrunedAt == nil || err != nil || len(public) != ed25519.PublicKeySize || sigErr != nil ||
+			!ed25519.Verify(public, pruneMessage(head.OrgID, head.PrunedSequence, head.PrunedHash, *head.PrunedAt), signature):
+			v.problem(head.PrunedSequence, ChainProblemUnsignedPrune, "entries up to %d were removed without a signed retention record", head.PrunedSequence)
+		case head.PrunedPublicKey != publicKey:
+			v.problem(head.PrunedSequence, ChainProblemUnknownKey, "retention record for entry %d is signed by another key", head.PrunedSequence)
+		}
+	}
+
+	for i := range checkpoints {
+		c := &checkpoints[i]
+		r.Checkpoints++
+		r.LastCheckpoint = c
+		public, err := base64.StdEncoding.DecodeString(c.PublicKey)
+		signature, sigErr := base64.StdEncoding.DecodeString(c.Signature)
+		switch {
+		case err != nil || len(public) != ed25519.PublicKeySize || sigErr != nil ||
+			!ed25519.Verify(public, checkpointMessage(c.OrgID, c.Sequence, c.Hash, c.CreatedAt), signature):
+			v.problem(c.Sequence, ChainProblemBadSignature, "checkpoint %s has an invalid signature", c.ID)
+			continue
+		case c.PublicKey != publicKey:
+			v.problem(c.Sequence, ChainProblemUnknownKey, "checkpoint %s is signed by key %s, not this server's", c.ID, c.KeyID)
+		}
+		if c.Sequence < v.pruned {
+			// The entry was removed by retention; only the signature is left to check.
+			continue
+		}
+		hash, ok := v.hashes[c.Sequence]
+		switch {
+		case !ok:
+			v.problem(c.Sequence, ChainProblemCheckpointMismatch, "checkpoint %s covers entry %d, which is missing", c.ID, c.Sequence)
+		case hash != c.Hash:
+			v.problem(c.Sequence, ChainProblemCheckpointMismatch, "checkpoint %s does not match entry %d; the chain was rewritten", c.ID, c.Sequence)
+		}
+	}
+	r.Valid = len(r.Problems) == 0 && r.ProblemsOmitted == 0
+}
+
+// Verify walks an organization's audit chain, streaming entries in
+// sequence order, and checks every link, every entry's hash, the chain
+// head, and every signed checkpoint.
+func (s *AuditChainService) Verify(ctx context.Context, orgID uuid.UUID) (*AuditChainReport, error) {
+	db := database.GetDB().WithContext(ctx)
+	report := &AuditChainReport{KeyID: s.keyID, PublicKey: s.PublicKey()}
+
+	var head models.AuditChainHead
+	if err := db.Where("org_id = ?", orgID).Limit(1).Find(&head).Error; err != nil {
+		return nil, err
+	}
+	var checkpoints []models.AuditCheckpoint
+	if err := db.Where("org_id = ?", orgID).Order("sequence ASC").Find(&checkpoints).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.AuditLog{}).Where("org_id = ? AND sequence = 0", orgID).Count(&report.UnchainedEntries).Error; err != nil {
+		return nil, err
+	}
+
+	verifier := newChainVerifier(report, head, checkpoints)
+	rows, err := db.Model(&models.AuditLog{}).Where("org_id = ? AND sequence > 0", orgID).Order("sequence ASC").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry models.AuditLog
+		if err := db.ScanRows(rows, &entry); err != nil {
+			return nil, err
+		}
+		verifier.add(&entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	verifier.finish(head, checkpoints, report.PublicKey)
+	return report, nil
+}
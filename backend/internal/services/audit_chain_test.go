@@ -0,0 +1,271 @@
+package services
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+var testChainOrg = uuid.MustParse("5d8a4f2e-1c3b-4e6a-8f9d-2b7c6a5e4d3c")
+
+// testChain builds n correctly chained entries.
+func testChain(t *testing.T, n int) []*models.AuditLog {
+	t.Helper()
+	var entries []*models.AuditLog
+	prev := ""
+	userID := uuid.MustParse("0b6f2c1e-8d2a-4c55-9a31-0f3d8d1e2a7b")
+	for i := 1; i <= n; i++ {
+		e := &models.AuditLog{
+			ID:           uuid.New(),
+			UserID:       &userID,
+			ActorType:    models.AuditActorHuman,
+			OrgID:        testChainOrg,
+			Action:       models.ActionSecretRead,
+			ResourceType: "secret",
+			ResourceID:   uuid.New(),
+			Metadata:     datatypes.JSON(`{"key":"API_KEY","count":2}`),
+			IPAddress:    "10.0.0.1",
+			CreatedAt:    time.Date(2026, 3, 2, 10, 4, i, 123456000, time.UTC),
+			Sequence:     int64(i),
+			PrevHash:     prev,
+		}
+		hash, err := AuditEntryHash(e)
+		if err != nil {
+			t.Fatalf("AuditEntryHash() returned %v", err)
+		}
+		e.Hash = hash
+		prev = hash
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func verifyTestChain(entries []*models.AuditLog, head models.AuditChainHead, checkpoints []models.AuditCheckpoint, publicKey string) *AuditChainReport {
+	report := &AuditChainReport{}
+	v := newChainVerifier(report, head, checkpoints)
+	for _, e := range entries {
+		v.add(e)
+	}
+	v.finish(head, checkpoints, publicKey)
+	return report
+}
+
+func chainHead(entries []*models.AuditLog) models.AuditChainHead {
+	last := entries[len(entries)-1]
+	return models.AuditChainHead{OrgID: testChainOrg, Sequence: last.Sequence, Hash: last.Hash}
+}
+
+// pruneHead records a signed pruned marker at entry on head.
+func pruneHead(signer *AuditChainService, head *models.AuditChainHead, entry *models.AuditLog) {
+	columns := signer.signPrune(head.OrgID, entry.Sequence, entry.Hash)
+	at := columns["pruned_at"].(time.Time)
+	head.PrunedSequence, head.PrunedHash, head.PrunedAt = entry.Sequence, entry.Hash, &at
+	head.PrunedPublicKey = columns["pruned_public_key"].(string)
+	head.PrunedSignature = columns["pruned_signature"].(string)
+}
+
+func problemKinds(r *AuditChainReport) []string {
+	var kinds []string
+	for _, p := range r.Problems {
+		kinds = append(kinds, p.Kind)
+	}
+	return kinds
+}
+
+func TestCanonicalJSONMatchesJSONBRoundTrip(t *testing.T) {
+	written, err := canonicalJSON([]byte(`{"b": 1.0, "a": {"y": "<x>", "x": [1e2, 3]}}`))
+	if err != nil {
+		t.Fatalf("canonicalJSON() returned %v", err)
+	}
+	// jsonb sorts keys by length then bytes and rewrites numbers.
+	read, err := canonicalJSON([]byte(`{"a": {"x": [100, 3], "y": "<x>"}, "b": 1}`))
+	if err != nil {
+		t.Fatalf("canonicalJSON() returned %v", err)
+	}
+	if written != read {
+		t.Fatalf("canonicalJSON() = %s and %s, want equal", written, read)
+	}
+	if want := `{"a":{"x":[100,3],"y":"<x>"},"b":1}`; written != want {
+		t.Fatalf("canonicalJSON() = %s, want %s", written, want)
+	}
+	for _, empty := range []string{"", "null", "  "} {
+		if got, err := canonicalJSON([]byte(empty)); err != nil || got != "" {
+			t.Fatalf("canonicalJSON(%q) = %q, %v", empty, got, err)
+		}
+	}
+}
+
+func TestAuditEntryHashCoversEveryField(t *testing.T) {
+	base := testChain(t, 1)[0]
+	agentID := uuid.New()
+	mutations := map[string]func(e *models.AuditLog){
+		"action":     func(e *models.AuditLog) { e.Action = models.ActionSecretUpdate },
+		"metadata":   func(e *models.AuditLog) { e.Metadata = datatypes.JSON(`{"key":"OTHER"}`) },
+		"ip":         func(e *models.AuditLog) { e.IPAddress = "10.0.0.2" },
+		"created_at": func(e *models.AuditLog) { e.CreatedAt = e.CreatedAt.Add(time.Microsecond) },
+		"actor":      func(e *models.AuditLog) { e.UserID, e.AgentID = nil, &agentID },
+		"sequence":   func(e *models.AuditLog) { e.Sequence++ },
+		"prev_hash":  func(e *models.AuditLog) { e.PrevHash = "00" },
+		"org":        func(e *models.AuditLog) { e.OrgID = uuid.New() },
+	}
+	for name, mutate := range mutations {
+		e := *base
+		mutate(&e)
+		hash, err := AuditEntryHash(&e)
+		if err != nil {
+			t.Fatalf("%s: AuditEntryHash() returned %v", name, err)
+		}
+		if hash == base.Hash {
+			t.Fatalf("%s: hash did not change", name)
+		}
+	}
+
+	// A different time zone for the same instant hashes the same.
+	e := *base
+	e.CreatedAt = e.CreatedAt.In(time.FixedZone("CET", 3600))
+	if hash, _ := AuditEntryHash(&e); hash != base.Hash {
+		t.Fatal("hash depends on the time zone of created_at")
+	}
+}
+
+func TestChainVerifierAcceptsIntactChain(t *testing.T) {
+	entries := testChain(t, 5)
+	report := verifyTestChain(entries, chainHead(entries), nil, "")
+	if !report.Valid || report.Entries != 5 || report.FirstSequence != 1 || report.LastSequence != 5 {
+		t.Fatalf("report = %+v, want a valid chain of 5", report)
+	}
+}
+
+func TestChainVerifierFindsTampering(t *testing.T) {
+	t.Run("modified", func(t *testing.T) {
+		entries := testChain(t, 5)
+		entries[2].Metadata = datatypes.JSON(`{"key":"SOMETHING_ELSE"}`)
+		report := verifyTestChain(entries, chainHead(entries), nil, "")
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemModified || report.Problems[0].Sequence != 3 {
+			t.Fatalf("problems = %+v, want entry 3 modified", report.Problems)
+		}
+	})
+	t.Run("deleted", func(t *testing.T) {
+		entries := testChain(t, 5)
+		head := chainHead(entries)
+		entries = append(entries[:1], entries[3:]...)
+		report := verifyTestChain(entries, head, nil, "")
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemGap || report.Problems[0].Sequence != 2 {
+			t.Fatalf("problems = %+v, want a gap at 2", report.Problems)
+		}
+	})
+	t.Run("rehashed", func(t *testing.T) {
+		// An edit whose hash was recomputed still breaks the next link.
+		entries := testChain(t, 5)
+		entries[2].IPAddress = "192.0.2.1"
+		entries[2].Hash, _ = AuditEntryHash(entries[2])
+		report := verifyTestChain(entries, chainHead(entries), nil, "")
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemBrokenLink || report.Problems[0].Sequence != 4 {
+			t.Fatalf("problems = %+v, want a broken link at 4", report.Problems)
+		}
+	})
+	t.Run("truncated", func(t *testing.T) {
+		entries := testChain(t, 5)
+		head := chainHead(entries)
+		report := verifyTestChain(entries[:3], head, nil, "")
+		if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemTruncated {
+			t.Fatalf("problems = %+v, want truncation", report.Problems)
+		}
+	})
+}
+
+func TestChainVerifierStartsAfterPrunedEntries(t *testing.T) {
+	signer, err := NewAuditChainService("", "secret")
+	if err != nil {
+		t.Fatalf("NewAuditChainService() returned %v", err)
+	}
+	entries := testChain(t, 6)
+	checkpoints := []models.AuditCheckpoint{
+		signer.sign(testChainOrg, 2, entries[1].Hash),
+		signer.sign(testChainOrg, 3, entries[2].Hash),
+		signer.sign(testChainOrg, 5, entries[4].Hash),
+	}
+	head := chainHead(entries)
+	pruneHead(signer, &head, entries[2])
+
+	report := verifyTestChain(entries[3:], head, checkpoints, signer.PublicKey())
+	if !report.Valid || report.FirstSequence != 4 || report.PrunedSequence != 3 || report.Checkpoints != 3 {
+		t.Fatalf("report = %+v, want a valid chain from 4", report)
+	}
+
+	// Deleting past the pruned point is still a gap.
+	report = verifyTestChain(entries[4:], head, checkpoints, signer.PublicKey())
+	if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemGap || report.Problems[0].Sequence != 4 {
+		t.Fatalf("problems = %+v, want a gap at 4", report.Problems)
+	}
+
+	// Moving the marker forward to hide deleted entries breaks its signature.
+	moved := head
+	moved.PrunedSequence, moved.PrunedHash = 4, entries[3].Hash
+	report = verifyTestChain(entries[4:], moved, checkpoints, signer.PublicKey())
+	if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemUnsignedPrune || report.Problems[0].Sequence != 4 {
+		t.Fatalf("problems = %+v, want an unsigned prune at 4", report.Problems)
+	}
+
+	// So does a marker written without the retention job.
+	unsigned := chainHead(entries)
+	unsigned.PrunedSequence, unsigned.PrunedHash = 3, entries[2].Hash
+	report = verifyTestChain(entries[3:], unsigned, checkpoints, signer.PublicKey())
+	if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemUnsignedPrune {
+		t.Fatalf("problems = %+v, want an unsigned prune", report.Problems)
+	}
+
+	// Every entry pruned leaves a valid, empty chain.
+	pruneHead(signer, &head, entries[5])
+	if report := verifyTestChain(nil, head, nil, signer.PublicKey()); !report.Valid {
+		t.Fatalf("problems = %+v, want a fully pruned chain to verify", report.Problems)
+	}
+}
+
+func TestChainVerifierChecksSignedCheckpoints(t *testing.T) {
+	signer, err := NewAuditChainService(base64.StdEncoding.EncodeToString(make([]byte, 32)), "")
+	if err != nil {
+		t.Fatalf("NewAuditChainService() returned %v", err)
+	}
+	entries := testChain(t, 5)
+	checkpoint := signer.sign(testChainOrg, 3, entries[2].Hash)
+
+	report := verifyTestChain(entries, chainHead(entries), []models.AuditCheckpoint{checkpoint}, signer.PublicKey())
+	if !report.Valid || report.Checkpoints != 1 {
+		t.Fatalf("report = %+v, want a valid chain with one checkpoint", report)
+	}
+
+	// Rewriting the whole chain from entry 1 keeps every link intact but
+	// no longer matches what was signed.
+	rewritten := testChain(t, 5)
+	report = verifyTestChain(rewritten, chainHead(rewritten), []models.AuditCheckpoint{checkpoint}, signer.PublicKey())
+	if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemCheckpointMismatch {
+		t.Fatalf("problems = %+v, want a checkpoint mismatch", report.Problems)
+	}
+
+	forged := checkpoint
+	forged.Hash = rewritten[2].Hash
+	report = verifyTestChain(rewritten, chainHead(rewritten), []models.AuditCheckpoint{forged}, signer.PublicKey())
+	if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemBadSignature {
+		t.Fatalf("problems = %+v, want a bad signature", report.Problems)
+	}
+
+	other, err := NewAuditChainService("", "another secret")
+	if err != nil {
+		t.Fatalf("NewAuditChainService() returned %v", err)
+	}
+	report = verifyTestChain(entries, chainHead(entries), []models.AuditCheckpoint{checkpoint}, other.PublicKey())
+	if kinds := problemKinds(report); len(kinds) != 1 || kinds[0] != ChainProblemUnknownKey {
+		t.Fatalf("problems = %+v, want an unknown key", report.Problems)
+	}
+}
+
+func TestNewAuditChainServiceRejectsBadKey(t *testing.T) {
+	if _, err := NewAuditChainService("c2hvcnQ=", ""); err == nil {
+		t.Fatal("NewAuditChainService() accepted a short key")
+	}
+}
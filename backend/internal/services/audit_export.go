@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// AuditRecord is the flat shape of an audit entry in exports and forwarded
+// batches.
+type AuditRecord struct {
+	ID           uuid.UUID       `json:"id"`
+	OrgID        uuid.UUID       `json:"org_id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ActorType    string          `json:"actor_type"`
+	UserID       *uuid.UUID      `json:"user_id,omitempty"`
+	AgentID      *uuid.UUID      `json:"agent_id,omitempty"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   uuid.UUID       `json:"resource_id"`
+	IPAddress    string          `json:"ip_address,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+}
+
+// NewAuditRecord flattens an audit entry.
+func NewAuditRecord(l *models.AuditLog) AuditRecord {
+	r := AuditRecord{
+		ID:           l.ID,
+		OrgID:        l.OrgID,
+		CreatedAt:    l.CreatedAt.UTC(),
+		ActorType:    l.ActorType,
+		UserID:       l.UserID,
+		AgentID:      l.AgentID,
+		Action:       l.Action,
+		ResourceType: l.ResourceType,
+		ResourceID:   l.ResourceID,
+		IPAddress:    l.IPAddress,
+	}
+	if len(l.Metadata) > 0 && string(l.Metadata) != "null" {
+		r.Metadata = json.RawMessage(l.Metadata)
+	}
+	return r
+}
+
+// AuditCSVHeader is the header row of a CSV export.
+var AuditCSVHeader = []string{"id", "created_at", "actor_type", "user_id", "agent_id", "action", "resource_type", "resource_id", "ip_address", "metadata"}
+
+// CSVRow renders r in AuditCSVHeader order. Metadata stays JSON.
+func (r AuditRecord) CSVRow() []string {
+	optional := func(id *uuid.UUID) string {
+		if id == nil {
+			return ""
+		}
+		return id.String()
+	}
+	return []string{
+		r.ID.String(),
+		r.CreatedAt.Format(time.RFC3339Nano),
+		r.ActorType,
+		optional(r.UserID),
+		optional(r.AgentID),
+		r.Action,
+		r.ResourceType,
+		r.ResourceID.String(),
+		r.IPAddress,
+		string(r.Metadata),
+	}
+}
+
+// ExportOrgLogs streams every entry of an org's audit log matching f to
+// emit, oldest first, reading rows from the database one at a time rather
+// than loading the result. Entries beyond the org's retention are left out.
+// The cursor and limit of f are ignored.
+func (s *AuditService) ExportOrgLogs(ctx context.Context, orgID uuid.UUID, f AuditLogFilter, emit func(AuditRecord) error) error {
+	cutoff, err := s.RetentionCutoff(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	db := database.GetDB().WithContext(ctx)
+	q, err := applyAuditFilter(db.Model(&models.AuditLog{}).Where("org_id = ?", orgID), f)
+	if err != nil {
+		return err
+	}
+	if !cutoff.IsZero() {
+		q = q.Where("created_at >= ?", cutoff)
+	}
+	rows, err := q.Order("created_at ASC, id ASC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry models.AuditLog
+		if err := db.ScanRows(rows, &entry); err != nil {
+			return err
+		}
+		if err := emit(NewAuditRecord(&entry)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,652 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAuditSinkNotFound = errors.New("audit sink not found")
+	ErrInvalidAuditSink  = errors.New("invalid audit sink")
+)
+
+// Forwarder tuning. Tests shorten them.
+var (
+	// auditForwardSettleDelay holds back the newest audit events so entries
+	// committed slightly out of order are not skipped by a sink's cursor.
+	auditForwardSettleDelay = 10 * time.Second
+	// auditForwardBaseBackoff is the wait after a sink's first failed
+	// delivery; it doubles with each further failure up to
+	// auditForwardMaxBackoff.
+	auditForwardBaseBackoff = 30 * time.Second
+	auditForwardMaxBackoff  = time.Hour
+)
+
+const (
+	auditForwardBatchSize = 500
+	// auditForwardMaxBatches bounds one sink's share of a run so a backlog
+	// does not starve the others.
+	auditForwardMaxBatches = 20
+	maxAuditSinksPerOrg    = 10
+	defaultSyslogAppName   = "envo"
+	defaultS3Region        = "us-east-1"
+	// syslogPriority is facility local0 (16) at severity informational (6).
+	syslogPriority = 16*8 + 6
+)
+
+var (
+	auditSinkKinds = []string{models.AuditSinkWebhook, models.AuditSinkSyslog, models.AuditSinkS3}
+	s3BucketName   = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	s3KeyPrefix    = regexp.MustCompile(`^[A-Za-z0-9._/-]*$`)
+	syslogAppName  = regexp.MustCompile(`^[!-~]{1,48}$`)
+)
+
+// AuditSinkConfig holds a sink's non-secret settings. URL applies to
+// webhook sinks; Address, Insecure and AppName to syslog; Endpoint, Region,
+// Bucket, Prefix, PathStyle and AccessKeyID to s3.
+type AuditSinkConfig struct {
+	URL string `json:"url,omitempty"`
+
+	// Address is the syslog receiver's host:port. Insecure sends plain TCP
+	// instead of TLS.
+	Address  string `json:"address,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	AppName  string `json:"app_name,omitempty"`
+
+	// Endpoint is empty for AWS, or the base URL of an S3-compatible store.
+	Endpoint    string `json:"endpoint,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Bucket      string `json:"bucket,omitempty"`
+	Prefix      string `json:"prefix,omitempty"`
+	PathStyle   bool   `json:"path_style,omitempty"`
+	AccessKeyID string `json:"access_key_id,omitempty"`
+}
+
+// AuditSinkInput describes a new audit sink.
+type AuditSinkInput struct {
+	Name   string
+	Kind   string
+	Config AuditSinkConfig
+	// Secret is the webhook signing secret, generated when empty, or the
+	// S3 secret access key. Syslog sinks take none.
+	Secret string
+}
+
+func normalizeAuditSink(in AuditSinkInput, allowLoopbackHTTP bool) (AuditSinkInput, error) {
+	invalid := func(format string, args ...any) (AuditSinkInput, error) {
+		return in, fmt.Errorf("%w: %s", ErrInvalidAuditSink, fmt.Sprintf(format, args...))
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || len(in.Name) > 120 {
+		return invalid("name must be between 1 and 120 characters")
+	}
+	in.Kind = strings.ToLower(strings.TrimSpace(in.Kind))
+	if !slices.Contains(auditSinkKinds, in.Kind) {
+		return invalid("kind must be one of %s", strings.Join(auditSinkKinds, ", "))
+	}
+	in.Secret = strings.TrimSpace(in.Secret)
+	c := in.Config
+	var err error
+	switch in.Kind {
+	case models.AuditSinkWebhook:
+		target, err := normalizeEndpointURL(c.URL, "webhook URL", allowLoopbackHTTP)
+		if err != nil || target == "" {
+			return invalid("a webhook sink needs an https url")
+		}
+		if in.Secret != "" && len(in.Secret) < 16 {
+			return invalid("the signing secret must be at least 16 characters")
+		}
+		in.Config = AuditSinkConfig{URL: target}
+	case models.AuditSinkSyslog:
+		host, port, err := net.SplitHostPort(strings.TrimSpace(c.Address))
+		if err != nil || host == "" {
+			return invalid("a syslog sink needs an address as host:port")
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return invalid("the syslog port must be between 1 and 65535")
+		}
+		if c.AppName == "" {
+			c.AppName = defaultSyslogAppName
+		}
+		if !syslogAppName.MatchString(c.AppName) {
+			return invalid("the syslog app name must be 1 to 48 printable characters without spaces")
+		}
+		if in.Secret != "" {
+			return invalid("syslog sinks take no secret")
+		}
+		in.Config = AuditSinkConfig{Address: net.JoinHostPort(host, port), Insecure: c.Insecure, AppName: c.AppName}
+	case models.AuditSinkS3:
+		if !s3BucketName.MatchString(c.Bucket) {
+			return invalid("bucket must be a valid S3 bucket name")
+		}
+		if c.Region = strings.TrimSpace(c.Region); c.Region == "" {
+			c.Region = defaultS3Region
+		}
+		if c.Endpoint, err = normalizeEndpointURL(c.Endpoint, "S3 endpoint", allowLoopbackHTTP); err != nil {
+			return invalid("%v", err)
+		}
+		c.Prefix = strings.Trim(strings.TrimSpace(c.Prefix), "/")
+		if len(c.Prefix) > 200 || !s3KeyPrefix.MatchString(c.Prefix) {
+			return invalid("prefix may only contain letters, digits, '.', '_', '-' and '/' (at most 200 characters)")
+		}
+		if c.AccessKeyID = strings.TrimSpace(c.AccessKeyID); c.AccessKeyID == "" || in.Secret == "" {
+			return invalid("an s3 sink needs access_key_id and secret")
+		}
+		in.Config = AuditSinkConfig{Endpoint: c.Endpoint, Region: c.Region, Bucket: c.Bucket, Prefix: c.Prefix, PathStyle: c.PathStyle, AccessKeyID: c.AccessKeyID}
+	}
+	return in, nil
+}
+
+// AuditForwardingService manages audit sinks and forwards new audit events
+// to them.
+type AuditForwardingService struct {
+	audit          *AuditService
+	encryptor      Encryptor
+	localEncryptor Encryptor
+	egress         egressGuard
+	httpClient     *http.Client
+	hostname       string
+	now            func() time.Time
+}
+
+// NewAuditForwardingService creates the audit forwarder. Sinks may only
+// point at public addresses unless allowPrivateEndpoints is set, which is
+// meant for development.
+func NewAuditForwardingService(audit *AuditService, encryptor, localEncryptor Encryptor, allowPrivateEndpoints bool) *AuditForwardingService {
+	hostname, err := os.Hostname()
+	if err != nil || !syslogAppName.MatchString(hostname) {
+		hostname = "envo"
+	}
+	egress := egressGuard{allowPrivate: allowPrivateEndpoints}
+	return &AuditForwardingService{
+		audit:          audit,
+		encryptor:      encryptor,
+		localEncryptor: localEncryptor,
+		egress:         egress,
+		httpClient:     egress.httpClient(20 * time.Second),
+		hostname:       hostname,
+		now:            time.Now,
+	}
+}
+
+// CreateSink adds an audit sink that forwards events from now on. For a
+// webhook sink without a secret it returns the generated signing secret,
+// which is not shown again.
+func (s *AuditForwardingService) CreateSink(ctx context.Context, userID, orgID uuid.UUID, in AuditSinkInput, ip string) (*models.AuditSink, string, error) {
+	in, err := normalizeAuditSink(in, s.egress.allowPrivate)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.checkSinkTarget(ctx, in); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidAuditSink, err)
+	}
+	db := database.GetDB().WithContext(ctx)
+	var count int64
+	if err := db.Model(&models.AuditSink{}).Where("org_id = ?", orgID).Count(&count).Error; err != nil {
+		return nil, "", err
+	}
+	if count >= maxAuditSinksPerOrg {
+		return nil, "", fmt.Errorf("%w: an organization can have at most %d audit sinks", ErrInvalidAuditSink, maxAuditSinksPerOrg)
+	}
+
+	var generated string
+	if in.Kind == models.AuditSinkWebhook && in.Secret == "" {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, "", err
+		}
+		generated = "whsec_" + hex.EncodeToString(raw)
+		in.Secret = generated
+	}
+	config, err := json.Marshal(in.Config)
+	if err != nil {
+		return nil, "", err
+	}
+	sink := &models.AuditSink{
+		OrgID:         orgID,
+		Name:          in.Name,
+		Kind:          in.Kind,
+		Config:        datatypes.JSON(config),
+		Enabled:       true,
+		LastCreatedAt: s.now().UTC(),
+		CreatedBy:     userID,
+	}
+	if in.Secret != "" {
+		if s.encryptor == nil {
+			return nil, "", fmt.Errorf("secret encryption is not configured")
+		}
+		if sink.EncryptedSecret, err = s.encryptor.Encrypt(ctx, in.Secret, orgID.String()); err != nil {
+			return nil, "", fmt.Errorf("failed to encrypt sink secret: %w", err)
+		}
+		sink.KeyID = s.encryptor.KeyID()
+	}
+	if err := db.Create(sink).Error; err != nil {
+		return nil, "", err
+	}
+	sink.Status = sink.HealthStatus()
+
+	metadata, _ := json.Marshal(map[string]any{"name": sink.Name, "kind": sink.Kind})
+	if err := s.audit.Log(ctx, userID, orgID, sink.ID, models.ActionAuditSinkCreate, "audit_sink", ip, datatypes.JSON(metadata)); err != nil {
+		log.Printf("[envo] audit log (audit sink create): %v", err)
+	}
+	return sink, generated, nil
+}
+
+// checkSinkTarget refuses a sink whose host resolves to an internal
+// address. Deliveries are checked again when they connect.
+func (s *AuditForwardingService) checkSinkTarget(ctx context.Context, in AuditSinkInput) error {
+	var host string
+	switch in.Kind {
+	case models.AuditSinkWebhook:
+		u, err := url.Parse(in.Config.URL)
+		if err != nil {
+			return err
+		}
+		host = u.Hostname()
+	case models.AuditSinkSyslog:
+		host, _, _ = net.SplitHostPort(in.Config.Address)
+	case models.AuditSinkS3:
+		if in.Config.Endpoint == "" {
+			return nil // AWS itself
+		}
+		u, err := url.Parse(in.Config.Endpoint)
+		if err != nil {
+			return err
+		}
+		host = u.Hostname()
+	}
+	return s.egress.checkHost(ctx, host)
+}
+
+// ListSinks lists an org's audit sinks with their delivery health.
+func (s *AuditForwardingService) ListSinks(ctx context.Context, orgID uuid.UUID) ([]models.AuditSink, error) {
+	var sinks []models.AuditSink
+	if err := database.GetDB().WithContext(ctx).Where("org_id = ?", orgID).Order("created_at ASC").Find(&sinks).Error; err != nil {
+		return nil, err
+	}
+	for i := range sinks {
+		sinks[i].Status = sinks[i].HealthStatus()
+	}
+	return sinks, nil
+}
+
+// SetSinkEnabled pauses or resumes a sink. A paused sink keeps its cursor,
+// so resuming delivers what it missed; resuming also retries a failing sink
+// immediately.
+func (s *AuditForwardingService) SetSinkEnabled(ctx context.Context, userID, orgID, sinkID uuid.UUID, enabled bool, ip string) (*models.AuditSink, error) {
+	db := database.GetDB().WithContext(ctx)
+	var sink models.AuditSink
+	if err := db.Where("id = ? AND org_id = ?", sinkID, orgID).First(&sink).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAuditSinkNotFound
+		}
+		return nil, err
+	}
+	updates := map[string]any{"enabled": enabled}
+	if enabled {
+		updates["next_attempt_at"] = nil
+	}
+	if err := db.Model(&sink).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	sink.Status = sink.HealthStatus()
+
+	metadata, _ := json.Marshal(map[string]any{"name": sink.Name, "enabled": enabled})
+	if err := s.audit.Log(ctx, userID, orgID, sink.ID, models.ActionAuditSinkUpdate, "audit_sink", ip, datatypes.JSON(metadata)); err != nil {
+		log.Printf("[envo] audit log (audit sink update): %v", err)
+	}
+	return &sink, nil
+}
+
+// DeleteSink stops forwarding to a sink and removes it.
+func (s *AuditForwardingService) DeleteSink(ctx context.Context, userID, orgID, sinkID uuid.UUID, ip string) error {
+	db := database.GetDB().WithContext(ctx)
+	var sink models.AuditSink
+	if err := db.Where("id = ? AND org_id = ?", sinkID, orgID).First(&sink).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAuditSinkNotFound
+		}
+		return err
+	}
+	if err := db.Delete(&sink).Error; err != nil {
+		return err
+	}
+	metadata, _ := json.Marshal(map[string]any{"name": sink.Name, "kind": sink.Kind})
+	if err := s.audit.Log(ctx, userID, orgID, sink.ID, models.ActionAuditSinkDelete, "audit_sink", ip, datatypes.JSON(metadata)); err != nil {
+		log.Printf("[envo] audit log (audit sink delete): %v", err)
+	}
+	return nil
+}
+
+// Run forwards new audit events every interval until ctx is cancelled.
+func (s *AuditForwardingService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if forwarded, err := s.RunOnce(ctx); err != nil {
+			log.Printf("[envo] audit forwarding: %v", err)
+		} else if forwarded > 0 {
+			log.Printf("[envo] audit forwarding: %d events", forwarded)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce forwards pending events to every enabled sink that is not backing
+// off and returns how many were delivered. A failed delivery is recorded on
+// the sink rather than returned.
+func (s *AuditForwardingService) RunOnce(ctx context.Context) (int, error) {
+	var ids []uuid.UUID
+	if err := database.GetDB().WithContext(ctx).Model(&models.AuditSink{}).
+		Where("enabled = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", true, s.now().UTC()).
+		Order("created_at ASC").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	total := 0
+	var errs []error
+	for _, id := range ids {
+		for batch := 0; batch < auditForwardMaxBatches; batch++ {
+			delivered, more, err := s.forwardBatch(ctx, id)
+			total += delivered
+			if err != nil {
+				errs = append(errs, fmt.Errorf("sink %s: %w", id, err))
+			}
+			if err != nil || !more {
+				break
+			}
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// forwardBatch delivers the next batch of a sink's events and moves its
+// cursor. The sink row stays locked during delivery so only one instance
+// forwards to it at a time. It reports whether a full batch was delivered,
+// meaning more may be waiting.
+func (s *AuditForwardingService) forwardBatch(ctx context.Context, sinkID uuid.UUID) (int, bool, error) {
+	delivered := 0
+	err := database.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var sink models.AuditSink
+		locked := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND enabled = ?", sinkID, true).Find(&sink)
+		if locked.Error != nil || locked.RowsAffected == 0 {
+			return locked.Error // paused, deleted, or another instance holds it
+		}
+		upTo := s.now().UTC().Add(-auditForwardSettleDelay)
+		var events []models.AuditLog
+		if err := tx.Where("org_id = ?", sink.OrgID).
+			Where("(created_at, id) > (?, ?) AND created_at <= ?", sink.LastCreatedAt, sink.LastAuditLogID, upTo).
+			Order("created_at ASC, id ASC").Limit(auditForwardBatchSize).Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		records := make([]AuditRecord, len(events))
+		for i := range events {
+			records[i] = NewAuditRecord(&events[i])
+		}
+
+		attempt := s.now().UTC()
+		if err := s.deliver(ctx, &sink, records); err != nil {
+			failures := sink.ConsecutiveFailures + 1
+			message := err.Error()
+			if len(message) > 500 {
+				message = message[:500]
+			}
+			log.Printf("[envo] audit sink %s (%s): delivery failed (%d in a row): %v", sink.ID, sink.Kind, failures, err)
+			return tx.Model(&sink).Updates(map[string]any{
+				"last_attempt_at":      attempt,
+				"last_error":           message,
+				"consecutive_failures": failures,
+				"next_attempt_at":      attempt.Add(auditForwardBackoff(failures)),
+			}).Error
+		}
+		last := events[len(events)-1]
+		if err := tx.Model(&sink).Updates(map[string]any{
+			"last_created_at":      last.CreatedAt,
+			"last_audit_log_id":    last.ID,
+			"last_attempt_at":      attempt,
+			"last_delivery_at":     attempt,
+			"last_error":           "",
+			"consecutive_failures": 0,
+			"next_attempt_at":      nil,
+			"delivered_count":      gorm.Expr("delivered_count + ?", len(events)),
+		}).Error; err != nil {
+			return err
+		}
+		delivered = len(events)
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return delivered, delivered == auditForwardBatchSize, nil
+}
+
+// auditForwardBackoff is how long a sink waits after its nth failure in a row.
+func auditForwardBackoff(failures int) time.Duration {
+	d := auditForwardBaseBackoff
+	for i := 1; i < failures && d < auditForwardMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, auditForwardMaxBackoff)
+}
+
+func (s *AuditForwardingService) deliver(ctx context.Context, sink *models.AuditSink, records []AuditRecord) error {
+	var cfg AuditSinkConfig
+	if err := json.Unmarshal(sink.Config, &cfg); err != nil {
+		return fmt.Errorf("invalid sink config: %w", err)
+	}
+	var secret string
+	if sink.EncryptedSecret != "" {
+		var err error
+		if secret, err = decryptWithFallback(ctx, s.encryptor, s.localEncryptor, sink.KeyID, sink.EncryptedSecret, sink.OrgID.String()); err != nil {
+			return fmt.Errorf("failed to decrypt sink secret")
+		}
+	}
+	switch sink.Kind {
+	case models.AuditSinkWebhook:
+		return s.postAuditWebhook(ctx, cfg.URL, secret, records)
+	case models.AuditSinkSyslog:
+		return s.sendSyslog(ctx, cfg, records)
+	case models.AuditSinkS3:
+		return s.putS3Object(ctx, cfg, secret, sink.OrgID, records)
+	default:
+		return fmt.Errorf("unsupported sink kind %q", sink.Kind)
+	}
+}
+
+func encodeJSONLines(records []AuditRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// signAuditWebhook computes the X-Envo-Signature header: an HMAC-SHA256 of
+// the timestamp, a dot, and the body, so receivers can reject replays.
+func signAuditWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func (s *AuditForwardingService) postAuditWebhook(ctx context.Context, target, secret string, records []AuditRecord) error {
+	body, err := encodeJSONLines(records)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("User-Agent", "Envo-Webhook/1")
+	req.Header.Set("X-Envo-Signature", signAuditWebhook(secret, s.now().Unix(), body))
+	req.Header.Set("X-Envo-Event-Count", strconv.Itoa(len(records)))
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// formatSyslog renders one event as an RFC 5424 message with octet-counting
+// framing (RFC 6587). The action is the MSGID and the record is the JSON
+// message body.
+func formatSyslog(r AuditRecord, hostname, appName string) ([]byte, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	msgID := r.Action
+	if len(msgID) > 32 {
+		msgID = msgID[:32]
+	}
+	if msgID == "" {
+		msgID = "-"
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s - %s - %s", syslogPriority, r.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z"), hostname, appName, msgID, body)
+	return []byte(strconv.Itoa(len(line)) + " " + line), nil
+}
+
+func (s *AuditForwardingService) sendSyslog(ctx context.Context, cfg AuditSinkConfig, records []AuditRecord) error {
+	dialer := s.egress.dialer(10 * time.Second)
+	var conn net.Conn
+	var err error
+	if cfg.Insecure {
+		conn, err = dialer.DialContext(ctx, "tcp", cfg.Address)
+	} else {
+		host, _, _ := net.SplitHostPort(cfg.Address)
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", cfg.Address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(s.now().Add(30 * time.Second)); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(conn)
+	for _, r := range records {
+		frame, err := formatSyslog(r, s.hostname, cfg.AppName)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// s3ObjectKey names the object holding a batch after its first event, so a
+// retried batch overwrites the failed attempt instead of duplicating it.
+func s3ObjectKey(prefix string, orgID uuid.UUID, first AuditRecord) string {
+	at := first.CreatedAt.UTC()
+	key := fmt.Sprintf("%s/%s/%s-%s.jsonl", orgID, at.Format("2006/01/02"), at.Format("150405.000000"), first.ID)
+	if prefix != "" {
+		key = prefix + "/" + key
+	}
+	return key
+}
+
+// s3ObjectURL addresses key in the configured bucket: virtual-hosted style
+// unless PathStyle is set, on AWS unless Endpoint is set.
+func s3ObjectURL(cfg AuditSinkConfig, key string) (string, error) {
+	if cfg.Endpoint == "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.Bucket, cfg.Region, key), nil
+	}
+	base, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil {
+		return "", err
+	}
+	if cfg.PathStyle {
+		return base.String() + "/" + cfg.Bucket + "/" + key, nil
+	}
+	base.Host = cfg.Bucket + "." + base.Host
+	return base.String() + "/" + key, nil
+}
+
+func (s *AuditForwardingService) putS3Object(ctx context.Context, cfg AuditSinkConfig, secretKey string, orgID uuid.UUID, records []AuditRecord) error {
+	return putS3Records(ctx, s.httpClient, cfg, secretKey, orgID, records, s.now())
+}
+
+// putS3Records writes records as one JSON lines object, signed with SigV4.
+func putS3Records(ctx context.Context, httpClient *http.Client, cfg AuditSinkConfig, secretKey string, orgID uuid.UUID, records []AuditRecord, now time.Time) error {
+	body, err := encodeJSONLines(records)
+	if err != nil {
+		return err
+	}
+	target, err := s3ObjectURL(cfg, s3ObjectKey(cfg.Prefix, orgID, records[0]))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signer := v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true })
+	credentials := aws.Credentials{AccessKeyID: cfg.AccessKeyID, SecretAccessKey: secretKey}
+	if err := signer.SignHTTP(ctx, credentials, req, payloadHash, "s3", cfg.Region, now.UTC()); err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+		return fmt.Errorf("object store answered %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
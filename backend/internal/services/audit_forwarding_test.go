@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+func testAuditRecord(action string) AuditRecord {
+	return AuditRecord{
+		ID:           uuid.MustParse("0b6f2c1e-8d2a-4c55-9a31-0f3d8d1e2a7b"),
+		OrgID:        uuid.MustParse("5d8a4f2e-1c3b-4e6a-8f9d-2b7c6a5e4d3c"),
+		CreatedAt:    time.Date(2026, 3, 2, 10, 4, 5, 123456000, time.UTC),
+		ActorType:    models.AuditActorHuman,
+		Action:       action,
+		ResourceType: "secret",
+		ResourceID:   uuid.MustParse("9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b"),
+		Metadata:     json.RawMessage(`{"key":"API_KEY"}`),
+	}
+}
+
+func TestNormalizeAuditSink(t *testing.T) {
+	in, err := normalizeAuditSink(AuditSinkInput{Name: " SIEM ", Kind: "Syslog", Config: AuditSinkConfig{Address: "siem.example.com:6514", URL: "ignored"}}, false)
+	if err != nil {
+		t.Fatalf("normalizeAuditSink(syslog) returned %v", err)
+	}
+	if in.Name != "SIEM" || in.Kind != models.AuditSinkSyslog || in.Config != (AuditSinkConfig{Address: "siem.example.com:6514", AppName: "envo"}) {
+		t.Fatalf("normalizeAuditSink(syslog) = %+v", in)
+	}
+	in, err = normalizeAuditSink(AuditSinkInput{Name: "archive", Kind: models.AuditSinkS3, Secret: "s3cr3t", Config: AuditSinkConfig{Bucket: "audit-archive", Prefix: "/envo/", AccessKeyID: "AKID"}}, false)
+	if err != nil {
+		t.Fatalf("normalizeAuditSink(s3) returned %v", err)
+	}
+	if in.Config.Region != defaultS3Region || in.Config.Prefix != "envo" {
+		t.Fatalf("normalizeAuditSink(s3) = %+v", in.Config)
+	}
+	if _, err := normalizeAuditSink(AuditSinkInput{Name: "hook", Kind: models.AuditSinkWebhook, Config: AuditSinkConfig{URL: "https://siem.example.com/ingest"}}, false); err != nil {
+		t.Fatalf("normalizeAuditSink(webhook) returned %v", err)
+	}
+
+	for name, bad := range map[string]AuditSinkInput{
+		"kind":           {Name: "x", Kind: "kafka"},
+		"no name":        {Kind: models.AuditSinkWebhook, Config: AuditSinkConfig{URL: "https://example.com"}},
+		"http webhook":   {Name: "x", Kind: models.AuditSinkWebhook, Config: AuditSinkConfig{URL: "http://example.com/hook"}},
+		"short secret":   {Name: "x", Kind: models.AuditSinkWebhook, Secret: "short", Config: AuditSinkConfig{URL: "https://example.com"}},
+		"syslog address": {Name: "x", Kind: models.AuditSinkSyslog, Config: AuditSinkConfig{Address: "siem.example.com"}},
+		"syslog port":    {Name: "x", Kind: models.AuditSinkSyslog, Config: AuditSinkConfig{Address: "siem.example.com:0"}},
+		"syslog secret":  {Name: "x", Kind: models.AuditSinkSyslog, Secret: "0123456789abcdef", Config: AuditSinkConfig{Address: "siem:514"}},
+		"s3 bucket":      {Name: "x", Kind: models.AuditSinkS3, Secret: "s", Config: AuditSinkConfig{Bucket: "Bad_Bucket", AccessKeyID: "AKID"}},
+		"s3 prefix":      {Name: "x", Kind: models.AuditSinkS3, Secret: "s", Config: AuditSinkConfig{Bucket: "audit", Prefix: "a b", AccessKeyID: "AKID"}},
+		"s3 credentials": {Name: "x", Kind: models.AuditSinkS3, Config: AuditSinkConfig{Bucket: "audit", AccessKeyID: "AKID"}},
+		"s3 endpoint":    {Name: "x", Kind: models.AuditSinkS3, Secret: "s", Config: AuditSinkConfig{Bucket: "audit", AccessKeyID: "AKID", Endpoint: "http://minio.internal:9000"}},
+	} {
+		if _, err := normalizeAuditSink(bad, false); !errors.Is(err, ErrInvalidAuditSink) {
+			t.Errorf("%s: normalizeAuditSink() error = %v, want ErrInvalidAuditSink", name, err)
+		}
+	}
+}
+
+func TestAuditForwardBackoff(t *testing.T) {
+	for failures, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute, 20: time.Hour} {
+		if got := auditForwardBackoff(failures); got != want {
+			t.Errorf("auditForwardBackoff(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestPostAuditWebhookSignsBody(t *testing.T) {
+	const secret = "whsec_0123456789abcdef"
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(strconv.FormatInt(now.Unix(), 10) + "."))
+		mac.Write(body)
+		want := "t=" + strconv.FormatInt(now.Unix(), 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+		if got := r.Header.Get("X-Envo-Signature"); got != want {
+			t.Errorf("X-Envo-Signature = %q, want %q", got, want)
+		}
+		if lines := strings.Count(string(body), "\n"); lines != 2 || r.Header.Get("X-Envo-Event-Count") != "2" {
+			t.Errorf("body has %d lines: %q", lines, body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := NewAuditForwardingService(nil, nil, nil, true)
+	s.now = func() time.Time { return now }
+	records := []AuditRecord{testAuditRecord(models.ActionSecretRead), testAuditRecord(models.ActionSecretUpdate)}
+	if err := s.postAuditWebhook(context.Background(), server.URL, secret, records); err != nil {
+		t.Fatalf("postAuditWebhook() returned %v", err)
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	frame, err := formatSyslog(testAuditRecord(models.ActionSecretRead), "api-1", "envo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, line, ok := strings.Cut(string(frame), " ")
+	if !ok || length != strconv.Itoa(len(line)) {
+		t.Fatalf("frame %q is not octet-counted", frame)
+	}
+	prefix := "<134>1 2026-03-02T10:04:05.123456Z api-1 envo - secret_read - {"
+	if !strings.HasPrefix(line, prefix) || !strings.Contains(line, `"metadata":{"key":"API_KEY"}`) {
+		t.Fatalf("syslog line = %q", line)
+	}
+}
+
+func TestSendSyslogOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var frames []string
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				break
+			}
+			frames = append(frames, string(buf))
+		}
+		received <- frames
+	}()
+
+	s := NewAuditForwardingService(nil, nil, nil, true)
+	cfg := AuditSinkConfig{Address: listener.Addr().String(), Insecure: true, AppName: "envo"}
+	if err := s.sendSyslog(context.Background(), cfg, []AuditRecord{testAuditRecord(models.ActionSecretRead), testAuditRecord(models.ActionSecretDelete)}); err != nil {
+		t.Fatalf("sendSyslog() returned %v", err)
+	}
+	frames := <-received
+	if len(frames) != 2 || !strings.Contains(frames[1], " secret_delete ") {
+		t.Fatalf("received %q", frames)
+	}
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	key := s3ObjectKey("envo", uuid.MustParse("5d8a4f2e-1c3b-4e6a-8f9d-2b7c6a5e4d3c"), testAuditRecord(models.ActionSecretRead))
+	if want := "envo/5d8a4f2e-1c3b-4e6a-8f9d-2b7c6a5e4d3c/2026/03/02/100405.123456-0b6f2c1e-8d2a-4c55-9a31-0f3d8d1e2a7b.jsonl"; key != want {
+		t.Fatalf("s3ObjectKey() = %q, want %q", key, want)
+	}
+	for _, tc := range []struct {
+		cfg  AuditSinkConfig
+		want string
+	}{
+		{AuditSinkConfig{Bucket: "audit", Region: "eu-west-1"}, "https://audit.s3.eu-west-1.amazonaws.com/k"},
+		{AuditSinkConfig{Bucket: "audit", Endpoint: "https://minio.example.com:9000/", PathStyle: true}, "https://minio.example.com:9000/audit/k"},
+		{AuditSinkConfig{Bucket: "audit", Endpoint: "https://r2.example.com"}, "https://audit.r2.example.com/k"},
+	} {
+		if got, err := s3ObjectURL(tc.cfg, "k"); err != nil || got != tc.want {
+			t.Errorf("s3ObjectURL(%+v) = %q, %v, want %q", tc.cfg, got, err, tc.want)
+		}
+	}
+}
+
+func TestPutS3ObjectSignsRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.URL.Path, "/audit/envo/") || !strings.HasSuffix(r.URL.Path, ".jsonl") {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(sum[:]) {
+			t.Errorf("X-Amz-Content-Sha256 = %q", got)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/20260302/us-east-1/s3/aws4_request") {
+			t.Errorf("Authorization = %q", auth)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s := NewAuditForwardingService(nil, nil, nil, true)
+	s.now = func() time.Time { return time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC) }
+	cfg := AuditSinkConfig{Endpoint: server.URL, PathStyle: true, Bucket: "audit", Region: "us-east-1", Prefix: "envo", AccessKeyID: "AKID"}
+	if err := s.putS3Object(context.Background(), cfg, "secret", uuid.New(), []AuditRecord{testAuditRecord(models.ActionSecretRead)}); err != nil {
+		t.Fatalf("putS3Object() returned %v", err)
+	}
+}
+
+func TestAuditRecordCSVRow(t *testing.T) {
+	userID := uuid.New()
+	entry := &models.AuditLog{ID: uuid.New(), UserID: &userID, ActorType: models.AuditActorHuman, Action: models.ActionSecretRead, ResourceType: "secret", Metadata: datatypes.JSON(`null`)}
+	row := NewAuditRecord(entry).CSVRow()
+	if len(row) != len(AuditCSVHeader) || row[3] != userID.String() || row[4] != "" || row[9] != "" {
+		t.Fatalf("CSVRow() = %q", row)
+	}
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// auditRetentionBatchSize bounds the rows one retention transaction locks
+// and deletes, keeping locks on audit_logs short.
+const auditRetentionBatchSize = 1000
+
+// retentionCutoff is when the oldest entry a tier keeps was written, or
+// zero when the tier keeps entries forever.
+func (s *AuditService) retentionCutoff(tier string, now time.Time) (time.Time, error) {
+	days, err := s.tierService.GetLimit(tier, models.LimitTypeAuditRetentionDays)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if days == models.UnlimitedValue {
+		return time.Time{}, nil
+	}
+	return now.AddDate(0, 0, -days), nil
+}
+
+// RetentionCutoff is when the oldest audit entry an organization may still
+// see was written, from its owner's tier. Zero means no limit. It applies
+// as soon as the owner's tier changes, before the retention job runs.
+func (s *AuditService) RetentionCutoff(ctx context.Context, orgID uuid.UUID) (time.Time, error) {
+	var tiers []string
+	if err := database.GetDB().WithContext(ctx).Table("organizations").
+		Select("users.subscription_tier").
+		Joins("JOIN users ON users.id = organizations.owner_id").
+		Where("organizations.id = ?", orgID).
+		Pluck("users.subscription_tier", &tiers).Error; err != nil {
+		return time.Time{}, err
+	}
+	if len(tiers) == 0 {
+		return time.Time{}, nil
+	}
+	return s.retentionCutoff(tiers[0], time.Now())
+}
+
+// AuditArchive is the S3-compatible bucket audit entries are copied to
+// before retention deletes them.
+type AuditArchive struct {
+	Config          AuditSinkConfig
+	SecretAccessKey string
+}
+
+// AuditRetentionService deletes audit entries older than each
+// organization's retention, archiving them first when an archive is set.
+type AuditRetentionService struct {
+	audit      *AuditService
+	chain      *AuditChainService
+	archive    *AuditArchive
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// NewAuditRetentionService creates the retention job. chain signs the
+// pruned marker on each chain head; archive may be nil.
+func NewAuditRetentionService(audit *AuditService, chain *AuditChainService, archive *AuditArchive) *AuditRetentionService {
+	if archive != nil {
+		trimmed := *archive
+		trimmed.Config.Prefix = strings.Trim(strings.TrimSpace(trimmed.Config.Prefix), "/")
+		archive = &trimmed
+	}
+	return &AuditRetentionService{
+		audit:      audit,
+		chain:      chain,
+		archive:    archive,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		now:        time.Now,
+	}
+}
+
+// Run applies retention every interval until ctx is cancelled.
+func (s *AuditRetentionService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if pruned, err := s.RunOnce(ctx); err != nil {
+			log.Printf("[envo] audit retention: %v", err)
+		} else if pruned > 0 {
+			log.Printf("[envo] audit retention: %d entries pruned", pruned)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce prunes every organization's entries beyond its retention, a
+// batch at a time. An organization that fails is skipped until next run.
+func (s *AuditRetentionService) RunOnce(ctx context.Context) (int64, error) {
+	var orgs []struct {
+		ID   uuid.UUID
+		Tier string
+	}
+	if err := database.GetDB().WithContext(ctx).Table("organizations").
+		Select("organizations.id, users.subscription_tier AS tier").
+		Joins("JOIN users ON users.id = organizations.owner_id").
+		Scan(&orgs).Error; err != nil {
+		return 0, err
+	}
+
+	var total int64
+	now := s.now()
+	for _, org := range orgs {
+		cutoff, err := s.audit.retentionCutoff(org.Tier, now)
+		if err != nil {
+			return total, err
+		}
+		if cutoff.IsZero() {
+			continue
+		}
+		for {
+			if ctx.Err() != nil {
+				return total, ctx.Err()
+			}
+			pruned, err := s.pruneBatch(ctx, org.ID, cutoff)
+			total += int64(pruned)
+			if err != nil {
+				log.Printf("[envo] audit retention: org %s: %v", org.ID, err)
+				break
+			}
+			if pruned < auditRetentionBatchSize {
+				break
+			}
+		}
+	}
+	return total, nil
+}
+
+// pruneBatch archives and deletes the oldest batch of an organization's
+// entries written before cutoff, in chain order, and records the last
+// chained entry removed on the chain head so verification starts after it.
+// Entries an enabled audit sink has not forwarded yet are kept, so a sink
+// that is behind or failing holds retention back instead of losing events.
+// The batch is read and archived outside any transaction, so a slow upload
+// holds no locks; entries past the cutoff never change, and an instance
+// racing on the same batch uploads the same object and deletes nothing.
+func (s *AuditRetentionService) pruneBatch(ctx context.Context, orgID uuid.UUID, cutoff time.Time) (int, error) {
+	db := database.GetDB().WithContext(ctx)
+	var oldestSink models.AuditSink
+	if err := db.Select("last_created_at").Where("org_id = ? AND enabled = ?", orgID, true).
+		Order("last_created_at ASC").Limit(1).Find(&oldestSink).Error; err != nil {
+		return 0, err
+	}
+	// The sink cursor is (created_at, id), so entries written at its
+	// timestamp may still be pending and the comparison stays strict.
+	if !oldestSink.LastCreatedAt.IsZero() && oldestSink.LastCreatedAt.Before(cutoff) {
+		cutoff = oldestSink.LastCreatedAt
+	}
+	var batch []models.AuditLog
+	if err := db.Where("org_id = ? AND created_at < ?", orgID, cutoff).
+		Order("sequence ASC, created_at ASC, id ASC").
+		Limit(auditRetentionBatchSize).
+		Find(&batch).Error; err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if s.archive != nil {
+		records := make([]AuditRecord, len(batch))
+		for i := range batch {
+			records[i] = NewAuditRecord(&batch[i])
+		}
+		// The object is named after the batch's first entry, so a batch
+		// retried after a failed delete overwrites its earlier copy.
+		if err := putS3Records(ctx, s.httpClient, s.archive.Config, s.archive.SecretAccessKey, orgID, records, s.now()); err != nil {
+			return 0, err
+		}
+	}
+
+	ids := make([]uuid.UUID, len(batch))
+	for i, entry := range batch {
+		ids[i] = entry.ID
+	}
+	last := batch[len(batch)-1]
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id IN ?", ids).Delete(&models.AuditLog{}).Error; err != nil {
+			return err
+		}
+		if last.Sequence == 0 {
+			return nil
+		}
+		var head models.AuditChainHead
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("org_id = ?", orgID).Limit(1).Find(&head).Error; err != nil {
+			return err
+		}
+		if head.OrgID == uuid.Nil || last.Sequence <= head.PrunedSequence {
+			return nil
+		}
+		return tx.Model(&head).Updates(s.chain.signPrune(orgID, last.Sequence, last.Hash)).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+)
+
+func TestRetentionCutoffFollowsTierLimit(t *testing.T) {
+	tiers := NewTierService(time.Hour)
+	expires := time.Now().Add(time.Hour)
+	tiers.limits[string(models.TierFree)+":"+models.LimitTypeAuditRetentionDays] = cachedTierLimit{value: 7, expiresAt: expires}
+	tiers.limits[string(models.TierTeam)+":"+models.LimitTypeAuditRetentionDays] = cachedTierLimit{value: models.UnlimitedValue, expiresAt: expires}
+	audit := NewAuditService(tiers)
+
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	cutoff, err := audit.retentionCutoff(string(models.TierFree), now)
+	if err != nil {
+		t.Fatalf("retentionCutoff() returned %v", err)
+	}
+	if want := time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC); !cutoff.Equal(want) {
+		t.Fatalf("retentionCutoff(free) = %v, want %v", cutoff, want)
+	}
+	if cutoff, err := audit.retentionCutoff(string(models.TierTeam), now); err != nil || !cutoff.IsZero() {
+		t.Fatalf("retentionCutoff(unlimited) = %v, %v, want zero", cutoff, err)
+	}
+}
+
+// auditLogRows renders entries as audit_logs rows for fakeDB.
+func auditLogRows(entries []*models.AuditLog) ([]string, [][]driver.Value) {
+	columns := []string{"id", "actor_type", "org_id", "action", "resource_type", "resource_id", "metadata", "ip_address", "sequence", "prev_hash", "hash", "created_at"}
+	var rows [][]driver.Value
+	for _, e := range entries {
+		rows = append(rows, []driver.Value{
+			e.ID.String(), e.ActorType, e.OrgID.String(), e.Action, e.ResourceType, e.ResourceID.String(),
+			string(e.Metadata), e.IPAddress, e.Sequence, e.PrevHash, e.Hash, e.CreatedAt,
+		})
+	}
+	return columns, rows
+}
+
+// testRetentionService returns a retention job archiving to an S3 server
+// that calls put for every object.
+func testRetentionService(t *testing.T, put func(w http.ResponseWriter, r *http.Request)) *AuditRetentionService {
+	t.Helper()
+	signer, err := NewAuditChainService("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(put))
+	t.Cleanup(server.Close)
+	archive := &AuditArchive{
+		Config:          AuditSinkConfig{Endpoint: server.URL, PathStyle: true, Bucket: "audit", Region: "us-east-1", AccessKeyID: "AKID"},
+		SecretAccessKey: "secret",
+	}
+	return NewAuditRetentionService(NewAuditService(NewTierService(time.Hour)), signer, archive)
+}
+
+func TestPruneBatchArchivesOutsideTransaction(t *testing.T) {
+	db := useFakeDB(t)
+	columns, rows := auditLogRows(testChain(t, 3))
+	db.on([]string{`SELECT * FROM "audit_logs"`}, columns, rows...)
+
+	uploadedAt := -1
+	s := testRetentionService(t, func(w http.ResponseWriter, r *http.Request) {
+		uploadedAt = len(db.statements())
+		w.WriteHeader(http.StatusOK)
+	})
+	pruned, err := s.pruneBatch(context.Background(), testChainOrg, time.Now())
+	if err != nil || pruned != 3 {
+		t.Fatalf("pruneBatch() = %d, %v, want 3 pruned", pruned, err)
+	}
+	if begin := db.index("BEGIN"); uploadedAt < 0 || begin < uploadedAt {
+		t.Fatalf("archive uploaded after statement %d, transaction began at %d", uploadedAt, begin)
+	}
+	for _, stmt := range db.statements(`"audit_logs"`) {
+		if strings.Contains(stmt.SQL, "FOR UPDATE") {
+			t.Fatalf("audit_logs rows locked: %s", stmt.SQL)
+		}
+	}
+}
+
+// retentionTestOrgs answers the retention job's organization query with
+// one free-tier organization keeping 7 days of entries.
+func retentionTestOrgs(db *fakeDB, s *AuditRetentionService) {
+	s.audit.tierService.limits[string(models.TierFree)+":"+models.LimitTypeAuditRetentionDays] = cachedTierLimit{value: 7, expiresAt: time.Now().Add(time.Hour)}
+	db.on([]string{"users.subscription_tier AS tier"}, []string{"id", "tier"}, []driver.Value{testChainOrg.String(), string(models.TierFree)})
+}
+
+func TestRetentionRunOnceDeletesInBatches(t *testing.T) {
+	db := useFakeDB(t)
+	s := testRetentionService(t, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	s.archive = nil
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	retentionTestOrgs(db, s)
+
+	entries := testChain(t, auditRetentionBatchSize+2)
+	columns, first := auditLogRows(entries[:auditRetentionBatchSize])
+	_, second := auditLogRows(entries[auditRetentionBatchSize:])
+	db.on([]string{`SELECT * FROM "audit_logs"`}, columns, first...).times = 1
+	db.on([]string{`SELECT * FROM "audit_logs"`}, columns, second...).times = 1
+
+	pruned, err := s.RunOnce(context.Background())
+	if err != nil || pruned != auditRetentionBatchSize+2 {
+		t.Fatalf("RunOnce() = %d, %v, want %d pruned", pruned, err, auditRetentionBatchSize+2)
+	}
+	selects := db.statements(`SELECT * FROM "audit_logs"`)
+	if len(selects) != 2 {
+		t.Fatalf("read %d batches, want 2 (a short batch ends the organization)", len(selects))
+	}
+	if cutoff := selects[0].Args[1]; cutoff != now.AddDate(0, 0, -7) {
+		t.Fatalf("batch cutoff = %v, want 7 days before %v", cutoff, now)
+	}
+	deletes := db.statements(`DELETE FROM "audit_logs"`)
+	if len(deletes) != 2 || len(deletes[0].Args) != auditRetentionBatchSize || len(deletes[1].Args) != 2 {
+		t.Fatalf("deletes = %d, want one per batch by id", len(deletes))
+	}
+	if deletes[1].Args[0] != entries[auditRetentionBatchSize].ID.String() {
+		t.Fatalf("second delete starts at %v, want entry %d", deletes[1].Args[0], auditRetentionBatchSize+1)
+	}
+}
+
+func TestPruneBatchKeepsEntriesSinksHaveNotForwarded(t *testing.T) {
+	s := testRetentionService(t, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	s.archive = nil
+	cutoff := time.Date(2026, 3, 13, 12, 0, 0, 0, time.UTC)
+	entries := testChain(t, 3)
+	columns, rows := auditLogRows(entries)
+
+	t.Run("behind", func(t *testing.T) {
+		db := useFakeDB(t)
+		sinkCursor := cutoff.Add(-48 * time.Hour)
+		db.on([]string{`FROM "audit_sinks"`}, []string{"last_created_at"}, []driver.Value{sinkCursor})
+		db.on([]string{`SELECT * FROM "audit_logs"`}, columns, rows...)
+		if _, err := s.pruneBatch(context.Background(), testChainOrg, cutoff); err != nil {
+			t.Fatal(err)
+		}
+		sinks := db.statements(`FROM "audit_sinks"`)
+		if len(sinks) != 1 || !strings.Contains(sinks[0].SQL, "enabled") || !strings.Contains(sinks[0].SQL, `"deleted_at" IS NULL`) {
+			t.Fatalf("sink lookups = %v, want the enabled sinks checked", sinks)
+		}
+		selects := db.statements(`SELECT * FROM "audit_logs"`)
+		if len(selects) != 1 || selects[0].Args[1] != sinkCursor {
+			t.Fatalf("batch cutoff = %v, want the oldest sink cursor %v", selects[0].Args[1], sinkCursor)
+		}
+	})
+	t.Run("caught up", func(t *testing.T) {
+		db := useFakeDB(t)
+		db.on([]string{`FROM "audit_sinks"`}, []string{"last_created_at"}, []driver.Value{cutoff.Add(time.Hour)})
+		db.on([]string{`SELECT * FROM "audit_logs"`}, columns, rows...)
+		if _, err := s.pruneBatch(context.Background(), testChainOrg, cutoff); err != nil {
+			t.Fatal(err)
+		}
+		if selects := db.statements(`SELECT * FROM "audit_logs"`); len(selects) != 1 || selects[0].Args[1] != cutoff {
+			t.Fatalf("batch cutoff = %v, want the retention cutoff %v", selects[0].Args[1], cutoff)
+		}
+	})
+}
+
+func TestPruneBatchKeepsEntriesWhenArchiveFails(t *testing.T) {
+	db := useFakeDB(t)
+	entries := testChain(t, 3)
+	columns, rows := auditLogRows(entries)
+	db.on([]string{`SELECT * FROM "audit_logs"`}, columns, rows...)
+
+	var archived string
+	fail := true
+	s := testRetentionService(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		archived = string(body)
+		w.WriteHeader(http.StatusOK)
+	})
+	if pruned, err := s.pruneBatch(context.Background(), testChainOrg, time.Now()); err == nil || pruned != 0 {
+		t.Fatalf("pruneBatch() = %d, %v, want the failed upload reported", pruned, err)
+	}
+	if got := db.statements("DELETE"); len(got) != 0 {
+		t.Fatalf("entries deleted after a failed upload: %+v", got)
+	}
+
+	fail = false
+	if _, err := s.pruneBatch(context.Background(), testChainOrg, time.Now()); err != nil {
+		t.Fatalf("pruneBatch() returned %v", err)
+	}
+	for _, e := range entries {
+		if !strings.Contains(archived, e.ID.String()) {
+			t.Fatalf("entry %d deleted without being archived", e.Sequence)
+		}
+	}
+	if got := db.statements(`DELETE FROM "audit_logs"`); len(got) != 1 || len(got[0].Args) != 3 {
+		t.Fatalf("deletes = %+v, want the batch deleted once archived", got)
+	}
+}
+
+func TestPruneBatchRecordsSignedPrunedHead(t *testing.T) {
+	entries := testChain(t, 3)
+	headColumns := []string{"org_id", "sequence", "hash", "pruned_sequence", "pruned_hash"}
+
+	t.Run("advances", func(t *testing.T) {
+		db := useFakeDB(t)
+		columns, rows := auditLogRows(entries)
+		db.on([]string{`SELECT * FROM "audit_logs"`}, columns, rows...)
+		db.on([]string{`FROM "audit_chain_heads"`}, headColumns, []driver.Value{testChainOrg.String(), int64(9), "h", int64(0), ""})
+		s := testRetentionService(t, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+		if _, err := s.pruneBatch(context.Background(), testChainOrg, time.Now()); err != nil {
+			t.Fatalf("pruneBatch() returned %v", err)
+		}
+		updates := db.statements(`UPDATE "audit_chain_heads"`)
+		if len(updates) != 1 {
+			t.Fatalf("head updates = %+v, want one", updates)
+		}
+		head := models.AuditChainHead{OrgID: testChainOrg}
+		sequence, _ := updates[0].set("pruned_sequence")
+		head.PrunedSequence, _ = sequence.(int64)
+		head.PrunedHash = setString(updates[0], "pruned_hash")
+		head.PrunedPublicKey = setString(updates[0], "pruned_public_key")
+		head.PrunedSignature = setString(updates[0], "pruned_signature")
+		if at, ok := updates[0].set("pruned_at"); ok {
+			prunedAt := at.(time.Time)
+			head.PrunedAt = &prunedAt
+		}
+		if head.PrunedSequence != 3 || head.PrunedHash != entries[2].Hash {
+			t.Fatalf("pruned marker = %d %s, want the batch's last entry", head.PrunedSequence, head.PrunedHash)
+		}
+		head.Sequence, head.Hash = 3, entries[2].Hash
+		if report := verifyTestChain(nil, head, nil, s.chain.PublicKey()); !report.Valid {
+			t.Fatalf("pruned marker does not verify: %+v", report.Problems)
+		}
+		if commit, update := db.index("COMMIT"), db.index(`UPDATE "audit_chain_heads"`); commit < update || db.index("BEGIN") > db.index(`DELETE FROM "audit_logs"`) {
+			t.Fatal("delete and head update are not in one transaction")
+		}
+	})
+
+	t.Run("never moves back", func(t *testing.T) {
+		db := useFakeDB(t)
+		columns, rows := auditLogRows(entries)
+		db.on([]string{`SELECT * FROM "audit_logs"`}, columns, rows...)
+		db.on([]string{`FROM "audit_chain_heads"`}, headColumns, []driver.Value{testChainOrg.String(), int64(9), "h", int64(5), "x"})
+		s := testRetentionService(t, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+		if _, err := s.pruneBatch(context.Background(), testChainOrg, time.Now()); err != nil {
+			t.Fatalf("pruneBatch() returned %v", err)
+		}
+		if got := db.statements(`UPDATE "audit_chain_heads"`); len(got) != 0 {
+			t.Fatalf("head moved back: %+v", got)
+		}
+	})
+
+	t.Run("unchained entries", func(t *testing.T) {
+		db := useFakeDB(t)
+		legacy := testChain(t, 2)
+		for _, e := range legacy {
+			e.Sequence, e.PrevHash, e.Hash = 0, "", ""
+		}
+		columns, rows := auditLogRows(legacy)
+		db.on([]string{`SELECT * FROM "audit_logs"`}, columns, rows...)
+		s := testRetentionService(t, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+		if pruned, err := s.pruneBatch(context.Background(), testChainOrg, time.Now()); err != nil || pruned != 2 {
+			t.Fatalf("pruneBatch() = %d, %v", pruned, err)
+		}
+		if got := db.statements(`"audit_chain_heads"`); len(got) != 0 {
+			t.Fatalf("head touched for unchained entries: %+v", got)
+		}
+	})
+}
+
+func setString(stmt fakeStmt, column string) string {
+	v, _ := stmt.set(column)
+	s, _ := v.(string)
+	return s
+}
+
+func TestOrgLogQueriesApplyRetention(t *testing.T) {
+	db := useFakeDB(t)
+	tiers := NewTierService(time.Hour)
+	tiers.limits[string(models.TierFree)+":"+models.LimitTypeAuditRetentionDays] = cachedTierLimit{value: 7, expiresAt: time.Now().Add(time.Hour)}
+	audit := NewAuditService(tiers)
+	db.on([]string{"JOIN users ON users.id = organizations.owner_id"}, []string{"subscription_tier"}, []driver.Value{string(models.TierFree)})
+
+	checkCutoff := func(name string, stmt fakeStmt) {
+		t.Helper()
+		if !strings.Contains(stmt.SQL, "created_at >= $2") {
+			t.Fatalf("%s ignores retention: %s", name, stmt.SQL)
+		}
+		cutoff, _ := stmt.Args[1].(time.Time)
+		if want := time.Now().AddDate(0, 0, -7); cutoff.Sub(want).Abs() > time.Minute {
+			t.Fatalf("%s cutoff = %v, want about %v", name, cutoff, want)
+		}
+	}
+
+	if _, err := audit.SearchOrgLogs(context.Background(), testChainOrg, AuditLogFilter{}); err != nil {
+		t.Fatalf("SearchOrgLogs() returned %v", err)
+	}
+	searches := db.statements(`FROM "audit_logs"`)
+	if len(searches) != 1 {
+		t.Fatalf("searches = %+v", searches)
+	}
+	checkCutoff("SearchOrgLogs", searches[0])
+
+	if err := audit.ExportOrgLogs(context.Background(), testChainOrg, AuditLogFilter{}, func(AuditRecord) error { return nil }); err != nil {
+		t.Fatalf("ExportOrgLogs() returned %v", err)
+	}
+	exports := db.statements(`FROM "audit_logs"`)
+	if len(exports) != 2 {
+		t.Fatalf("exports = %+v", exports)
+	}
+	checkCutoff("ExportOrgLogs", exports[1])
+}
@@ -2,26 +2,32 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/envo/backend/internal/database"
 	"github.com/envo/backend/internal/models"
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // AuditService handles writing and querying audit logs
-type AuditService struct{}
+type AuditService struct {
+	tierService *TierService
+}
 
 // NewAuditService creates a new audit service
-func NewAuditService() *AuditService {
-	return &AuditService{}
+func NewAuditService(tierService *TierService) *AuditService {
+	return &AuditService{tierService: tierService}
 }
 
 // Log writes an audit log entry
 func (s *AuditService) Log(ctx context.Context, userID, orgID, resourceID uuid.UUID, action, resourceType, ip string, metadata datatypes.JSON) error {
-	db := database.GetDB().WithContext(ctx)
-
-	logEntry := &models.AuditLog{
+	return s.appendEntry(ctx, &models.AuditLog{
 		UserID:       &userID,
 		ActorType:    models.AuditActorHuman,
 		OrgID:        orgID,
@@ -30,14 +36,12 @@ func (s *AuditService) Log(ctx context.Context, userID, orgID, resourceID uuid.U
 		ResourceID:   resourceID,
 		Metadata:     metadata,
 		IPAddress:    ip,
-	}
-
-	return db.Create(logEntry).Error
+	})
 }
 
 // LogAgent writes an audit entry attributed to a non-human identity.
 func (s *AuditService) LogAgent(ctx context.Context, agentID, orgID, resourceID uuid.UUID, action, resourceType, ip string, metadata datatypes.JSON) error {
-	return database.GetDB().WithContext(ctx).Create(&models.AuditLog{
+	return s.appendEntry(ctx, &models.AuditLog{
 		AgentID:      &agentID,
 		ActorType:    models.AuditActorAgent,
 		OrgID:        orgID,
@@ -46,24 +50,233 @@ func (s *AuditService) LogAgent(ctx context.Context, agentID, orgID, resourceID
 		ResourceID:   resourceID,
 		Metadata:     metadata,
 		IPAddress:    ip,
-	}).Error
+	})
+}
+
+// LogSystem writes an audit entry for a change Envo made on its own, such
+// as an anomaly rule suspending an agent. metadata should name the rule.
+func (s *AuditService) LogSystem(ctx context.Context, orgID, resourceID uuid.UUID, action, resourceType string, metadata datatypes.JSON) error {
+	return s.appendEntry(ctx, &models.AuditLog{
+		ActorType:    models.AuditActorSystem,
+		OrgID:        orgID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Metadata:     metadata,
+	})
 }
 
-// ListOrgLogs lists audit logs for an organization (most recent first)
-func (s *AuditService) ListOrgLogs(orgID uuid.UUID, limit int) ([]models.AuditLog, error) {
-	db := database.GetDB()
+// Actor is who made a change: a human user, an agent when AgentID is set,
+// or Envo itself when neither is.
+type Actor struct {
+	UserID  uuid.UUID
+	AgentID uuid.UUID
+}
+
+// UserActor attributes a change to a human user.
+func UserActor(userID uuid.UUID) Actor {
+	return Actor{UserID: userID}
+}
+
+// AgentActor attributes a change to an agent identity.
+func AgentActor(agentID uuid.UUID) Actor {
+	return Actor{AgentID: agentID}
+}
+
+// SystemActor attributes a change to Envo itself.
+func SystemActor() Actor {
+	return Actor{}
+}
 
-	if limit <= 0 || limit > 500 {
-		limit = 100
+// IsSystem reports whether the actor is Envo itself.
+func (a Actor) IsSystem() bool {
+	return a.UserID == uuid.Nil && a.AgentID == uuid.Nil
+}
+
+// IsAgent reports whether the actor is an agent.
+func (a Actor) IsAgent() bool {
+	return a.AgentID != uuid.Nil
+}
+
+// LogActor writes an audit entry attributed to actor.
+func (s *AuditService) LogActor(ctx context.Context, actor Actor, orgID, resourceID uuid.UUID, action, resourceType, ip string, metadata datatypes.JSON) error {
+	if actor.IsAgent() {
+		return s.LogAgent(ctx, actor.AgentID, orgID, resourceID, action, resourceType, ip, metadata)
+	}
+	if actor.IsSystem() {
+		return s.LogSystem(ctx, orgID, resourceID, action, resourceType, metadata)
 	}
+	return s.Log(ctx, actor.UserID, orgID, resourceID, action, resourceType, ip, metadata)
+}
 
-	var logs []models.AuditLog
-	if err := db.Preload("User").Preload("Agent").Where("org_id = ?", orgID).
-		Order("created_at DESC").
-		Limit(limit).
-		Find(&logs).Error; err != nil {
-		return nil, err
+// LogUser writes an account-level entry, such as a sign-in, about subjectID.
+// Accounts belong to no organization, so the entry goes to the subject's
+// personal workspace, which every user owns.
+func (s *AuditService) LogUser(ctx context.Context, actorID, subjectID uuid.UUID, action, ip string, metadata datatypes.JSON) error {
+	var personal models.Organization
+	if err := database.GetDB().WithContext(ctx).
+		Where("owner_id = ? AND owner_type = ?", subjectID, models.OwnerTypePersonal).
+		First(&personal).Error; err != nil {
+		return err
 	}
+	return s.Log(ctx, actorID, personal.ID, subjectID, action, "user", ip, metadata)
+}
+
+// Audit log search bounds.
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 500
+)
+
+// ErrInvalidAuditFilter is returned for a malformed audit search.
+var ErrInvalidAuditFilter = errors.New("invalid audit log filter")
+
+// AuditLogFilter narrows an org's audit log. Zero fields do not filter.
+type AuditLogFilter struct {
+	ActorType    string
+	UserID       *uuid.UUID
+	AgentID      *uuid.UUID
+	Actions      []string
+	ResourceType string
+	ResourceID   *uuid.UUID
+	Since        *time.Time
+	Until        *time.Time
+	IP           string
+	// Query matches metadata text case-insensitively.
+	Query string
+	// Cursor continues from the NextCursor of a previous page.
+	Cursor string
+	Limit  int
+}
+
+// AuditLogPage is one page of audit entries, most recent first. NextCursor
+// is empty on the last page.
+type AuditLogPage struct {
+	Logs       []models.AuditLog
+	NextCursor string
+}
+
+// auditCursor is the position after the last entry of a page. Entries are
+// ordered by (created_at, id) so rows sharing a timestamp are neither
+// repeated nor skipped.
+type auditCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
 
-	return logs, nil
+func encodeAuditCursor(c auditCursor) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeAuditCursor(s string) (auditCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return auditCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidAuditFilter)
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return auditCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidAuditFilter)
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return auditCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidAuditFilter)
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return auditCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidAuditFilter)
+	}
+	return auditCursor{CreatedAt: createdAt, ID: parsed}, nil
+}
+
+// escapeLike escapes the LIKE wildcards in a literal search term.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// applyAuditFilter adds f's conditions, except the cursor and limit, to q.
+func applyAuditFilter(q *gorm.DB, f AuditLogFilter) (*gorm.DB, error) {
+	switch f.ActorType {
+	case "":
+	case models.AuditActorHuman, models.AuditActorAgent, models.AuditActorSystem:
+		q = q.Where("actor_type = ?", f.ActorType)
+	default:
+		return nil, fmt.Errorf("%w: actor_type must be %s, %s or %s", ErrInvalidAuditFilter, models.AuditActorHuman, models.AuditActorAgent, models.AuditActorSystem)
+	}
+	if f.UserID != nil {
+		q = q.Where("user_id = ?", *f.UserID)
+	}
+	if f.AgentID != nil {
+		q = q.Where("agent_id = ?", *f.AgentID)
+	}
+	if len(f.Actions) == 1 {
+		q = q.Where("action = ?", f.Actions[0])
+	} else if len(f.Actions) > 1 {
+		q = q.Where("action IN ?", f.Actions)
+	}
+	if f.ResourceType != "" {
+		q = q.Where("resource_type = ?", f.ResourceType)
+	}
+	if f.ResourceID != nil {
+		q = q.Where("resource_id = ?", *f.ResourceID)
+	}
+	if f.Since != nil && f.Until != nil && !f.Since.Before(*f.Until) {
+		return nil, fmt.Errorf("%w: since must be before until", ErrInvalidAuditFilter)
+	}
+	if f.Since != nil {
+		q = q.Where("created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		q = q.Where("created_at < ?", *f.Until)
+	}
+	if f.IP != "" {
+		q = q.Where("ip_address = ?", f.IP)
+	}
+	if f.Query != "" {
+		q = q.Where("metadata::text ILIKE ?", "%"+escapeLike(f.Query)+"%")
+	}
+	return q, nil
+}
+
+// SearchOrgLogs returns one page of an org's audit log matching f, most
+// recent first.
+func (s *AuditService) SearchOrgLogs(ctx context.Context, orgID uuid.UUID, f AuditLogFilter) (AuditLogPage, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultAuditPageSize
+	}
+	if limit > maxAuditPageSize {
+		limit = maxAuditPageSize
+	}
+
+	cutoff, err := s.RetentionCutoff(ctx, orgID)
+	if err != nil {
+		return AuditLogPage{}, err
+	}
+	q, err := applyAuditFilter(database.GetDB().WithContext(ctx).Preload("User").Preload("Agent").Where("org_id = ?", orgID), f)
+	if err != nil {
+		return AuditLogPage{}, err
+	}
+	if !cutoff.IsZero() {
+		q = q.Where("created_at >= ?", cutoff)
+	}
+	if f.Cursor != "" {
+		cursor, err := decodeAuditCursor(f.Cursor)
+		if err != nil {
+			return AuditLogPage{}, err
+		}
+		q = q.Where("(created_at, id) < (?, ?)", cursor.CreatedAt, cursor.ID)
+	}
+
+	var logs []models.AuditLog
+	if err := q.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&logs).Error; err != nil {
+		return AuditLogPage{}, err
+	}
+	page := AuditLogPage{Logs: logs}
+	if len(logs) > limit {
+		page.Logs = logs[:limit]
+		last := page.Logs[limit-1]
+		page.NextCursor = encodeAuditCursor(auditCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	return page, nil
 }
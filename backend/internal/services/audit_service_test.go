@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestAuditCursorRoundTrip(t *testing.T) {
+	want := auditCursor{CreatedAt: time.Date(2026, 3, 2, 10, 4, 5, 123456000, time.UTC), ID: uuid.New()}
+	got, err := decodeAuditCursor(encodeAuditCursor(want))
+	if err != nil {
+		t.Fatalf("decodeAuditCursor() returned %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Fatalf("decodeAuditCursor() = %+v, want %+v", got, want)
+	}
+	for _, bad := range []string{"not base64!", "bm8tc2VwYXJhdG9y", encodeAuditCursor(auditCursor{})[:8]} {
+		if _, err := decodeAuditCursor(bad); !errors.Is(err, ErrInvalidAuditFilter) {
+			t.Errorf("decodeAuditCursor(%q) error = %v, want ErrInvalidAuditFilter", bad, err)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`50%_off\`); got != `50\%\_off\\` {
+		t.Fatalf("escapeLike() = %q", got)
+	}
+}
+
+func TestApplyAuditFilter(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Now().Add(-time.Hour)
+	until := time.Now()
+	agentID := uuid.New()
+
+	q, err := applyAuditFilter(db.Model(&models.AuditLog{}), AuditLogFilter{
+		ActorType: models.AuditActorAgent,
+		AgentID:   &agentID,
+		Actions:   []string{models.ActionSecretRead, models.ActionSecretUpdate},
+		Since:     &since,
+		Until:     &until,
+		Query:     "deploy",
+	})
+	if err != nil {
+		t.Fatalf("applyAuditFilter() returned %v", err)
+	}
+	sql := q.Find(&[]models.AuditLog{}).Statement.SQL.String()
+	for _, want := range []string{"actor_type = $1", "agent_id = $2", "action IN ($3,$4)", "created_at >= $5", "created_at < $6", "metadata::text ILIKE $7"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("SQL %q does not contain %q", sql, want)
+		}
+	}
+
+	for name, f := range map[string]AuditLogFilter{
+		"actor type": {ActorType: "robot"},
+		"time range": {Since: &until, Until: &since},
+	} {
+		if _, err := applyAuditFilter(db.Model(&models.AuditLog{}), f); !errors.Is(err, ErrInvalidAuditFilter) {
+			t.Errorf("%s: applyAuditFilter() error = %v, want ErrInvalidAuditFilter", name, err)
+		}
+	}
+}
@@ -32,6 +32,7 @@ type GoogleUserInfo struct {
 type AuthService struct {
 	oauth2Config *oauth2.Config
 	jwtManager   *utils.JWTManager
+	auditService *AuditService
 }
 
 // refreshTokenHash returns the irreversible database representation of a
@@ -42,7 +43,7 @@ func refreshTokenHash(token string) string {
 }
 
 // NewAuthService creates a new auth service
-func NewAuthService(cfg *config.Config, jwtManager *utils.JWTManager) *AuthService {
+func NewAuthService(cfg *config.Config, jwtManager *utils.JWTManager, auditService *AuditService) *AuthService {
 	oauth2Config := &oauth2.Config{
 		ClientID:     cfg.GoogleClientID,
 		ClientSecret: cfg.GoogleClientSecret,
@@ -57,9 +58,20 @@ func NewAuthService(cfg *config.Config, jwtManager *utils.JWTManager) *AuthServi
 	return &AuthService{
 		oauth2Config: oauth2Config,
 		jwtManager:   jwtManager,
+		auditService: auditService,
 	}
 }
 
+// logSession audits a sign-in, refresh or sign-out in the user's personal
+// workspace, since a session is not tied to any one organization.
+func (s *AuthService) logSession(ctx context.Context, userID uuid.UUID, action, ip string, fields map[string]any) {
+	if s.auditService == nil {
+		return
+	}
+	metadata, _ := json.Marshal(fields)
+	_ = s.auditService.LogUser(ctx, userID, userID, action, ip, metadata)
+}
+
 // GetAuthURL returns the Google OAuth URL. Web login can request an account
 // chooser so signing out of Envo cannot look like an automatic sign-in caused
 // by Google's separate browser session.
@@ -72,7 +84,7 @@ func (s *AuthService) GetAuthURL(state string, selectAccount ...bool) string {
 }
 
 // HandleCallback handles the OAuth callback
-func (s *AuthService) HandleCallback(ctx context.Context, code string) (*models.User, string, string, error) {
+func (s *AuthService) HandleCallback(ctx context.Context, code string, ip string) (*models.User, string, string, error) {
 	// Exchange code for token
 	token, err := s.oauth2Config.Exchange(ctx, code)
 	if err != nil {
@@ -97,12 +109,18 @@ func (s *AuthService) HandleCallback(ctx context.Context, code string) (*models.
 		return nil, "", "", fmt.Errorf("failed to generate tokens: %w", err)
 	}
 
+	s.logSession(ctx, user.ID, models.ActionUserLogin, ip, map[string]any{"provider": "google"})
 	return user, accessToken, refreshToken, nil
 }
 
 // GenerateTokensForUser is used by the CLI exchange flow to mint tokens after a one-time login code.
-func (s *AuthService) GenerateTokensForUser(user *models.User) (string, string, error) {
-	return s.generateTokens(user)
+func (s *AuthService) GenerateTokensForUser(ctx context.Context, user *models.User, ip string) (string, string, error) {
+	accessToken, refreshToken, err := s.generateTokens(user)
+	if err != nil {
+		return "", "", err
+	}
+	s.logSession(ctx, user.ID, models.ActionUserLogin, ip, map[string]any{"provider": "google", "via": "cli_exchange"})
+	return accessToken, refreshToken, nil
 }
 
 // getUserInfo fetches user info from Google
@@ -238,7 +256,7 @@ func (s *AuthService) generateTokens(user *models.User) (string, string, error)
 }
 
 // RefreshAccessToken generates a new access token from a refresh token
-func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshTokenString string) (string, error) {
+func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshTokenString string, ip string) (string, error) {
 	db := database.GetDB().WithContext(ctx)
 
 	// New records are stored as hashes. The plaintext fallback keeps sessions
@@ -291,15 +309,27 @@ func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshTokenString
 		return "", err
 	}
 
+	s.logSession(ctx, user.ID, models.ActionUserTokenRefresh, ip, map[string]any{"refresh_token_id": refreshToken.ID})
 	return accessToken, nil
 }
 
-// Logout revokes a refresh token
-func (s *AuthService) Logout(ctx context.Context, refreshTokenString string) error {
+// Logout revokes a refresh token. An unknown token is not an error, so
+// logging out twice succeeds.
+func (s *AuthService) Logout(ctx context.Context, refreshTokenString string, ip string) error {
 	db := database.GetDB().WithContext(ctx)
 	tokenHash := refreshTokenHash(refreshTokenString)
 
-	return db.Model(&models.RefreshToken{}).
+	var tokens []models.RefreshToken
+	if err := db.Where("token IN ? AND revoked = ?", []string{tokenHash, refreshTokenString}, false).Find(&tokens).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.RefreshToken{}).
 		Where("token IN ?", []string{tokenHash, refreshTokenString}).
-		Updates(map[string]any{"token": tokenHash, "revoked": true}).Error
+		Updates(map[string]any{"token": tokenHash, "revoked": true}).Error; err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		s.logSession(ctx, token.UserID, models.ActionUserLogout, ip, map[string]any{"refresh_token_id": token.ID})
+	}
+	return nil
 }
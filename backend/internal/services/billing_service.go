@@ -1,6 +1,8 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -12,12 +14,13 @@ import (
 // BillingService orchestrates payment flows through a PaymentProvider.
 type BillingService struct {
 	provider     PaymentProvider
+	auditService *AuditService
 	frontendURL  string
 	pricingCache map[string]interface{}
 }
 
-func NewBillingService(provider PaymentProvider, frontendURL string) *BillingService {
-	return &BillingService{provider: provider, frontendURL: frontendURL, pricingCache: make(map[string]interface{})}
+func NewBillingService(provider PaymentProvider, auditService *AuditService, frontendURL string) *BillingService {
+	return &BillingService{provider: provider, auditService: auditService, frontendURL: frontendURL, pricingCache: make(map[string]interface{})}
 }
 
 func (s *BillingService) GetPricingCache() map[string]interface{} {
@@ -76,7 +79,7 @@ func (s *BillingService) VerifyStandardPayment(orderID, paymentID, signature str
 	return rp.VerifyStandardPaymentSignature(orderID, paymentID, signature)
 }
 
-func (s *BillingService) HandleWebhook(payload []byte, sigHeader string) error {
+func (s *BillingService) HandleWebhook(ctx context.Context, payload []byte, sigHeader string, ip string) error {
 	evt, err := s.provider.VerifyWebhookPayload(payload, sigHeader)
 	if err != nil {
 		return err
@@ -84,18 +87,18 @@ func (s *BillingService) HandleWebhook(payload []byte, sigHeader string) error {
 
 	switch evt.Type {
 	case EventSubscriptionActivated:
-		return s.onActivated(evt)
+		return s.onActivated(ctx, evt, ip)
 	case EventSubscriptionUpdated:
-		return s.onUpdated(evt)
+		return s.onUpdated(ctx, evt, ip)
 	case EventSubscriptionCancelled:
-		return s.onCancelled(evt)
+		return s.onCancelled(ctx, evt, ip)
 	default:
 		log.Printf("[billing] unhandled normalised event: %s", evt.Type)
 	}
 	return nil
 }
 
-func (s *BillingService) onActivated(evt WebhookEvent) error {
+func (s *BillingService) onActivated(ctx context.Context, evt WebhookEvent, ip string) error {
 	if evt.UserID == "" {
 		log.Printf("[billing] subscription activated but no user_id in metadata")
 		return nil
@@ -106,14 +109,13 @@ func (s *BillingService) onActivated(evt WebhookEvent) error {
 		return fmt.Errorf("invalid user_id: %w", err)
 	}
 
-	db := database.GetDB()
 	updates := map[string]interface{}{
 		"subscription_tier":    evt.Plan,
 		"subscription_status":  "active",
 		"payment_customer_id":  evt.CustomerID,
 	}
 
-	if err := db.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error; err != nil {
+	if err := s.updateSubscriptions(ctx, updates, ip, "id = ?", userID); err != nil {
 		return fmt.Errorf("update user after activation: %w", err)
 	}
 
@@ -121,28 +123,57 @@ func (s *BillingService) onActivated(evt WebhookEvent) error {
 	return nil
 }
 
-func (s *BillingService) onUpdated(evt WebhookEvent) error {
-	db := database.GetDB()
+func (s *BillingService) onUpdated(ctx context.Context, evt WebhookEvent, ip string) error {
 	updates := map[string]interface{}{
 		"subscription_status": evt.Status,
 	}
 	if evt.Plan != "" {
 		updates["subscription_tier"] = evt.Plan
 	}
-	if err := db.Model(&models.User{}).Where("payment_customer_id = ?", evt.CustomerID).Updates(updates).Error; err != nil {
+	if err := s.updateSubscriptions(ctx, updates, ip, "payment_customer_id = ?", evt.CustomerID); err != nil {
 		return fmt.Errorf("update subscription: %w", err)
 	}
 	return nil
 }
 
-func (s *BillingService) onCancelled(evt WebhookEvent) error {
-	db := database.GetDB()
+func (s *BillingService) onCancelled(ctx context.Context, evt WebhookEvent, ip string) error {
 	updates := map[string]interface{}{
 		"subscription_tier":   "free",
 		"subscription_status": "cancelled",
 	}
-	if err := db.Model(&models.User{}).Where("payment_customer_id = ?", evt.CustomerID).Updates(updates).Error; err != nil {
+	if err := s.updateSubscriptions(ctx, updates, ip, "payment_customer_id = ?", evt.CustomerID); err != nil {
 		return fmt.Errorf("cancel subscription: %w", err)
 	}
 	return nil
 }
+
+// updateSubscriptions applies updates to the users matching query and
+// audits each tier change in the user's personal workspace. The change is
+// attributed to the user whose payment caused it.
+func (s *BillingService) updateSubscriptions(ctx context.Context, updates map[string]interface{}, ip string, query string, args ...interface{}) error {
+	db := database.GetDB().WithContext(ctx)
+	var users []models.User
+	if err := db.Where(query, args...).Find(&users).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.User{}).Where(query, args...).Updates(updates).Error; err != nil {
+		return err
+	}
+	if s.auditService == nil {
+		return nil
+	}
+	tier, _ := updates["subscription_tier"].(string)
+	for _, user := range users {
+		if tier == "" || tier == user.SubscriptionTier {
+			continue
+		}
+		metadata, _ := json.Marshal(map[string]any{
+			"tier":                tier,
+			"previous_tier":       user.SubscriptionTier,
+			"subscription_status": updates["subscription_status"],
+			"source":              "billing_webhook",
+		})
+		_ = s.auditService.LogUser(ctx, user.ID, user.ID, models.ActionUserTierChange, ip, metadata)
+	}
+	return nil
+}
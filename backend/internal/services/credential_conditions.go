@@ -0,0 +1,233 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+)
+
+// Reasons an authenticated credential is refused. They are recorded on the
+// agent_auth_denied audit event.
+const (
+	DenialRevoked           = "revoked"
+	DenialExpired           = "expired"
+	DenialAgentInactive     = "agent_inactive"
+	DenialIPNotAllowed      = "ip_not_allowed"
+	DenialOutsideTimeWindow = "outside_time_window"
+	DenialMaxUsesExceeded   = "max_uses_exceeded"
+)
+
+const (
+	maxCredentialCIDRs       = 50
+	maxCredentialTimeWindows = 14
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// CredentialConditions restrict where, when and how often a credential may
+// be used. The zero value places no restriction.
+type CredentialConditions struct {
+	AllowedCIDRs []string
+	TimeWindows  []models.CredentialTimeWindow
+	TimeZone     string
+	MaxUses      int
+}
+
+// applyCredentialConditions validates conditions and stores them on credential.
+// Bare addresses are accepted as single-host networks.
+func applyCredentialConditions(credential *models.AgentCredential, in CredentialConditions) error {
+	if len(in.AllowedCIDRs) > maxCredentialCIDRs {
+		return fmt.Errorf("at most %d networks are allowed", maxCredentialCIDRs)
+	}
+	cidrs := make([]string, 0, len(in.AllowedCIDRs))
+	for _, raw := range in.AllowedCIDRs {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		prefix, err := parseCredentialCIDR(raw)
+		if err != nil {
+			return fmt.Errorf("invalid network %q", raw)
+		}
+		cidrs = append(cidrs, prefix.String())
+	}
+	slices.Sort(cidrs)
+	cidrs = slices.Compact(cidrs)
+
+	if len(in.TimeWindows) > maxCredentialTimeWindows {
+		return fmt.Errorf("at most %d time windows are allowed", maxCredentialTimeWindows)
+	}
+	windows := make([]models.CredentialTimeWindow, 0, len(in.TimeWindows))
+	for _, w := range in.TimeWindows {
+		days := make([]string, 0, len(w.Days))
+		for _, day := range w.Days {
+			day = strings.ToLower(strings.TrimSpace(day))
+			if _, ok := weekdayNames[day]; !ok {
+				return fmt.Errorf("unknown weekday %q; use mon, tue, wed, thu, fri, sat or sun", day)
+			}
+			days = append(days, day)
+		}
+		if _, err := parseClock(w.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(w.End); err != nil {
+			return err
+		}
+		if w.Start == w.End {
+			return fmt.Errorf("time window %s-%s is empty", w.Start, w.End)
+		}
+		windows = append(windows, models.CredentialTimeWindow{Days: days, Start: w.Start, End: w.End})
+	}
+
+	timeZone := strings.TrimSpace(in.TimeZone)
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(timeZone); err != nil || len(timeZone) > 64 {
+		return fmt.Errorf("unknown time zone %q", timeZone)
+	}
+	if in.MaxUses < 0 {
+		return fmt.Errorf("maximum uses cannot be negative")
+	}
+
+	encodedCIDRs, err := json.Marshal(cidrs)
+	if err != nil {
+		return err
+	}
+	encodedWindows, err := json.Marshal(windows)
+	if err != nil {
+		return err
+	}
+	credential.AllowedCIDRs = encodedCIDRs
+	credential.TimeWindows = encodedWindows
+	credential.TimeZone = timeZone
+	if in.MaxUses > 0 {
+		maxUses := in.MaxUses
+		credential.MaxUses = &maxUses
+	}
+	return nil
+}
+
+func parseCredentialCIDR(raw string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(raw); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(raw)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q; use HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// credentialDenial returns why credential may not be used from ip at now, or
+// "" when it may. The use count is enforced separately because it needs an
+// atomic update.
+func credentialDenial(credential *models.AgentCredential, ip string, now time.Time) string {
+	switch {
+	case credential.RevokedAt != nil:
+		return DenialRevoked
+	case credential.ExpiresAt != nil && !credential.ExpiresAt.After(now):
+		return DenialExpired
+	case credentialRetired(credential, now):
+		return DenialExpired
+	case credential.Agent.Status != models.AgentStatusActive:
+		return DenialAgentInactive
+	case !credentialAllowsIP(credential, ip):
+		return DenialIPNotAllowed
+	case !credentialAllowsTime(credential, now):
+		return DenialOutsideTimeWindow
+	}
+	return ""
+}
+
+func credentialAllowsIP(credential *models.AgentCredential, ip string) bool {
+	var cidrs []string
+	if len(credential.AllowedCIDRs) > 0 {
+		if err := json.Unmarshal(credential.AllowedCIDRs, &cidrs); err != nil {
+			return false
+		}
+	}
+	if len(cidrs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, raw := range cidrs {
+		if prefix, err := netip.ParsePrefix(raw); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func credentialAllowsTime(credential *models.AgentCredential, now time.Time) bool {
+	var windows []models.CredentialTimeWindow
+	if len(credential.TimeWindows) > 0 {
+		if err := json.Unmarshal(credential.TimeWindows, &windows); err != nil {
+			return false
+		}
+	}
+	if len(windows) == 0 {
+		return true
+	}
+	loc, err := time.LoadLocation(credential.TimeZone)
+	if err != nil {
+		return false
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	for _, w := range windows {
+		start, errStart := parseClock(w.Start)
+		end, errEnd := parseClock(w.End)
+		if errStart != nil || errEnd != nil {
+			continue
+		}
+		// For a window past midnight, the part after midnight belongs to the
+		// previous day's window.
+		day := local.Weekday()
+		inWindow := minute >= start && minute < end
+		if end <= start {
+			inWindow = minute >= start || minute < end
+			if minute < end {
+				day = (day + 6) % 7
+			}
+		}
+		if inWindow && windowIncludesDay(w.Days, day) {
+			return true
+		}
+	}
+	return false
+}
+
+func windowIncludesDay(days []string, day time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, name := range days {
+		if d, ok := weekdayNames[name]; ok && d == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+)
+
+func conditionedCredential(t *testing.T, in CredentialConditions) *models.AgentCredential {
+	t.Helper()
+	credential := &models.AgentCredential{Agent: models.AgentIdentity{Status: models.AgentStatusActive}}
+	if err := applyCredentialConditions(credential, in); err != nil {
+		t.Fatalf("applyCredentialConditions() error = %v", err)
+	}
+	return credential
+}
+
+func TestCredentialDenialNetworks(t *testing.T) {
+	credential := conditionedCredential(t, CredentialConditions{AllowedCIDRs: []string{"203.0.113.0/24", "2001:db8::1"}})
+	now := time.Now()
+	for ip, want := range map[string]string{
+		"203.0.113.7":        "",
+		"::ffff:203.0.113.7": "",
+		"2001:db8::1":        "",
+		"2001:db8::2":        DenialIPNotAllowed,
+		"198.51.100.1":       DenialIPNotAllowed,
+		"not-an-ip":          DenialIPNotAllowed,
+	} {
+		if got := credentialDenial(credential, ip, now); got != want {
+			t.Errorf("credentialDenial(%s) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestCredentialDenialTimeWindows(t *testing.T) {
+	credential := conditionedCredential(t, CredentialConditions{
+		TimeZone: "America/New_York",
+		TimeWindows: []models.CredentialTimeWindow{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "18:00"},
+			{Days: []string{"sat"}, Start: "22:00", End: "02:00"},
+		},
+	})
+	ny, _ := time.LoadLocation("America/New_York")
+	cases := map[string]struct {
+		at   time.Time
+		want string
+	}{
+		"weekday office hours":     {time.Date(2026, 3, 4, 10, 30, 0, 0, ny), ""},
+		"weekday evening":          {time.Date(2026, 3, 4, 18, 0, 0, 0, ny), DenialOutsideTimeWindow},
+		"sunday morning":           {time.Date(2026, 3, 8, 10, 0, 0, 0, ny), DenialOutsideTimeWindow},
+		"saturday night":           {time.Date(2026, 3, 7, 23, 0, 0, 0, ny), ""},
+		"after midnight into sun":  {time.Date(2026, 3, 8, 1, 0, 0, 0, ny), ""},
+		"after midnight into sat":  {time.Date(2026, 3, 7, 1, 0, 0, 0, ny), DenialOutsideTimeWindow},
+		"office hours seen in utc": {time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC), ""},
+	}
+	for name, tc := range cases {
+		if got := credentialDenial(credential, "192.0.2.1", tc.at); got != tc.want {
+			t.Errorf("%s: credentialDenial() = %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestCredentialDenialLifecycleComesFirst(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	credential := conditionedCredential(t, CredentialConditions{AllowedCIDRs: []string{"10.0.0.0/8"}})
+	credential.ExpiresAt = &past
+	if got := credentialDenial(credential, "192.0.2.1", now); got != DenialExpired {
+		t.Fatalf("credentialDenial() = %q, want %q", got, DenialExpired)
+	}
+	credential.RevokedAt = &past
+	if got := credentialDenial(credential, "192.0.2.1", now); got != DenialRevoked {
+		t.Fatalf("credentialDenial() = %q, want %q", got, DenialRevoked)
+	}
+}
+
+func TestApplyCredentialConditionsValidates(t *testing.T) {
+	invalid := map[string]CredentialConditions{
+		"bad network":  {AllowedCIDRs: []string{"10.0.0.0/33"}},
+		"bad weekday":  {TimeWindows: []models.CredentialTimeWindow{{Days: []string{"monday"}, Start: "09:00", End: "17:00"}}},
+		"bad time":     {TimeWindows: []models.CredentialTimeWindow{{Start: "9am", End: "17:00"}}},
+		"empty window": {TimeWindows: []models.CredentialTimeWindow{{Start: "09:00", End: "09:00"}}},
+		"bad zone":     {TimeZone: "Mars/Olympus_Mons"},
+		"negative":     {MaxUses: -1},
+	}
+	for name, in := range invalid {
+		if err := applyCredentialConditions(&models.AgentCredential{}, in); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	credential := conditionedCredential(t, CredentialConditions{AllowedCIDRs: []string{" 10.1.2.3/8 ", "10.0.0.0/8", "192.0.2.1"}, MaxUses: 3})
+	if string(credential.AllowedCIDRs) != `["10.0.0.0/8","192.0.2.1/32"]` {
+		t.Fatalf("AllowedCIDRs = %s", credential.AllowedCIDRs)
+	}
+	if credential.MaxUses == nil || *credential.MaxUses != 3 || credential.TimeZone != "UTC" {
+		t.Fatalf("MaxUses = %v, TimeZone = %q", credential.MaxUses, credential.TimeZone)
+	}
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DataKeyCache keeps plaintext KMS data keys in memory for a short time so
+// bulk exports and agent resolves do not pay one KMS round trip per secret.
+// Entries are scoped to a workspace, bounded in count, age, and number of
+// uses, and zeroized as soon as they leave the cache. Callers always receive
+// a copy of the key and must zero it after use.
+type DataKeyCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	maxUses    int
+	now        func() time.Time
+
+	// encrypt holds the active data key per workspace for new ciphertexts.
+	encrypt map[string]*dataKeyEntry
+	// decrypt maps workspace + encrypted data key blob to its plaintext.
+	decrypt map[string]*dataKeyEntry
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type dataKeyEntry struct {
+	plaintext []byte
+	blob      []byte
+	expiresAt time.Time
+	uses      int
+}
+
+// DataKeyCacheStats is a point-in-time snapshot of cache effectiveness.
+type DataKeyCacheStats struct {
+	Enabled   bool    `json:"enabled"`
+	Entries   int     `json:"entries"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+// NewDataKeyCache creates a cache. maxUses bounds how many encrypt or decrypt
+// operations a single cached key may serve before it is discarded.
+func NewDataKeyCache(ttl time.Duration, maxEntries, maxUses int) *DataKeyCache {
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	if maxUses <= 0 {
+		maxUses = 1000
+	}
+	return &DataKeyCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxUses:    maxUses,
+		now:        time.Now,
+		encrypt:    make(map[string]*dataKeyEntry),
+		decrypt:    make(map[string]*dataKeyEntry),
+	}
+}
+
+func decryptCacheKey(workspaceID string, blob []byte) string {
+	return workspaceID + "\x00" + string(blob)
+}
+
+// EncryptionKey returns a copy of the cached data key and its encrypted blob
+// for workspaceID, if a live one exists.
+func (c *DataKeyCache) EncryptionKey(workspaceID string) ([]byte, []byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	plaintext, blob, ok := c.take(c.encrypt, workspaceID)
+	c.record(ok)
+	return plaintext, blob, ok
+}
+
+// DecryptionKey returns a copy of the plaintext for an encrypted data key
+// previously seen in workspaceID.
+func (c *DataKeyCache) DecryptionKey(workspaceID string, blob []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	plaintext, _, ok := c.take(c.decrypt, decryptCacheKey(workspaceID, blob))
+	c.record(ok)
+	return plaintext, ok
+}
+
+// PutEncryptionKey stores a freshly generated data key as the workspace's
+// active encryption key. It is also made available for decryption so values
+// written with it can be read back without another KMS call.
+func (c *DataKeyCache) PutEncryptionKey(workspaceID string, plaintext, blob []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(c.encrypt, workspaceID, plaintext, blob)
+	c.put(c.decrypt, decryptCacheKey(workspaceID, blob), plaintext, blob)
+}
+
+// PutDecryptionKey stores the plaintext of an encrypted data key.
+func (c *DataKeyCache) PutDecryptionKey(workspaceID string, blob, plaintext []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(c.decrypt, decryptCacheKey(workspaceID, blob), plaintext, blob)
+}
+
+// Stats reports hit rate and current size.
+func (c *DataKeyCache) Stats() DataKeyCacheStats {
+	c.mu.Lock()
+	entries := len(c.encrypt) + len(c.decrypt)
+	c.mu.Unlock()
+	stats := DataKeyCacheStats{
+		Enabled:   true,
+		Entries:   entries,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+func (c *DataKeyCache) record(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// take must be called with c.mu held.
+func (c *DataKeyCache) take(entries map[string]*dataKeyEntry, key string) ([]byte, []byte, bool) {
+	entry, ok := entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	if !c.now().Before(entry.expiresAt) || entry.uses >= c.maxUses {
+		c.evict(entries, key, entry)
+		return nil, nil, false
+	}
+	entry.uses++
+	plaintext := append([]byte(nil), entry.plaintext...)
+	blob := append([]byte(nil), entry.blob...)
+	if entry.uses >= c.maxUses {
+		c.evict(entries, key, entry)
+	}
+	return plaintext, blob, true
+}
+
+// put must be called with c.mu held.
+func (c *DataKeyCache) put(entries map[string]*dataKeyEntry, key string, plaintext, blob []byte) {
+	if existing, ok := entries[key]; ok {
+		c.evict(entries, key, existing)
+	}
+	c.makeRoom()
+	entries[key] = &dataKeyEntry{
+		plaintext: append([]byte(nil), plaintext...),
+		blob:      append([]byte(nil), blob...),
+		expiresAt: c.now().Add(c.ttl),
+	}
+}
+
+// makeRoom drops expired entries and then the oldest entries until there is
+// space for one more. Must be called with c.mu held.
+func (c *DataKeyCache) makeRoom() {
+	if len(c.encrypt)+len(c.decrypt) < c.maxEntries {
+		return
+	}
+	now := c.now()
+	for _, entries := range []map[string]*dataKeyEntry{c.encrypt, c.decrypt} {
+		for key, entry := range entries {
+			if !now.Before(entry.expiresAt) {
+				c.evict(entries, key, entry)
+			}
+		}
+	}
+	for len(c.encrypt)+len(c.decrypt) >= c.maxEntries {
+		var (
+			oldestMap   map[string]*dataKeyEntry
+			oldestKey   string
+			oldestEntry *dataKeyEntry
+		)
+		for _, entries := range []map[string]*dataKeyEntry{c.encrypt, c.decrypt} {
+			for key, entry := range entries {
+				if oldestEntry == nil || entry.expiresAt.Before(oldestEntry.expiresAt) {
+					oldestMap, oldestKey, oldestEntry = entries, key, entry
+				}
+			}
+		}
+		if oldestEntry == nil {
+			return
+		}
+		c.evict(oldestMap, oldestKey, oldestEntry)
+	}
+}
+
+// evict must be called with c.mu held.
+func (c *DataKeyCache) evict(entries map[string]*dataKeyEntry, key string, entry *dataKeyEntry) {
+	zeroBytes(entry.plaintext)
+	delete(entries, key)
+	c.evictions.Add(1)
+}
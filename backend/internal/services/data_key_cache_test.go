@@ -0,0 +1,92 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDataKeyCacheServesEncryptAndDecryptFromOneKey(t *testing.T) {
+	cache := NewDataKeyCache(time.Minute, 10, 10)
+	cache.PutEncryptionKey("ws-a", []byte("plain-key"), []byte("blob"))
+
+	plaintext, blob, ok := cache.EncryptionKey("ws-a")
+	if !ok || !bytes.Equal(plaintext, []byte("plain-key")) || !bytes.Equal(blob, []byte("blob")) {
+		t.Fatalf("EncryptionKey() = %q, %q, %v", plaintext, blob, ok)
+	}
+	if decrypted, ok := cache.DecryptionKey("ws-a", []byte("blob")); !ok || !bytes.Equal(decrypted, []byte("plain-key")) {
+		t.Fatalf("DecryptionKey() = %q, %v", decrypted, ok)
+	}
+	if _, ok := cache.DecryptionKey("ws-b", []byte("blob")); ok {
+		t.Fatal("data key leaked across workspaces")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.HitRate < 0.66 || stats.HitRate > 0.67 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+}
+
+func TestDataKeyCacheReturnsCopies(t *testing.T) {
+	cache := NewDataKeyCache(time.Minute, 10, 10)
+	cache.PutDecryptionKey("ws", []byte("blob"), []byte("secret"))
+
+	first, _ := cache.DecryptionKey("ws", []byte("blob"))
+	zeroBytes(first)
+	second, ok := cache.DecryptionKey("ws", []byte("blob"))
+	if !ok || !bytes.Equal(second, []byte("secret")) {
+		t.Fatalf("zeroing a returned key corrupted the cache: %q", second)
+	}
+}
+
+func TestDataKeyCacheExpiresAndZeroizes(t *testing.T) {
+	now := time.Now()
+	cache := NewDataKeyCache(time.Minute, 10, 10)
+	cache.now = func() time.Time { return now }
+	cache.PutDecryptionKey("ws", []byte("blob"), []byte("secret"))
+	stored := cache.decrypt[decryptCacheKey("ws", []byte("blob"))].plaintext
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.DecryptionKey("ws", []byte("blob")); ok {
+		t.Fatal("expired key was served")
+	}
+	if !bytes.Equal(stored, make([]byte, len(stored))) {
+		t.Fatalf("evicted key was not zeroized: %q", stored)
+	}
+}
+
+func TestDataKeyCacheEnforcesMaxUses(t *testing.T) {
+	cache := NewDataKeyCache(time.Minute, 10, 2)
+	cache.PutEncryptionKey("ws", []byte("key"), []byte("blob"))
+
+	for i := 0; i < 2; i++ {
+		if _, _, ok := cache.EncryptionKey("ws"); !ok {
+			t.Fatalf("use %d was not served", i+1)
+		}
+	}
+	if _, _, ok := cache.EncryptionKey("ws"); ok {
+		t.Fatal("key served beyond its use limit")
+	}
+}
+
+func TestDataKeyCacheIsBounded(t *testing.T) {
+	now := time.Now()
+	cache := NewDataKeyCache(time.Minute, 2, 10)
+	cache.now = func() time.Time { return now }
+
+	cache.PutDecryptionKey("ws", []byte("first"), []byte("k1"))
+	now = now.Add(time.Second)
+	cache.PutDecryptionKey("ws", []byte("second"), []byte("k2"))
+	now = now.Add(time.Second)
+	cache.PutDecryptionKey("ws", []byte("third"), []byte("k3"))
+
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Fatalf("Stats() = %+v, want 2 entries and 1 eviction", stats)
+	}
+	if _, ok := cache.DecryptionKey("ws", []byte("first")); ok {
+		t.Fatal("oldest entry should have been evicted")
+	}
+	if _, ok := cache.DecryptionKey("ws", []byte("third")); !ok {
+		t.Fatal("newest entry should be cached")
+	}
+}
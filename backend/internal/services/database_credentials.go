@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var ErrDatabaseCredentialIssue = errors.New("failed to issue database credentials")
+
+const (
+	grantTemplateRolePlaceholder = "{{role}}"
+	databaseLeaseRolePrefix      = "envo_lease_"
+	databaseLeaseReapBatchSize   = 100
+)
+
+// Reasons recorded when a lease role is dropped.
+const (
+	DatabaseLeaseExpired = "expired"
+	DatabaseLeaseRevoked = "revoked"
+)
+
+// IssuedDatabaseCredential is one minted role, returned to the agent as if
+// it were a secret under Key.
+type IssuedDatabaseCredential struct {
+	Key       string
+	Value     string
+	RoleName  string
+	ExpiresAt time.Time
+	GrantID   uuid.UUID
+}
+
+// DatabaseCredentialService mints per-lease PostgreSQL roles for agents and
+// drops them once the lease expires or is revoked, or its grant or the agent
+// is revoked. Roles are created with VALID UNTIL as a backstop, but that only
+// blocks new logins, so dropping also terminates the role's open sessions.
+type DatabaseCredentialService struct {
+	secretService *SecretService
+	auditService  *AuditService
+	egress        egressGuard
+	now           func() time.Time
+}
+
+// NewDatabaseCredentialService creates the service. allowPrivateHosts lets
+// admin connections reach PostgreSQL on loopback or private addresses.
+func NewDatabaseCredentialService(secretService *SecretService, audit *AuditService, allowPrivateHosts bool) *DatabaseCredentialService {
+	return &DatabaseCredentialService{secretService: secretService, auditService: audit, egress: egressGuard{allowPrivate: allowPrivateHosts}, now: time.Now}
+}
+
+// renderGrantTemplate substitutes the quoted role name into a grant template.
+func renderGrantTemplate(template, role string) string {
+	return strings.ReplaceAll(template, grantTemplateRolePlaceholder, quotePostgresIdent(role))
+}
+
+func newDatabaseLeaseRole() (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return databaseLeaseRolePrefix + hex.EncodeToString(random), nil
+}
+
+// databaseLeaseExpiry is how long a role issued now for grant may live: its
+// TTL, but never past the grant's own expiry.
+func databaseLeaseExpiry(grant *models.AgentGrant, now time.Time) time.Time {
+	ttl := time.Duration(grant.CredentialTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultDatabaseCredentialTTL
+	}
+	expiresAt := now.Add(ttl)
+	if grant.ExpiresAt != nil && grant.ExpiresAt.Before(expiresAt) {
+		expiresAt = *grant.ExpiresAt
+	}
+	return expiresAt.Truncate(time.Second)
+}
+
+// Issue mints one role per database grant for a resolve lease. Either every
+// credential is issued or none are: roles created before a failure are dropped.
+func (s *DatabaseCredentialService) Issue(ctx context.Context, agent *models.AgentIdentity, access *AgentAccess, leaseID uuid.UUID, ip string) ([]IssuedDatabaseCredential, error) {
+	issued := make([]IssuedDatabaseCredential, 0, len(access.DatabaseGrants))
+	var leases []models.DatabaseLease
+	for i := range access.DatabaseGrants {
+		cred, lease, err := s.issueOne(ctx, agent, access.Environment, &access.DatabaseGrants[i], leaseID, ip)
+		if err != nil {
+			for j := range leases {
+				s.drop(context.WithoutCancel(ctx), &leases[j], DatabaseLeaseRevoked)
+			}
+			return nil, fmt.Errorf("%w for %s: %v", ErrDatabaseCredentialIssue, access.DatabaseGrants[i].CredentialKey, err)
+		}
+		issued = append(issued, *cred)
+		leases = append(leases, *lease)
+	}
+	return issued, nil
+}
+
+func (s *DatabaseCredentialService) issueOne(ctx context.Context, agent *models.AgentIdentity, envID uuid.UUID, grant *models.AgentGrant, leaseID uuid.UUID, ip string) (*IssuedDatabaseCredential, *models.DatabaseLease, error) {
+	if grant.AdminSecretID == nil {
+		return nil, nil, fmt.Errorf("grant has no admin connection")
+	}
+	admin, _, orgID, err := s.secretService.readSecretValue(ctx, *grant.AdminSecretID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read admin connection: %w", err)
+	}
+	if orgID != agent.OrgID {
+		return nil, nil, fmt.Errorf("admin connection belongs to another organization")
+	}
+	role, err := newDatabaseLeaseRole()
+	if err != nil {
+		return nil, nil, err
+	}
+	password, err := generatePostgresPassword()
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := postgresURLWithCredentials(admin, role, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("admin connection: %w", err)
+	}
+
+	lease := &models.DatabaseLease{
+		OrgID:         agent.OrgID,
+		AgentID:       agent.ID,
+		GrantID:       grant.ID,
+		LeaseID:       leaseID,
+		EnvironmentID: envID,
+		AdminSecretID: *grant.AdminSecretID,
+		RoleName:      role,
+		ExpiresAt:     databaseLeaseExpiry(grant, s.now().UTC()),
+	}
+	db := database.GetDB().WithContext(ctx)
+	if err := db.Create(lease).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if err := createLeaseRole(ctx, s.egress, admin, role, password, lease.ExpiresAt, grant.GrantTemplate); err != nil {
+		// Roles are created transactionally, so there is nothing to drop.
+		now := s.now().UTC()
+		_ = db.Model(lease).Updates(map[string]any{"dropped_at": now, "drop_error": err.Error()}).Error
+		return nil, nil, err
+	}
+
+	if s.auditService != nil {
+		metadata, _ := json.Marshal(map[string]any{
+			"lease_id":       leaseID,
+			"grant_id":       grant.ID,
+			"role":           role,
+			"credential_key": grant.CredentialKey,
+			"expires_at":     lease.ExpiresAt,
+		})
+		_ = s.auditService.LogAgent(ctx, agent.ID, agent.OrgID, lease.ID, models.ActionDatabaseLeaseIssue, "database_lease", ip, datatypes.JSON(metadata))
+	}
+	return &IssuedDatabaseCredential{
+		Key:       grant.CredentialKey,
+		Value:     value,
+		RoleName:  role,
+		ExpiresAt: lease.ExpiresAt,
+		GrantID:   grant.ID,
+	}, lease, nil
+}
+
+func createLeaseRole(ctx context.Context, guard egressGuard, adminConnection, role, password string, validUntil time.Time, template string) error {
+	admin, closeAdmin, err := openPostgres(ctx, guard, adminConnection)
+	if err != nil {
+		return fmt.Errorf("admin connection: %w", err)
+	}
+	defer closeAdmin()
+
+	return admin.Transaction(func(tx *gorm.DB) error {
+		create := fmt.Sprintf("CREATE ROLE %s WITH LOGIN NOINHERIT PASSWORD %s VALID UNTIL %s",
+			quotePostgresIdent(role), quotePostgresLiteral(password), quotePostgresLiteral(validUntil.UTC().Format(time.RFC3339)))
+		if err := tx.Exec(create).Error; err != nil {
+			return fmt.Errorf("failed to create role: %w", err)
+		}
+		if err := tx.Exec(renderGrantTemplate(template, role)).Error; err != nil {
+			return fmt.Errorf("grant template failed: %w", err)
+		}
+		return nil
+	})
+}
+
+func dropLeaseRole(ctx context.Context, guard egressGuard, adminConnection, role string) error {
+	admin, closeAdmin, err := openPostgres(ctx, guard, adminConnection)
+	if err != nil {
+		return fmt.Errorf("admin connection: %w", err)
+	}
+	defer closeAdmin()
+
+	var exists int64
+	if err := admin.Raw("SELECT count(*) FROM pg_roles WHERE rolname = ?", role).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if exists == 0 {
+		return nil
+	}
+	if err := admin.Exec("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE usename = ?", role).Error; err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	ident := quotePostgresIdent(role)
+	if err := admin.Exec("DROP OWNED BY " + ident).Error; err != nil {
+		return fmt.Errorf("failed to drop privileges: %w", err)
+	}
+	if err := admin.Exec("DROP ROLE IF EXISTS " + ident).Error; err != nil {
+		return fmt.Errorf("failed to drop role: %w", err)
+	}
+	return nil
+}
+
+// drop claims a lease and drops its role. A failed drop releases the claim so
+// the next reaper run retries it.
+func (s *DatabaseCredentialService) drop(ctx context.Context, lease *models.DatabaseLease, reason string) bool {
+	db := database.GetDB().WithContext(ctx)
+	now := s.now().UTC()
+	claim := db.Model(&models.DatabaseLease{}).Where("id = ? AND dropped_at IS NULL", lease.ID).UpdateColumn("dropped_at", now)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return false
+	}
+
+	err := func() error {
+		admin, _, _, err := s.secretService.readSecretValue(ctx, lease.AdminSecretID)
+		if err != nil {
+			return fmt.Errorf("failed to read admin connection: %w", err)
+		}
+		return dropLeaseRole(ctx, s.egress, admin, lease.RoleName)
+	}()
+	if err != nil {
+		_ = db.Model(&models.DatabaseLease{}).Where("id = ?", lease.ID).
+			Updates(map[string]any{"dropped_at": nil, "drop_error": err.Error()}).Error
+		log.Printf("[envo] database leases: failed to drop %s: %v", lease.RoleName, err)
+		return false
+	}
+	_ = db.Model(&models.DatabaseLease{}).Where("id = ?", lease.ID).UpdateColumn("drop_error", "").Error
+
+	if s.auditService != nil {
+		metadata, _ := json.Marshal(map[string]any{"lease_id": lease.LeaseID, "grant_id": lease.GrantID, "role": lease.RoleName, "reason": reason})
+		_ = s.auditService.LogAgent(ctx, lease.AgentID, lease.OrgID, lease.ID, models.ActionDatabaseLeaseRevoke, "database_lease", "", datatypes.JSON(metadata))
+	}
+	return true
+}
+
+// RevokeForAgent drops the live roles of an agent, or of one of its grants
+// when grantID is set. Failures are left for the reaper to retry.
+func (s *DatabaseCredentialService) RevokeForAgent(ctx context.Context, agentID uuid.UUID, grantID *uuid.UUID) int {
+	q := database.GetDB().WithContext(ctx).Where("agent_id = ? AND dropped_at IS NULL", agentID)
+	if grantID != nil {
+		q = q.Where("grant_id = ?", *grantID)
+	}
+	return s.revokeWhere(ctx, q)
+}
+
+// RevokeLease drops the live roles issued for one resolve lease.
+func (s *DatabaseCredentialService) RevokeLease(ctx context.Context, leaseID uuid.UUID) int {
+	return s.revokeWhere(ctx, database.GetDB().WithContext(ctx).Where("lease_id = ? AND dropped_at IS NULL", leaseID))
+}
+
+func (s *DatabaseCredentialService) revokeWhere(ctx context.Context, q *gorm.DB) int {
+	var leases []models.DatabaseLease
+	if err := q.Find(&leases).Error; err != nil {
+		log.Printf("[envo] database leases: %v", err)
+		return 0
+	}
+	dropped := 0
+	for i := range leases {
+		if s.drop(ctx, &leases[i], DatabaseLeaseRevoked) {
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// ExtendLease moves the VALID UNTIL of every live role issued for a resolve
+// lease to until, following a lease renewal.
+func (s *DatabaseCredentialService) ExtendLease(ctx context.Context, leaseID uuid.UUID, until time.Time) error {
+	db := database.GetDB().WithContext(ctx)
+	var leases []models.DatabaseLease
+	if err := db.Where("lease_id = ? AND dropped_at IS NULL", leaseID).Find(&leases).Error; err != nil {
+		return err
+	}
+	until = until.UTC().Truncate(time.Second)
+	for _, lease := range leases {
+		admin, _, _, err := s.secretService.readSecretValue(ctx, lease.AdminSecretID)
+		if err != nil {
+			return fmt.Errorf("failed to read admin connection: %w", err)
+		}
+		conn, closeConn, err := openPostgres(ctx, s.egress, admin)
+		if err != nil {
+			return fmt.Errorf("admin connection: %w", err)
+		}
+		stmt := fmt.Sprintf("ALTER ROLE %s VALID UNTIL %s", quotePostgresIdent(lease.RoleName), quotePostgresLiteral(until.Format(time.RFC3339)))
+		err = conn.Exec(stmt).Error
+		closeConn()
+		if err != nil {
+			return fmt.Errorf("failed to extend %s: %w", lease.RoleName, err)
+		}
+		if err := db.Model(&models.DatabaseLease{}).Where("id = ?", lease.ID).UpdateColumn("expires_at", until).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run drops expired and revoked lease roles every interval until ctx is cancelled.
+func (s *DatabaseCredentialService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if dropped, err := s.RunOnce(ctx); err != nil {
+			log.Printf("[envo] database leases: %v", err)
+		} else if dropped > 0 {
+			log.Printf("[envo] database leases: dropped %d roles", dropped)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce drops one batch of lease roles whose lease expired or whose grant,
+// agent or resolve lease is no longer active, and returns how many were
+// dropped. A role extended for a renewal that then failed to commit is
+// dropped when the unrenewed resolve lease runs out.
+func (s *DatabaseCredentialService) RunOnce(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	var leases []models.DatabaseLease
+	if err := database.GetDB().WithContext(ctx).
+		Where("dropped_at IS NULL").
+		Where(`expires_at <= ?
+			OR grant_id IN (SELECT id FROM agent_grants WHERE revoked_at IS NOT NULL OR deleted_at IS NOT NULL OR expires_at <= ?)
+			OR agent_id IN (SELECT id FROM agent_identities WHERE status <> ? OR deleted_at IS NOT NULL)
+			OR lease_id IN (SELECT id FROM agent_leases WHERE revoked_at IS NOT NULL OR expires_at <= ?)`,
+			now, now, models.AgentStatusActive, now).
+		Order("expires_at ASC").
+		Limit(databaseLeaseReapBatchSize).
+		Find(&leases).Error; err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	for i := range leases {
+		reason := DatabaseLeaseRevoked
+		if !leases[i].ExpiresAt.After(now) {
+			reason = DatabaseLeaseExpired
+		}
+		if s.drop(ctx, &leases[i], reason) {
+			dropped++
+		}
+	}
+	return dropped, nil
+}
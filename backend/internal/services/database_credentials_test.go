@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+)
+
+func TestRenderGrantTemplateQuotesRole(t *testing.T) {
+	got := renderGrantTemplate("GRANT app_read TO {{role}}; GRANT CONNECT ON DATABASE app TO {{role}}", "envo_lease_ab")
+	want := `GRANT app_read TO "envo_lease_ab"; GRANT CONNECT ON DATABASE app TO "envo_lease_ab"`
+	if got != want {
+		t.Fatalf("renderGrantTemplate() = %s", got)
+	}
+}
+
+func TestNewDatabaseLeaseRoleIsAValidUniqueRoleName(t *testing.T) {
+	a, err := newDatabaseLeaseRole()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newDatabaseLeaseRole()
+	if a == b || !strings.HasPrefix(a, databaseLeaseRolePrefix) || !validPostgresRole(a) {
+		t.Fatalf("newDatabaseLeaseRole() = %q, %q", a, b)
+	}
+}
+
+func TestDatabaseLeaseExpiryIsCappedByGrant(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	grant := &models.AgentGrant{CredentialTTL: 3600}
+	if got := databaseLeaseExpiry(grant, now); !got.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expiry = %v, want TTL", got)
+	}
+	grantEnd := now.Add(10 * time.Minute)
+	grant.ExpiresAt = &grantEnd
+	if got := databaseLeaseExpiry(grant, now); !got.Equal(grantEnd) {
+		t.Fatalf("expiry = %v, want grant expiry %v", got, grantEnd)
+	}
+	if got := databaseLeaseExpiry(&models.AgentGrant{}, now); !got.Equal(now.Add(defaultDatabaseCredentialTTL)) {
+		t.Fatalf("expiry without TTL = %v", got)
+	}
+}
+
+func TestOpenPostgresRefusesInternalHosts(t *testing.T) {
+	for _, dsn := range []string{"postgres://admin:pw@127.0.0.1:5432/app", "postgres://admin:pw@169.254.169.254/app", "postgresql://admin:pw@[fd00::1]:5432/app"} {
+		_, _, err := openPostgres(t.Context(), egressGuard{}, dsn)
+		if !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("openPostgres(%s) error = %v, want ErrPrivateAddress", dsn, err)
+		}
+	}
+}
+
+// TestLeaseRoleLifecycleAgainstServer creates and drops a lease role on a
+// real server. Set ENVO_TEST_POSTGRES_URL to a superuser URL to run it.
+func TestLeaseRoleLifecycleAgainstServer(t *testing.T) {
+	admin := os.Getenv("ENVO_TEST_POSTGRES_URL")
+	if admin == "" {
+		t.Skip("ENVO_TEST_POSTGRES_URL not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	guard := egressGuard{allowPrivate: true}
+
+	role, err := newDatabaseLeaseRole()
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := generatePostgresPassword()
+	if err := createLeaseRole(ctx, guard, admin, role, password, time.Now().Add(time.Hour), "GRANT pg_read_all_settings TO {{role}}"); err != nil {
+		t.Fatalf("createLeaseRole() error = %v", err)
+	}
+	dsn, _ := postgresURLWithCredentials(admin, role, password)
+	conn, closeConn, err := openPostgres(ctx, guard, dsn)
+	if err != nil {
+		t.Fatalf("lease role cannot log in: %v", err)
+	}
+	var user string
+	if err := conn.Raw("SELECT current_user").Scan(&user).Error; err != nil || user != role {
+		t.Fatalf("current_user = %q, %v", user, err)
+	}
+
+	// Dropping must also end the open session.
+	if err := dropLeaseRole(ctx, guard, admin, role); err != nil {
+		t.Fatalf("dropLeaseRole() error = %v", err)
+	}
+	closeConn()
+	if _, closeAgain, err := openPostgres(ctx, guard, dsn); err == nil {
+		closeAgain()
+		t.Fatal("dropped role can still log in")
+	}
+	if err := dropLeaseRole(ctx, guard, admin, role); err != nil {
+		t.Fatalf("dropping twice error = %v", err)
+	}
+
+	if err := createLeaseRole(ctx, guard, admin, role, password, time.Now().Add(time.Hour), "GRANT no_such_role TO {{role}}"); err == nil {
+		t.Fatal("bad template accepted")
+	}
+	db, closeDB, err := openPostgres(ctx, guard, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB()
+	var count int64
+	db.Raw("SELECT count(*) FROM pg_roles WHERE rolname = ?", role).Scan(&count)
+	if count != 0 {
+		t.Fatal("failed template left the role behind")
+	}
+}
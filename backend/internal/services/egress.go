@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned for an endpoint that resolves to a
+// loopback, private, link-local or otherwise internal address.
+var ErrPrivateAddress = errors.New("endpoint resolves to a private address")
+
+// egressGuard keeps connections to endpoints configured by organizations,
+// such as audit sinks, off Envo's own network. allowPrivate turns it off for
+// development, where such endpoints usually run on localhost.
+type egressGuard struct {
+	allowPrivate bool
+}
+
+// checkIP refuses addresses a public endpoint never has.
+func (g egressGuard) checkIP(ip net.IP) error {
+	if g.allowPrivate {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, ip)
+	}
+	return nil
+}
+
+// checkHost resolves host and refuses it if any address is internal. The
+// dialer checks again at connect time, since DNS can change in between.
+func (g egressGuard) checkHost(ctx context.Context, host string) error {
+	if g.allowPrivate {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return g.checkIP(ip)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err := g.checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialer returns a dialer that refuses to connect to internal addresses,
+// checked after resolution so a rebinding DNS answer cannot slip through.
+func (g egressGuard) dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return g.checkIP(ip)
+		},
+	}
+}
+
+// httpClient returns a client whose connections go through the guarded
+// dialer. It ignores proxy settings, which would hide the real target.
+func (g egressGuard) httpClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = g.dialer(10 * time.Second).DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestEgressGuardRefusesInternalAddresses(t *testing.T) {
+	guard := egressGuard{}
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:10.0.0.1"} {
+		if err := guard.checkIP(net.ParseIP(addr)); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("checkIP(%s) = %v, want ErrPrivateAddress", addr, err)
+		}
+	}
+	for _, addr := range []string{"8.8.8.8", "2606:4700:4700::1111"} {
+		if err := guard.checkIP(net.ParseIP(addr)); err != nil {
+			t.Errorf("checkIP(%s) = %v", addr, err)
+		}
+	}
+	if err := (egressGuard{allowPrivate: true}).checkIP(net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("development guard refused loopback: %v", err)
+	}
+}
+
+func TestEgressGuardChecksAtDialTime(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer server.Close()
+
+	_, err := egressGuard{}.httpClient(5 * time.Second).Get(server.URL)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("Get() error = %v, want ErrPrivateAddress", err)
+	}
+}
+
+func TestCreateSinkRefusesInternalTargets(t *testing.T) {
+	s := NewAuditForwardingService(nil, nil, nil, false)
+	for name, in := range map[string]AuditSinkInput{
+		"webhook":  {Name: "x", Kind: models.AuditSinkWebhook, Config: AuditSinkConfig{URL: "https://169.254.169.254/latest"}},
+		"loopback": {Name: "x", Kind: models.AuditSinkWebhook, Config: AuditSinkConfig{URL: "https://127.0.0.1:8080/hook"}},
+		"syslog":   {Name: "x", Kind: models.AuditSinkSyslog, Config: AuditSinkConfig{Address: "10.0.0.5:6514"}},
+		"s3":       {Name: "x", Kind: models.AuditSinkS3, Secret: "s", Config: AuditSinkConfig{Bucket: "audit", AccessKeyID: "AKID", Endpoint: "https://[fd00::1]:9000"}},
+	} {
+		_, _, err := s.CreateSink(context.Background(), uuid.Nil, uuid.Nil, in, "")
+		if !errors.Is(err, ErrInvalidAuditSink) || !strings.Contains(err.Error(), "private address") {
+			t.Errorf("%s: CreateSink() error = %v, want a private address refused", name, err)
+		}
+	}
+}
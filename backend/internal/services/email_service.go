@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/smtp"
 	"strings"
+	"time"
 
 	"github.com/envo/backend/internal/config"
 )
@@ -12,6 +13,58 @@ import (
 // EmailSender sends transactional emails.
 type EmailSender interface {
 	SendInvite(toEmail, orgName, inviterName, roleName, inviteURL string) error
+	SendSecretRotationReminder(toEmail, orgName string, secrets []SecretRotationNotice, reportURL string) error
+	SendAgentAccessRequest(toEmail, orgName string, request AgentAccessRequestNotice, reviewURL string) error
+	SendAgentCredentialExpiry(toEmail, orgName string, credentials []AgentCredentialExpiryNotice, manageURL string) error
+	SendAgentAnomalyAlert(toEmail, orgName string, alert AgentAnomalyNotice, manageURL string) error
+}
+
+// SecretRotationNotice is one secret listed in a rotation reminder. It never
+// carries the value.
+type SecretRotationNotice struct {
+	Key             string
+	ProjectName     string
+	EnvironmentName string
+	DueAt           time.Time
+	Expired         bool
+}
+
+// AgentAccessRequestNotice describes a pending agent access request to the
+// humans who can approve it.
+type AgentAccessRequestNotice struct {
+	AgentName       string
+	ProjectName     string
+	EnvironmentName string
+	Keys            []string
+	AllSecrets      bool
+	Purpose         string
+	Duration        time.Duration
+}
+
+func (n AgentAccessRequestNotice) scope() string {
+	if n.AllSecrets {
+		return "all secrets"
+	}
+	return strings.Join(n.Keys, ", ")
+}
+
+// AgentCredentialExpiryNotice is one agent credential that its agent's
+// rotation policy is about to retire. It never carries the token.
+type AgentCredentialExpiryNotice struct {
+	AgentName      string
+	CredentialName string
+	TokenPrefix    string
+	RetiresAt      time.Time
+}
+
+// AgentAnomalyNotice describes an anomaly rule firing on an agent's resolve.
+type AgentAnomalyNotice struct {
+	AgentName string
+	RuleName  string
+	Summary   string
+	IPAddress string
+	EventAt   time.Time
+	Suspended bool
 }
 
 // LogEmailSender is a safe fallback for dev/local environments.
@@ -22,6 +75,34 @@ func (s *LogEmailSender) SendInvite(toEmail, orgName, inviterName, roleName, inv
 	return nil
 }
 
+func (s *LogEmailSender) SendSecretRotationReminder(toEmail, orgName string, secrets []SecretRotationNotice, reportURL string) error {
+	keys := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, secret.ProjectName+"/"+secret.EnvironmentName+"/"+secret.Key)
+	}
+	log.Printf("[email] rotation reminder to=%s org=%q secrets=%s url=%s", toEmail, orgName, strings.Join(keys, ","), reportURL)
+	return nil
+}
+
+func (s *LogEmailSender) SendAgentAccessRequest(toEmail, orgName string, request AgentAccessRequestNotice, reviewURL string) error {
+	log.Printf("[email] agent access request to=%s org=%q agent=%q env=%s/%s keys=%q duration=%s url=%s", toEmail, orgName, request.AgentName, request.ProjectName, request.EnvironmentName, request.scope(), request.Duration, reviewURL)
+	return nil
+}
+
+func (s *LogEmailSender) SendAgentCredentialExpiry(toEmail, orgName string, credentials []AgentCredentialExpiryNotice, manageURL string) error {
+	names := make([]string, 0, len(credentials))
+	for _, credential := range credentials {
+		names = append(names, credential.AgentName+"/"+credential.CredentialName)
+	}
+	log.Printf("[email] agent credential expiry to=%s org=%q credentials=%s url=%s", toEmail, orgName, strings.Join(names, ","), manageURL)
+	return nil
+}
+
+func (s *LogEmailSender) SendAgentAnomalyAlert(toEmail, orgName string, alert AgentAnomalyNotice, manageURL string) error {
+	log.Printf("[email] agent anomaly to=%s org=%q agent=%q rule=%q summary=%q suspended=%t url=%s", toEmail, orgName, alert.AgentName, alert.RuleName, alert.Summary, alert.Suspended, manageURL)
+	return nil
+}
+
 // SMTPEmailSender sends invitations through SMTP.
 type SMTPEmailSender struct {
 	host      string
@@ -53,6 +134,58 @@ func (s *SMTPEmailSender) SendInvite(toEmail, orgName, inviterName, roleName, in
 		inviterName, orgName, roleName, inviteURL,
 	)
 
+	return s.send(toEmail, subject, body)
+}
+
+func (s *SMTPEmailSender) SendSecretRotationReminder(toEmail, orgName string, secrets []SecretRotationNotice, reportURL string) error {
+	subject := fmt.Sprintf("%d secrets in %s need rotation", len(secrets), orgName)
+	body := strings.Builder{}
+	body.WriteString(fmt.Sprintf("Hello,\n\nThese secrets in \"%s\" are expired or due for rotation:\n\n", orgName))
+	for _, secret := range secrets {
+		state := "due"
+		if secret.Expired {
+			state = "expired"
+		}
+		body.WriteString(fmt.Sprintf("  - %s (%s / %s): %s %s\n", secret.Key, secret.ProjectName, secret.EnvironmentName, state, secret.DueAt.UTC().Format("2006-01-02")))
+	}
+	body.WriteString(fmt.Sprintf("\nReview stale secrets:\n%s\n\n- Envo\n", reportURL))
+	return s.send(toEmail, subject, body.String())
+}
+
+func (s *SMTPEmailSender) SendAgentAccessRequest(toEmail, orgName string, request AgentAccessRequestNotice, reviewURL string) error {
+	subject := fmt.Sprintf("Agent %s is requesting access in %s", request.AgentName, orgName)
+	body := fmt.Sprintf(
+		"Hello,\n\nThe agent \"%s\" in \"%s\" is requesting access for %s:\n\n  Project:     %s\n  Environment: %s\n  Secrets:     %s\n  Purpose:     %s\n\nApprove or deny the request:\n%s\n\n- Envo\n",
+		request.AgentName, orgName, request.Duration, request.ProjectName, request.EnvironmentName, request.scope(), request.Purpose, reviewURL,
+	)
+	return s.send(toEmail, subject, body)
+}
+
+func (s *SMTPEmailSender) SendAgentCredentialExpiry(toEmail, orgName string, credentials []AgentCredentialExpiryNotice, manageURL string) error {
+	subject := fmt.Sprintf("%d agent credentials in %s must be rotated", len(credentials), orgName)
+	body := strings.Builder{}
+	body.WriteString(fmt.Sprintf("Hello,\n\nThese agent credentials in \"%s\" reach their agent's maximum age and will stop working:\n\n", orgName))
+	for _, credential := range credentials {
+		body.WriteString(fmt.Sprintf("  - %s / %s (%s...): %s\n", credential.AgentName, credential.CredentialName, credential.TokenPrefix, credential.RetiresAt.UTC().Format("2006-01-02 15:04 MST")))
+	}
+	body.WriteString(fmt.Sprintf("\nRotate them before then:\n%s\n\n- Envo\n", manageURL))
+	return s.send(toEmail, subject, body.String())
+}
+
+func (s *SMTPEmailSender) SendAgentAnomalyAlert(toEmail, orgName string, alert AgentAnomalyNotice, manageURL string) error {
+	subject := fmt.Sprintf("Unusual activity by agent %s in %s", alert.AgentName, orgName)
+	action := "The agent is still active."
+	if alert.Suspended {
+		action = "The agent has been suspended. Reactivate it once you have checked the activity."
+	}
+	body := fmt.Sprintf(
+		"Hello,\n\nThe anomaly rule \"%s\" flagged the agent \"%s\" in \"%s\":\n\n  %s\n  Address: %s\n  Time:    %s\n\n%s\n\nReview the agent:\n%s\n\n- Envo\n",
+		alert.RuleName, alert.AgentName, orgName, alert.Summary, alert.IPAddress, alert.EventAt.UTC().Format("2006-01-02 15:04 MST"), action, manageURL,
+	)
+	return s.send(toEmail, subject, body)
+}
+
+func (s *SMTPEmailSender) send(toEmail, subject, body string) error {
 	message := strings.Builder{}
 	message.WriteString(fmt.Sprintf("From: %s <%s>\r\n", s.fromName, s.fromEmail))
 	message.WriteString(fmt.Sprintf("To: %s\r\n", toEmail))
@@ -1,22 +1,42 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+
 	"github.com/envo/backend/internal/database"
 	"github.com/envo/backend/internal/models"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // EnvironmentService handles environment business logic
-type EnvironmentService struct{}
+type EnvironmentService struct {
+	auditService *AuditService
+}
 
 // NewEnvironmentService creates a new environment service
-func NewEnvironmentService() *EnvironmentService {
-	return &EnvironmentService{}
+func NewEnvironmentService(auditService *AuditService) *EnvironmentService {
+	return &EnvironmentService{auditService: auditService}
+}
+
+// logEnvironment audits a change to env under its project's organization.
+func (s *EnvironmentService) logEnvironment(ctx context.Context, db *gorm.DB, userID uuid.UUID, env *models.Environment, action, ip string, fields map[string]any) {
+	if s.auditService == nil {
+		return
+	}
+	var project models.Project
+	if err := db.First(&project, env.ProjectID).Error; err != nil {
+		return
+	}
+	fields["project_id"] = env.ProjectID
+	metadata, _ := json.Marshal(fields)
+	_ = s.auditService.Log(ctx, userID, project.OrgID, env.ID, action, "environment", ip, metadata)
 }
 
 // CreateEnvironment creates a new environment within a project
-func (s *EnvironmentService) CreateEnvironment(projectID uuid.UUID, name string) (*models.Environment, error) {
-	db := database.GetDB()
+func (s *EnvironmentService) CreateEnvironment(ctx context.Context, userID, projectID uuid.UUID, name, ip string) (*models.Environment, error) {
+	db := database.GetDB().WithContext(ctx)
 
 	env := &models.Environment{
 		ProjectID: projectID,
@@ -27,6 +47,7 @@ func (s *EnvironmentService) CreateEnvironment(projectID uuid.UUID, name string)
 		return nil, err
 	}
 
+	s.logEnvironment(ctx, db, userID, env, models.ActionEnvironmentCreate, ip, map[string]any{"name": env.Name})
 	return env, nil
 }
 
@@ -45,35 +66,44 @@ func (s *EnvironmentService) ListProjectEnvironments(projectID uuid.UUID) ([]mod
 }
 
 // UpdateEnvironment updates an environment's name
-func (s *EnvironmentService) UpdateEnvironment(envID uuid.UUID, name string) (*models.Environment, error) {
-	db := database.GetDB()
+func (s *EnvironmentService) UpdateEnvironment(ctx context.Context, userID, envID uuid.UUID, name, ip string) (*models.Environment, error) {
+	db := database.GetDB().WithContext(ctx)
 
 	var env models.Environment
 	if err := db.First(&env, envID).Error; err != nil {
 		return nil, err
 	}
 
+	previous := env.Name
 	env.Name = name
 	if err := db.Save(&env).Error; err != nil {
 		return nil, err
 	}
 
+	s.logEnvironment(ctx, db, userID, &env, models.ActionEnvironmentUpdate, ip, map[string]any{"name": env.Name, "previous_name": previous})
 	return &env, nil
 }
 
 // DeleteEnvironment deletes an environment and its secrets
-func (s *EnvironmentService) DeleteEnvironment(envID uuid.UUID) error {
-	db := database.GetDB()
+func (s *EnvironmentService) DeleteEnvironment(ctx context.Context, userID, envID uuid.UUID, ip string) error {
+	db := database.GetDB().WithContext(ctx)
 
-	// Delete secrets first, then the environment
-	if err := db.Where("environment_id = ?", envID).Delete(&models.Secret{}).Error; err != nil {
+	var env models.Environment
+	if err := db.First(&env, envID).Error; err != nil {
 		return err
 	}
 
+	// Delete secrets first, then the environment
+	deleted := db.Where("environment_id = ?", envID).Delete(&models.Secret{})
+	if deleted.Error != nil {
+		return deleted.Error
+	}
+
 	if err := db.Delete(&models.Environment{}, envID).Error; err != nil {
 		return err
 	}
 
+	s.logEnvironment(ctx, db, userID, &env, models.ActionEnvironmentDelete, ip, map[string]any{"name": env.Name, "secrets_deleted": deleted.RowsAffected})
 	return nil
 }
 
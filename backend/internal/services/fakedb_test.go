@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/envo/backend/internal/database"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeDB is a scripted database/sql driver behind GORM's postgres dialect,
+// so service code can run against database.GetDB() without a server. Each
+// statement is answered by the first rule whose fragments it all contains;
+// queries no rule matches return no rows and execs affect one row. Every
+// statement is recorded with its arguments for assertions.
+type fakeDB struct {
+	mu    sync.Mutex
+	rules []*fakeRule
+	log   []fakeStmt
+}
+
+// fakeRule answers statements containing every fragment in match.
+type fakeRule struct {
+	match    []string
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+	// times limits how often the rule answers; zero means always.
+	times int
+	used  int
+}
+
+// fakeStmt is a statement the code under test ran.
+type fakeStmt struct {
+	SQL  string
+	Args []any
+}
+
+var fakeDriverSeq atomic.Int64
+
+// useFakeDB points database.DB at a new fakeDB for the rest of the test.
+func useFakeDB(t *testing.T) *fakeDB {
+	t.Helper()
+	f := &fakeDB{}
+	name := fmt.Sprintf("envo-fake-%d", fakeDriverSeq.Add(1))
+	sql.Register(name, fakeDriver{f})
+	conn, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		_ = conn.Close()
+	})
+	return f
+}
+
+// on answers statements containing every fragment with rows of columns.
+func (f *fakeDB) on(match []string, columns []string, rows ...[]driver.Value) *fakeRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rule := &fakeRule{match: match, columns: columns, rows: rows, affected: 1}
+	f.rules = append(f.rules, rule)
+	return rule
+}
+
+// fail makes statements containing every fragment return err.
+func (f *fakeDB) fail(err error, match ...string) *fakeRule {
+	rule := f.on(match, nil)
+	rule.err = err
+	return rule
+}
+
+// statements returns the recorded statements containing every fragment.
+func (f *fakeDB) statements(match ...string) []fakeStmt {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeStmt
+	for _, stmt := range f.log {
+		if containsAll(stmt.SQL, match) {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+// index returns the position of the first recorded statement containing
+// every fragment, or -1.
+func (f *fakeDB) index(match ...string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, stmt := range f.log {
+		if containsAll(stmt.SQL, match) {
+			return i
+		}
+	}
+	return -1
+}
+
+// set returns the value an UPDATE statement assigns to column.
+func (s fakeStmt) set(column string) (any, bool) {
+	m := regexp.MustCompile(`"` + regexp.QuoteMeta(column) + `"=\$(\d+)`).FindStringSubmatch(s.SQL)
+	if m == nil {
+		return nil, false
+	}
+	n, _ := strconv.Atoi(m[1])
+	if n < 1 || n > len(s.Args) {
+		return nil, false
+	}
+	return s.Args[n-1], true
+}
+
+// inserted returns the value a single-row INSERT statement gives column.
+func (s fakeStmt) inserted(column string) (any, bool) {
+	open, end := strings.Index(s.SQL, "("), strings.Index(s.SQL, ")")
+	if open < 0 || end < open {
+		return nil, false
+	}
+	for i, name := range strings.Split(s.SQL[open+1:end], ",") {
+		if strings.Trim(name, `" `) == column && i < len(s.Args) {
+			return s.Args[i], true
+		}
+	}
+	return nil, false
+}
+
+func containsAll(s string, fragments []string) bool {
+	for _, fragment := range fragments {
+		if !strings.Contains(s, fragment) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeDB) answer(query string, args []driver.NamedValue) *fakeRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stmt := fakeStmt{SQL: query}
+	for _, arg := range args {
+		stmt.Args = append(stmt.Args, arg.Value)
+	}
+	f.log = append(f.log, stmt)
+	for _, rule := range f.rules {
+		if rule.times > 0 && rule.used >= rule.times {
+			continue
+		}
+		if containsAll(query, rule.match) {
+			rule.used++
+			return rule
+		}
+	}
+	return &fakeRule{affected: 1}
+}
+
+type fakeDriver struct{ db *fakeDB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{db: d.db}, nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fakeDB: prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.answer("BEGIN", nil)
+	return fakeTx{c.db}, nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rule := c.db.answer(query, args)
+	if rule.err != nil {
+		return nil, rule.err
+	}
+	return &fakeRows{columns: rule.columns, rows: rule.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rule := c.db.answer(query, args)
+	if rule.err != nil {
+		return nil, rule.err
+	}
+	return driver.RowsAffected(rule.affected), nil
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.db.answer("COMMIT", nil)
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.answer("ROLLBACK", nil)
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
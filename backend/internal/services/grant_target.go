@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GrantTarget is what a grant applies to: one environment, or every
+// environment of a project whose name matches EnvironmentPattern (all of
+// them when empty), including environments created later.
+type GrantTarget struct {
+	EnvironmentID      *uuid.UUID
+	ProjectID          *uuid.UUID
+	EnvironmentPattern string
+}
+
+// EnvironmentTarget targets a single environment.
+func EnvironmentTarget(envID uuid.UUID) GrantTarget {
+	return GrantTarget{EnvironmentID: &envID}
+}
+
+// checkGrantTarget validates a target and confirms it belongs to orgID.
+func checkGrantTarget(db *gorm.DB, orgID uuid.UUID, target GrantTarget) (GrantTarget, error) {
+	target.EnvironmentPattern = strings.TrimSpace(target.EnvironmentPattern)
+	switch {
+	case target.EnvironmentID != nil && target.ProjectID != nil:
+		return GrantTarget{}, fmt.Errorf("a grant targets either one environment or a project, not both")
+	case target.EnvironmentID != nil:
+		if target.EnvironmentPattern != "" {
+			return GrantTarget{}, fmt.Errorf("an environment pattern only applies to project grants")
+		}
+		var env models.Environment
+		if err := db.Joins("JOIN projects ON projects.id = environments.project_id").
+			Where("environments.id = ? AND projects.org_id = ?", *target.EnvironmentID, orgID).First(&env).Error; err != nil {
+			return GrantTarget{}, fmt.Errorf("environment does not belong to this organization")
+		}
+	case target.ProjectID != nil:
+		if len(target.EnvironmentPattern) > 100 || strings.ContainsAny(target.EnvironmentPattern, "?[]\\") {
+			return GrantTarget{}, fmt.Errorf("environment pattern must be at most 100 characters and may only use * as a wildcard")
+		}
+		var project models.Project
+		if err := db.Where("id = ? AND org_id = ?", *target.ProjectID, orgID).First(&project).Error; err != nil {
+			return GrantTarget{}, fmt.Errorf("project does not belong to this organization")
+		}
+	default:
+		return GrantTarget{}, fmt.Errorf("select an environment or a project")
+	}
+	return target, nil
+}
+
+// grantCoversEnvironment reports whether grant applies to env. Project grants
+// are matched by name at resolve time, so renamed and new environments are
+// picked up without touching the grant.
+func grantCoversEnvironment(grant *models.AgentGrant, env *models.Environment) bool {
+	if grant.EnvironmentID != nil {
+		return *grant.EnvironmentID == env.ID
+	}
+	if grant.ProjectID == nil || *grant.ProjectID != env.ProjectID {
+		return false
+	}
+	if grant.EnvironmentPattern == "" {
+		return true
+	}
+	return matchKeyPattern(strings.ToLower(grant.EnvironmentPattern), strings.ToLower(env.Name))
+}
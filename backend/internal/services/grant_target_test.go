@@ -0,0 +1,49 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestGrantCoversEnvironment(t *testing.T) {
+	projectID, otherProjectID := uuid.New(), uuid.New()
+	staging := &models.Environment{ID: uuid.New(), ProjectID: projectID, Name: "Staging-EU"}
+	production := &models.Environment{ID: uuid.New(), ProjectID: projectID, Name: "production"}
+	elsewhere := &models.Environment{ID: uuid.New(), ProjectID: otherProjectID, Name: "staging"}
+
+	cases := []struct {
+		name  string
+		grant models.AgentGrant
+		env   *models.Environment
+		want  bool
+	}{
+		{"environment grant", models.AgentGrant{EnvironmentID: &staging.ID}, staging, true},
+		{"environment grant elsewhere", models.AgentGrant{EnvironmentID: &staging.ID}, production, false},
+		{"whole project", models.AgentGrant{ProjectID: &projectID}, production, true},
+		{"pattern matches case-insensitively", models.AgentGrant{ProjectID: &projectID, EnvironmentPattern: "staging*"}, staging, true},
+		{"pattern excludes", models.AgentGrant{ProjectID: &projectID, EnvironmentPattern: "staging*"}, production, false},
+		{"other project", models.AgentGrant{ProjectID: &projectID}, elsewhere, false},
+		{"no target", models.AgentGrant{}, staging, false},
+	}
+	for _, tc := range cases {
+		if got := grantCoversEnvironment(&tc.grant, tc.env); got != tc.want {
+			t.Errorf("%s: grantCoversEnvironment = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCheckGrantTargetRejectsAmbiguousTargets(t *testing.T) {
+	envID, projectID := uuid.New(), uuid.New()
+	for name, target := range map[string]GrantTarget{
+		"both":                  {EnvironmentID: &envID, ProjectID: &projectID},
+		"neither":               {},
+		"pattern on env":        {EnvironmentID: &envID, EnvironmentPattern: "prod*"},
+		"unsupported wildcards": {ProjectID: &projectID, EnvironmentPattern: "prod?"},
+	} {
+		if _, err := checkGrantTarget(nil, uuid.New(), target); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/envo/backend/internal/models"
+)
+
+// KeyFilter decides which secret keys may be decrypted. A nil filter allows
+// every key.
+type KeyFilter func(key string) bool
+
+// KeyScope is the set of secret keys one grant covers: exact names or glob
+// patterns where `*` matches any run of characters, or every key when All is
+// set. Deny patterns win over everything else in the same scope.
+type KeyScope struct {
+	All   bool
+	Allow []string
+	Deny  []string
+}
+
+// Matches reports whether key is in the scope.
+func (s KeyScope) Matches(key string) bool {
+	for _, pattern := range s.Deny {
+		if matchKeyPattern(pattern, key) {
+			return false
+		}
+	}
+	if s.All {
+		return true
+	}
+	for _, pattern := range s.Allow {
+		if matchKeyPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// exactKeys returns the key names scopes can match when every scope lists
+// exact names only, so they can be looked up directly. It reports false when
+// any scope covers all keys or allows a pattern.
+func exactKeys(scopes []KeyScope) ([]string, bool) {
+	set := make(map[string]struct{})
+	for _, scope := range scopes {
+		if scope.All {
+			return nil, false
+		}
+		for _, key := range scope.Allow {
+			if isKeyPattern(key) {
+				return nil, false
+			}
+			set[key] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, true
+}
+
+// matchKeyPattern matches key against a pattern whose only wildcard is `*`.
+func matchKeyPattern(pattern, key string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == key
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(key, parts[0]) {
+		return false
+	}
+	rest := key[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	return len(rest) >= len(last) && strings.HasSuffix(rest, last)
+}
+
+func isKeyPattern(key string) bool {
+	return strings.Contains(key, "*")
+}
+
+// normalizeKeys trims, deduplicates and sorts key names and patterns. Only
+// `*` is a wildcard; a bare `*` is rejected so that access to every secret
+// stays an explicit choice.
+func normalizeKeys(keys []string) ([]string, error) {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if len(key) > 255 {
+			return nil, fmt.Errorf("key %q is longer than 255 characters", key[:32]+"...")
+		}
+		if strings.ContainsAny(key, "?[]\\") {
+			return nil, fmt.Errorf("key pattern %q may only use * as a wildcard", key)
+		}
+		if strings.Trim(key, "*") == "" {
+			return nil, fmt.Errorf("key pattern %q matches every secret; allow all secrets explicitly instead", key)
+		}
+		set[key] = struct{}{}
+	}
+	out := make([]string, 0, len(set))
+	for key := range set {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// grantKeyScope decodes the key scope stored on a secrets.inject grant.
+func grantKeyScope(grant *models.AgentGrant) (KeyScope, error) {
+	scope := KeyScope{All: grant.AllowAllSecrets}
+	if err := json.Unmarshal(grant.AllowedKeys, &scope.Allow); err != nil {
+		return KeyScope{}, fmt.Errorf("invalid stored grant policy: %w", err)
+	}
+	if len(grant.DeniedKeys) > 0 {
+		if err := json.Unmarshal(grant.DeniedKeys, &scope.Deny); err != nil {
+			return KeyScope{}, fmt.Errorf("invalid stored grant policy: %w", err)
+		}
+	}
+	return scope, nil
+}
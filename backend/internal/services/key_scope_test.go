@@ -0,0 +1,91 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/envo/backend/internal/models"
+	"gorm.io/datatypes"
+)
+
+func TestMatchKeyPattern(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"DATABASE_URL", "DATABASE_URL", true},
+		{"DATABASE_URL", "DATABASE_URL_2", false},
+		{"STRIPE_*", "STRIPE_SECRET_KEY", true},
+		{"STRIPE_*", "STRIPE_", true},
+		{"STRIPE_*", "OLD_STRIPE_KEY", false},
+		{"*_TEST_KEY", "STRIPE_TEST_KEY", true},
+		{"*_TEST_KEY", "STRIPE_TEST_KEY_2", false},
+		{"AWS_*_KEY", "AWS_SECRET_ACCESS_KEY", true},
+		{"AWS_*_KEY", "AWS_KEY", false},
+		{"*_KEY_*", "A_KEY_KEY_B", true},
+		{"A*A", "A", false},
+		{"A*A", "AA", true},
+	}
+	for _, tc := range cases {
+		if got := matchKeyPattern(tc.pattern, tc.key); got != tc.want {
+			t.Errorf("matchKeyPattern(%q, %q) = %v, want %v", tc.pattern, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestKeyScopeDenyWins(t *testing.T) {
+	scope := KeyScope{Allow: []string{"STRIPE_*", "DATABASE_URL"}, Deny: []string{"*_LIVE_*"}}
+	for key, want := range map[string]bool{
+		"STRIPE_TEST_KEY":   true,
+		"STRIPE_LIVE_KEY":   false,
+		"DATABASE_URL":      true,
+		"SENTRY_DSN":        false,
+		"DATABASE_LIVE_URL": false,
+	} {
+		if got := scope.Matches(key); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", key, got, want)
+		}
+	}
+	all := KeyScope{All: true, Deny: []string{"PROD_*"}}
+	if !all.Matches("ANYTHING") || all.Matches("PROD_DB") {
+		t.Fatal("deny patterns must also restrict all-secrets scopes")
+	}
+}
+
+func TestNormalizeKeysRejectsUnsupportedPatterns(t *testing.T) {
+	for _, key := range []string{"*", "**", "KEY_?", "KEY_[AB]", `KEY\*`} {
+		if _, err := normalizeKeys([]string{key}); err == nil {
+			t.Errorf("normalizeKeys(%q) accepted an unsupported pattern", key)
+		}
+	}
+}
+
+func TestAgentAccessUnionsGrantScopes(t *testing.T) {
+	grant := &models.AgentGrant{AllowedKeys: datatypes.JSON(`["STRIPE_*"]`), DeniedKeys: datatypes.JSON(`["STRIPE_LIVE_*"]`)}
+	scope, err := grantKeyScope(grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := &AgentAccess{Scopes: []KeyScope{scope, {Allow: []string{"STRIPE_LIVE_PUBLISHABLE"}}}}
+	if !access.AllowsKey("STRIPE_TEST_KEY") || !access.AllowsKey("STRIPE_LIVE_PUBLISHABLE") || access.AllowsKey("STRIPE_LIVE_SECRET") {
+		t.Fatal("a deny pattern in one grant must not block keys another grant allows")
+	}
+}
+
+func TestExactKeys(t *testing.T) {
+	keys, ok := exactKeys([]KeyScope{{Allow: []string{"B", "A"}, Deny: []string{"A_*"}}, {Allow: []string{"A"}}})
+	if !ok || !reflect.DeepEqual(keys, []string{"A", "B"}) {
+		t.Fatalf("exactKeys() = %v, %v, want [A B], true", keys, ok)
+	}
+	if keys, ok := exactKeys(nil); !ok || keys == nil || len(keys) != 0 {
+		t.Fatalf("exactKeys(nil) = %#v, %v, want an empty non-nil list", keys, ok)
+	}
+	for _, scopes := range [][]KeyScope{
+		{{Allow: []string{"A"}}, {Allow: []string{"STRIPE_*"}}},
+		{{Allow: []string{"A"}}, {All: true}},
+	} {
+		if keys, ok := exactKeys(scopes); ok || keys != nil {
+			t.Fatalf("exactKeys(%+v) = %v, %v, want a full scan", scopes, keys, ok)
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 type KMSService struct {
 	client *kms.Client
 	keyID  string
+	cache  *DataKeyCache // nil when KMS_DATA_KEY_CACHE_ENABLED=false
 }
 
 // NewKMSService creates a new KMS service
@@ -46,30 +47,85 @@ func NewKMSService(cfg *config.Config) (*KMSService, error) {
 	// Create KMS client
 	client := kms.NewFromConfig(awsCfg)
 
-	return &KMSService{
+	service := &KMSService{
 		client: client,
 		keyID:  cfg.AWSKMSKeyID,
-	}, nil
+	}
+	if cfg.KMSDataKeyCacheEnabled {
+		service.cache = NewDataKeyCache(cfg.KMSDataKeyCacheTTL, cfg.KMSDataKeyCacheMaxEntries, cfg.KMSDataKeyCacheMaxUses)
+	}
+	return service, nil
 }
 
-// Encrypt encrypts plaintext using envelope encryption.
-// workspaceID is accepted for interface conformance; KMS uses its own key hierarchy.
-func (s *KMSService) Encrypt(ctx context.Context, plaintext string, workspaceID string) (string, error) {
-	encCtx := kmsContext(workspaceID)
-
-	// Step 1: Generate a data key from KMS
+// dataKeyForEncrypt returns a plaintext data key and its KMS-encrypted blob,
+// reusing the workspace's cached key when one is live.
+func (s *KMSService) dataKeyForEncrypt(ctx context.Context, workspaceID string) ([]byte, []byte, error) {
+	if s.cache != nil {
+		if plaintext, blob, ok := s.cache.EncryptionKey(workspaceID); ok {
+			return plaintext, blob, nil
+		}
+	}
 	dataKeyOutput, err := s.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
 		KeyId:             aws.String(s.keyID),
 		KeySpec:           "AES_256",
-		EncryptionContext: encCtx,
+		EncryptionContext: kmsContext(workspaceID),
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to generate data key: %w", err)
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	if s.cache != nil {
+		s.cache.PutEncryptionKey(workspaceID, dataKeyOutput.Plaintext, dataKeyOutput.CiphertextBlob)
 	}
-	defer zeroBytes(dataKeyOutput.Plaintext)
+	return dataKeyOutput.Plaintext, dataKeyOutput.CiphertextBlob, nil
+}
+
+// dataKeyForDecrypt unwraps an encrypted data key, consulting the cache first.
+func (s *KMSService) dataKeyForDecrypt(ctx context.Context, blob []byte, workspaceID string) ([]byte, error) {
+	if s.cache != nil {
+		if plaintext, ok := s.cache.DecryptionKey(workspaceID, blob); ok {
+			return plaintext, nil
+		}
+	}
+	decryptOutput, err := s.client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    blob,
+		EncryptionContext: kmsContext(workspaceID),
+	})
+	if err != nil {
+		// Backward-compat for old data keys encrypted without KMS encryption context.
+		decryptOutput, err = s.client.Decrypt(ctx, &kms.DecryptInput{
+			CiphertextBlob: blob,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	if s.cache != nil {
+		s.cache.PutDecryptionKey(workspaceID, blob, decryptOutput.Plaintext)
+	}
+	return decryptOutput.Plaintext, nil
+}
+
+// CacheStats reports data-key cache effectiveness. A disabled cache reports
+// Enabled=false with zero counters.
+func (s *KMSService) CacheStats() DataKeyCacheStats {
+	if s.cache == nil {
+		return DataKeyCacheStats{}
+	}
+	return s.cache.Stats()
+}
+
+// Encrypt encrypts plaintext using envelope encryption.
+// workspaceID is accepted for interface conformance; KMS uses its own key hierarchy.
+func (s *KMSService) Encrypt(ctx context.Context, plaintext string, workspaceID string) (string, error) {
+	// Step 1: Obtain a data key from the cache or KMS
+	dataKey, dataKeyBlob, err := s.dataKeyForEncrypt(ctx, workspaceID)
+	if err != nil {
+		return "", err
+	}
+	defer zeroBytes(dataKey)
 
 	// Step 2: Encrypt the plaintext with the data key using AES-GCM
-	block, err := aes.NewCipher(dataKeyOutput.Plaintext)
+	block, err := aes.NewCipher(dataKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
@@ -89,7 +145,7 @@ func (s *KMSService) Encrypt(ctx context.Context, plaintext string, workspaceID
 	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(workspaceID))
 
 	// Step 3: Encode encrypted data key and ciphertext
-	encryptedDataKey := base64.StdEncoding.EncodeToString(dataKeyOutput.CiphertextBlob)
+	encryptedDataKey := base64.StdEncoding.EncodeToString(dataKeyBlob)
 	encryptedValue := base64.StdEncoding.EncodeToString(ciphertext)
 
 	// Return in format: encryptedDataKey:encryptedValue
@@ -121,24 +177,15 @@ func (s *KMSService) Decrypt(ctx context.Context, encryptedData string, workspac
 		return "", fmt.Errorf("failed to decode ciphertext: %w", err)
 	}
 
-	// Step 1: Decrypt the data key using KMS
-	decryptOutput, err := s.client.Decrypt(ctx, &kms.DecryptInput{
-		CiphertextBlob:    encryptedKeyBytes,
-		EncryptionContext: kmsContext(workspaceID),
-	})
-	if err != nil {
-		// Backward-compat for old data keys encrypted without KMS encryption context.
-		decryptOutput, err = s.client.Decrypt(ctx, &kms.DecryptInput{
-			CiphertextBlob: encryptedKeyBytes,
-		})
-	}
+	// Step 1: Decrypt the data key using the cache or KMS
+	dataKey, err := s.dataKeyForDecrypt(ctx, encryptedKeyBytes, workspaceID)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt data key: %w", err)
+		return "", err
 	}
-	defer zeroBytes(decryptOutput.Plaintext)
+	defer zeroBytes(dataKey)
 
 	// Step 2: Decrypt the ciphertext with the data key
-	block, err := aes.NewCipher(decryptOutput.Plaintext)
+	block, err := aes.NewCipher(dataKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
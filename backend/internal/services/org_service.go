@@ -1,9 +1,11 @@
 package services
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -16,14 +18,15 @@ import (
 
 // OrgService handles organization business logic
 type OrgService struct {
-	tierService *TierService
-	emailSender EmailSender
-	frontendURL string
-	inviteTTL   time.Duration
+	tierService  *TierService
+	auditService *AuditService
+	emailSender  EmailSender
+	frontendURL  string
+	inviteTTL    time.Duration
 }
 
 // NewOrgService creates a new organization service
-func NewOrgService(tierService *TierService, emailSender EmailSender, frontendURL string, inviteTTLHours int) *OrgService {
+func NewOrgService(tierService *TierService, auditService *AuditService, emailSender EmailSender, frontendURL string, inviteTTLHours int) *OrgService {
 	if inviteTTLHours <= 0 {
 		inviteTTLHours = 168
 	}
@@ -31,15 +34,26 @@ func NewOrgService(tierService *TierService, emailSender EmailSender, frontendUR
 		emailSender = &LogEmailSender{}
 	}
 	return &OrgService{
-		tierService: tierService,
-		emailSender: emailSender,
-		frontendURL: strings.TrimRight(frontendURL, "/"),
-		inviteTTL:   time.Duration(inviteTTLHours) * time.Hour,
+		tierService:  tierService,
+		auditService: auditService,
+		emailSender:  emailSender,
+		frontendURL:  strings.TrimRight(frontendURL, "/"),
+		inviteTTL:    time.Duration(inviteTTLHours) * time.Hour,
 	}
 }
 
+// logOrg audits a change to an organization, its members, roles or
+// invitations.
+func (s *OrgService) logOrg(ctx context.Context, userID, orgID, resourceID uuid.UUID, action, resourceType, ip string, fields map[string]any) {
+	if s.auditService == nil {
+		return
+	}
+	metadata, _ := json.Marshal(fields)
+	_ = s.auditService.Log(ctx, userID, orgID, resourceID, action, resourceType, ip, metadata)
+}
+
 // CreateOrganization creates a new organization
-func (s *OrgService) CreateOrganization(ownerID uuid.UUID, name string) (*models.Organization, error) {
+func (s *OrgService) CreateOrganization(ctx context.Context, ownerID uuid.UUID, name string, ip string) (*models.Organization, error) {
 	db := database.GetDB()
 
 	// Check tier limits
@@ -87,6 +101,8 @@ func (s *OrgService) CreateOrganization(ownerID uuid.UUID, name string) (*models
 		return nil, fmt.Errorf("failed to create organization: %w", err)
 	}
 
+	s.logOrg(ctx, ownerID, org.ID, org.ID, models.ActionOrgCreate, "organization", ip, map[string]any{"name": org.Name})
+
 	// Load owner for response
 	db.Preload("Owner").First(org, org.ID)
 
@@ -126,7 +142,7 @@ func (s *OrgService) ListUserOrganizations(userID uuid.UUID) ([]models.Organizat
 }
 
 // UpdateOrganization updates an organization
-func (s *OrgService) UpdateOrganization(orgID uuid.UUID, name string) (*models.Organization, error) {
+func (s *OrgService) UpdateOrganization(ctx context.Context, userID, orgID uuid.UUID, name string, excludeExpiredSecrets *bool, ip string) (*models.Organization, error) {
 	db := database.GetDB()
 
 	var org models.Organization
@@ -134,16 +150,22 @@ func (s *OrgService) UpdateOrganization(orgID uuid.UUID, name string) (*models.O
 		return nil, err
 	}
 
+	fields := map[string]any{"name": name, "previous_name": org.Name}
 	org.Name = name
+	if excludeExpiredSecrets != nil {
+		org.ExcludeExpiredSecrets = *excludeExpiredSecrets
+		fields["exclude_expired_secrets"] = *excludeExpiredSecrets
+	}
 	if err := db.Save(&org).Error; err != nil {
 		return nil, err
 	}
 
+	s.logOrg(ctx, userID, org.ID, org.ID, models.ActionOrgUpdate, "organization", ip, fields)
 	return &org, nil
 }
 
 // DeleteOrganization deletes an organization. Personal workspaces cannot be deleted.
-func (s *OrgService) DeleteOrganization(orgID uuid.UUID) error {
+func (s *OrgService) DeleteOrganization(ctx context.Context, userID, orgID uuid.UUID, ip string) error {
 	db := database.GetDB()
 
 	var org models.Organization
@@ -154,7 +176,7 @@ func (s *OrgService) DeleteOrganization(orgID uuid.UUID) error {
 		return fmt.Errorf("personal workspaces cannot be deleted")
 	}
 
-	return db.Transaction(func(tx *gorm.DB) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Where("org_id = ?", orgID).Delete(&models.OrgMember{}).Error; err != nil {
 			return err
 		}
@@ -165,10 +187,15 @@ func (s *OrgService) DeleteOrganization(orgID uuid.UUID) error {
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	s.logOrg(ctx, userID, org.ID, org.ID, models.ActionOrgDelete, "organization", ip, map[string]any{"name": org.Name})
+	return nil
 }
 
 // InviteMember creates a pending invitation and emails the recipient.
-func (s *OrgService) InviteMember(orgID uuid.UUID, invitedBy uuid.UUID, email string, roleID *uuid.UUID, roleName string) (*models.OrgInvitation, string, string, error) {
+func (s *OrgService) InviteMember(ctx context.Context, orgID uuid.UUID, invitedBy uuid.UUID, email string, roleID *uuid.UUID, roleName string, ip string) (*models.OrgInvitation, string, string, error) {
 	db := database.GetDB()
 	normalizedEmail := strings.ToLower(strings.TrimSpace(email))
 	if normalizedEmail == "" {
@@ -233,6 +260,7 @@ func (s *OrgService) InviteMember(orgID uuid.UUID, invitedBy uuid.UUID, email st
 	if err := db.Create(invitation).Error; err != nil {
 		return nil, "", "", err
 	}
+	s.logOrg(ctx, invitedBy, orgID, invitation.ID, models.ActionMemberInvite, "invitation", ip, map[string]any{"email": normalizedEmail, "role": role.Name})
 
 	var inviter models.User
 	_ = db.First(&inviter, invitedBy).Error
@@ -247,14 +275,15 @@ func (s *OrgService) InviteMember(orgID uuid.UUID, invitedBy uuid.UUID, email st
 	return invitation, inviteURL, emailWarning, nil
 }
 
-// UpdateMemberRole updates a member's role
-func (s *OrgService) UpdateMemberRole(memberID uuid.UUID, roleID *uuid.UUID, roleName string) (*models.OrgMember, error) {
+// UpdateMemberRole updates the role of a member of orgID
+func (s *OrgService) UpdateMemberRole(ctx context.Context, userID, orgID, memberID uuid.UUID, roleID *uuid.UUID, roleName string, ip string) (*models.OrgMember, error) {
 	db := database.GetDB()
 
 	var member models.OrgMember
-	if err := db.First(&member, memberID).Error; err != nil {
+	if err := db.Where("id = ? AND org_id = ?", memberID, orgID).First(&member).Error; err != nil {
 		return nil, err
 	}
+	previousRoleID := member.RoleID
 
 	role, err := s.resolveRole(member.OrgID, roleID, roleName)
 	if err != nil {
@@ -265,6 +294,12 @@ func (s *OrgService) UpdateMemberRole(memberID uuid.UUID, roleID *uuid.UUID, rol
 	if err := db.Save(&member).Error; err != nil {
 		return nil, err
 	}
+	s.logOrg(ctx, userID, orgID, member.ID, models.ActionRoleChange, "member", ip, map[string]any{
+		"member_user_id":   member.UserID,
+		"role":             role.Name,
+		"role_id":          role.ID,
+		"previous_role_id": previousRoleID,
+	})
 
 	// Load relationships
 	db.Preload("User").Preload("Role").First(&member, member.ID)
@@ -272,11 +307,19 @@ func (s *OrgService) UpdateMemberRole(memberID uuid.UUID, roleID *uuid.UUID, rol
 	return &member, nil
 }
 
-// RemoveMember removes a user from an organization
-func (s *OrgService) RemoveMember(memberID uuid.UUID) error {
+// RemoveMember removes a member of orgID from the organization
+func (s *OrgService) RemoveMember(ctx context.Context, userID, orgID, memberID uuid.UUID, ip string) error {
 	db := database.GetDB()
 
-	return db.Delete(&models.OrgMember{}, memberID).Error
+	var member models.OrgMember
+	if err := db.Where("id = ? AND org_id = ?", memberID, orgID).First(&member).Error; err != nil {
+		return err
+	}
+	if err := db.Delete(&member).Error; err != nil {
+		return err
+	}
+	s.logOrg(ctx, userID, orgID, member.ID, models.ActionMemberRemove, "member", ip, map[string]any{"member_user_id": member.UserID})
+	return nil
 }
 
 // CheckUserAccess checks if a user has access to an organization
@@ -344,7 +387,7 @@ func (s *OrgService) ListRoles(orgID uuid.UUID) ([]models.Role, error) {
 }
 
 // CreateRole creates a custom role for the org and attaches permissions.
-func (s *OrgService) CreateRole(orgID uuid.UUID, name string, permissionNames []string) (*models.Role, error) {
+func (s *OrgService) CreateRole(ctx context.Context, userID, orgID uuid.UUID, name string, permissionNames []string, ip string) (*models.Role, error) {
 	db := database.GetDB()
 	name = strings.TrimSpace(name)
 	if name == "" {
@@ -375,10 +418,11 @@ func (s *OrgService) CreateRole(orgID uuid.UUID, name string, permissionNames []
 	if err := db.Preload("Permissions").First(role, role.ID).Error; err != nil {
 		return nil, err
 	}
+	s.logOrg(ctx, userID, orgID, role.ID, models.ActionRoleCreate, "role", ip, map[string]any{"name": role.Name, "permissions": rolePermissionNames(role)})
 	return role, nil
 }
 
-func (s *OrgService) UpdateRole(orgID uuid.UUID, roleID uuid.UUID, name string, permissionNames []string) (*models.Role, error) {
+func (s *OrgService) UpdateRole(ctx context.Context, userID, orgID uuid.UUID, roleID uuid.UUID, name string, permissionNames []string, ip string) (*models.Role, error) {
 	db := database.GetDB()
 	var role models.Role
 	if err := db.Where("id = ? AND org_id = ?", roleID, orgID).First(&role).Error; err != nil {
@@ -387,6 +431,7 @@ func (s *OrgService) UpdateRole(orgID uuid.UUID, roleID uuid.UUID, name string,
 	if role.IsSystemRole {
 		return nil, fmt.Errorf("system roles cannot be modified")
 	}
+	previousName := role.Name
 	if strings.TrimSpace(name) != "" {
 		role.Name = strings.TrimSpace(name)
 		if err := db.Save(&role).Error; err != nil {
@@ -405,10 +450,15 @@ func (s *OrgService) UpdateRole(orgID uuid.UUID, roleID uuid.UUID, name string,
 	if err := db.Preload("Permissions").First(&role, role.ID).Error; err != nil {
 		return nil, err
 	}
+	s.logOrg(ctx, userID, orgID, role.ID, models.ActionRoleUpdate, "role", ip, map[string]any{
+		"name":          role.Name,
+		"previous_name": previousName,
+		"permissions":   rolePermissionNames(&role),
+	})
 	return &role, nil
 }
 
-func (s *OrgService) DeleteRole(orgID uuid.UUID, roleID uuid.UUID, replacementRoleID *uuid.UUID) error {
+func (s *OrgService) DeleteRole(ctx context.Context, userID, orgID uuid.UUID, roleID uuid.UUID, replacementRoleID *uuid.UUID, ip string) error {
 	db := database.GetDB()
 	var role models.Role
 	if err := db.Where("id = ? AND org_id = ?", roleID, orgID).First(&role).Error; err != nil {
@@ -417,7 +467,8 @@ func (s *OrgService) DeleteRole(orgID uuid.UUID, roleID uuid.UUID, replacementRo
 	if role.IsSystemRole {
 		return fmt.Errorf("system roles cannot be deleted")
 	}
-	return db.Transaction(func(tx *gorm.DB) error {
+	var reassigned int64
+	err := db.Transaction(func(tx *gorm.DB) error {
 		var count int64
 		if err := tx.Model(&models.OrgMember{}).Where("role_id = ?", roleID).Count(&count).Error; err != nil {
 			return err
@@ -429,9 +480,29 @@ func (s *OrgService) DeleteRole(orgID uuid.UUID, roleID uuid.UUID, replacementRo
 			if err := tx.Model(&models.OrgMember{}).Where("role_id = ?", roleID).Update("role_id", *replacementRoleID).Error; err != nil {
 				return err
 			}
+			reassigned = count
 		}
 		return tx.Delete(&models.Role{}, roleID).Error
 	})
+	if err != nil {
+		return err
+	}
+	fields := map[string]any{"name": role.Name}
+	if reassigned > 0 {
+		fields["replacement_role_id"] = *replacementRoleID
+		fields["members_reassigned"] = reassigned
+	}
+	s.logOrg(ctx, userID, orgID, role.ID, models.ActionRoleDelete, "role", ip, fields)
+	return nil
+}
+
+// rolePermissionNames lists a role's loaded permissions for audit metadata.
+func rolePermissionNames(role *models.Role) []string {
+	names := make([]string, 0, len(role.Permissions))
+	for _, p := range role.Permissions {
+		names = append(names, p.Name)
+	}
+	return names
 }
 
 func (s *OrgService) ListInvitations(orgID uuid.UUID) ([]models.OrgInvitation, error) {
@@ -447,7 +518,7 @@ func (s *OrgService) ListInvitations(orgID uuid.UUID) ([]models.OrgInvitation, e
 	return invitations, nil
 }
 
-func (s *OrgService) ResendInvitation(orgID, inviteID, actorID uuid.UUID) (string, error) {
+func (s *OrgService) ResendInvitation(ctx context.Context, orgID, inviteID, actorID uuid.UUID, ip string) (string, error) {
 	db := database.GetDB()
 	var invite models.OrgInvitation
 	if err := db.Where("id = ? AND org_id = ?", inviteID, orgID).Preload("Role").First(&invite).Error; err != nil {
@@ -474,17 +545,24 @@ func (s *OrgService) ResendInvitation(orgID, inviteID, actorID uuid.UUID) (strin
 	if err := s.emailSender.SendInvite(invite.Email, org.Name, inviter.Name, invite.Role.Name, url); err != nil {
 		return "", err
 	}
+	s.logOrg(ctx, actorID, orgID, invite.ID, models.ActionInviteResend, "invitation", ip, map[string]any{"email": invite.Email})
 	return url, nil
 }
 
-func (s *OrgService) RevokeInvitation(orgID, inviteID uuid.UUID) error {
+func (s *OrgService) RevokeInvitation(ctx context.Context, userID, orgID, inviteID uuid.UUID, ip string) error {
 	db := database.GetDB()
-	return db.Model(&models.OrgInvitation{}).
-		Where("id = ? AND org_id = ?", inviteID, orgID).
-		Updates(map[string]interface{}{"status": models.InvitationRevoked}).Error
+	var invite models.OrgInvitation
+	if err := db.Where("id = ? AND org_id = ?", inviteID, orgID).First(&invite).Error; err != nil {
+		return fmt.Errorf("invitation not found")
+	}
+	if err := db.Model(&invite).Updates(map[string]interface{}{"status": models.InvitationRevoked}).Error; err != nil {
+		return err
+	}
+	s.logOrg(ctx, userID, orgID, invite.ID, models.ActionInviteRevoke, "invitation", ip, map[string]any{"email": invite.Email, "previous_status": invite.Status})
+	return nil
 }
 
-func (s *OrgService) AcceptInvitation(userID uuid.UUID, rawToken string) (*models.OrgMember, error) {
+func (s *OrgService) AcceptInvitation(ctx context.Context, userID uuid.UUID, rawToken string, ip string) (*models.OrgMember, error) {
 	db := database.GetDB()
 	tokenHash := invitationTokenHash(strings.TrimSpace(rawToken))
 	var invite models.OrgInvitation
@@ -529,6 +607,7 @@ func (s *OrgService) AcceptInvitation(userID uuid.UUID, rawToken string) (*model
 	if err != nil {
 		return nil, err
 	}
+	s.logOrg(ctx, userID, invite.OrgID, invite.ID, models.ActionInviteAccept, "invitation", ip, map[string]any{"email": invite.Email, "member_id": member.ID})
 	if err := db.Preload("Role").Preload("User").First(member, member.ID).Error; err != nil {
 		return nil, err
 	}
@@ -536,7 +615,7 @@ func (s *OrgService) AcceptInvitation(userID uuid.UUID, rawToken string) (*model
 }
 
 // AcceptInvitationByID accepts a pending invitation for the signed-in user by invite id.
-func (s *OrgService) AcceptInvitationByID(userID, inviteID uuid.UUID) (*models.OrgMember, error) {
+func (s *OrgService) AcceptInvitationByID(ctx context.Context, userID, inviteID uuid.UUID, ip string) (*models.OrgMember, error) {
 	db := database.GetDB()
 	var user models.User
 	if err := db.First(&user, userID).Error; err != nil {
@@ -580,6 +659,7 @@ func (s *OrgService) AcceptInvitationByID(userID, inviteID uuid.UUID) (*models.O
 	if err != nil {
 		return nil, err
 	}
+	s.logOrg(ctx, userID, invite.OrgID, invite.ID, models.ActionInviteAccept, "invitation", ip, map[string]any{"email": invite.Email, "member_id": created.ID})
 	if err := db.Preload("User").Preload("Role").First(&created, created.ID).Error; err != nil {
 		return nil, err
 	}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -38,6 +39,8 @@ type SyncResult struct {
 	TargetProject  string `json:"target_project_id"`
 	TargetEnv      string `json:"target_environment"`
 	Synced         int    `json:"synced"`
+
+	Skipped []SkippedSecret `json:"skipped_keys,omitempty"`
 }
 
 // PlatformService manages deploy platform connections and manual env sync.
@@ -45,14 +48,16 @@ type PlatformService struct {
 	encryptor      Encryptor
 	localEncryptor Encryptor
 	secretService  *SecretService
+	auditService   *AuditService
 	httpClient     *http.Client
 }
 
-func NewPlatformService(encryptor Encryptor, localEncryptor Encryptor, secretService *SecretService) *PlatformService {
+func NewPlatformService(encryptor Encryptor, localEncryptor Encryptor, secretService *SecretService, auditService *AuditService) *PlatformService {
 	return &PlatformService{
 		encryptor:      encryptor,
 		localEncryptor: localEncryptor,
 		secretService:  secretService,
+		auditService:   auditService,
 		httpClient: &http.Client{
 			Timeout: 25 * time.Second,
 		},
@@ -71,7 +76,23 @@ func tokenPrefix(token string) string {
 	return t[:6]
 }
 
-func (s *PlatformService) CreateConnection(ctx context.Context, userID uuid.UUID, in CreatePlatformConnectionInput) (*models.PlatformConnection, error) {
+// logConnection audits a change to a user's platform connections. They
+// belong to the user, not an organization, so the entry goes to the
+// user's personal workspace.
+func (s *PlatformService) logConnection(ctx context.Context, userID uuid.UUID, conn *models.PlatformConnection, action, ip string) {
+	if s.auditService == nil {
+		return
+	}
+	metadata, _ := json.Marshal(map[string]any{
+		"connection_id": conn.ID,
+		"platform":      conn.Platform,
+		"name":          conn.Name,
+		"token_prefix":  conn.TokenPrefix,
+	})
+	_ = s.auditService.LogUser(ctx, userID, userID, action, ip, metadata)
+}
+
+func (s *PlatformService) CreateConnection(ctx context.Context, userID uuid.UUID, in CreatePlatformConnectionInput, ip string) (*models.PlatformConnection, error) {
 	if s.encryptor == nil {
 		return nil, fmt.Errorf("secret encryption is not configured")
 	}
@@ -121,6 +142,7 @@ func (s *PlatformService) CreateConnection(ctx context.Context, userID uuid.UUID
 	if err := database.GetDB().WithContext(ctx).Create(conn).Error; err != nil {
 		return nil, fmt.Errorf("failed to save platform connection: %w", err)
 	}
+	s.logConnection(ctx, userID, conn, models.ActionPlatformConnect, ip)
 	return conn, nil
 }
 
@@ -136,8 +158,12 @@ func (s *PlatformService) ListConnections(ctx context.Context, userID uuid.UUID)
 	return rows, nil
 }
 
-func (s *PlatformService) DeleteConnection(ctx context.Context, userID, connectionID uuid.UUID) error {
+func (s *PlatformService) DeleteConnection(ctx context.Context, userID, connectionID uuid.UUID, ip string) error {
 	db := database.GetDB().WithContext(ctx)
+	var conn models.PlatformConnection
+	if err := db.Where("id = ? AND user_id = ?", connectionID, userID).First(&conn).Error; err != nil {
+		return fmt.Errorf("platform connection not found")
+	}
 	res := db.Where("id = ? AND user_id = ?", connectionID, userID).Delete(&models.PlatformConnection{})
 	if res.Error != nil {
 		return res.Error
@@ -145,6 +171,7 @@ func (s *PlatformService) DeleteConnection(ctx context.Context, userID, connecti
 	if res.RowsAffected == 0 {
 		return fmt.Errorf("platform connection not found")
 	}
+	s.logConnection(ctx, userID, &conn, models.ActionPlatformDisconnect, ip)
 	return nil
 }
 
@@ -161,7 +188,7 @@ func (s *PlatformService) SyncEnvironment(ctx context.Context, userID uuid.UUID,
 		return nil, err
 	}
 
-	secrets, _, err := s.secretService.ExportEnvironmentSecrets(ctx, userID, in.EnvironmentID, ip)
+	secrets, skipped, _, err := s.secretService.ExportEnvironmentSecrets(ctx, userID, in.EnvironmentID, ip)
 	if err != nil {
 		return nil, err
 	}
@@ -174,12 +201,15 @@ func (s *PlatformService) SyncEnvironment(ctx context.Context, userID uuid.UUID,
 
 	switch conn.Platform {
 	case platformVercel:
-		if err := s.syncVercel(ctx, token, targetProject, targetEnv, secrets); err != nil {
-			return nil, err
-		}
+		err = s.syncVercel(ctx, token, targetProject, targetEnv, secrets)
 	default:
 		return nil, fmt.Errorf("unsupported platform %q; currently supported: vercel", conn.Platform)
 	}
+	// A failed sync may have pushed some keys already, so it is audited too.
+	s.logSync(ctx, userID, &conn, in.EnvironmentID, targetProject, targetEnv, secrets, err, ip)
+	if err != nil {
+		return nil, err
+	}
 
 	return &SyncResult{
 		Platform:       conn.Platform,
@@ -187,28 +217,69 @@ func (s *PlatformService) SyncEnvironment(ctx context.Context, userID uuid.UUID,
 		TargetProject:  targetProject,
 		TargetEnv:      targetEnv,
 		Synced:         len(secrets),
+		Skipped:        skipped,
 	}, nil
 }
 
+// logSync audits a push of an environment's secrets to a platform in the
+// environment's organization. Only key names are recorded.
+func (s *PlatformService) logSync(ctx context.Context, userID uuid.UUID, conn *models.PlatformConnection, envID uuid.UUID, targetProject, targetEnv string, secrets map[string]string, syncErr error, ip string) {
+	if s.auditService == nil {
+		return
+	}
+	var env models.Environment
+	if err := database.GetDB().WithContext(ctx).Preload("Project").First(&env, envID).Error; err != nil {
+		return
+	}
+	keys := make([]string, 0, len(secrets))
+	for key := range secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := map[string]any{
+		"connection_id":      conn.ID,
+		"platform":           conn.Platform,
+		"target_project_id":  targetProject,
+		"target_environment": targetEnv,
+		"keys":               keys,
+		"status":             "succeeded",
+	}
+	if syncErr != nil {
+		fields["status"] = "failed"
+	}
+	metadata, _ := json.Marshal(fields)
+	_ = s.auditService.Log(ctx, userID, env.Project.OrgID, env.ID, models.ActionPlatformSync, "environment", ip, metadata)
+}
+
 func (s *PlatformService) decryptToken(ctx context.Context, conn *models.PlatformConnection, scope string) (string, error) {
-	dec := s.encryptor
-	alt := s.localEncryptor
-	if s.localEncryptor != nil && (conn.KeyID == "local" || strings.HasPrefix(conn.EncryptedToken, "local:")) {
-		dec = s.localEncryptor
-		alt = s.encryptor
+	token, err := decryptWithFallback(ctx, s.encryptor, s.localEncryptor, conn.KeyID, conn.EncryptedToken, scope)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt platform token")
+	}
+	return token, nil
+}
+
+// decryptWithFallback decrypts a stored credential with the encryptor that
+// wrote it, judged by keyID or the "local:" prefix, and then the other one,
+// so credentials survive switching between local encryption and KMS.
+func decryptWithFallback(ctx context.Context, encryptor, localEncryptor Encryptor, keyID, ciphertext, scope string) (string, error) {
+	dec := encryptor
+	alt := localEncryptor
+	if localEncryptor != nil && (keyID == "local" || strings.HasPrefix(ciphertext, "local:")) {
+		dec = localEncryptor
+		alt = encryptor
 	}
 
-	token, err := dec.Decrypt(ctx, conn.EncryptedToken, scope)
+	plain, err := dec.Decrypt(ctx, ciphertext, scope)
 	if err == nil {
-		return token, nil
+		return plain, nil
 	}
 	if alt != nil && alt != dec {
-		token2, err2 := alt.Decrypt(ctx, conn.EncryptedToken, scope)
-		if err2 == nil {
-			return token2, nil
+		if plain, err2 := alt.Decrypt(ctx, ciphertext, scope); err2 == nil {
+			return plain, nil
 		}
 	}
-	return "", fmt.Errorf("failed to decrypt platform token")
+	return "", err
 }
 
 func (s *PlatformService) validateConnection(ctx context.Context, platform, token string) error {
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Helpers for talking to customer PostgreSQL servers (rotation and dynamic
+// credentials). These connections are short-lived, never pooled with Envo's
+// own database, and never logged: statements carry passwords.
+
+var postgresRolePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+const (
+	postgresPasswordLength   = 32
+	postgresPasswordAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	postgresConnectTimeout   = 10 * time.Second
+)
+
+// validPostgresRole reports whether name is a plain, unquoted-safe role name.
+func validPostgresRole(name string) bool {
+	return postgresRolePattern.MatchString(name)
+}
+
+func quotePostgresIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quotePostgresLiteral(value string) string {
+	return `'` + strings.ReplaceAll(value, `'`, `''`) + `'`
+}
+
+// generatePostgresPassword returns a random alphanumeric password, which
+// needs no escaping in URLs or SQL.
+func generatePostgresPassword() (string, error) {
+	max := big.NewInt(int64(len(postgresPasswordAlphabet)))
+	out := make([]byte, postgresPasswordLength)
+	for i := range out {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		out[i] = postgresPasswordAlphabet[n.Int64()]
+	}
+	return string(out), nil
+}
+
+// parsePostgresURL accepts postgres:// and postgresql:// connection URLs.
+func parsePostgresURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") || u.Host == "" {
+		return nil, fmt.Errorf("expected a postgres:// connection URL")
+	}
+	return u, nil
+}
+
+// postgresURLWithCredentials returns base with its user and password replaced.
+func postgresURLWithCredentials(base, user, password string) (string, error) {
+	u, err := parsePostgresURL(base)
+	if err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword(user, password)
+	return u.String(), nil
+}
+
+// openPostgres connects to an external PostgreSQL server. Connection URLs
+// are supplied by organization members, so every connection goes through
+// guard's dialer. The returned close function must always be called.
+func openPostgres(ctx context.Context, guard egressGuard, dsn string) (*gorm.DB, func(), error) {
+	u, err := parsePostgresURL(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	q := u.Query()
+	if q.Get("connect_timeout") == "" {
+		q.Set("connect_timeout", fmt.Sprint(int(postgresConnectTimeout.Seconds())))
+		u.RawQuery = q.Encode()
+	}
+	config, err := pgx.ParseConfig(u.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid connection URL: %w", err)
+	}
+	config.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	config.DialFunc = guard.dialer(postgresConnectTimeout).DialContext
+	sqlDB := stdlib.OpenDB(*config)
+	sqlDB.SetMaxOpenConns(1)
+	closeFn := func() { _ = sqlDB.Close() }
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:                 logger.Discard,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		closeFn()
+		return nil, nil, fmt.Errorf("failed to open connection: %w", err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		closeFn()
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return db.WithContext(ctx), closeFn, nil
+}
@@ -1,29 +1,43 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/envo/backend/internal/database"
 	"github.com/envo/backend/internal/models"
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
 // ProjectService handles project business logic
 type ProjectService struct {
-	tierService *TierService
+	tierService  *TierService
+	auditService *AuditService
 }
 
 // NewProjectService creates a new project service
-func NewProjectService(tierService *TierService) *ProjectService {
+func NewProjectService(tierService *TierService, auditService *AuditService) *ProjectService {
 	return &ProjectService{
-		tierService: tierService,
+		tierService:  tierService,
+		auditService: auditService,
 	}
 }
 
+// logProject audits a change to a project.
+func (s *ProjectService) logProject(ctx context.Context, userID uuid.UUID, project *models.Project, action, ip string, fields map[string]any) {
+	if s.auditService == nil {
+		return
+	}
+	metadata, _ := json.Marshal(fields)
+	_ = s.auditService.Log(ctx, userID, project.OrgID, project.ID, action, "project", ip, metadata)
+}
+
 // CreateProject creates a new project within an organization
-func (s *ProjectService) CreateProject(orgID uuid.UUID, name string, description *string) (*models.Project, error) {
-	db := database.GetDB()
+func (s *ProjectService) CreateProject(ctx context.Context, userID, orgID uuid.UUID, name string, description *string, ip string) (*models.Project, error) {
+	db := database.GetDB().WithContext(ctx)
 
 	// Check tier limits
 	canCreate, err := s.tierService.CanCreateProject(orgID)
@@ -43,6 +57,7 @@ func (s *ProjectService) CreateProject(orgID uuid.UUID, name string, description
 	if err := db.Create(project).Error; err != nil {
 		return nil, fmt.Errorf("failed to create project: %w", err)
 	}
+	s.logProject(ctx, userID, project, models.ActionProjectCreate, ip, map[string]any{"name": project.Name})
 
 	// Preload relations for response
 	if err := db.Preload("Organization").First(project, project.ID).Error; err != nil && err != gorm.ErrRecordNotFound {
@@ -80,14 +95,15 @@ func (s *ProjectService) GetProject(projectID uuid.UUID) (*models.Project, error
 }
 
 // UpdateProject updates a project's basic fields
-func (s *ProjectService) UpdateProject(projectID uuid.UUID, name string, description *string) (*models.Project, error) {
-	db := database.GetDB()
+func (s *ProjectService) UpdateProject(ctx context.Context, userID, projectID uuid.UUID, name string, description *string, ip string) (*models.Project, error) {
+	db := database.GetDB().WithContext(ctx)
 
 	var project models.Project
 	if err := db.First(&project, projectID).Error; err != nil {
 		return nil, err
 	}
 
+	previous := project.Name
 	project.Name = name
 	project.Description = description
 
@@ -95,14 +111,20 @@ func (s *ProjectService) UpdateProject(projectID uuid.UUID, name string, descrip
 		return nil, err
 	}
 
+	s.logProject(ctx, userID, &project, models.ActionProjectUpdate, ip, map[string]any{"name": project.Name, "previous_name": previous})
 	return &project, nil
 }
 
 // DeleteProject deletes a project and its environments and secrets (via cascading)
-func (s *ProjectService) DeleteProject(projectID uuid.UUID) error {
-	db := database.GetDB()
+func (s *ProjectService) DeleteProject(ctx context.Context, userID, projectID uuid.UUID, ip string) error {
+	db := database.GetDB().WithContext(ctx)
+
+	var project models.Project
+	if err := db.First(&project, projectID).Error; err != nil {
+		return err
+	}
 
-	return db.Transaction(func(tx *gorm.DB) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
 		// Delete environments and their secrets via explicit deletes to keep behavior clear
 		var envs []models.Environment
 		if err := tx.Where("project_id = ?", projectID).Find(&envs).Error; err != nil {
@@ -126,5 +148,61 @@ func (s *ProjectService) DeleteProject(projectID uuid.UUID) error {
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	s.logProject(ctx, userID, &project, models.ActionProjectDelete, ip, map[string]any{"name": project.Name})
+	return nil
+}
+
+
+// GetSecretSchema returns the project's secret schema, or nil if none is set.
+func (s *ProjectService) GetSecretSchema(projectID uuid.UUID) (*models.SecretSchema, error) {
+	db := database.GetDB()
+
+	var project models.Project
+	if err := db.First(&project, projectID).Error; err != nil {
+		return nil, err
+	}
+	return project.Schema()
 }
 
+// UpdateSecretSchema validates and stores a project's secret schema. A nil
+// or empty schema removes it. Existing secrets are not checked here; use the
+// schema report to find values that no longer conform.
+func (s *ProjectService) UpdateSecretSchema(ctx context.Context, userID, projectID uuid.UUID, schema *models.SecretSchema, ip string) (*models.SecretSchema, error) {
+	if _, err := compileSchema(schema); err != nil {
+		return nil, err
+	}
+
+	db := database.GetDB().WithContext(ctx)
+
+	var project models.Project
+	if err := db.First(&project, projectID).Error; err != nil {
+		return nil, err
+	}
+
+	var stored datatypes.JSON
+	if schema != nil && len(schema.Keys) > 0 {
+		encoded, err := json.Marshal(schema)
+		if err != nil {
+			return nil, err
+		}
+		stored = datatypes.JSON(encoded)
+	} else {
+		schema = nil
+	}
+	if err := db.Model(&project).Update("secret_schema", stored).Error; err != nil {
+		return nil, err
+	}
+
+	if s.auditService != nil {
+		keys := 0
+		if schema != nil {
+			keys = len(schema.Keys)
+		}
+		metadata, _ := json.Marshal(map[string]any{"keys": keys})
+		_ = s.auditService.Log(ctx, userID, project.OrgID, project.ID, models.ActionSecretSchemaUpdate, "project", ip, metadata)
+	}
+	return schema, nil
+}
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRotationNotConfigured = errors.New("rotation is not configured for this secret")
+	ErrRotationInProgress    = errors.New("a rotation for this secret is already in progress")
+	ErrInvalidRotationConfig = errors.New("invalid rotation config")
+)
+
+// Rotation steps recorded in the audit log, in the order they happen.
+const (
+	RotationStepStarted           = "started"
+	RotationStepCredentialChanged = "credential_changed"
+	RotationStepVerified          = "verified"
+	RotationStepSecretUpdated     = "secret_updated"
+	RotationStepCompleted         = "completed"
+	RotationStepFailed            = "failed"
+	RotationStepReverted          = "reverted"
+)
+
+const (
+	rotationLockTTL       = 5 * time.Minute
+	rotationRetryInterval = time.Hour
+	rotationBatchSize     = 50
+	// rotationFinishTimeout bounds the steps after the credential changed,
+	// which run even when the caller has gone away.
+	rotationFinishTimeout = time.Minute
+)
+
+// RotationRequest is everything a Rotator needs for one rotation. Values are
+// decrypted only for the duration of the call.
+type RotationRequest struct {
+	Config          models.SecretRotation
+	AdminConnection string
+	CurrentValue    string
+	TypeHint        string
+}
+
+// RotationResult is the outcome of a successful Rotate.
+type RotationResult struct {
+	NewValue   string
+	ActiveUser string
+}
+
+// Rotator changes a credential on an external system. Rotate must leave the
+// previous credential untouched on error; Revert undoes a successful Rotate
+// when a later step fails.
+type Rotator interface {
+	ValidateConfig(ctx context.Context, cfg *models.SecretRotation, adminConnection string) error
+	Rotate(ctx context.Context, req RotationRequest) (*RotationResult, error)
+	Verify(ctx context.Context, req RotationRequest, result *RotationResult) error
+	Revert(ctx context.Context, req RotationRequest, result *RotationResult) error
+}
+
+// RotationConfigInput is the user-supplied rotation config for a secret.
+type RotationConfigInput struct {
+	Rotator       string
+	Strategy      string
+	AdminSecretID uuid.UUID
+	Username      string
+	AlternateUser string
+	IntervalDays  int
+}
+
+// RotationService rotates secrets automatically, on a schedule or on demand.
+// Each rotation holds a short lock on its config row so concurrent API
+// instances never rotate the same secret twice.
+type RotationService struct {
+	secretService *SecretService
+	auditService  *AuditService
+	rotators      map[string]Rotator
+	now           func() time.Time
+}
+
+// NewRotationService creates a rotation service with the built-in rotators
+// registered. allowPrivateHosts lets them reach servers on private addresses.
+func NewRotationService(secretService *SecretService, audit *AuditService, allowPrivateHosts bool) *RotationService {
+	s := &RotationService{
+		secretService: secretService,
+		auditService:  audit,
+		rotators:      map[string]Rotator{},
+		now:           time.Now,
+	}
+	s.RegisterRotator(models.RotatorPostgres, PostgresRotator{egress: egressGuard{allowPrivate: allowPrivateHosts}})
+	return s
+}
+
+// RegisterRotator adds or replaces the rotator used for configs of the given kind.
+func (s *RotationService) RegisterRotator(name string, r Rotator) {
+	s.rotators[name] = r
+}
+
+// Get returns the rotation config for a secret.
+func (s *RotationService) Get(ctx context.Context, secretID uuid.UUID) (*models.SecretRotation, error) {
+	var cfg models.SecretRotation
+	if err := database.GetDB().WithContext(ctx).Where("secret_id = ?", secretID).First(&cfg).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRotationNotConfigured
+		}
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Configure creates or replaces the rotation config for a secret. The admin
+// connection must be another secret in the same organization, and userID must
+// be allowed to read it: rotations connect with it on their behalf.
+func (s *RotationService) Configure(ctx context.Context, userID, secretID uuid.UUID, in RotationConfigInput, ip string) (*models.SecretRotation, error) {
+	rotator, ok := s.rotators[in.Rotator]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown rotator %q", ErrInvalidRotationConfig, in.Rotator)
+	}
+	if in.Strategy == "" {
+		in.Strategy = models.RotationStrategyPassword
+	}
+	if in.Strategy != models.RotationStrategyPassword && in.Strategy != models.RotationStrategyAlternateRoles {
+		return nil, fmt.Errorf("%w: unknown strategy %q", ErrInvalidRotationConfig, in.Strategy)
+	}
+	if in.Strategy == models.RotationStrategyPassword {
+		in.AlternateUser = ""
+	}
+	if in.IntervalDays < 0 || in.IntervalDays > maxRotationIntervalDays {
+		return nil, fmt.Errorf("%w: interval_days must be between 0 and %d", ErrInvalidRotationConfig, maxRotationIntervalDays)
+	}
+	if in.AdminSecretID == secretID {
+		return nil, fmt.Errorf("%w: a secret cannot be its own admin connection", ErrInvalidRotationConfig)
+	}
+
+	db := database.GetDB().WithContext(ctx)
+	var target models.Secret
+	if err := db.First(&target, secretID).Error; err != nil {
+		return nil, err
+	}
+	orgID, err := s.secretService.resolveWorkspaceID(db, target.EnvironmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve workspace: %w", err)
+	}
+	// The admin secret has to be in orgID, so this covers its environment.
+	if err := checkSecretReadable(db, userID, orgID); err != nil {
+		return nil, err
+	}
+	adminValue, _, adminOrgID, err := s.secretService.readSecretValue(ctx, in.AdminSecretID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: admin secret not found", ErrInvalidRotationConfig)
+		}
+		return nil, err
+	}
+	if adminOrgID != orgID {
+		return nil, fmt.Errorf("%w: admin secret not found", ErrInvalidRotationConfig)
+	}
+
+	var cfg models.SecretRotation
+	err = db.Where("secret_id = ?", secretID).First(&cfg).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	isNew := errors.Is(err, gorm.ErrRecordNotFound)
+	if isNew || cfg.Username != in.Username || cfg.AlternateUser != in.AlternateUser {
+		cfg.ActiveUser = in.Username
+	}
+	cfg.OrgID = orgID
+	cfg.SecretID = secretID
+	cfg.Rotator = in.Rotator
+	cfg.Strategy = in.Strategy
+	cfg.AdminSecretID = in.AdminSecretID
+	cfg.Username = in.Username
+	cfg.AlternateUser = in.AlternateUser
+	cfg.IntervalDays = in.IntervalDays
+	if isNew {
+		cfg.CreatedBy = userID
+	}
+	cfg.NextRotationAt = nil
+	if in.IntervalDays > 0 {
+		next := s.now().UTC().AddDate(0, 0, in.IntervalDays)
+		if cfg.LastRotatedAt != nil {
+			next = cfg.LastRotatedAt.AddDate(0, 0, in.IntervalDays)
+		}
+		cfg.NextRotationAt = &next
+	}
+
+	if err := rotator.ValidateConfig(ctx, &cfg, adminValue); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRotationConfig, err)
+	}
+	if err := db.Save(&cfg).Error; err != nil {
+		return nil, err
+	}
+
+	if s.auditService != nil {
+		metadata, _ := json.Marshal(map[string]any{
+			"key":             target.Key,
+			"rotator":         cfg.Rotator,
+			"strategy":        cfg.Strategy,
+			"admin_secret_id": cfg.AdminSecretID,
+			"interval_days":   cfg.IntervalDays,
+		})
+		_ = s.auditService.Log(ctx, userID, orgID, secretID, models.ActionSecretRotationConfig, "secret", ip, datatypes.JSON(metadata))
+	}
+	return &cfg, nil
+}
+
+// Disable removes a secret's rotation config. The secret itself is untouched.
+func (s *RotationService) Disable(ctx context.Context, userID, secretID uuid.UUID, ip string) error {
+	db := database.GetDB().WithContext(ctx)
+	cfg, err := s.Get(ctx, secretID)
+	if err != nil {
+		return err
+	}
+	if err := db.Delete(&models.SecretRotation{}, cfg.ID).Error; err != nil {
+		return err
+	}
+	if s.auditService != nil {
+		metadata, _ := json.Marshal(map[string]any{"rotator": cfg.Rotator, "disabled": true})
+		_ = s.auditService.Log(ctx, userID, cfg.OrgID, secretID, models.ActionSecretRotationConfig, "secret", ip, datatypes.JSON(metadata))
+	}
+	return nil
+}
+
+// RotateNow rotates a secret immediately on behalf of userID, who must be
+// allowed to read the admin connection. A failed rotation is recorded on the
+// config and returned; the stored secret is only replaced after the new
+// credential has been verified.
+func (s *RotationService) RotateNow(ctx context.Context, userID, secretID uuid.UUID, ip string) (*models.SecretRotation, error) {
+	cfg, err := s.Get(ctx, secretID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSecretReadable(database.GetDB().WithContext(ctx), userID, cfg.OrgID); err != nil {
+		return nil, err
+	}
+	if err := s.rotate(ctx, cfg, userID, "manual", ip); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// Run rotates due secrets every interval until ctx is cancelled.
+func (s *RotationService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if rotated, err := s.RunOnce(ctx); err != nil {
+			log.Printf("[envo] secret rotation: %v", err)
+		} else if rotated > 0 {
+			log.Printf("[envo] secret rotation: rotated %d secrets", rotated)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce rotates one batch of due secrets and returns how many succeeded.
+// Scheduled rotations are attributed to the user who configured them and
+// fail while that user can no longer read the admin connection.
+func (s *RotationService) RunOnce(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	var due []models.SecretRotation
+	if err := database.GetDB().WithContext(ctx).
+		Where("next_rotation_at <= ? AND (locked_until IS NULL OR locked_until < ?)", now, now).
+		Order("next_rotation_at ASC").
+		Limit(rotationBatchSize).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for i := range due {
+		err := s.rotate(ctx, &due[i], due[i].CreatedBy, "schedule", "")
+		switch {
+		case err == nil:
+			rotated++
+		case errors.Is(err, ErrRotationInProgress):
+			// another instance got it
+		default:
+			log.Printf("[envo] secret rotation: secret %s: %v", due[i].SecretID, err)
+		}
+	}
+	return rotated, nil
+}
+
+func (s *RotationService) auditStep(ctx context.Context, userID uuid.UUID, cfg *models.SecretRotation, step, trigger, ip string, detail map[string]any) {
+	if s.auditService == nil {
+		return
+	}
+	payload := map[string]any{"step": step, "rotator": cfg.Rotator, "trigger": trigger}
+	for k, v := range detail {
+		payload[k] = v
+	}
+	metadata, _ := json.Marshal(payload)
+	_ = s.auditService.Log(ctx, userID, cfg.OrgID, cfg.SecretID, models.ActionSecretRotationStep, "secret", ip, datatypes.JSON(metadata))
+}
+
+// claim takes the rotation lock. It returns false if another rotation holds it.
+func (s *RotationService) claim(db *gorm.DB, cfg *models.SecretRotation, now time.Time) (bool, error) {
+	until := now.Add(rotationLockTTL)
+	res := db.Model(&models.SecretRotation{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until < ?)", cfg.ID, now).
+		UpdateColumn("locked_until", until)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (s *RotationService) rotate(ctx context.Context, cfg *models.SecretRotation, userID uuid.UUID, trigger, ip string) error {
+	rotator, ok := s.rotators[cfg.Rotator]
+	if !ok {
+		return fmt.Errorf("%w: unknown rotator %q", ErrInvalidRotationConfig, cfg.Rotator)
+	}
+	db := database.GetDB().WithContext(ctx)
+	now := s.now().UTC()
+	claimed, err := s.claim(db, cfg, now)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrRotationInProgress
+	}
+
+	s.auditStep(ctx, userID, cfg, RotationStepStarted, trigger, ip, nil)
+	result, err := s.runSteps(ctx, rotator, cfg, userID, trigger, ip)
+	finished := s.now().UTC()
+
+	// Record the outcome even if the caller went away.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rotationFinishTimeout)
+	defer cancel()
+
+	updates := map[string]any{"locked_until": nil}
+	if err != nil {
+		s.auditStep(ctx, userID, cfg, RotationStepFailed, trigger, ip, map[string]any{"error": err.Error()})
+		cfg.LastStatus = models.RotationStatusFailed
+		cfg.LastError = err.Error()
+		if cfg.IntervalDays > 0 {
+			retry := finished.Add(rotationRetryInterval)
+			cfg.NextRotationAt = &retry
+		}
+	} else {
+		s.auditStep(ctx, userID, cfg, RotationStepCompleted, trigger, ip, map[string]any{"active_username": result.ActiveUser})
+		cfg.LastStatus = models.RotationStatusSucceeded
+		cfg.LastError = ""
+		cfg.LastRotatedAt = &finished
+		cfg.ActiveUser = result.ActiveUser
+		cfg.NextRotationAt = nil
+		if cfg.IntervalDays > 0 {
+			next := finished.AddDate(0, 0, cfg.IntervalDays)
+			cfg.NextRotationAt = &next
+		}
+		updates["last_rotated_at"] = cfg.LastRotatedAt
+		updates["active_user"] = cfg.ActiveUser
+	}
+	cfg.LockedUntil = nil
+	updates["last_status"] = cfg.LastStatus
+	updates["last_error"] = cfg.LastError
+	updates["next_rotation_at"] = cfg.NextRotationAt
+	if uerr := database.GetDB().WithContext(ctx).Model(&models.SecretRotation{}).Where("id = ?", cfg.ID).Updates(updates).Error; uerr != nil && err == nil {
+		return uerr
+	}
+	return err
+}
+
+func (s *RotationService) runSteps(ctx context.Context, rotator Rotator, cfg *models.SecretRotation, userID uuid.UUID, trigger, ip string) (*RotationResult, error) {
+	if err := checkSecretReadable(database.GetDB().WithContext(ctx), userID, cfg.OrgID); err != nil {
+		return nil, err
+	}
+	adminValue, _, _, err := s.secretService.readSecretValue(ctx, cfg.AdminSecretID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin connection: %w", err)
+	}
+	current, target, _, err := s.secretService.readSecretValue(ctx, cfg.SecretID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	req := RotationRequest{
+		Config:          *cfg,
+		AdminConnection: adminValue,
+		CurrentValue:    current,
+		TypeHint:        target.TypeHint,
+	}
+
+	result, err := rotator.Rotate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The external system now has the new credential. Cancelling here would
+	// lose it, so verify, store, or revert on a context of their own.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rotationFinishTimeout)
+	defer cancel()
+	s.auditStep(ctx, userID, cfg, RotationStepCredentialChanged, trigger, ip, map[string]any{"username": result.ActiveUser})
+
+	revert := func(cause error) error {
+		if rerr := rotator.Revert(ctx, req, result); rerr != nil {
+			return fmt.Errorf("%v (revert also failed: %v)", cause, rerr)
+		}
+		s.auditStep(ctx, userID, cfg, RotationStepReverted, trigger, ip, nil)
+		return cause
+	}
+
+	if err := rotator.Verify(ctx, req, result); err != nil {
+		return nil, revert(err)
+	}
+	s.auditStep(ctx, userID, cfg, RotationStepVerified, trigger, ip, nil)
+
+	if _, err := s.secretService.UpdateSecret(ctx, userID, cfg.SecretID, nil, &result.NewValue, SecretMetadataInput{}, ip); err != nil {
+		return nil, revert(fmt.Errorf("failed to store new value: %w", err))
+	}
+	s.auditStep(ctx, userID, cfg, RotationStepSecretUpdated, trigger, ip, nil)
+	return result, nil
+}
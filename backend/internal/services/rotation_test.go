@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// cancellingRotator cancels the caller's context as soon as the credential
+// has changed, like a client disconnecting mid-request.
+type cancellingRotator struct {
+	cancel    context.CancelFunc
+	verifyErr error
+}
+
+func (r *cancellingRotator) ValidateConfig(context.Context, *models.SecretRotation, string) error {
+	return nil
+}
+
+func (r *cancellingRotator) Rotate(context.Context, RotationRequest) (*RotationResult, error) {
+	r.cancel()
+	return &RotationResult{NewValue: "postgres://app:new@db/app", ActiveUser: "app"}, nil
+}
+
+func (r *cancellingRotator) Verify(ctx context.Context, _ RotationRequest, _ *RotationResult) error {
+	r.verifyErr = ctx.Err()
+	return ctx.Err()
+}
+
+func (r *cancellingRotator) Revert(context.Context, RotationRequest, *RotationResult) error {
+	return nil
+}
+
+func TestRotationStoresNewValueAfterCallerCancels(t *testing.T) {
+	db := useFakeDB(t)
+	encryptor := NewLocalEncryptionService("secret")
+	orgID, projectID, envID := uuid.New(), uuid.New(), uuid.New()
+	current, err := encryptor.Encrypt(context.Background(), "postgres://app:old@db/app", orgID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretID := uuid.New()
+	db.on([]string{`FROM "secrets"`}, []string{"id", "environment_id", "key", "encrypted_value", "kms_key_id"},
+		[]driver.Value{secretID.String(), envID.String(), "DATABASE_URL", current, "local"})
+	db.on([]string{`FROM "environments"`}, []string{"id", "project_id"}, []driver.Value{envID.String(), projectID.String()})
+	db.on([]string{`FROM "projects"`}, []string{"id", "org_id"}, []driver.Value{projectID.String(), orgID.String()})
+	allowSecretRead(db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rotator := &cancellingRotator{cancel: cancel}
+	service := NewRotationService(NewSecretService(encryptor, encryptor, nil, nil, nil, 1), nil, false)
+	cfg := &models.SecretRotation{ID: uuid.New(), SecretID: secretID, AdminSecretID: uuid.New(), Username: "app"}
+
+	if _, err := service.runSteps(ctx, rotator, cfg, uuid.New(), "manual", ""); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+	if rotator.verifyErr != nil {
+		t.Fatalf("Verify ran on a cancelled context: %v", rotator.verifyErr)
+	}
+	if writes := db.statements(`UPDATE "secrets"`); len(writes) != 1 {
+		t.Fatalf("secret updates = %d, want the new value stored once", len(writes))
+	}
+}
+
+// allowSecretRead grants the acting user secrets.read in every organization.
+func allowSecretRead(db *fakeDB) {
+	db.on([]string{`FROM "org_members"`, "permissions.name"}, []string{"count"}, []driver.Value{int64(1)})
+}
+
+// recordingRotator records whether it was asked to rotate.
+type recordingRotator struct{ rotated bool }
+
+func (r *recordingRotator) ValidateConfig(context.Context, *models.SecretRotation, string) error {
+	return nil
+}
+
+func (r *recordingRotator) Rotate(context.Context, RotationRequest) (*RotationResult, error) {
+	r.rotated = true
+	return &RotationResult{NewValue: "postgres://app:new@db/app", ActiveUser: "app"}, nil
+}
+
+func (r *recordingRotator) Verify(context.Context, RotationRequest, *RotationResult) error {
+	return nil
+}
+
+func (r *recordingRotator) Revert(context.Context, RotationRequest, *RotationResult) error {
+	return nil
+}
+
+func TestRotationRequiresReadAccessToAdminSecret(t *testing.T) {
+	orgID, projectID, envID := uuid.New(), uuid.New(), uuid.New()
+	secretID, adminID, creator := uuid.New(), uuid.New(), uuid.New()
+	// The caller can update the target secret but their role lacks
+	// secrets.read, so the admin connection in another environment is off
+	// limits.
+	rotationDB := func(t *testing.T) *fakeDB {
+		db := useFakeDB(t)
+		db.on([]string{`FROM "secrets"`}, []string{"id", "environment_id", "key"}, []driver.Value{secretID.String(), envID.String(), "DATABASE_URL"})
+		db.on([]string{`FROM "environments"`}, []string{"id", "project_id"}, []driver.Value{envID.String(), projectID.String()})
+		db.on([]string{`FROM "projects"`}, []string{"id", "org_id"}, []driver.Value{projectID.String(), orgID.String()})
+		db.on([]string{`FROM "org_members"`, "permissions.name"}, []string{"count"}, []driver.Value{int64(0)})
+		return db
+	}
+	rotator := &recordingRotator{}
+	service := NewRotationService(NewSecretService(NewLocalEncryptionService("secret"), nil, nil, nil, nil, 1), nil, false)
+	service.RegisterRotator("test", rotator)
+
+	t.Run("configure", func(t *testing.T) {
+		db := rotationDB(t)
+		_, err := service.Configure(t.Context(), uuid.New(), secretID, RotationConfigInput{Rotator: "test", AdminSecretID: adminID, Username: "app"}, "")
+		if !errors.Is(err, ErrSecretReadForbidden) {
+			t.Fatalf("Configure() error = %v, want ErrSecretReadForbidden", err)
+		}
+		if reads := db.statements(`FROM "secrets"`); len(reads) != 1 {
+			t.Fatalf("secret reads = %v, want only the target loaded", reads)
+		}
+		if writes := db.statements(`"secret_rotations"`); len(writes) != 0 {
+			t.Fatalf("denied configure touched the rotation config: %v", writes)
+		}
+	})
+	cfgRow := []driver.Value{uuid.NewString(), orgID.String(), secretID.String(), adminID.String(), "test", "app", creator.String(), int64(30), time.Now().Add(-time.Minute)}
+	cfgColumns := []string{"id", "org_id", "secret_id", "admin_secret_id", "rotator", "username", "created_by", "interval_days", "next_rotation_at"}
+	t.Run("rotate now", func(t *testing.T) {
+		db := rotationDB(t)
+		db.on([]string{`FROM "secret_rotations"`}, cfgColumns, cfgRow)
+		if _, err := service.RotateNow(t.Context(), uuid.New(), secretID, ""); !errors.Is(err, ErrSecretReadForbidden) {
+			t.Fatalf("RotateNow() error = %v, want ErrSecretReadForbidden", err)
+		}
+		if claims := db.statements(`UPDATE "secret_rotations"`); len(claims) != 0 || rotator.rotated {
+			t.Fatalf("denied rotation ran: %v", claims)
+		}
+	})
+	t.Run("schedule", func(t *testing.T) {
+		db := rotationDB(t)
+		db.on([]string{`FROM "secret_rotations"`}, cfgColumns, cfgRow)
+		if rotated, err := service.RunOnce(t.Context()); err != nil || rotated != 0 {
+			t.Fatalf("RunOnce() = %d, %v, want nothing rotated", rotated, err)
+		}
+		if rotator.rotated {
+			t.Fatal("scheduled rotation ran for a creator who cannot read the admin connection")
+		}
+		checks := db.statements(`FROM "org_members"`)
+		if len(checks) != 1 || checks[0].Args[0] != creator.String() {
+			t.Fatalf("permission checks = %v, want the creator checked", checks)
+		}
+		outcome := db.statements(`UPDATE "secret_rotations"`, "last_status")
+		if len(outcome) != 1 {
+			t.Fatalf("outcome updates = %v, want the failure recorded", outcome)
+		}
+		if status, _ := outcome[0].set("last_status"); status != models.RotationStatusFailed {
+			t.Fatalf("last_status = %v, want %s", status, models.RotationStatusFailed)
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/envo/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// PostgresRotator rotates PostgreSQL login passwords. The target secret may
+// hold either a bare password or a postgres:// URL; the new value keeps the
+// same shape. Its connections go through egress, so a connection URL cannot
+// reach Envo's own network.
+type PostgresRotator struct {
+	egress egressGuard
+}
+
+var _ Rotator = PostgresRotator{}
+
+// nextPostgresUser picks the role to rotate. With alternating roles it is
+// the one that is not currently in use.
+func nextPostgresUser(cfg *models.SecretRotation) string {
+	if cfg.Strategy != models.RotationStrategyAlternateRoles {
+		return cfg.Username
+	}
+	if cfg.ActiveUser == cfg.Username {
+		return cfg.AlternateUser
+	}
+	return cfg.Username
+}
+
+// valueIsPostgresURL reports whether a stored value is a connection URL
+// rather than a bare password.
+func valueIsPostgresURL(value, typeHint string) bool {
+	if typeHint == models.SecretTypeConnectionString {
+		return true
+	}
+	_, err := parsePostgresURL(value)
+	return err == nil
+}
+
+// ValidateConfig checks the config statically, then asks the server that
+// every target role exists and is an ordinary login role.
+func (r PostgresRotator) ValidateConfig(ctx context.Context, cfg *models.SecretRotation, adminConnection string) error {
+	if err := validatePostgresConfig(cfg, adminConnection); err != nil {
+		return err
+	}
+	admin, closeAdmin, err := openPostgres(ctx, r.egress, adminConnection)
+	if err != nil {
+		return fmt.Errorf("admin connection: %w", err)
+	}
+	defer closeAdmin()
+
+	roles := []string{cfg.Username}
+	if cfg.Strategy == models.RotationStrategyAlternateRoles {
+		roles = append(roles, cfg.AlternateUser)
+	}
+	return checkPostgresTargets(admin, roles...)
+}
+
+// validatePostgresConfig holds the checks that need no server round trip.
+func validatePostgresConfig(cfg *models.SecretRotation, adminConnection string) error {
+	if !validPostgresRole(cfg.Username) {
+		return fmt.Errorf("username must be a plain PostgreSQL role name")
+	}
+	if cfg.Strategy == models.RotationStrategyAlternateRoles {
+		if !validPostgresRole(cfg.AlternateUser) {
+			return fmt.Errorf("alternate_username must be a plain PostgreSQL role name")
+		}
+		if cfg.AlternateUser == cfg.Username {
+			return fmt.Errorf("alternate_username must differ from username")
+		}
+	}
+	u, err := parsePostgresURL(adminConnection)
+	if err != nil {
+		return fmt.Errorf("admin secret: %v", err)
+	}
+	if adminUser := u.User.Username(); adminUser != "" {
+		if cfg.Username == adminUser || (cfg.Strategy == models.RotationStrategyAlternateRoles && cfg.AlternateUser == adminUser) {
+			return fmt.Errorf("cannot rotate the admin connection's own role %q", adminUser)
+		}
+	}
+	return nil
+}
+
+// postgresPrivilegedRoleQuery reports whether a role is privileged: a
+// superuser or holder of CREATEROLE, REPLICATION or BYPASSRLS, directly or
+// through membership, or a member of the admin session's own role.
+const postgresPrivilegedRoleQuery = `SELECT r.rolsuper OR r.rolcreaterole OR r.rolreplication OR r.rolbypassrls
+	OR pg_has_role(r.oid, session_user, 'MEMBER')
+	OR EXISTS (
+		SELECT 1 FROM pg_roles p
+		WHERE p.oid <> r.oid
+		  AND (p.rolsuper OR p.rolcreaterole OR p.rolreplication OR p.rolbypassrls)
+		  AND pg_has_role(r.oid, p.oid, 'MEMBER')
+	) AS privileged
+FROM pg_roles r WHERE r.rolname = ?`
+
+// checkPostgresTargets refuses roles that do not exist, the admin session's
+// own role, and privileged roles. Rotating those would hand Envo's rotation
+// secret control over the server, or lock the admin connection out.
+func checkPostgresTargets(admin *gorm.DB, roles ...string) error {
+	var sessionUser string
+	if err := admin.Raw("SELECT session_user").Scan(&sessionUser).Error; err != nil {
+		return fmt.Errorf("admin connection: %w", err)
+	}
+	for _, role := range roles {
+		if role == sessionUser {
+			return fmt.Errorf("cannot rotate the admin connection's own role %q", role)
+		}
+		var rows []struct{ Privileged bool }
+		if err := admin.Raw(postgresPrivilegedRoleQuery, role).Scan(&rows).Error; err != nil {
+			return fmt.Errorf("failed to look up role %s: %w", role, err)
+		}
+		if len(rows) == 0 {
+			return fmt.Errorf("role %q does not exist", role)
+		}
+		if rows[0].Privileged {
+			return fmt.Errorf("role %q is privileged; only ordinary login roles can be rotated", role)
+		}
+	}
+	return nil
+}
+
+func (r PostgresRotator) Rotate(ctx context.Context, req RotationRequest) (*RotationResult, error) {
+	user := nextPostgresUser(&req.Config)
+	if !validPostgresRole(user) {
+		return nil, fmt.Errorf("invalid role name %q", user)
+	}
+	password, err := generatePostgresPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	newValue := password
+	if valueIsPostgresURL(req.CurrentValue, req.TypeHint) {
+		newValue, err = postgresURLWithCredentials(req.CurrentValue, user, password)
+		if err != nil {
+			return nil, fmt.Errorf("target secret: %w", err)
+		}
+	}
+
+	admin, closeAdmin, err := openPostgres(ctx, r.egress, req.AdminConnection)
+	if err != nil {
+		return nil, fmt.Errorf("admin connection: %w", err)
+	}
+	defer closeAdmin()
+
+	// Privileges can change after the rotation was configured.
+	if err := checkPostgresTargets(admin, user); err != nil {
+		return nil, err
+	}
+	stmt := fmt.Sprintf("ALTER ROLE %s WITH PASSWORD %s", quotePostgresIdent(user), quotePostgresLiteral(password))
+	if err := admin.Exec(stmt).Error; err != nil {
+		return nil, fmt.Errorf("failed to set password for %s: %w", user, err)
+	}
+	return &RotationResult{NewValue: newValue, ActiveUser: user}, nil
+}
+
+func (r PostgresRotator) Verify(ctx context.Context, req RotationRequest, result *RotationResult) error {
+	dsn := result.NewValue
+	if !valueIsPostgresURL(result.NewValue, req.TypeHint) {
+		var err error
+		dsn, err = postgresURLWithCredentials(req.AdminConnection, result.ActiveUser, result.NewValue)
+		if err != nil {
+			return err
+		}
+	}
+	db, closeDB, err := openPostgres(ctx, r.egress, dsn)
+	if err != nil {
+		return fmt.Errorf("new credential rejected: %w", err)
+	}
+	defer closeDB()
+
+	var currentUser string
+	if err := db.Raw("SELECT current_user").Scan(&currentUser).Error; err != nil {
+		return fmt.Errorf("new credential rejected: %w", err)
+	}
+	if currentUser != result.ActiveUser {
+		return fmt.Errorf("new credential authenticated as %q, expected %q", currentUser, result.ActiveUser)
+	}
+	return nil
+}
+
+// Revert restores the previous password when a single role is rotated. With
+// alternating roles the previous role was never touched, so there is nothing
+// to undo.
+func (r PostgresRotator) Revert(ctx context.Context, req RotationRequest, result *RotationResult) error {
+	if req.Config.Strategy == models.RotationStrategyAlternateRoles {
+		return nil
+	}
+	previous := req.CurrentValue
+	if valueIsPostgresURL(req.CurrentValue, req.TypeHint) {
+		u, err := url.Parse(strings.TrimSpace(req.CurrentValue))
+		if err != nil || u.User == nil {
+			return fmt.Errorf("previous password unknown")
+		}
+		previous, _ = u.User.Password()
+	}
+	if previous == "" {
+		return fmt.Errorf("previous password unknown")
+	}
+
+	admin, closeAdmin, err := openPostgres(ctx, r.egress, req.AdminConnection)
+	if err != nil {
+		return fmt.Errorf("admin connection: %w", err)
+	}
+	defer closeAdmin()
+
+	stmt := fmt.Sprintf("ALTER ROLE %s WITH PASSWORD %s", quotePostgresIdent(result.ActiveUser), quotePostgresLiteral(previous))
+	return admin.Exec(stmt).Error
+}
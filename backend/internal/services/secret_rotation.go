@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Stale secret states, from most to least urgent.
+const (
+	StaleStatusExpired = "expired"
+	StaleStatusOverdue = "overdue"
+	StaleStatusDueSoon = "due_soon"
+)
+
+const rotationReminderBatchSize = 500
+
+// StaleSecret is one secret in the stale secrets report.
+type StaleSecret struct {
+	SecretID             uuid.UUID  `json:"secret_id"`
+	Key                  string     `json:"key"`
+	ProjectID            uuid.UUID  `json:"project_id"`
+	ProjectName          string     `json:"project_name"`
+	EnvironmentID        uuid.UUID  `json:"environment_id"`
+	EnvironmentName      string     `json:"environment_name"`
+	OwnerUserID          *uuid.UUID `json:"owner_user_id,omitempty"`
+	OwnerTeam            string     `json:"owner_team,omitempty"`
+	ExpiresAt            *time.Time `json:"expires_at,omitempty"`
+	RotationIntervalDays int        `json:"rotation_interval_days,omitempty"`
+	ValueUpdatedAt       *time.Time `json:"value_updated_at,omitempty"`
+	RotationDueAt        time.Time  `json:"rotation_due_at"`
+	Status               string     `json:"status"`
+}
+
+// StaleSecretsReport lists secrets that are expired or due for rotation within a window.
+type StaleSecretsReport struct {
+	OrgID       uuid.UUID     `json:"org_id"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	WithinDays  int           `json:"within_days"`
+	Expired     int           `json:"expired"`
+	Overdue     int           `json:"overdue"`
+	DueSoon     int           `json:"due_soon"`
+	Secrets     []StaleSecret `json:"secrets"`
+}
+
+func staleStatus(sec *models.Secret, now time.Time) string {
+	switch {
+	case sec.IsExpired(now):
+		return StaleStatusExpired
+	case sec.RotationDueAt != nil && !now.Before(*sec.RotationDueAt):
+		return StaleStatusOverdue
+	default:
+		return StaleStatusDueSoon
+	}
+}
+
+// StaleSecrets reports the org's secrets whose expiry or rotation deadline has
+// passed or falls within withinDays. Values are never read.
+func (s *SecretService) StaleSecrets(ctx context.Context, orgID uuid.UUID, withinDays int) (*StaleSecretsReport, error) {
+	db := database.GetDB().WithContext(ctx)
+	now := time.Now().UTC()
+
+	var secrets []models.Secret
+	if err := db.Preload("Environment.Project").
+		Joins("JOIN environments ON environments.id = secrets.environment_id AND environments.deleted_at IS NULL").
+		Joins("JOIN projects ON projects.id = environments.project_id AND projects.deleted_at IS NULL").
+		Where("projects.org_id = ? AND secrets.rotation_due_at <= ?", orgID, now.AddDate(0, 0, withinDays)).
+		Order("secrets.rotation_due_at ASC, secrets.key ASC").
+		Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+
+	report := &StaleSecretsReport{
+		OrgID:       orgID,
+		GeneratedAt: now,
+		WithinDays:  withinDays,
+		Secrets:     make([]StaleSecret, 0, len(secrets)),
+	}
+	for i := range secrets {
+		sec := &secrets[i]
+		status := staleStatus(sec, now)
+		switch status {
+		case StaleStatusExpired:
+			report.Expired++
+		case StaleStatusOverdue:
+			report.Overdue++
+		default:
+			report.DueSoon++
+		}
+		report.Secrets = append(report.Secrets, StaleSecret{
+			SecretID:             sec.ID,
+			Key:                  sec.Key,
+			ProjectID:            sec.Environment.ProjectID,
+			ProjectName:          sec.Environment.Project.Name,
+			EnvironmentID:        sec.EnvironmentID,
+			EnvironmentName:      sec.Environment.Name,
+			OwnerUserID:          sec.OwnerUserID,
+			OwnerTeam:            sec.OwnerTeam,
+			ExpiresAt:            sec.ExpiresAt,
+			RotationIntervalDays: sec.RotationIntervalDays,
+			ValueUpdatedAt:       sec.ValueUpdatedAt,
+			RotationDueAt:        *sec.RotationDueAt,
+			Status:               status,
+		})
+	}
+	return report, nil
+}
+
+// SecretRotationNotifier periodically emails owners about secrets that are
+// expired or due for rotation. Each secret is reminded once per deadline; a
+// new value or a changed deadline re-arms the reminder. Claims are made with
+// a conditional update so several API instances can run it concurrently.
+type SecretRotationNotifier struct {
+	emailSender EmailSender
+	frontendURL string
+	lead        time.Duration
+	now         func() time.Time
+}
+
+// NewSecretRotationNotifier creates a notifier that warns lead ahead of each deadline.
+func NewSecretRotationNotifier(emailSender EmailSender, frontendURL string, lead time.Duration) *SecretRotationNotifier {
+	if emailSender == nil {
+		emailSender = &LogEmailSender{}
+	}
+	return &SecretRotationNotifier{
+		emailSender: emailSender,
+		frontendURL: frontendURL,
+		lead:        lead,
+		now:         time.Now,
+	}
+}
+
+// Run checks for due secrets every interval until ctx is cancelled.
+func (n *SecretRotationNotifier) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if sent, err := n.RunOnce(ctx); err != nil {
+			log.Printf("[envo] rotation reminders: %v", err)
+		} else if sent > 0 {
+			log.Printf("[envo] rotation reminders: notified about %d secrets", sent)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type rotationRecipient struct {
+	email string
+	orgID uuid.UUID
+}
+
+type claimedReminder struct {
+	secretID uuid.UUID
+	notice   SecretRotationNotice
+}
+
+// RunOnce sends one batch of reminders and returns how many secrets were
+// included in delivered emails.
+func (n *SecretRotationNotifier) RunOnce(ctx context.Context) (int, error) {
+	db := database.GetDB().WithContext(ctx)
+	now := n.now().UTC()
+
+	var secrets []models.Secret
+	if err := db.Preload("Environment.Project.Organization.Owner").
+		Joins("JOIN environments ON environments.id = secrets.environment_id AND environments.deleted_at IS NULL").
+		Joins("JOIN projects ON projects.id = environments.project_id AND projects.deleted_at IS NULL").
+		// Secrets nobody can be emailed about are never claimed, so leave
+		// them out rather than let them fill every batch.
+		Joins("JOIN organizations ON organizations.id = projects.org_id AND organizations.deleted_at IS NULL").
+		Joins("LEFT JOIN users AS org_owners ON org_owners.id = organizations.owner_id AND org_owners.deleted_at IS NULL").
+		Joins("LEFT JOIN users AS secret_owners ON secret_owners.id = secrets.owner_user_id AND secret_owners.deleted_at IS NULL").
+		Where("secrets.rotation_due_at <= ? AND secrets.rotation_notified_at IS NULL", now.Add(n.lead)).
+		Where("COALESCE(NULLIF(secret_owners.email, ''), NULLIF(org_owners.email, '')) IS NOT NULL").
+		Order("secrets.rotation_due_at ASC").
+		Limit(rotationReminderBatchSize).
+		Find(&secrets).Error; err != nil {
+		return 0, err
+	}
+	if len(secrets) == 0 {
+		return 0, nil
+	}
+
+	ownerIDs := make([]uuid.UUID, 0)
+	for _, sec := range secrets {
+		if sec.OwnerUserID != nil {
+			ownerIDs = append(ownerIDs, *sec.OwnerUserID)
+		}
+	}
+	owners := map[uuid.UUID]models.User{}
+	if len(ownerIDs) > 0 {
+		var users []models.User
+		if err := db.Where("id IN ?", ownerIDs).Find(&users).Error; err != nil {
+			return 0, err
+		}
+		for _, u := range users {
+			owners[u.ID] = u
+		}
+	}
+
+	batches := map[rotationRecipient][]claimedReminder{}
+	orgNames := map[uuid.UUID]string{}
+	for i := range secrets {
+		sec := &secrets[i]
+		org := sec.Environment.Project.Organization
+		email := org.Owner.Email
+		if sec.OwnerUserID != nil {
+			if owner, ok := owners[*sec.OwnerUserID]; ok && owner.Email != "" {
+				email = owner.Email
+			}
+		}
+		if email == "" {
+			continue
+		}
+
+		claim := db.Model(&models.Secret{}).
+			Where("id = ? AND rotation_notified_at IS NULL", sec.ID).
+			UpdateColumn("rotation_notified_at", now)
+		if claim.Error != nil {
+			return 0, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue // another instance got it
+		}
+
+		key := rotationRecipient{email: email, orgID: org.ID}
+		orgNames[org.ID] = org.Name
+		batches[key] = append(batches[key], claimedReminder{
+			secretID: sec.ID,
+			notice: SecretRotationNotice{
+				Key:             sec.Key,
+				ProjectName:     sec.Environment.Project.Name,
+				EnvironmentName: sec.Environment.Name,
+				DueAt:           *sec.RotationDueAt,
+				Expired:         sec.IsExpired(now),
+			},
+		})
+	}
+
+	recipients := make([]rotationRecipient, 0, len(batches))
+	for r := range batches {
+		recipients = append(recipients, r)
+	}
+	sort.Slice(recipients, func(i, j int) bool { return recipients[i].email < recipients[j].email })
+
+	sent := 0
+	var firstErr error
+	for _, r := range recipients {
+		claimed := batches[r]
+		notices := make([]SecretRotationNotice, 0, len(claimed))
+		for _, c := range claimed {
+			notices = append(notices, c.notice)
+		}
+		reportURL := fmt.Sprintf("%s/orgs/%s", n.frontendURL, r.orgID)
+		if err := n.emailSender.SendSecretRotationReminder(r.email, orgNames[r.orgID], notices, reportURL); err != nil {
+			// Release the claims so the next run retries.
+			ids := make([]uuid.UUID, 0, len(claimed))
+			for _, c := range claimed {
+				ids = append(ids, c.secretID)
+			}
+			_ = db.Model(&models.Secret{}).Where("id IN ?", ids).UpdateColumn("rotation_notified_at", nil).Error
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to send reminder to %s: %w", r.email, err)
+			}
+			continue
+		}
+		sent += len(claimed)
+	}
+	return sent, firstErr
+}
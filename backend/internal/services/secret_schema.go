@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/envo/backend/internal/database"
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidSchema is wrapped by errors describing a malformed schema definition.
+	ErrInvalidSchema = errors.New("invalid secret schema")
+	// ErrSchemaNotDefined is returned when a report is requested for a project without a schema.
+	ErrSchemaNotDefined = errors.New("project has no secret schema")
+	// ErrSchemaViolation is matched by SchemaValidationError.
+	ErrSchemaViolation = errors.New("value does not satisfy the project schema")
+)
+
+const maxSchemaKeys = 500
+
+// SchemaViolation describes one failed rule. Messages never include the value.
+type SchemaViolation struct {
+	Key     string `json:"key"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// SchemaValidationError is returned by secret writes rejected by the schema.
+type SchemaValidationError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Key+": "+v.Message)
+	}
+	return ErrSchemaViolation.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *SchemaValidationError) Unwrap() error { return ErrSchemaViolation }
+
+type compiledSchemaRule struct {
+	models.SecretSchemaRule
+	pattern *regexp.Regexp
+}
+
+// compiledSchema is a validated SecretSchema with its patterns compiled.
+type compiledSchema struct {
+	rules map[string]compiledSchemaRule
+}
+
+// compileSchema validates a schema definition. A nil schema compiles to nil.
+func compileSchema(schema *models.SecretSchema) (*compiledSchema, error) {
+	if schema == nil || len(schema.Keys) == 0 {
+		return nil, nil
+	}
+	if len(schema.Keys) > maxSchemaKeys {
+		return nil, fmt.Errorf("%w: at most %d keys are allowed", ErrInvalidSchema, maxSchemaKeys)
+	}
+	cs := &compiledSchema{rules: make(map[string]compiledSchemaRule, len(schema.Keys))}
+	for key, rule := range schema.Keys {
+		if strings.TrimSpace(key) == "" || len(key) > 255 {
+			return nil, fmt.Errorf("%w: key names must be 1-255 characters", ErrInvalidSchema)
+		}
+		switch rule.Type {
+		case "", models.SchemaTypeString, models.SchemaTypeInteger, models.SchemaTypeNumber,
+			models.SchemaTypeBoolean, models.SchemaTypeURL, models.SchemaTypeJSON:
+		default:
+			return nil, fmt.Errorf("%w: %s: unknown type %q", ErrInvalidSchema, key, rule.Type)
+		}
+		if rule.MinLength != nil && *rule.MinLength < 0 || rule.MaxLength != nil && *rule.MaxLength < 0 {
+			return nil, fmt.Errorf("%w: %s: lengths must not be negative", ErrInvalidSchema, key)
+		}
+		if rule.MinLength != nil && rule.MaxLength != nil && *rule.MinLength > *rule.MaxLength {
+			return nil, fmt.Errorf("%w: %s: min_length is greater than max_length", ErrInvalidSchema, key)
+		}
+		compiled := compiledSchemaRule{SecretSchemaRule: rule}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: bad pattern: %v", ErrInvalidSchema, key, err)
+			}
+			compiled.pattern = re
+		}
+		cs.rules[key] = compiled
+	}
+	return cs, nil
+}
+
+func (cs *compiledSchema) hasRule(key string) bool {
+	if cs == nil {
+		return false
+	}
+	_, ok := cs.rules[key]
+	return ok
+}
+
+// checkValue returns the rules value breaks for key. Keys without a rule
+// always pass.
+func (cs *compiledSchema) checkValue(key, value string) []SchemaViolation {
+	if cs == nil {
+		return nil
+	}
+	rule, ok := cs.rules[key]
+	if !ok {
+		return nil
+	}
+	var out []SchemaViolation
+	fail := func(name, msg string) {
+		out = append(out, SchemaViolation{Key: key, Rule: name, Message: msg})
+	}
+
+	switch rule.Type {
+	case models.SchemaTypeInteger:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			fail("type", "must be an integer")
+		}
+	case models.SchemaTypeNumber:
+		if f, err := strconv.ParseFloat(value, 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			fail("type", "must be a number")
+		}
+	case models.SchemaTypeBoolean:
+		if _, err := strconv.ParseBool(value); err != nil {
+			fail("type", "must be a boolean")
+		}
+	case models.SchemaTypeURL:
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			fail("type", "must be an absolute URL")
+		}
+	case models.SchemaTypeJSON:
+		if !json.Valid([]byte(value)) {
+			fail("type", "must be valid JSON")
+		}
+	}
+
+	length := utf8.RuneCountInString(value)
+	if rule.MinLength != nil && length < *rule.MinLength {
+		fail("min_length", fmt.Sprintf("must be at least %d characters", *rule.MinLength))
+	}
+	if rule.MaxLength != nil && length > *rule.MaxLength {
+		fail("max_length", fmt.Sprintf("must be at most %d characters", *rule.MaxLength))
+	}
+	if rule.pattern != nil && !rule.pattern.MatchString(value) {
+		fail("pattern", "does not match "+rule.Pattern)
+	}
+	if len(rule.Enum) > 0 {
+		allowed := false
+		for _, option := range rule.Enum {
+			if value == option {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			fail("enum", "must be one of "+strings.Join(rule.Enum, ", "))
+		}
+	}
+	return out
+}
+
+// checkEnvironment validates a full set of decrypted values. Skipped keys
+// (undecryptable or excluded as expired) are reported as violations because
+// they cannot be checked.
+func (cs *compiledSchema) checkEnvironment(values map[string]string, skipped []SkippedSecret) (missing []string, invalid []SchemaViolation) {
+	missing = []string{}
+	invalid = []SchemaViolation{}
+	if cs == nil {
+		return missing, invalid
+	}
+	unreadable := make(map[string]string, len(skipped))
+	for _, s := range skipped {
+		unreadable[s.Key] = s.Reason
+	}
+	keys := make([]string, 0, len(cs.rules))
+	for key := range cs.rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if reason, ok := unreadable[key]; ok {
+			if reason == SkipReasonExpired {
+				invalid = append(invalid, SchemaViolation{Key: key, Rule: "expired", Message: "value has expired"})
+			} else {
+				invalid = append(invalid, SchemaViolation{Key: key, Rule: "decrypt", Message: "value could not be decrypted"})
+			}
+			continue
+		}
+		value, ok := values[key]
+		if !ok {
+			if cs.rules[key].Required {
+				missing = append(missing, key)
+			}
+			continue
+		}
+		invalid = append(invalid, cs.checkValue(key, value)...)
+	}
+	return missing, invalid
+}
+
+// SchemaEnvironmentReport is the validation result for one environment.
+type SchemaEnvironmentReport struct {
+	EnvironmentID   uuid.UUID         `json:"environment_id"`
+	EnvironmentName string            `json:"environment_name"`
+	Valid           bool              `json:"valid"`
+	Missing         []string          `json:"missing"`
+	Invalid         []SchemaViolation `json:"invalid"`
+}
+
+// SchemaReport validates every environment of a project against its schema.
+type SchemaReport struct {
+	ProjectID    uuid.UUID                 `json:"project_id"`
+	ProjectName  string                    `json:"project_name"`
+	CheckedAt    time.Time                 `json:"checked_at"`
+	Valid        bool                      `json:"valid"`
+	Environments []SchemaEnvironmentReport `json:"environments"`
+}
+
+// ValidateProjectSecrets checks the current values of a project's
+// environments against its schema. envSelector, when non-empty, limits the
+// report to the environment with that ID or (case-insensitive) name.
+func (s *SecretService) ValidateProjectSecrets(ctx context.Context, userID, projectID uuid.UUID, envSelector, ip string) (*SchemaReport, error) {
+	db := database.GetDB().WithContext(ctx)
+
+	var project models.Project
+	if err := db.Preload("Environments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at ASC")
+	}).First(&project, projectID).Error; err != nil {
+		return nil, err
+	}
+	definition, err := project.Schema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode project schema: %w", err)
+	}
+	schema, err := compileSchema(definition)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return nil, ErrSchemaNotDefined
+	}
+
+	envs := project.Environments
+	if envSelector = strings.TrimSpace(envSelector); envSelector != "" {
+		envs = nil
+		for _, env := range project.Environments {
+			if env.ID.String() == envSelector || strings.EqualFold(env.Name, envSelector) {
+				envs = append(envs, env)
+			}
+		}
+		if len(envs) == 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+
+	report := &SchemaReport{
+		ProjectID:    project.ID,
+		ProjectName:  project.Name,
+		CheckedAt:    time.Now().UTC(),
+		Valid:        true,
+		Environments: make([]SchemaEnvironmentReport, 0, len(envs)),
+	}
+	invalidEnvs := []string{}
+	for _, env := range envs {
+		values, skipped, _, err := s.DecryptEnvironmentSecrets(ctx, env.ID, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		missing, invalid := schema.checkEnvironment(values, skipped)
+		envReport := SchemaEnvironmentReport{
+			EnvironmentID:   env.ID,
+			EnvironmentName: env.Name,
+			Valid:           len(missing) == 0 && len(invalid) == 0,
+			Missing:         missing,
+			Invalid:         invalid,
+		}
+		if !envReport.Valid {
+			report.Valid = false
+			invalidEnvs = append(invalidEnvs, env.Name)
+		}
+		report.Environments = append(report.Environments, envReport)
+	}
+
+	if s.auditService != nil {
+		metadata, _ := json.Marshal(map[string]any{
+			"environments":         len(report.Environments),
+			"invalid_environments": invalidEnvs,
+		})
+		_ = s.auditService.Log(ctx, userID, project.OrgID, project.ID, models.ActionSecretSchemaCheck, "project", ip, metadata)
+	}
+	return report, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/envo/backend/internal/models"
+)
+
+func intPtr(n int) *int { return &n }
+
+func TestCompileSchemaRejectsBadDefinitions(t *testing.T) {
+	for name, rule := range map[string]models.SecretSchemaRule{
+		"unknown type":   {Type: "date"},
+		"bad pattern":    {Pattern: "("},
+		"inverted range": {MinLength: intPtr(5), MaxLength: intPtr(2)},
+		"negative":       {MinLength: intPtr(-1)},
+	} {
+		schema := &models.SecretSchema{Keys: map[string]models.SecretSchemaRule{"KEY": rule}}
+		if _, err := compileSchema(schema); !errors.Is(err, ErrInvalidSchema) {
+			t.Errorf("%s: compileSchema() error = %v, want ErrInvalidSchema", name, err)
+		}
+	}
+	if cs, err := compileSchema(nil); cs != nil || err != nil {
+		t.Fatalf("compileSchema(nil) = %v, %v", cs, err)
+	}
+}
+
+func TestSchemaCheckValue(t *testing.T) {
+	cs, err := compileSchema(&models.SecretSchema{Keys: map[string]models.SecretSchemaRule{
+		"PORT":      {Type: models.SchemaTypeInteger},
+		"DEBUG":     {Type: models.SchemaTypeBoolean},
+		"API_URL":   {Type: models.SchemaTypeURL},
+		"FLAGS":     {Type: models.SchemaTypeJSON},
+		"RATIO":     {Type: models.SchemaTypeNumber},
+		"LOG_LEVEL": {Enum: []string{"debug", "info"}},
+		"REGION":    {Pattern: `^[a-z]{2}-[a-z]+-\d$`},
+		"TOKEN":     {MinLength: intPtr(4), MaxLength: intPtr(8)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		key, value string
+		rules      []string
+	}{
+		{"PORT", "8080", nil},
+		{"PORT", "abc", []string{"type"}},
+		{"DEBUG", "true", nil},
+		{"DEBUG", "maybe", []string{"type"}},
+		{"API_URL", "https://api.example.com", nil},
+		{"API_URL", "api.example.com", []string{"type"}},
+		{"FLAGS", `{"a":1}`, nil},
+		{"FLAGS", `{a:1}`, []string{"type"}},
+		{"RATIO", "0.5", nil},
+		{"RATIO", "NaN", []string{"type"}},
+		{"LOG_LEVEL", "info", nil},
+		{"LOG_LEVEL", "trace", []string{"enum"}},
+		{"REGION", "us-east-1", nil},
+		{"REGION", "useast1", []string{"pattern"}},
+		{"TOKEN", "abc", []string{"min_length"}},
+		{"TOKEN", "abcdefghi", []string{"max_length"}},
+		{"UNLISTED", "anything", nil},
+	}
+	for _, tc := range cases {
+		var rules []string
+		for _, v := range cs.checkValue(tc.key, tc.value) {
+			rules = append(rules, v.Rule)
+		}
+		if !reflect.DeepEqual(rules, tc.rules) {
+			t.Errorf("checkValue(%s=%q) rules = %v, want %v", tc.key, tc.value, rules, tc.rules)
+		}
+	}
+}
+
+func TestSchemaCheckEnvironment(t *testing.T) {
+	cs, err := compileSchema(&models.SecretSchema{Keys: map[string]models.SecretSchemaRule{
+		"DATABASE_URL": {Required: true, Type: models.SchemaTypeURL},
+		"PORT":         {Required: true, Type: models.SchemaTypeInteger},
+		"SENTRY_DSN":   {Type: models.SchemaTypeURL},
+		"API_KEY":      {Required: true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing, invalid := cs.checkEnvironment(
+		map[string]string{"PORT": "abc", "EXTRA": "x"},
+		[]SkippedSecret{{Key: "API_KEY", Reason: SkipReasonDecryptFailed}},
+	)
+	if !reflect.DeepEqual(missing, []string{"DATABASE_URL"}) {
+		t.Fatalf("missing = %v", missing)
+	}
+	want := []SchemaViolation{
+		{Key: "API_KEY", Rule: "decrypt", Message: "value could not be decrypted"},
+		{Key: "PORT", Rule: "type", Message: "must be an integer"},
+	}
+	if !reflect.DeepEqual(invalid, want) {
+		t.Fatalf("invalid = %+v, want %+v", invalid, want)
+	}
+}
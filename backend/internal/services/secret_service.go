@@ -2,16 +2,22 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/envo/backend/internal/database"
 	"github.com/envo/backend/internal/models"
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Encryptor is the interface for encrypting/decrypting secrets.
@@ -32,12 +38,13 @@ type SecretService struct {
 	localEncryptor     Encryptor // optional; used to decrypt secrets stored with KMSKeyID "local"
 	tierService        *TierService
 	auditService       *AuditService
+	policies           *PolicyService // optional; org access policies
 	decryptConcurrency int
 }
 
 // NewSecretService creates a new secret service. Pass localEncryptor so secrets
 // stored with local encryption can be decrypted when primary is KMS (or vice versa).
-func NewSecretService(encryptor Encryptor, localEncryptor Encryptor, tier *TierService, audit *AuditService, decryptConcurrency int) *SecretService {
+func NewSecretService(encryptor Encryptor, localEncryptor Encryptor, tier *TierService, audit *AuditService, policies *PolicyService, decryptConcurrency int) *SecretService {
 	if decryptConcurrency <= 0 {
 		decryptConcurrency = 8
 	}
@@ -46,6 +53,7 @@ func NewSecretService(encryptor Encryptor, localEncryptor Encryptor, tier *TierS
 		localEncryptor:     localEncryptor,
 		tierService:        tier,
 		auditService:       audit,
+		policies:           policies,
 		decryptConcurrency: decryptConcurrency,
 	}
 }
@@ -59,8 +67,239 @@ func (s *SecretService) resolveWorkspaceID(db *gorm.DB, envID uuid.UUID) (uuid.U
 	return env.Project.OrgID, nil
 }
 
+// schemaForEnvironment loads and compiles the schema of the environment's
+// project. It returns nil when the project has no schema.
+func schemaForEnvironment(db *gorm.DB, envID uuid.UUID) (*compiledSchema, error) {
+	var env models.Environment
+	if err := db.Preload("Project").First(&env, envID).Error; err != nil {
+		return nil, err
+	}
+	schema, err := env.Project.Schema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode project schema: %w", err)
+	}
+	return compileSchema(schema)
+}
+
+// checkSchema rejects a value that breaks the environment's project schema.
+func checkSchema(db *gorm.DB, envID uuid.UUID, key, value string) error {
+	schema, err := schemaForEnvironment(db, envID)
+	if err != nil {
+		return err
+	}
+	if violations := schema.checkValue(key, value); len(violations) > 0 {
+		return &SchemaValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// ErrInvalidSecretMetadata is wrapped by errors for bad descriptions, tags,
+// owners, or type hints so handlers can answer 400 instead of 500.
+var ErrInvalidSecretMetadata = errors.New("invalid secret metadata")
+
+// ErrSecretLimitReached is returned when creating a secret would exceed the
+// workspace tier's per-environment limit.
+var ErrSecretLimitReached = errors.New("secret limit reached for this environment")
+
+const (
+	maxRotationIntervalDays = 3650
+	maxSecretTags           = 20
+	maxSecretTagLength      = 50
+	maxSecretDescription    = 1000
+	maxSecretOwnerTeamName  = 100
+)
+
+var secretTypeHints = map[string]bool{
+	models.SecretTypeString:           true,
+	models.SecretTypeURL:              true,
+	models.SecretTypeJSON:             true,
+	models.SecretTypePEM:              true,
+	models.SecretTypeConnectionString: true,
+}
+
+// SecretMetadataInput carries optional metadata for create and update. Nil
+// fields are left untouched; OwnerUserID set to uuid.Nil, a zero ExpiresAt,
+// and a zero RotationIntervalDays clear those settings.
+type SecretMetadataInput struct {
+	Description          *string
+	Tags                 *[]string
+	OwnerUserID          *uuid.UUID
+	OwnerTeam            *string
+	TypeHint             *string
+	Sensitive            *bool
+	ExpiresAt            *time.Time
+	RotationIntervalDays *int
+}
+
+// normalizeTags lowercases, trims, dedupes, and sorts tags, rejecting any
+// that are too long or contain characters outside [a-z0-9_.:-].
+func normalizeTags(tags []string) ([]string, error) {
+	set := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if len(tag) > maxSecretTagLength {
+			return nil, fmt.Errorf("%w: tag %q is longer than %d characters", ErrInvalidSecretMetadata, tag, maxSecretTagLength)
+		}
+		for _, r := range tag {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.' || r == ':') {
+				return nil, fmt.Errorf("%w: tag %q may only contain letters, digits, '_', '-', '.', and ':'", ErrInvalidSecretMetadata, tag)
+			}
+		}
+		set[tag] = struct{}{}
+	}
+	if len(set) > maxSecretTags {
+		return nil, fmt.Errorf("%w: at most %d tags are allowed", ErrInvalidSecretMetadata, maxSecretTags)
+	}
+	out := make([]string, 0, len(set))
+	for tag := range set {
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// applyMetadata validates meta and copies the provided fields onto secret.
+// orgID is the secret's organization; an owner user must be a member of it.
+func applyMetadata(db *gorm.DB, secret *models.Secret, orgID uuid.UUID, meta SecretMetadataInput) error {
+	if meta.Description != nil {
+		description := strings.TrimSpace(*meta.Description)
+		if utf8.RuneCountInString(description) > maxSecretDescription {
+			return fmt.Errorf("%w: description is longer than %d characters", ErrInvalidSecretMetadata, maxSecretDescription)
+		}
+		secret.Description = description
+	}
+	if meta.Tags != nil {
+		tags, err := normalizeTags(*meta.Tags)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(tags)
+		if err != nil {
+			return err
+		}
+		secret.Tags = datatypes.JSON(encoded)
+	}
+	if meta.TypeHint != nil {
+		typeHint := strings.ToLower(strings.TrimSpace(*meta.TypeHint))
+		if typeHint == "" {
+			typeHint = models.SecretTypeString
+		}
+		if !secretTypeHints[typeHint] {
+			return fmt.Errorf("%w: unknown type hint %q", ErrInvalidSecretMetadata, typeHint)
+		}
+		secret.TypeHint = typeHint
+	}
+	if meta.OwnerTeam != nil {
+		team := strings.TrimSpace(*meta.OwnerTeam)
+		if utf8.RuneCountInString(team) > maxSecretOwnerTeamName {
+			return fmt.Errorf("%w: owner team is longer than %d characters", ErrInvalidSecretMetadata, maxSecretOwnerTeamName)
+		}
+		secret.OwnerTeam = team
+	}
+	if meta.OwnerUserID != nil {
+		if *meta.OwnerUserID == uuid.Nil {
+			secret.OwnerUserID = nil
+		} else {
+			var count int64
+			if err := db.Model(&models.OrgMember{}).
+				Where("org_id = ? AND user_id = ?", orgID, *meta.OwnerUserID).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return fmt.Errorf("%w: owner must be a member of the organization", ErrInvalidSecretMetadata)
+			}
+			owner := *meta.OwnerUserID
+			secret.OwnerUserID = &owner
+		}
+	}
+	if meta.Sensitive != nil {
+		secret.Sensitive = *meta.Sensitive
+	}
+	if meta.RotationIntervalDays != nil {
+		days := *meta.RotationIntervalDays
+		if days < 0 || days > maxRotationIntervalDays {
+			return fmt.Errorf("%w: rotation interval must be between 0 and %d days", ErrInvalidSecretMetadata, maxRotationIntervalDays)
+		}
+		secret.RotationIntervalDays = days
+	}
+	if meta.ExpiresAt != nil {
+		if meta.ExpiresAt.IsZero() {
+			secret.ExpiresAt = nil
+		} else {
+			expiresAt := meta.ExpiresAt.UTC()
+			secret.ExpiresAt = &expiresAt
+		}
+	}
+	if meta.RotationIntervalDays != nil || meta.ExpiresAt != nil {
+		// A new deadline deserves a new reminder.
+		secret.RotationNotifiedAt = nil
+	}
+	secret.SyncRotationDueAt()
+	return nil
+}
+
+// markValueChanged records a new value so rotation reminders restart.
+func markValueChanged(secret *models.Secret, now time.Time) {
+	secret.ValueUpdatedAt = &now
+	secret.RotationNotifiedAt = nil
+	secret.SyncRotationDueAt()
+}
+
+// checkPolicy evaluates action by actor on secret against the org's access
+// policies. It returns the decision to record with the audit entry, or a
+// *PolicyDeniedError when an enforced policy denies the change.
+func (s *SecretService) checkPolicy(ctx context.Context, actor Actor, action string, secret *models.Secret, ip string) (map[string]any, error) {
+	if s.policies == nil {
+		return nil, nil
+	}
+	result, err := s.policies.CheckSecret(ctx, PolicyRequest{Actor: actor, Action: action, IP: ip}, secret.EnvironmentID, PolicySecretFrom(secret))
+	if err != nil {
+		return nil, err
+	}
+	return result.Decision(), nil
+}
+
+// checkWritePolicy evaluates secrets.write against the stored secret, when
+// there is one, and against the state the write leaves, so a deny keyed on
+// a tag or key name cannot be sidestepped by changing it in the same write.
+func (s *SecretService) checkWritePolicy(ctx context.Context, actor Actor, stored, result *models.Secret, ip string) (map[string]any, error) {
+	var before map[string]any
+	if stored != nil {
+		var err error
+		if before, err = s.checkPolicy(ctx, actor, models.PolicyActionSecretsWrite, stored, ip); err != nil {
+			return nil, err
+		}
+	}
+	after, err := s.checkPolicy(ctx, actor, models.PolicyActionSecretsWrite, result, ip)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case before == nil:
+		return after, nil
+	case after == nil:
+		return before, nil
+	}
+	return map[string]any{"stored": before, "result": after}, nil
+}
+
+// auditMetadata encodes fields, adding the policy decision when there is one.
+func auditMetadata(fields map[string]any, decision map[string]any) datatypes.JSON {
+	if decision != nil {
+		fields["policy"] = decision
+	}
+	encoded, _ := json.Marshal(fields)
+	return datatypes.JSON(encoded)
+}
+
 // CreateSecret creates a new secret or updates an existing one with the same key (upsert).
-func (s *SecretService) CreateSecret(ctx context.Context, userID, envID uuid.UUID, key, value string, ip string) (*models.SecretResponse, bool, error) {
+// On upsert only the metadata fields set in meta are changed. The change is
+// attributed to actor, which may be an agent holding a secrets.write grant.
+func (s *SecretService) CreateSecret(ctx context.Context, actor Actor, envID uuid.UUID, key, value string, meta SecretMetadataInput, ip string) (*models.SecretResponse, bool, error) {
 	if s.encryptor == nil {
 		return nil, false, fmt.Errorf("secret encryption is not configured")
 	}
@@ -73,39 +312,70 @@ func (s *SecretService) CreateSecret(ctx context.Context, userID, envID uuid.UUI
 	}
 	wsKey := wsID.String()
 
+	if err := checkSchema(db, envID, key, value); err != nil {
+		return nil, false, err
+	}
+
 	// Check if a secret with this key already exists in the environment
 	var existing models.Secret
 	err = db.Where("environment_id = ? AND key = ?", envID, key).First(&existing).Error
 
 	if err == nil {
 		// Key exists — update its value
+		stored := existing
+		if metaErr := applyMetadata(db, &existing, wsID, meta); metaErr != nil {
+			return nil, false, metaErr
+		}
+		decision, policyErr := s.checkWritePolicy(ctx, actor, &stored, &existing, ip)
+		if policyErr != nil {
+			return nil, false, policyErr
+		}
 		encrypted, encErr := s.encryptor.Encrypt(ctx, value, wsKey)
 		if encErr != nil {
 			return nil, false, fmt.Errorf("failed to encrypt secret: %w", encErr)
 		}
 		existing.EncryptedValue = encrypted
 		existing.KMSKeyID = s.encryptor.KeyID()
+		markValueChanged(&existing, time.Now().UTC())
 		if saveErr := db.Save(&existing).Error; saveErr != nil {
 			return nil, false, saveErr
 		}
 
 		var env models.Environment
 		if err := db.Preload("Project.Organization").First(&env, envID).Error; err == nil && s.auditService != nil {
-			_ = s.auditService.Log(ctx, userID, env.Project.Organization.ID, existing.ID, models.ActionSecretUpdate, "secret", ip,
-				datatypes.JSON([]byte(`{"key":"`+key+`","via":"upsert"}`)))
+			metadata := auditMetadata(map[string]any{"key": key, "via": "upsert"}, decision)
+			_ = s.auditService.LogActor(ctx, actor, env.Project.Organization.ID, existing.ID, models.ActionSecretUpdate, "secret", ip, metadata)
 		}
 
 		resp := existing.ToResponse()
 		return &resp, true, nil
 	}
 
-	// New secret — check tier limits
+	// New secret — sensitive unless told otherwise
+	secret := &models.Secret{
+		EnvironmentID: envID,
+		Key:           key,
+		Sensitive:     true,
+	}
+	if actor.IsAgent() {
+		secret.CreatedByAgentID = &actor.AgentID
+	} else {
+		secret.CreatedBy = &actor.UserID
+	}
+	if err := applyMetadata(db, secret, wsID, meta); err != nil {
+		return nil, false, err
+	}
+	decision, err := s.checkWritePolicy(ctx, actor, nil, secret, ip)
+	if err != nil {
+		return nil, false, err
+	}
+
 	canCreate, err := s.tierService.CanCreateSecret(envID)
 	if err != nil {
 		return nil, false, fmt.Errorf("failed to check tier limits: %w", err)
 	}
 	if !canCreate {
-		return nil, false, fmt.Errorf("secret limit reached for this environment")
+		return nil, false, ErrSecretLimitReached
 	}
 
 	encrypted, err := s.encryptor.Encrypt(ctx, value, wsKey)
@@ -113,50 +383,93 @@ func (s *SecretService) CreateSecret(ctx context.Context, userID, envID uuid.UUI
 		return nil, false, fmt.Errorf("failed to encrypt secret: %w", err)
 	}
 
-	secret := &models.Secret{
-		EnvironmentID:  envID,
-		Key:            key,
-		EncryptedValue: encrypted,
-		KMSKeyID:       s.encryptor.KeyID(),
-		CreatedBy:      userID,
-	}
+	secret.EncryptedValue = encrypted
+	secret.KMSKeyID = s.encryptor.KeyID()
+	markValueChanged(secret, time.Now().UTC())
 
-	if err := db.Create(secret).Error; err != nil {
+	// Sensitive has a database default of true, so GORM would drop an explicit
+	// false from the INSERT; write every column instead.
+	if err := db.Select("*").Omit(clause.Associations).Create(secret).Error; err != nil {
 		return nil, false, err
 	}
 
 	var env models.Environment
 	if err := db.Preload("Project.Organization").First(&env, envID).Error; err == nil && s.auditService != nil {
-		_ = s.auditService.Log(ctx, userID, env.Project.Organization.ID, secret.ID, models.ActionSecretCreate, "secret", ip,
-			datatypes.JSON([]byte(`{"key":"`+key+`"}`)))
+		metadata := auditMetadata(map[string]any{"key": key}, decision)
+		_ = s.auditService.LogActor(ctx, actor, env.Project.Organization.ID, secret.ID, models.ActionSecretCreate, "secret", ip, metadata)
 	}
 
 	resp := secret.ToResponse()
 	return &resp, false, nil
 }
 
-// ListSecrets lists secrets for an environment (metadata only)
-func (s *SecretService) ListSecrets(ctx context.Context, envID uuid.UUID) ([]models.SecretResponse, error) {
+// ListSecrets lists secrets for an environment. Values are only included for
+// secrets marked non-sensitive, and their keys are audited as a secret_read
+// by userID. When tags are given, only secrets carrying all of them are
+// returned.
+func (s *SecretService) ListSecrets(ctx context.Context, userID, envID uuid.UUID, tags []string, ip string) ([]models.SecretResponse, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
 	db := database.GetDB().WithContext(ctx)
 
+	query := db.Where("environment_id = ?", envID)
+	for _, tag := range tags {
+		encoded, _ := json.Marshal([]string{tag})
+		query = query.Where("tags @> ?", string(encoded))
+	}
+
 	var secrets []models.Secret
-	if err := db.Where("environment_id = ?", envID).
-		Order("created_at ASC").
-		Find(&secrets).Error; err != nil {
+	if err := query.Order("created_at ASC").Find(&secrets).Error; err != nil {
 		return nil, err
 	}
 
+	var plain []models.Secret
+	var plainIdx []int
+	for i := range secrets {
+		if !secrets[i].Sensitive {
+			plain = append(plain, secrets[i])
+			plainIdx = append(plainIdx, i)
+		}
+	}
+	values := make(map[int]string, len(plain))
+	if len(plain) > 0 && s.encryptor != nil {
+		wsID, err := s.resolveWorkspaceID(db, envID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve workspace: %w", err)
+		}
+		plaintexts, errs := s.decryptAll(ctx, plain, wsID.String())
+		var shown []string
+		for j, i := range plainIdx {
+			if errs[j] != nil {
+				log.Printf("[envo] list: cannot show value of %s (%s): %v", secrets[i].ID, secrets[i].Key, errs[j])
+				continue
+			}
+			values[i] = plaintexts[j]
+			shown = append(shown, secrets[i].Key)
+		}
+		if len(shown) > 0 && s.auditService != nil {
+			metadata, _ := json.Marshal(map[string]any{"keys": shown, "source": "list"})
+			_ = s.auditService.Log(ctx, userID, wsID, envID, models.ActionSecretRead, "environment", ip, datatypes.JSON(metadata))
+		}
+	}
+
 	responses := make([]models.SecretResponse, 0, len(secrets))
-	for _, sec := range secrets {
+	for i, sec := range secrets {
 		resp := sec.ToResponse()
+		if value, ok := values[i]; ok {
+			resp.Value = &value
+		}
 		responses = append(responses, resp)
 	}
 
 	return responses, nil
 }
 
-// UpdateSecret updates a secret's key and/or value
-func (s *SecretService) UpdateSecret(ctx context.Context, userID, secretID uuid.UUID, newKey *string, newValue *string, ip string) (*models.SecretResponse, error) {
+// UpdateSecret updates a secret's key, value, and/or metadata
+func (s *SecretService) UpdateSecret(ctx context.Context, userID, secretID uuid.UUID, newKey *string, newValue *string, meta SecretMetadataInput, ip string) (*models.SecretResponse, error) {
 	if s.encryptor == nil {
 		return nil, fmt.Errorf("secret encryption is not configured")
 	}
@@ -168,20 +481,54 @@ func (s *SecretService) UpdateSecret(ctx context.Context, userID, secretID uuid.
 		return nil, err
 	}
 
+	wsID, err := s.resolveWorkspaceID(db, secret.EnvironmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve workspace: %w", err)
+	}
+
+	stored := secret
 	if newKey != nil {
 		secret.Key = *newKey
 	}
 
-	if newValue != nil {
-		wsID, err := s.resolveWorkspaceID(db, secret.EnvironmentID)
+	if err := applyMetadata(db, &secret, wsID, meta); err != nil {
+		return nil, err
+	}
+	decision, err := s.checkWritePolicy(ctx, UserActor(userID), &stored, &secret, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	if newKey != nil || newValue != nil {
+		schema, err := schemaForEnvironment(db, secret.EnvironmentID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to resolve workspace: %w", err)
+			return nil, err
+		}
+		if schema.hasRule(secret.Key) {
+			var value string
+			if newValue != nil {
+				value = *newValue
+			} else {
+				// A rename moves the existing value under the new key's rule.
+				plaintexts, errs := s.decryptAll(ctx, []models.Secret{secret}, wsID.String())
+				if errs[0] != nil {
+					return nil, fmt.Errorf("failed to decrypt secret: %w", errs[0])
+				}
+				value = plaintexts[0]
+			}
+			if violations := schema.checkValue(secret.Key, value); len(violations) > 0 {
+				return nil, &SchemaValidationError{Violations: violations}
+			}
 		}
+	}
+
+	if newValue != nil {
 		encrypted, err := s.encryptor.Encrypt(ctx, *newValue, wsID.String())
 		if err != nil {
 			return nil, fmt.Errorf("failed to encrypt secret: %w", err)
 		}
 		secret.EncryptedValue = encrypted
+		markValueChanged(&secret, time.Now().UTC())
 	}
 
 	if err := db.Save(&secret).Error; err != nil {
@@ -191,7 +538,7 @@ func (s *SecretService) UpdateSecret(ctx context.Context, userID, secretID uuid.
 	var env models.Environment
 	if err := db.Preload("Project.Organization").First(&env, secret.EnvironmentID).Error; err == nil && s.auditService != nil {
 		_ = s.auditService.Log(ctx, userID, env.Project.Organization.ID, secret.ID, models.ActionSecretUpdate, "secret", ip,
-			datatypes.JSON([]byte(`{"key":"`+secret.Key+`"}`)))
+			auditMetadata(map[string]any{"key": secret.Key}, decision))
 	}
 
 	resp := secret.ToResponse()
@@ -206,18 +553,28 @@ func (s *SecretService) DeleteSecret(ctx context.Context, userID, secretID uuid.
 	if err := db.First(&secret, secretID).Error; err != nil {
 		return err
 	}
+	decision, err := s.checkPolicy(ctx, UserActor(userID), models.PolicyActionSecretsDelete, &secret, ip)
+	if err != nil {
+		return err
+	}
 
 	// Load env -> project -> org before deletion
 	var env models.Environment
 	_ = db.Preload("Project.Organization").First(&env, secret.EnvironmentID).Error
 
-	if err := db.Delete(&models.Secret{}, secretID).Error; err != nil {
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		// A rotation config is meaningless without its target secret.
+		if err := tx.Where("secret_id = ?", secretID).Delete(&models.SecretRotation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Secret{}, secretID).Error
+	}); err != nil {
 		return err
 	}
 
 	if s.auditService != nil && env.Project.Organization.ID != uuid.Nil {
 		_ = s.auditService.Log(ctx, userID, env.Project.Organization.ID, secretID, models.ActionSecretDelete, "secret", ip,
-			datatypes.JSON([]byte(`{"key":"`+secret.Key+`"}`)))
+			auditMetadata(map[string]any{"key": secret.Key}, decision))
 	}
 
 	return nil
@@ -232,19 +589,74 @@ func (s *SecretService) PurgeSecret(ctx context.Context, userID, secretID uuid.U
 	if err := db.Unscoped().First(&secret, secretID).Error; err != nil {
 		return err
 	}
+	decision, err := s.checkPolicy(ctx, UserActor(userID), models.PolicyActionSecretsDelete, &secret, ip)
+	if err != nil {
+		return err
+	}
 
 	var env models.Environment
 	_ = db.Preload("Project.Organization").First(&env, secret.EnvironmentID).Error
 
-	if err := db.Unscoped().Delete(&models.Secret{}, secretID).Error; err != nil {
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("secret_id = ?", secretID).Delete(&models.SecretRotation{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&models.Secret{}, secretID).Error
+	}); err != nil {
 		return err
 	}
 
 	if s.auditService != nil && env.Project.Organization.ID != uuid.Nil {
-		_ = s.auditService.Log(ctx, userID, env.Project.Organization.ID, secretID, "secret.purge", "secret", ip,
-			datatypes.JSON([]byte(`{"key":"`+secret.Key+`","permanent":true}`)))
+		_ = s.auditService.Log(ctx, userID, env.Project.Organization.ID, secretID, models.ActionSecretPurge, "secret", ip,
+			auditMetadata(map[string]any{"key": secret.Key, "permanent": true}, decision))
+	}
+
+	return nil
+}
+
+// readSecretValue loads and decrypts a single secret, returning it with its
+// organization ID. Used by subsystems that act on a value server-side.
+func (s *SecretService) readSecretValue(ctx context.Context, secretID uuid.UUID) (string, *models.Secret, uuid.UUID, error) {
+	if s.encryptor == nil {
+		return "", nil, uuid.Nil, fmt.Errorf("secret encryption is not configured")
+	}
+	db := database.GetDB().WithContext(ctx)
+
+	var secret models.Secret
+	if err := db.First(&secret, secretID).Error; err != nil {
+		return "", nil, uuid.Nil, err
+	}
+	orgID, err := s.resolveWorkspaceID(db, secret.EnvironmentID)
+	if err != nil {
+		return "", nil, uuid.Nil, fmt.Errorf("failed to resolve workspace: %w", err)
+	}
+	plaintexts, errs := s.decryptAll(ctx, []models.Secret{secret}, orgID.String())
+	if errs[0] != nil {
+		return "", nil, uuid.Nil, fmt.Errorf("failed to decrypt secret %s: %w", secret.Key, errs[0])
 	}
+	return plaintexts[0], &secret, orgID, nil
+}
 
+// ErrSecretReadForbidden is returned when an operation would use a secret
+// value, such as an admin connection, that the acting user may not read.
+var ErrSecretReadForbidden = errors.New("you do not have permission to read that secret")
+
+// checkSecretReadable returns ErrSecretReadForbidden unless userID's role in
+// orgID, the organization owning the secret's environment, carries
+// secrets.read. Features that act on a stored connection for a user call it
+// so they cannot be used to borrow a credential the user cannot see.
+func checkSecretReadable(db *gorm.DB, userID, orgID uuid.UUID) error {
+	var count int64
+	if err := db.Table("org_members").
+		Joins("JOIN role_permissions ON role_permissions.role_id = org_members.role_id").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("org_members.user_id = ? AND org_members.org_id = ? AND permissions.name = ?", userID, orgID, models.PermissionSecretsRead).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSecretReadForbidden
+	}
 	return nil
 }
 
@@ -275,25 +687,77 @@ func (s *SecretService) tryDecrypt(ctx context.Context, sec *models.Secret, dec,
 	return "", err
 }
 
+// SkippedSecret identifies a value left out of an export because it could not
+// be decrypted or has expired. Reason is a stable machine-readable code; the
+// underlying error is only logged server-side and shown in the encryption
+// health report.
+type SkippedSecret struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+const (
+	SkipReasonDecryptFailed = "decrypt_failed"
+	// SkipReasonExpired marks secrets past their hard expiry in orgs that
+	// exclude expired values.
+	SkipReasonExpired = "expired"
+	// SkipReasonPolicyDenied marks secrets an access policy withheld.
+	SkipReasonPolicyDenied = "policy_denied"
+)
+
 // ExportEnvironmentSecrets returns decrypted secrets for an environment (for CLI).
-// Secrets that fail to decrypt are skipped (and logged); decryptor is chosen by KMSKeyID, with fallback to the other if configured.
-func (s *SecretService) ExportEnvironmentSecrets(ctx context.Context, userID, envID uuid.UUID, ip string) (map[string]string, uuid.UUID, error) {
-	result, orgID, err := s.DecryptEnvironmentSecrets(ctx, envID, nil, true)
+// Secrets that fail to decrypt are skipped and reported; decryptor is chosen by KMSKeyID, with fallback to the other if configured.
+func (s *SecretService) ExportEnvironmentSecrets(ctx context.Context, userID, envID uuid.UUID, ip string) (map[string]string, []SkippedSecret, uuid.UUID, error) {
+	var policy *SecretPolicyResult
+	if s.policies != nil {
+		var err error
+		policy, err = s.policies.CheckEnvironment(ctx, PolicyRequest{Actor: UserActor(userID), Action: models.PolicyActionSecretsExport, IP: ip}, envID, nil)
+		if err == nil {
+			err = policy.ErrIfAllDenied()
+		}
+		if err != nil {
+			return nil, nil, uuid.Nil, err
+		}
+	}
+	result, skipped, orgID, err := s.DecryptEnvironmentSecrets(ctx, envID, policy.Allows, nil)
 	if err != nil {
-		return nil, uuid.Nil, err
+		return nil, nil, uuid.Nil, err
 	}
+	skipped = MergeSkipped(skipped, policy.Skipped())
 	if s.auditService != nil {
-		_ = s.auditService.Log(ctx, userID, orgID, envID, models.ActionSecretRead, "environment", ip, nil)
+		var metadata datatypes.JSON
+		if len(skipped) > 0 || policy.Decision() != nil {
+			fields := map[string]any{}
+			if len(skipped) > 0 {
+				fields["skipped_keys"] = skipped
+			}
+			metadata = auditMetadata(fields, policy.Decision())
+		}
+		_ = s.auditService.Log(ctx, userID, orgID, envID, models.ActionSecretRead, "environment", ip, metadata)
 	}
-	return result, orgID, nil
+	return result, skipped, orgID, nil
 }
 
-// DecryptEnvironmentSecrets decrypts only the approved keys. It intentionally
+// MergeSkipped combines skipped lists, sorted by key.
+func MergeSkipped(lists ...[]SkippedSecret) []SkippedSecret {
+	var merged []SkippedSecret
+	for _, list := range lists {
+		merged = append(merged, list...)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Key < merged[j].Key })
+	return merged
+}
+
+// DecryptEnvironmentSecrets decrypts only the keys allowed by filter (all keys
+// when filter is nil); filtered keys are never decrypted. It intentionally
 // does not audit by itself so callers can attribute the read to a human or an
-// agent correctly.
-func (s *SecretService) DecryptEnvironmentSecrets(ctx context.Context, envID uuid.UUID, allowedKeys map[string]struct{}, allowAll bool) (map[string]string, uuid.UUID, error) {
+// agent correctly. Keys that fail to decrypt are returned in the skipped list,
+// sorted by key, so callers can warn instead of silently producing a partial .env.
+// A non-nil keys loads only secrets with those names; callers pass it when
+// filter allows nothing else, so large environments are not scanned.
+func (s *SecretService) DecryptEnvironmentSecrets(ctx context.Context, envID uuid.UUID, filter KeyFilter, keys []string) (map[string]string, []SkippedSecret, uuid.UUID, error) {
 	if s.encryptor == nil {
-		return nil, uuid.Nil, fmt.Errorf("secret encryption is not configured")
+		return nil, nil, uuid.Nil, fmt.Errorf("secret encryption is not configured")
 	}
 
 	db := database.GetDB().WithContext(ctx)
@@ -301,69 +765,201 @@ func (s *SecretService) DecryptEnvironmentSecrets(ctx context.Context, envID uui
 	// Load env with project + org
 	var env models.Environment
 	if err := db.Preload("Project.Organization").First(&env, envID).Error; err != nil {
-		return nil, uuid.Nil, err
+		return nil, nil, uuid.Nil, err
 	}
 
 	// Load secrets
 	var secrets []models.Secret
-	query := db.Where("environment_id = ?", envID)
-	if !allowAll {
-		keys := make([]string, 0, len(allowedKeys))
-		for key := range allowedKeys {
-			keys = append(keys, key)
+	if keys == nil || len(keys) > 0 {
+		query := db.Where("environment_id = ?", envID)
+		if keys != nil {
+			query = query.Where("key IN ?", keys)
 		}
-		if len(keys) == 0 {
-			return map[string]string{}, env.Project.OrgID, nil
+		if err := query.Find(&secrets).Error; err != nil {
+			return nil, nil, uuid.Nil, err
 		}
-		query = query.Where("key IN ?", keys)
 	}
-	if err := query.Find(&secrets).Error; err != nil {
-		return nil, uuid.Nil, err
+	if filter != nil {
+		allowed := secrets[:0]
+		for _, sec := range secrets {
+			if filter(sec.Key) {
+				allowed = append(allowed, sec)
+			}
+		}
+		secrets = allowed
 	}
 
-	wsID := env.Project.OrgID.String()
+	var skipped []SkippedSecret
+	if env.Project.Organization.ExcludeExpiredSecrets {
+		now := time.Now()
+		live := secrets[:0]
+		for _, sec := range secrets {
+			if sec.IsExpired(now) {
+				skipped = append(skipped, SkippedSecret{Key: sec.Key, Reason: SkipReasonExpired})
+				continue
+			}
+			live = append(live, sec)
+		}
+		secrets = live
+	}
+
+	plaintexts, errs := s.decryptAll(ctx, secrets, env.Project.OrgID.String())
+	if err := ctx.Err(); err != nil {
+		return nil, nil, uuid.Nil, err
+	}
 	result := make(map[string]string, len(secrets))
+	for i, sec := range secrets {
+		if errs[i] != nil {
+			log.Printf("[envo] skip secret %s (%s): decrypt failed: %v", sec.ID, sec.Key, errs[i])
+			skipped = append(skipped, SkippedSecret{Key: sec.Key, Reason: SkipReasonDecryptFailed})
+			continue
+		}
+		result[sec.Key] = plaintexts[i]
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].Key < skipped[j].Key })
+	if len(secrets) > 0 && len(result) == 0 {
+		log.Printf("[envo] export: %d secrets in env but 0 decrypted; check KMS/local config and re-create secrets if needed", len(secrets))
+	}
+
+	return result, skipped, env.Project.Organization.ID, nil
+}
+
+// decryptAll decrypts secrets concurrently. The returned slices are indexed
+// like secrets; exactly one of plaintexts[i] or errs[i] is meaningful.
+func (s *SecretService) decryptAll(ctx context.Context, secrets []models.Secret, wsID string) ([]string, []error) {
+	plaintexts := make([]string, len(secrets))
+	errs := make([]error, len(secrets))
 	workers := s.decryptConcurrency
 	if workers > len(secrets) {
 		workers = len(secrets)
 	}
-	if workers > 0 {
-		jobs := make(chan models.Secret)
-		var wg sync.WaitGroup
-		var resultMu sync.Mutex
-		wg.Add(workers)
-		for range workers {
-			go func() {
-				defer wg.Done()
-				for sec := range jobs {
-					if ctx.Err() != nil {
-						continue
-					}
-					dec := s.decryptorForSecret(&sec)
-					alt := s.localEncryptor
-					if dec == s.localEncryptor {
-						alt = s.encryptor
-					}
-					plaintext, err := s.tryDecrypt(ctx, &sec, dec, alt, wsID)
-					if err != nil {
-						log.Printf("[envo] skip secret %s (%s): decrypt failed: %v", sec.ID, sec.Key, err)
-						continue
-					}
-					resultMu.Lock()
-					result[sec.Key] = plaintext
-					resultMu.Unlock()
+	if workers == 0 {
+		return plaintexts, errs
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
 				}
-			}()
-		}
-		for _, sec := range secrets {
-			jobs <- sec
-		}
-		close(jobs)
-		wg.Wait()
+				sec := &secrets[i]
+				dec := s.decryptorForSecret(sec)
+				alt := s.localEncryptor
+				if dec == s.localEncryptor {
+					alt = s.encryptor
+				}
+				plaintexts[i], errs[i] = s.tryDecrypt(ctx, sec, dec, alt, wsID)
+			}
+		}()
 	}
-	if len(secrets) > 0 && len(result) == 0 {
-		log.Printf("[envo] export: %d secrets in env but 0 decrypted; check KMS/local config and re-create secrets if needed", len(secrets))
+	for i := range secrets {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
+	return plaintexts, errs
+}
 
-	return result, env.Project.Organization.ID, nil
+// EncryptionKeyUsage counts the secrets stored under one KMSKeyID.
+type EncryptionKeyUsage struct {
+	KMSKeyID string `json:"kms_key_id"`
+	Secrets  int    `json:"secrets"`
+	Failures int    `json:"failures"`
+}
+
+// SecretDecryptFailure describes one secret that cannot currently be read.
+type SecretDecryptFailure struct {
+	SecretID        uuid.UUID `json:"secret_id"`
+	Key             string    `json:"key"`
+	ProjectID       uuid.UUID `json:"project_id"`
+	ProjectName     string    `json:"project_name"`
+	EnvironmentID   uuid.UUID `json:"environment_id"`
+	EnvironmentName string    `json:"environment_name"`
+	KMSKeyID        string    `json:"kms_key_id"`
+	Error           string    `json:"error"`
+}
+
+// EncryptionHealthReport summarizes whether every secret in a workspace can
+// be decrypted with the encryptors this server is configured with.
+type EncryptionHealthReport struct {
+	OrgID        uuid.UUID              `json:"org_id"`
+	PrimaryKeyID string                 `json:"primary_key_id"`
+	ScannedAt    time.Time              `json:"scanned_at"`
+	TotalSecrets int                    `json:"total_secrets"`
+	Healthy      int                    `json:"healthy"`
+	KeyUsage     []EncryptionKeyUsage   `json:"key_usage"`
+	Failures     []SecretDecryptFailure `json:"failures"`
+}
+
+// EncryptionHealth trial-decrypts every active secret in the workspace and
+// reports failures with their cause. Plaintexts are discarded immediately.
+func (s *SecretService) EncryptionHealth(ctx context.Context, userID, orgID uuid.UUID, ip string) (*EncryptionHealthReport, error) {
+	if s.encryptor == nil {
+		return nil, fmt.Errorf("secret encryption is not configured")
+	}
+
+	db := database.GetDB().WithContext(ctx)
+
+	var secrets []models.Secret
+	if err := db.Preload("Environment.Project").
+		Joins("JOIN environments ON environments.id = secrets.environment_id AND environments.deleted_at IS NULL").
+		Joins("JOIN projects ON projects.id = environments.project_id AND projects.deleted_at IS NULL").
+		Where("projects.org_id = ?", orgID).
+		Order("secrets.key ASC").
+		Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+
+	_, errs := s.decryptAll(ctx, secrets, orgID.String())
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &EncryptionHealthReport{
+		OrgID:        orgID,
+		PrimaryKeyID: s.encryptor.KeyID(),
+		ScannedAt:    time.Now().UTC(),
+		TotalSecrets: len(secrets),
+		KeyUsage:     []EncryptionKeyUsage{},
+		Failures:     []SecretDecryptFailure{},
+	}
+	usage := map[string]*EncryptionKeyUsage{}
+	for i, sec := range secrets {
+		u, ok := usage[sec.KMSKeyID]
+		if !ok {
+			u = &EncryptionKeyUsage{KMSKeyID: sec.KMSKeyID}
+			usage[sec.KMSKeyID] = u
+		}
+		u.Secrets++
+		if errs[i] == nil {
+			report.Healthy++
+			continue
+		}
+		u.Failures++
+		report.Failures = append(report.Failures, SecretDecryptFailure{
+			SecretID:        sec.ID,
+			Key:             sec.Key,
+			ProjectID:       sec.Environment.ProjectID,
+			ProjectName:     sec.Environment.Project.Name,
+			EnvironmentID:   sec.EnvironmentID,
+			EnvironmentName: sec.Environment.Name,
+			KMSKeyID:        sec.KMSKeyID,
+			Error:           errs[i].Error(),
+		})
+	}
+	for _, u := range usage {
+		report.KeyUsage = append(report.KeyUsage, *u)
+	}
+	sort.Slice(report.KeyUsage, func(i, j int) bool { return report.KeyUsage[i].KMSKeyID < report.KeyUsage[j].KMSKeyID })
+
+	if s.auditService != nil {
+		metadata, _ := json.Marshal(map[string]int{"total_secrets": report.TotalSecrets, "failures": len(report.Failures)})
+		_ = s.auditService.Log(ctx, userID, orgID, orgID, models.ActionEncryptionHealthCheck, "organization", ip, datatypes.JSON(metadata))
+	}
+	return report, nil
 }
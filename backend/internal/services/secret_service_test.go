@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envo/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := normalizeTags([]string{" Payments ", "db", "payments", "", "team:core"})
+	if err != nil {
+		t.Fatalf("normalizeTags() error = %v", err)
+	}
+	want := []string{"db", "payments", "team:core"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("normalizeTags() = %v, want %v", got, want)
+	}
+
+	for _, bad := range [][]string{
+		{"has space"},
+		{strings.Repeat("a", maxSecretTagLength+1)},
+	} {
+		if _, err := normalizeTags(bad); !errors.Is(err, ErrInvalidSecretMetadata) {
+			t.Fatalf("normalizeTags(%q) error = %v, want ErrInvalidSecretMetadata", bad, err)
+		}
+	}
+
+	many := make([]string, maxSecretTags+1)
+	for i := range many {
+		many[i] = "t" + strings.Repeat("x", i)
+	}
+	if _, err := normalizeTags(many); !errors.Is(err, ErrInvalidSecretMetadata) {
+		t.Fatalf("normalizeTags() accepted %d tags", len(many))
+	}
+}
+
+func TestApplyMetadataValidatesTypeHintAndKeepsUnsetFields(t *testing.T) {
+	secret := &models.Secret{Description: "keep me", Sensitive: true, TypeHint: models.SecretTypeString}
+
+	typeHint := "PEM"
+	sensitive := false
+	if err := applyMetadata(nil, secret, uuid.Nil, SecretMetadataInput{TypeHint: &typeHint, Sensitive: &sensitive}); err != nil {
+		t.Fatalf("applyMetadata() error = %v", err)
+	}
+	if secret.TypeHint != models.SecretTypePEM || secret.Sensitive || secret.Description != "keep me" {
+		t.Fatalf("applyMetadata() left secret as %+v", secret)
+	}
+
+	unknown := "yaml"
+	if err := applyMetadata(nil, secret, uuid.Nil, SecretMetadataInput{TypeHint: &unknown}); !errors.Is(err, ErrInvalidSecretMetadata) {
+		t.Fatalf("applyMetadata() error = %v, want ErrInvalidSecretMetadata", err)
+	}
+
+	clear := uuid.Nil
+	owner := uuid.New()
+	secret.OwnerUserID = &owner
+	if err := applyMetadata(nil, secret, uuid.Nil, SecretMetadataInput{OwnerUserID: &clear}); err != nil || secret.OwnerUserID != nil {
+		t.Fatalf("clearing owner: err = %v, owner = %v", err, secret.OwnerUserID)
+	}
+}
+
+func TestApplyMetadataSetsRotationDeadline(t *testing.T) {
+	updated := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notified := updated
+	secret := &models.Secret{ValueUpdatedAt: &updated, RotationNotifiedAt: &notified}
+
+	days := 90
+	if err := applyMetadata(nil, secret, uuid.Nil, SecretMetadataInput{RotationIntervalDays: &days}); err != nil {
+		t.Fatal(err)
+	}
+	if want := updated.AddDate(0, 0, 90); secret.RotationDueAt == nil || !secret.RotationDueAt.Equal(want) {
+		t.Fatalf("RotationDueAt = %v, want %v", secret.RotationDueAt, want)
+	}
+	if secret.RotationNotifiedAt != nil {
+		t.Fatal("changing the interval should re-arm the reminder")
+	}
+
+	expires := updated.AddDate(0, 0, 30)
+	if err := applyMetadata(nil, secret, uuid.Nil, SecretMetadataInput{ExpiresAt: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	if !secret.RotationDueAt.Equal(expires) {
+		t.Fatalf("RotationDueAt = %v, want the earlier hard expiry %v", secret.RotationDueAt, expires)
+	}
+	if status := staleStatus(secret, expires.Add(time.Hour)); status != StaleStatusExpired {
+		t.Fatalf("staleStatus() = %q, want expired", status)
+	}
+
+	var clear time.Time
+	zero := 0
+	if err := applyMetadata(nil, secret, uuid.Nil, SecretMetadataInput{ExpiresAt: &clear, RotationIntervalDays: &zero}); err != nil {
+		t.Fatal(err)
+	}
+	if secret.ExpiresAt != nil || secret.RotationDueAt != nil {
+		t.Fatalf("clearing left ExpiresAt=%v RotationDueAt=%v", secret.ExpiresAt, secret.RotationDueAt)
+	}
+
+	tooLong := maxRotationIntervalDays + 1
+	if err := applyMetadata(nil, secret, uuid.Nil, SecretMetadataInput{RotationIntervalDays: &tooLong}); !errors.Is(err, ErrInvalidSecretMetadata) {
+		t.Fatalf("applyMetadata() error = %v, want ErrInvalidSecretMetadata", err)
+	}
+}
+
+// policySecretDB scripts an environment holding stored and one enforced
+// secrets.write policy with condition.
+func policySecretDB(t *testing.T, stored *models.Secret, condition string) *fakeDB {
+	t.Helper()
+	db := useFakeDB(t)
+	projectID, orgID := uuid.New(), uuid.New()
+	db.on([]string{`FROM "secrets"`}, []string{"id", "environment_id", "key", "tags", "sensitive", "type_hint"},
+		[]driver.Value{stored.ID.String(), stored.EnvironmentID.String(), stored.Key, string(stored.Tags), true, "string"})
+	db.on([]string{`FROM "environments"`}, []string{"id", "project_id", "name"},
+		[]driver.Value{stored.EnvironmentID.String(), projectID.String(), "production"})
+	db.on([]string{`FROM "projects"`}, []string{"id", "org_id", "name"},
+		[]driver.Value{projectID.String(), orgID.String(), "api"})
+	db.on([]string{`FROM "access_policies"`}, []string{"id", "org_id", "name", "actions", "condition", "mode", "enabled"},
+		[]driver.Value{uuid.NewString(), orgID.String(), "protect prod", `["secrets.write"]`, condition, models.PolicyModeEnforce, true})
+	return db
+}
+
+func TestUpdateSecretChecksPolicyBeforeAndAfterTheChange(t *testing.T) {
+	stored := &models.Secret{ID: uuid.New(), EnvironmentID: uuid.New(), Key: "PROD_DB_URL", Tags: []byte(`["prod"]`)}
+	service := NewSecretService(NewLocalEncryptionService("secret"), nil, nil, nil, NewPolicyService(nil), 1)
+	value := "postgres://new"
+
+	t.Run("removing the tag", func(t *testing.T) {
+		db := policySecretDB(t, stored, `"prod" in secret.tags`)
+		untagged := []string{}
+		_, err := service.UpdateSecret(context.Background(), uuid.New(), stored.ID, nil, &value, SecretMetadataInput{Tags: &untagged}, "")
+		var denied *PolicyDeniedError
+		if !errors.As(err, &denied) {
+			t.Fatalf("UpdateSecret() error = %v, want a policy denial", err)
+		}
+		if writes := db.statements(`UPDATE "secrets"`); len(writes) != 0 {
+			t.Fatalf("denied update still wrote the secret: %v", writes)
+		}
+	})
+	t.Run("renaming the key", func(t *testing.T) {
+		db := policySecretDB(t, stored, `secret.key.startsWith("PROD_")`)
+		renamed := "DB_URL"
+		_, err := service.UpdateSecret(context.Background(), uuid.New(), stored.ID, &renamed, &value, SecretMetadataInput{}, "")
+		var denied *PolicyDeniedError
+		if !errors.As(err, &denied) || denied.Key != stored.Key {
+			t.Fatalf("UpdateSecret() error = %v, want the stored key denied", err)
+		}
+		if writes := db.statements(`UPDATE "secrets"`); len(writes) != 0 {
+			t.Fatalf("denied rename still wrote the secret: %v", writes)
+		}
+	})
+	t.Run("adding the tag", func(t *testing.T) {
+		untaggedSecret := *stored
+		untaggedSecret.Tags = []byte(`[]`)
+		policySecretDB(t, &untaggedSecret, `"prod" in secret.tags`)
+		tagged := []string{"prod"}
+		_, err := service.UpdateSecret(context.Background(), uuid.New(), stored.ID, nil, nil, SecretMetadataInput{Tags: &tagged}, "")
+		var denied *PolicyDeniedError
+		if !errors.As(err, &denied) {
+			t.Fatalf("UpdateSecret() error = %v, want the resulting state denied", err)
+		}
+	})
+}
+
+func TestDecryptEnvironmentSecretsLoadsOnlyExactKeys(t *testing.T) {
+	service := NewSecretService(NewLocalEncryptionService("secret"), nil, nil, nil, nil, 1)
+	envID := uuid.New()
+	load := func(t *testing.T, keys []string) []fakeStmt {
+		t.Helper()
+		db := useFakeDB(t)
+		db.on([]string{`FROM "environments"`}, []string{"id", "project_id", "name"},
+			[]driver.Value{envID.String(), uuid.NewString(), "production"})
+		if _, _, _, err := service.DecryptEnvironmentSecrets(context.Background(), envID, nil, keys); err != nil {
+			t.Fatalf("DecryptEnvironmentSecrets() error = %v", err)
+		}
+		return db.statements(`FROM "secrets"`)
+	}
+
+	t.Run("exact keys", func(t *testing.T) {
+		reads := load(t, []string{"API_KEY", "DB_URL"})
+		if len(reads) != 1 || !strings.Contains(reads[0].SQL, "key IN") {
+			t.Fatalf("secret reads = %v, want one lookup by key", reads)
+		}
+		if !reflect.DeepEqual(reads[0].Args[1:], []any{"API_KEY", "DB_URL"}) {
+			t.Fatalf("lookup args = %v, want the requested keys", reads[0].Args)
+		}
+	})
+	t.Run("no keys", func(t *testing.T) {
+		if reads := load(t, []string{}); len(reads) != 0 {
+			t.Fatalf("an empty key list still read secrets: %v", reads)
+		}
+	})
+	t.Run("patterns", func(t *testing.T) {
+		reads := load(t, nil)
+		if len(reads) != 1 || strings.Contains(reads[0].SQL, "key IN") {
+			t.Fatalf("secret reads = %v, want a full environment scan", reads)
+		}
+	})
+}
+
+func TestListSecretsAuditsShownValues(t *testing.T) {
+	encryptor := NewLocalEncryptionService("secret")
+	audit := NewAuditService(NewTierService(time.Hour))
+	service := NewSecretService(encryptor, nil, nil, audit, nil, 1)
+	orgID, projectID, envID, userID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	listDB := func(t *testing.T, sensitive ...bool) *fakeDB {
+		t.Helper()
+		db := useFakeDB(t)
+		var rows [][]driver.Value
+		for i, key := range []string{"PUBLIC_URL", "API_KEY"} {
+			encrypted, err := encryptor.Encrypt(context.Background(), "value-"+key, orgID.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, []driver.Value{uuid.NewString(), envID.String(), key, encrypted, "local", sensitive[i]})
+		}
+		db.on([]string{`FROM "secrets"`}, []string{"id", "environment_id", "key", "encrypted_value", "kms_key_id", "sensitive"}, rows...)
+		db.on([]string{`FROM "environments"`}, []string{"id", "project_id"}, []driver.Value{envID.String(), projectID.String()})
+		db.on([]string{`FROM "projects"`}, []string{"id", "org_id"}, []driver.Value{projectID.String(), orgID.String()})
+		db.on([]string{`FROM "audit_chain_heads"`}, []string{"org_id", "sequence", "hash"}, []driver.Value{orgID.String(), int64(0), ""})
+		return db
+	}
+
+	db := listDB(t, false, true)
+	secrets, err := service.ListSecrets(context.Background(), userID, envID, nil, "203.0.113.7")
+	if err != nil {
+		t.Fatalf("ListSecrets() error = %v", err)
+	}
+	if secrets[0].Value == nil || *secrets[0].Value != "value-PUBLIC_URL" || secrets[1].Value != nil {
+		t.Fatalf("values = %v, %v, want only the non-sensitive one", secrets[0].Value, secrets[1].Value)
+	}
+	reads := db.statements(`INSERT INTO "audit_logs"`)
+	if len(reads) != 1 {
+		t.Fatalf("audit entries = %v, want one read", reads)
+	}
+	action, _ := reads[0].inserted("action")
+	resource, _ := reads[0].inserted("resource_id")
+	metadata, _ := reads[0].inserted("metadata")
+	if action != models.ActionSecretRead || resource != envID.String() {
+		t.Fatalf("audited %v on %v, want %s on the environment", action, resource, models.ActionSecretRead)
+	}
+	if got := fmt.Sprint(metadata); !strings.Contains(got, "PUBLIC_URL") || strings.Contains(got, "API_KEY") {
+		t.Fatalf("metadata = %s, want only the shown key", got)
+	}
+
+	// Listing only sensitive secrets shows no values and reads nothing.
+	db = listDB(t, true, true)
+	if _, err := service.ListSecrets(context.Background(), userID, envID, nil, ""); err != nil {
+		t.Fatalf("ListSecrets() error = %v", err)
+	}
+	if reads := db.statements(`INSERT INTO "audit_logs"`); len(reads) != 0 {
+		t.Fatalf("audit entries = %v, want none without values", reads)
+	}
+}
+
+// unavailableKMS stands in for a KMS key this server can no longer use.
+type unavailableKMS struct{}
+
+func (unavailableKMS) Encrypt(context.Context, string, string) (string, error) {
+	return "", errors.New("kms unavailable")
+}
+
+func (unavailableKMS) Decrypt(context.Context, string, string) (string, error) {
+	return "", errors.New("kms unavailable")
+}
+
+func (unavailableKMS) KeyID() string { return "arn:aws:kms:test" }
+
+// decryptTestDB serves one environment holding a value written by the local
+// encryptor, a local value stored without its prefix under the KMS key ID
+// (found only through the fallback), and a KMS value nothing can decrypt.
+func decryptTestDB(t *testing.T, local *LocalEncryptionService, orgID, projectID, envID uuid.UUID) *fakeDB {
+	t.Helper()
+	db := useFakeDB(t)
+	encrypt := func(value string) string {
+		encrypted, err := local.Encrypt(context.Background(), value, orgID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encrypted
+	}
+	db.on([]string{`FROM "secrets"`}, []string{"id", "environment_id", "key", "encrypted_value", "kms_key_id"},
+		[]driver.Value{uuid.NewString(), envID.String(), "LOCAL_KEY", encrypt("local-value"), "local"},
+		[]driver.Value{uuid.NewString(), envID.String(), "MIGRATED_KEY", strings.TrimPrefix(encrypt("migrated-value"), "local:"), "arn:aws:kms:test"},
+		[]driver.Value{uuid.NewString(), envID.String(), "BROKEN_KEY", "AQIDBAUG", "arn:aws:kms:test"})
+	db.on([]string{`FROM "environments"`}, []string{"id", "project_id", "name"}, []driver.Value{envID.String(), projectID.String(), "production"})
+	db.on([]string{`FROM "projects"`}, []string{"id", "org_id", "name"}, []driver.Value{projectID.String(), orgID.String(), "api"})
+	db.on([]string{`FROM "organizations"`}, []string{"id"}, []driver.Value{orgID.String()})
+	return db
+}
+
+func TestDecryptEnvironmentSecretsSkipsOnlyUndecryptableSecrets(t *testing.T) {
+	local := NewLocalEncryptionService("secret")
+	service := NewSecretService(unavailableKMS{}, local, nil, nil, nil, 4)
+	orgID, projectID, envID := uuid.New(), uuid.New(), uuid.New()
+	decryptTestDB(t, local, orgID, projectID, envID)
+
+	values, skipped, gotOrg, err := service.DecryptEnvironmentSecrets(context.Background(), envID, nil, nil)
+	if err != nil {
+		t.Fatalf("DecryptEnvironmentSecrets() error = %v", err)
+	}
+	want := map[string]string{"LOCAL_KEY": "local-value", "MIGRATED_KEY": "migrated-value"}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("values = %v, want %v (the local fallback recovers MIGRATED_KEY)", values, want)
+	}
+	if !reflect.DeepEqual(skipped, []SkippedSecret{{Key: "BROKEN_KEY", Reason: SkipReasonDecryptFailed}}) {
+		t.Fatalf("skipped = %v, want only BROKEN_KEY", skipped)
+	}
+	if gotOrg != orgID {
+		t.Fatalf("org = %v, want %v", gotOrg, orgID)
+	}
+}
+
+func TestEncryptionHealthCountsFailuresPerKey(t *testing.T) {
+	local := NewLocalEncryptionService("secret")
+	service := NewSecretService(unavailableKMS{}, local, nil, nil, nil, 2)
+	orgID, projectID, envID := uuid.New(), uuid.New(), uuid.New()
+	decryptTestDB(t, local, orgID, projectID, envID)
+
+	report, err := service.EncryptionHealth(context.Background(), uuid.New(), orgID, "")
+	if err != nil {
+		t.Fatalf("EncryptionHealth() error = %v", err)
+	}
+	if report.PrimaryKeyID != "arn:aws:kms:test" || report.TotalSecrets != 3 || report.Healthy != 2 {
+		t.Fatalf("report = %+v, want 2 of 3 healthy under the KMS key", report)
+	}
+	wantUsage := []EncryptionKeyUsage{{KMSKeyID: "arn:aws:kms:test", Secrets: 2, Failures: 1}, {KMSKeyID: "local", Secrets: 1}}
+	if !reflect.DeepEqual(report.KeyUsage, wantUsage) {
+		t.Fatalf("key usage = %+v, want %+v", report.KeyUsage, wantUsage)
+	}
+	if len(report.Failures) != 1 {
+		t.Fatalf("failures = %+v, want one", report.Failures)
+	}
+	failure := report.Failures[0]
+	if failure.Key != "BROKEN_KEY" || failure.EnvironmentName != "production" || failure.ProjectName != "api" || failure.Error != "kms unavailable" {
+		t.Fatalf("failure = %+v", failure)
+	}
+}
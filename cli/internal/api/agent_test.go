@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -39,3 +40,67 @@ func TestResolveAgentSecretsUsesAgentBearerAndRequestBody(t *testing.T) {
 		t.Fatalf("secrets = %v", result.Secrets)
 	}
 }
+
+func TestRenewAndReleaseAgentLease(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"lease_id":"l1","expires_at":"2026-08-04T00:05:00Z","renew_count":1}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"lease_id":"l1"}`))
+	}))
+	defer server.Close()
+
+	client := NewAgentClient(server.URL, "envo_agent_test")
+	renewed, err := client.RenewAgentLease(context.Background(), "l1")
+	if err != nil || renewed.RenewCount != 1 || renewed.ExpiresAt.IsZero() {
+		t.Fatalf("RenewAgentLease() = %+v, %v", renewed, err)
+	}
+	if err := client.ReleaseAgentLease(context.Background(), "l1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "POST /api/v1/agent/leases/l1/renew" || calls[1] != "DELETE /api/v1/agent/leases/l1" {
+		t.Fatalf("calls = %v", calls)
+	}
+}
+
+func TestResolveAgentSecretsMarksForbidden(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":"Agent is not authorized"}`))
+	}))
+	defer server.Close()
+
+	_, err := NewAgentClient(server.URL, "envo_agent_test").ResolveAgentSecrets(context.Background(), ResolveAgentSecretsRequest{Project: "api", Environment: "production"})
+	if !errors.Is(err, ErrAgentForbidden) {
+		t.Fatalf("error = %v, want ErrAgentForbidden", err)
+	}
+}
+
+func TestGetAgentKeysSendsSelectorsAndMarksForbidden(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v1/agent/access/keys" || q.Get("project") != "api" || q.Get("environment") != "staging" {
+			t.Fatalf("request = %s", r.URL)
+		}
+		if q.Get("keys") == "SECRET" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":"Agent is not authorized"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"project":"api","environment":"staging","keys":["A","B"],"dynamic_keys":["DATABASE_URL"]}`))
+	}))
+	defer server.Close()
+
+	client := NewAgentClient(server.URL, "envo_agent_test")
+	keys, err := client.GetAgentKeys(context.Background(), "api", "staging", nil)
+	if err != nil || len(keys.Keys) != 2 || keys.DynamicKeys[0] != "DATABASE_URL" {
+		t.Fatalf("GetAgentKeys() = %+v, %v", keys, err)
+	}
+	if _, err := client.GetAgentKeys(context.Background(), "api", "staging", []string{"SECRET"}); !errors.Is(err, ErrAgentForbidden) {
+		t.Fatalf("error = %v, want ErrAgentForbidden", err)
+	}
+}
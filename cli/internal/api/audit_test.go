@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/envo/cli/internal/store"
+)
+
+func TestListAuditLogsSendsFiltersAndReturnsCursor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/orgs/o1/audit-logs" {
+			t.Fatalf("path = %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("actor_type") != "agent" || q.Get("since") != "2026-03-01T00:00:00Z" || q.Get("q") != "deploy" || q.Get("limit") != "2" || q.Get("cursor") != "c1" {
+			t.Fatalf("query = %v", q)
+		}
+		if actions := q["action"]; len(actions) != 2 || actions[0] != "secret_read" {
+			t.Fatalf("actions = %v", actions)
+		}
+		if q.Has("until") || q.Has("user_id") {
+			t.Fatalf("empty filters were sent: %v", q)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Next-Cursor", "c2")
+		_, _ = w.Write([]byte(`[{"id":"l1","action":"secret_read","created_at":"2026-03-02T10:00:00Z"}]`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, &store.Tokens{AccessToken: "t"})
+	logs, next, err := client.ListAuditLogs(context.Background(), "o1", AuditLogQuery{
+		ActorType: "agent",
+		Actions:   []string{"secret_read", "secret_update"},
+		Since:     time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		Search:    "deploy",
+		Cursor:    "c1",
+		Limit:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Action != "secret_read" || next != "c2" {
+		t.Fatalf("ListAuditLogs() = %+v, %q", logs, next)
+	}
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/orgs/o1/audit-logs/verify" {
+			t.Fatalf("path = %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"valid":false,"entries":4,"problems":[{"sequence":2,"kind":"gap","detail":"entry 2 is missing"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, &store.Tokens{AccessToken: "t"})
+	report, err := client.VerifyAuditChain(context.Background(), "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid || report.Entries != 4 || len(report.Problems) != 1 || report.Problems[0].Kind != "gap" {
+		t.Fatalf("VerifyAuditChain() = %+v", report)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -145,6 +147,65 @@ func (c *Client) GetAgentMe(ctx context.Context) (*AgentMe, error) {
 	return &out, err
 }
 
+type AgentGrantSummary struct {
+	ID                 string     `json:"id"`
+	Capability         string     `json:"capability"`
+	Project            string     `json:"project"`
+	Environment        string     `json:"environment"`
+	EnvironmentPattern string     `json:"environment_pattern"`
+	Environments       []string   `json:"environments"`
+	AllowAllSecrets    bool       `json:"allow_all_secrets"`
+	AllowedKeys        []string   `json:"allowed_keys"`
+	DeniedKeys         []string   `json:"denied_keys"`
+	CredentialKey      string     `json:"credential_key"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+}
+
+type AgentAccess struct {
+	AgentID string              `json:"agent_id"`
+	Grants  []AgentGrantSummary `json:"grants"`
+}
+
+// GetAgentAccess lists the agent's live grants.
+func (c *Client) GetAgentAccess(ctx context.Context) (*AgentAccess, error) {
+	if c.agentToken == "" {
+		return nil, fmt.Errorf("ENVO_TOKEN is not set")
+	}
+	var out AgentAccess
+	_, err := c.do(ctx, http.MethodGet, "/api/v1/agent/access", nil, &out, true)
+	return &out, err
+}
+
+type AgentEnvironmentKeys struct {
+	Project       string     `json:"project"`
+	Environment   string     `json:"environment"`
+	EnvironmentID string     `json:"environment_id"`
+	Keys          []string   `json:"keys"`
+	DynamicKeys   []string   `json:"dynamic_keys"`
+	ExpiredKeys   []string   `json:"expired_keys"`
+	DeniedKeys    []string   `json:"denied_keys"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+}
+
+// GetAgentKeys lists the key names a resolve of the environment would
+// return, without values. When keys are given, it fails with
+// ErrAgentForbidden unless every one is covered.
+func (c *Client) GetAgentKeys(ctx context.Context, project, environment string, keys []string) (*AgentEnvironmentKeys, error) {
+	if c.agentToken == "" {
+		return nil, fmt.Errorf("ENVO_TOKEN is not set")
+	}
+	q := url.Values{"project": {project}, "environment": {environment}}
+	if len(keys) > 0 {
+		q.Set("keys", strings.Join(keys, ","))
+	}
+	var out AgentEnvironmentKeys
+	resp, err := c.do(ctx, http.MethodGet, "/api/v1/agent/access/keys?"+q.Encode(), nil, &out, true)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusForbidden {
+		err = fmt.Errorf("%w (%v)", ErrAgentForbidden, err)
+	}
+	return &out, err
+}
+
 type ResolveAgentSecretsRequest struct {
 	Project     string   `json:"project"`
 	Environment string   `json:"environment"`
@@ -159,20 +220,109 @@ type ResolveAgentSecretsResponse struct {
 	LeaseID       string            `json:"lease_id"`
 	ExpiresAt     time.Time         `json:"expires_at"`
 	Secrets       map[string]string `json:"secrets"`
+	DynamicKeys   []string          `json:"dynamic_keys"`
+	Skipped       []SkippedSecret   `json:"skipped_keys"`
 }
 
+// ErrAgentForbidden is returned when the agent has no live grant for what it
+// asked for. An access request can ask a human for one.
+var ErrAgentForbidden = errors.New("agent is not authorized for the requested secrets")
+
 func (c *Client) ResolveAgentSecrets(ctx context.Context, req ResolveAgentSecretsRequest) (*ResolveAgentSecretsResponse, error) {
 	if c.agentToken == "" {
 		return nil, fmt.Errorf("ENVO_TOKEN is not set")
 	}
 	var out ResolveAgentSecretsResponse
-	_, err := c.do(ctx, http.MethodPost, "/api/v1/agent/secrets/resolve", req, &out, true)
+	resp, err := c.do(ctx, http.MethodPost, "/api/v1/agent/secrets/resolve", req, &out, true)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusForbidden {
+		err = fmt.Errorf("%w (%v)", ErrAgentForbidden, err)
+	}
 	if out.Secrets == nil {
 		out.Secrets = map[string]string{}
 	}
 	return &out, err
 }
 
+type AgentLeaseRenewal struct {
+	LeaseID    string    `json:"lease_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	RenewCount int       `json:"renew_count"`
+}
+
+// RenewAgentLease extends a lease returned by ResolveAgentSecrets.
+func (c *Client) RenewAgentLease(ctx context.Context, leaseID string) (*AgentLeaseRenewal, error) {
+	if c.agentToken == "" {
+		return nil, fmt.Errorf("ENVO_TOKEN is not set")
+	}
+	var out AgentLeaseRenewal
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/agent/leases/"+url.PathEscape(leaseID)+"/renew", nil, &out, true)
+	return &out, err
+}
+
+// ReleaseAgentLease ends a lease early.
+func (c *Client) ReleaseAgentLease(ctx context.Context, leaseID string) error {
+	if c.agentToken == "" {
+		return fmt.Errorf("ENVO_TOKEN is not set")
+	}
+	var out map[string]any
+	_, err := c.do(ctx, http.MethodDelete, "/api/v1/agent/leases/"+url.PathEscape(leaseID), nil, &out, true)
+	return err
+}
+
+type AccessRequest struct {
+	ID              string          `json:"id"`
+	OrgID           string          `json:"org_id"`
+	AgentID         string          `json:"agent_id"`
+	EnvironmentID   string          `json:"environment_id"`
+	Keys            []string        `json:"keys"`
+	AllowAllSecrets bool            `json:"allow_all_secrets"`
+	Purpose         string          `json:"purpose"`
+	DurationSeconds int             `json:"duration_seconds"`
+	Status          string          `json:"status"`
+	ExpiresAt       time.Time       `json:"expires_at"`
+	DecisionNote    string          `json:"decision_note"`
+	GrantID         string          `json:"grant_id"`
+	CreatedAt       time.Time       `json:"created_at"`
+	Agent           *AgentIdentity  `json:"agent,omitempty"`
+	Environment     *Environment    `json:"environment,omitempty"`
+	Grant           *AgentGrantInfo `json:"grant,omitempty"`
+}
+
+type AgentGrantInfo struct {
+	ID        string     `json:"id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateAccessRequestReq struct {
+	Project         string   `json:"project"`
+	Environment     string   `json:"environment"`
+	Keys            []string `json:"keys,omitempty"`
+	AllSecrets      bool     `json:"all_secrets,omitempty"`
+	Purpose         string   `json:"purpose"`
+	DurationSeconds int      `json:"duration_seconds,omitempty"`
+}
+
+// CreateAgentAccessRequest asks the humans who manage agents for access. An
+// identical pending request is returned rather than duplicated.
+func (c *Client) CreateAgentAccessRequest(ctx context.Context, req CreateAccessRequestReq) (*AccessRequest, error) {
+	if c.agentToken == "" {
+		return nil, fmt.Errorf("ENVO_TOKEN is not set")
+	}
+	var out AccessRequest
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/agent/access-requests", req, &out, true)
+	return &out, err
+}
+
+// GetAgentAccessRequest returns one of the agent's own requests.
+func (c *Client) GetAgentAccessRequest(ctx context.Context, requestID string) (*AccessRequest, error) {
+	if c.agentToken == "" {
+		return nil, fmt.Errorf("ENVO_TOKEN is not set")
+	}
+	var out AccessRequest
+	_, err := c.do(ctx, http.MethodGet, "/api/v1/agent/access-requests/"+url.PathEscape(requestID), nil, &out, true)
+	return &out, err
+}
+
 // -------- Auth --------
 
 type googleLoginResp struct {
@@ -308,9 +458,10 @@ type Project struct {
 }
 
 type Environment struct {
-	ID        string `json:"id"`
-	ProjectID string `json:"project_id"`
-	Name      string `json:"name"`
+	ID        string   `json:"id"`
+	ProjectID string   `json:"project_id"`
+	Name      string   `json:"name"`
+	Project   *Project `json:"project,omitempty"`
 }
 
 // -------- List endpoints --------
@@ -344,25 +495,73 @@ func (c *Client) ListProjectEnvironments(ctx context.Context, projectID string)
 
 // -------- Secrets export --------
 
+// SkippedSecret is a key the server could not decrypt and left out of an
+// export. Reason is a stable code such as "decrypt_failed".
+type SkippedSecret struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
 type exportResp struct {
 	OrgID         string            `json:"org_id"`
 	EnvironmentID string            `json:"environment_id"`
 	Secrets       map[string]string `json:"secrets"`
+	Skipped       []SkippedSecret   `json:"skipped_keys"`
 }
 
-func (c *Client) ExportEnvironmentSecrets(ctx context.Context, envID string) (map[string]string, error) {
+func (c *Client) ExportEnvironmentSecrets(ctx context.Context, envID string) (map[string]string, []SkippedSecret, error) {
 	if _, err := c.EnsureAccessToken(ctx); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	var out exportResp
 	_, err := c.do(ctx, http.MethodGet, "/api/v1/environments/"+envID+"/secrets/export", nil, &out, true)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if out.Secrets == nil {
 		out.Secrets = map[string]string{}
 	}
-	return out.Secrets, nil
+	return out.Secrets, out.Skipped, nil
+}
+
+// -------- Secret schema --------
+
+type SchemaViolation struct {
+	Key     string `json:"key"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type SchemaEnvironmentReport struct {
+	EnvironmentID   string            `json:"environment_id"`
+	EnvironmentName string            `json:"environment_name"`
+	Valid           bool              `json:"valid"`
+	Missing         []string          `json:"missing"`
+	Invalid         []SchemaViolation `json:"invalid"`
+}
+
+type SchemaReport struct {
+	ProjectID    string                    `json:"project_id"`
+	ProjectName  string                    `json:"project_name"`
+	Valid        bool                      `json:"valid"`
+	Environments []SchemaEnvironmentReport `json:"environments"`
+}
+
+// ValidateProjectSecrets fetches the schema report for a project. envID may be
+// empty to check every environment.
+func (c *Client) ValidateProjectSecrets(ctx context.Context, projectID, envID string) (*SchemaReport, error) {
+	if _, err := c.EnsureAccessToken(ctx); err != nil {
+		return nil, err
+	}
+	path := "/api/v1/projects/" + projectID + "/schema/report"
+	if envID != "" {
+		path += "?" + url.Values{"environment": {envID}}.Encode()
+	}
+	var out SchemaReport
+	if _, err := c.do(ctx, http.MethodGet, path, nil, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
 }
 
 type PlatformConnection struct {
@@ -406,3 +605,151 @@ func (c *Client) SyncEnvironment(ctx context.Context, envID string, req SyncEnvi
 	}
 	return &out, nil
 }
+
+// -------- Agent access requests --------
+
+func (c *Client) ListAccessRequests(ctx context.Context, orgID, status string) ([]AccessRequest, error) {
+	path := "/api/v1/orgs/" + url.PathEscape(orgID) + "/agent-access-requests"
+	if status != "" {
+		path += "?status=" + url.QueryEscape(status)
+	}
+	var out []AccessRequest
+	_, err := c.do(ctx, http.MethodGet, path, nil, &out, true)
+	return out, err
+}
+
+type DecideAccessRequestReq struct {
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+	Note            string `json:"note,omitempty"`
+}
+
+func (c *Client) ApproveAccessRequest(ctx context.Context, orgID, requestID string, req DecideAccessRequestReq) (*AccessRequest, error) {
+	var out AccessRequest
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/orgs/"+url.PathEscape(orgID)+"/agent-access-requests/"+url.PathEscape(requestID)+"/approve", req, &out, true)
+	return &out, err
+}
+
+func (c *Client) DenyAccessRequest(ctx context.Context, orgID, requestID string, req DecideAccessRequestReq) (*AccessRequest, error) {
+	var out AccessRequest
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/orgs/"+url.PathEscape(orgID)+"/agent-access-requests/"+url.PathEscape(requestID)+"/deny", req, &out, true)
+	return &out, err
+}
+
+// -------- Audit logs --------
+
+type AuditLog struct {
+	ID           string          `json:"id"`
+	ActorType    string          `json:"actor_type"`
+	UserID       string          `json:"user_id,omitempty"`
+	AgentID      string          `json:"agent_id,omitempty"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	IPAddress    string          `json:"ip_address"`
+	CreatedAt    time.Time       `json:"created_at"`
+	User         *CurrentUser    `json:"user,omitempty"`
+	Agent        *AgentIdentity  `json:"agent,omitempty"`
+}
+
+// AuditLogQuery filters an org's audit log. Empty fields do not filter.
+type AuditLogQuery struct {
+	ActorType    string
+	UserID       string
+	AgentID      string
+	Actions      []string
+	ResourceType string
+	ResourceID   string
+	Since        time.Time
+	Until        time.Time
+	IP           string
+	Search       string
+	Cursor       string
+	Limit        int
+}
+
+func (q AuditLogQuery) values() url.Values {
+	v := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	set("actor_type", q.ActorType)
+	set("user_id", q.UserID)
+	set("agent_id", q.AgentID)
+	set("resource_type", q.ResourceType)
+	set("resource_id", q.ResourceID)
+	set("ip", q.IP)
+	set("q", q.Search)
+	set("cursor", q.Cursor)
+	for _, action := range q.Actions {
+		v.Add("action", action)
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.UTC().Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.UTC().Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// ListAuditLogs returns one page of an org's audit log, most recent first,
+// and the cursor for the next page (empty on the last page).
+func (c *Client) ListAuditLogs(ctx context.Context, orgID string, q AuditLogQuery) ([]AuditLog, string, error) {
+	path := "/api/v1/orgs/" + url.PathEscape(orgID) + "/audit-logs"
+	if enc := q.values().Encode(); enc != "" {
+		path += "?" + enc
+	}
+	var out []AuditLog
+	resp, err := c.do(ctx, http.MethodGet, path, nil, &out, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, resp.Header.Get("X-Next-Cursor"), nil
+}
+
+// AuditChainProblem is one defect found while verifying an audit chain.
+type AuditChainProblem struct {
+	Sequence int64  `json:"sequence"`
+	Kind     string `json:"kind"`
+	Detail   string `json:"detail"`
+}
+
+// AuditCheckpoint is a server-signed hash of an audit chain at a sequence.
+type AuditCheckpoint struct {
+	Sequence  int64     `json:"sequence"`
+	Hash      string    `json:"hash"`
+	KeyID     string    `json:"key_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditChainReport is the result of verifying an org's audit chain.
+type AuditChainReport struct {
+	Valid            bool                `json:"valid"`
+	Entries          int64               `json:"entries"`
+	UnchainedEntries int64               `json:"unchained_entries"`
+	FirstSequence    int64               `json:"first_sequence"`
+	LastSequence     int64               `json:"last_sequence"`
+	HeadSequence     int64               `json:"head_sequence"`
+	PrunedSequence   int64               `json:"pruned_sequence"`
+	Checkpoints      int                 `json:"checkpoints"`
+	LastCheckpoint   *AuditCheckpoint    `json:"last_checkpoint,omitempty"`
+	KeyID            string              `json:"key_id"`
+	PublicKey        string              `json:"public_key"`
+	Problems         []AuditChainProblem `json:"problems,omitempty"`
+	ProblemsOmitted  int                 `json:"problems_omitted,omitempty"`
+}
+
+// VerifyAuditChain asks the server to verify an org's hash-chained audit log.
+func (c *Client) VerifyAuditChain(ctx context.Context, orgID string) (*AuditChainReport, error) {
+	var out AuditChainReport
+	if _, err := c.do(ctx, http.MethodGet, "/api/v1/orgs/"+url.PathEscape(orgID)+"/audit-logs/verify", nil, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
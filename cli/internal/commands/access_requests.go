@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/envo/cli/internal/api"
+	"github.com/envo/cli/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func newAccessRequestsCmd(deps *rootDeps) *cobra.Command {
+	var orgSel string
+
+	// humanClient logs in and resolves the workspace shared by every subcommand.
+	humanClient := func(ctx context.Context) (*api.Client, string, error) {
+		if deps.tokens == nil {
+			return nil, "", fmt.Errorf("not logged in; run `envo login`")
+		}
+		client := api.NewClient(deps.cfg.APIBaseURL, deps.tokens)
+		t, err := client.EnsureAccessToken(ctx)
+		if err != nil {
+			return nil, "", err
+		}
+		_ = store.SaveTokens(*t)
+		orgID, err := resolveOrgID(ctx, client, orgSel)
+		if err != nil {
+			return nil, "", err
+		}
+		return client, orgID, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:     "access-requests",
+		Aliases: []string{"requests"},
+		Short:   "Review agent requests for just-in-time access",
+	}
+	cmd.PersistentFlags().StringVar(&orgSel, "org", "", "Organization id or name (default: personal vault)")
+
+	var status string
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List agent access requests",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			client, orgID, err := humanClient(ctx)
+			if err != nil {
+				return err
+			}
+			requests, err := client.ListAccessRequests(ctx, orgID, status)
+			if err != nil {
+				return err
+			}
+			printAccessRequests(cmd.OutOrStdout(), requests)
+			return nil
+		},
+	}
+	list.Flags().StringVar(&status, "status", "pending", "Only show requests with this status (pending, approved, denied, expired; empty for all)")
+
+	var (
+		duration time.Duration
+		note     string
+	)
+	approve := &cobra.Command{
+		Use:   "approve <request-id>",
+		Short: "Approve a request and create a time-boxed grant",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			client, orgID, err := humanClient(ctx)
+			if err != nil {
+				return err
+			}
+			request, err := client.ApproveAccessRequest(ctx, orgID, args[0], api.DecideAccessRequestReq{DurationSeconds: int(duration / time.Second), Note: note})
+			if err != nil {
+				return err
+			}
+			expires := "when revoked"
+			if request.Grant != nil && request.Grant.ExpiresAt != nil {
+				expires = request.Grant.ExpiresAt.Local().Format(time.RFC1123)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Approved %s; grant %s expires %s\n", request.ID, request.GrantID, expires)
+			return nil
+		},
+	}
+	approve.Flags().DurationVar(&duration, "duration", 0, "Grant for less time than requested (default: as requested)")
+	approve.Flags().StringVar(&note, "note", "", "Note recorded with the decision")
+
+	var denyNote string
+	deny := &cobra.Command{
+		Use:   "deny <request-id>",
+		Short: "Deny a request",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			client, orgID, err := humanClient(ctx)
+			if err != nil {
+				return err
+			}
+			request, err := client.DenyAccessRequest(ctx, orgID, args[0], api.DecideAccessRequestReq{Note: denyNote})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Denied %s\n", request.ID)
+			return nil
+		},
+	}
+	deny.Flags().StringVar(&denyNote, "note", "", "Reason shown to the agent")
+
+	cmd.AddCommand(list, approve, deny)
+	return cmd
+}
+
+func printAccessRequests(w io.Writer, requests []api.AccessRequest) {
+	if len(requests) == 0 {
+		fmt.Fprintln(w, "No access requests")
+		return
+	}
+	for _, r := range requests {
+		agent, scope := r.AgentID, strings.Join(r.Keys, ",")
+		if r.Agent != nil && r.Agent.Name != "" {
+			agent = r.Agent.Name
+		}
+		if r.AllowAllSecrets {
+			scope = "all secrets"
+		}
+		target := r.EnvironmentID
+		if r.Environment != nil {
+			target = r.Environment.Name
+			if r.Environment.Project != nil {
+				target = r.Environment.Project.Name + "/" + target
+			}
+		}
+		fmt.Fprintf(w, "%s  %-8s  %s -> %s [%s] for %s: %s\n", r.ID, r.Status, agent, target, scope, time.Duration(r.DurationSeconds)*time.Second, r.Purpose)
+	}
+}
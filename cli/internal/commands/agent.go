@@ -3,6 +3,8 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/envo/cli/internal/api"
@@ -14,16 +16,26 @@ func newAgentCmd(deps *rootDeps) *cobra.Command {
 		Use:   "agent",
 		Short: "Inspect the agent identity provided through ENVO_TOKEN",
 	}
+
+	// agentClient requires ENVO_TOKEN for every subcommand.
+	agentClient := func() (*api.Client, error) {
+		if deps.cfg.AgentToken == "" {
+			return nil, fmt.Errorf("ENVO_TOKEN is not set")
+		}
+		return api.NewAgentClient(deps.cfg.APIBaseURL, deps.cfg.AgentToken), nil
+	}
+
 	cmd.AddCommand(&cobra.Command{
 		Use:   "whoami",
 		Short: "Show the current Envo agent and credential",
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			if deps.cfg.AgentToken == "" {
-				return fmt.Errorf("ENVO_TOKEN is not set")
+			client, err := agentClient()
+			if err != nil {
+				return err
 			}
 			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
 			defer cancel()
-			me, err := api.NewAgentClient(deps.cfg.APIBaseURL, deps.cfg.AgentToken).GetAgentMe(ctx)
+			me, err := client.GetAgentMe(ctx)
 			if err != nil {
 				return err
 			}
@@ -31,5 +43,101 @@ func newAgentCmd(deps *rootDeps) *cobra.Command {
 			return nil
 		},
 	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "access",
+		Short: "List the agent's live grants",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client, err := agentClient()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			access, err := client.GetAgentAccess(ctx)
+			if err != nil {
+				return err
+			}
+			printAgentGrants(cmd.OutOrStdout(), access.Grants)
+			return nil
+		},
+	})
+
+	var projectSel, envSel string
+	keys := &cobra.Command{
+		Use:   "keys --project <project> --env <env>",
+		Short: "List the secret key names the agent would receive (never values)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client, err := agentClient()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			result, err := client.GetAgentKeys(ctx, projectSel, envSel, nil)
+			if err != nil {
+				return err
+			}
+			printAgentKeys(cmd.OutOrStdout(), cmd.ErrOrStderr(), result)
+			return nil
+		},
+	}
+	keys.Flags().StringVar(&projectSel, "project", "", "Project id or name (required)")
+	keys.Flags().StringVar(&envSel, "env", "", "Environment id or name (required)")
+	_ = keys.MarkFlagRequired("project")
+	_ = keys.MarkFlagRequired("env")
+	cmd.AddCommand(keys)
 	return cmd
 }
+
+func printAgentGrants(w io.Writer, grants []api.AgentGrantSummary) {
+	if len(grants) == 0 {
+		fmt.Fprintln(w, "No live grants")
+		return
+	}
+	for _, g := range grants {
+		target := g.Project + "/" + g.Environment
+		if g.Environment == "" {
+			pattern := g.EnvironmentPattern
+			if pattern == "" {
+				pattern = "*"
+			}
+			target = fmt.Sprintf("%s/%s (%s)", g.Project, pattern, strings.Join(g.Environments, ","))
+		}
+		scope := strings.Join(g.AllowedKeys, ",")
+		switch {
+		case g.CredentialKey != "":
+			scope = g.CredentialKey
+		case g.AllowAllSecrets:
+			scope = "all secrets"
+		}
+		if len(g.DeniedKeys) > 0 {
+			scope += " except " + strings.Join(g.DeniedKeys, ",")
+		}
+		expiry := "no expiry"
+		if g.ExpiresAt != nil {
+			expiry = "until " + g.ExpiresAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%-19s  %s [%s] %s\n", g.Capability, target, scope, expiry)
+	}
+}
+
+func printAgentKeys(w, warn io.Writer, result *api.AgentEnvironmentKeys) {
+	for _, key := range result.Keys {
+		fmt.Fprintln(w, key)
+	}
+	for _, key := range result.DynamicKeys {
+		fmt.Fprintf(w, "%s (dynamic)\n", key)
+	}
+	if len(result.ExpiredKeys) > 0 {
+		fmt.Fprintf(warn, "envo: expired and withheld: %s\n", strings.Join(result.ExpiredKeys, ", "))
+	}
+	if len(result.DeniedKeys) > 0 {
+		fmt.Fprintf(warn, "envo: withheld by an access policy: %s\n", strings.Join(result.DeniedKeys, ", "))
+	}
+	if len(result.Keys) == 0 && len(result.DynamicKeys) == 0 {
+		fmt.Fprintf(warn, "envo: no keys available in %s/%s\n", result.Project, result.Environment)
+	}
+}
@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/envo/cli/internal/api"
+)
+
+func TestPrintAgentGrantsDescribesScope(t *testing.T) {
+	var out strings.Builder
+	printAgentGrants(&out, []api.AgentGrantSummary{
+		{Capability: "secrets.inject", Project: "api", Environment: "staging", AllowedKeys: []string{"STRIPE_*"}, DeniedKeys: []string{"STRIPE_ADMIN"}},
+		{Capability: "secrets.inject", Project: "api", EnvironmentPattern: "preview-*", Environments: []string{"preview-1"}, AllowAllSecrets: true},
+	})
+	got := out.String()
+	for _, want := range []string{"api/staging [STRIPE_* except STRIPE_ADMIN] no expiry", "api/preview-* (preview-1) [all secrets]"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("output %q missing %q", got, want)
+		}
+	}
+}
+
+func TestPrintAgentKeysWarnsAboutWithheldKeys(t *testing.T) {
+	var out, warn strings.Builder
+	printAgentKeys(&out, &warn, &api.AgentEnvironmentKeys{
+		Project: "api", Environment: "staging",
+		Keys: []string{"A"}, DynamicKeys: []string{"DATABASE_URL"}, ExpiredKeys: []string{"OLD"}, DeniedKeys: []string{"PROD"},
+	})
+	if out.String() != "A\nDATABASE_URL (dynamic)\n" {
+		t.Fatalf("output = %q", out.String())
+	}
+	if !strings.Contains(warn.String(), "OLD") || !strings.Contains(warn.String(), "access policy: PROD") {
+		t.Fatalf("warning = %q", warn.String())
+	}
+}
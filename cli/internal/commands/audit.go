@@ -0,0 +1,250 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/envo/cli/internal/api"
+	"github.com/envo/cli/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func newAuditCmd(deps *rootDeps) *cobra.Command {
+	var (
+		orgSel     string
+		query      api.AuditLogQuery
+		since      string
+		until      string
+		all        bool
+		jsonOutput bool
+	)
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Search the workspace audit log",
+		Long: `Search the workspace audit log, most recent first.
+
+--since and --until take an RFC 3339 time, a date (2006-01-02), or an age
+such as 90m, 24h or 7d. Without --all, the command prints one page and the
+--cursor that continues it.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if deps.tokens == nil {
+				return fmt.Errorf("not logged in; run `envo login`")
+			}
+			now := time.Now()
+			var err error
+			if query.Since, err = parseAuditTime(since, now); err != nil {
+				return fmt.Errorf("--since: %w", err)
+			}
+			if query.Until, err = parseAuditTime(until, now); err != nil {
+				return fmt.Errorf("--until: %w", err)
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+			defer cancel()
+			client := api.NewClient(deps.cfg.APIBaseURL, deps.tokens)
+			t, err := client.EnsureAccessToken(ctx)
+			if err != nil {
+				return err
+			}
+			_ = store.SaveTokens(*t)
+			orgID, err := resolveOrgID(ctx, client, orgSel)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			printed := 0
+			for {
+				pageCtx, cancel := context.WithTimeout(cmd.Context(), 30*time.Second)
+				logs, next, err := client.ListAuditLogs(pageCtx, orgID, query)
+				cancel()
+				if err != nil {
+					return err
+				}
+				if err := printAuditLogs(out, logs, jsonOutput); err != nil {
+					return err
+				}
+				printed += len(logs)
+				if next == "" {
+					break
+				}
+				if !all {
+					fmt.Fprintf(cmd.ErrOrStderr(), "More entries: rerun with --cursor %s, or use --all\n", next)
+					break
+				}
+				query.Cursor = next
+			}
+			if printed == 0 && !jsonOutput {
+				fmt.Fprintln(out, "No audit entries")
+			}
+			return nil
+		},
+	}
+	cmd.PersistentFlags().StringVar(&orgSel, "org", "", "Organization id or name (default: personal vault)")
+	cmd.Flags().StringVar(&query.ActorType, "actor-type", "", "Only entries by a human, an agent, or Envo itself (human, agent, system)")
+	cmd.Flags().StringVar(&query.UserID, "user", "", "Only entries by this user id")
+	cmd.Flags().StringVar(&query.AgentID, "agent", "", "Only entries by this agent id")
+	cmd.Flags().StringSliceVar(&query.Actions, "action", nil, "Only these actions, e.g. secret_read,secret_update")
+	cmd.Flags().StringVar(&query.ResourceType, "resource-type", "", "Only entries about this resource type, e.g. secret")
+	cmd.Flags().StringVar(&query.ResourceID, "resource", "", "Only entries about this resource id")
+	cmd.Flags().StringVar(&since, "since", "", "Only entries at or after this time or age")
+	cmd.Flags().StringVar(&until, "until", "", "Only entries before this time or age")
+	cmd.Flags().StringVar(&query.IP, "ip", "", "Only entries from this client address")
+	cmd.Flags().StringVar(&query.Search, "search", "", "Only entries whose metadata contains this text")
+	cmd.Flags().IntVar(&query.Limit, "limit", 50, "Entries per page (at most 500)")
+	cmd.Flags().StringVar(&query.Cursor, "cursor", "", "Continue from a previous page")
+	cmd.Flags().BoolVar(&all, "all", false, "Follow every page instead of stopping after one")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print one JSON object per entry")
+	cmd.AddCommand(newAuditVerifyCmd(deps, &orgSel))
+	return cmd
+}
+
+func newAuditVerifyCmd(deps *rootDeps, orgSel *string) *cobra.Command {
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the audit log has not been altered",
+		Long: `Verify the workspace's hash-chained audit log on the server: every entry
+still matches its hash and links to the one before it, no entries are
+missing, and each signed checkpoint still matches the chain. Exits non-zero
+when a problem is found.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if deps.tokens == nil {
+				return fmt.Errorf("not logged in; run `envo login`")
+			}
+			// Verification walks the whole chain.
+			ctx, cancel := context.WithTimeout(cmd.Context(), 5*time.Minute)
+			defer cancel()
+			client := api.NewClient(deps.cfg.APIBaseURL, deps.tokens)
+			t, err := client.EnsureAccessToken(ctx)
+			if err != nil {
+				return err
+			}
+			_ = store.SaveTokens(*t)
+			orgID, err := resolveOrgID(ctx, client, *orgSel)
+			if err != nil {
+				return err
+			}
+			report, err := client.VerifyAuditChain(ctx, orgID)
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				if err := json.NewEncoder(cmd.OutOrStdout()).Encode(report); err != nil {
+					return err
+				}
+			} else {
+				printAuditChainReport(cmd.OutOrStdout(), report)
+			}
+			if !report.Valid {
+				return fmt.Errorf("audit log verification failed")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the report as JSON")
+	return cmd
+}
+
+func printAuditChainReport(w io.Writer, r *api.AuditChainReport) {
+	if r.Entries == 0 {
+		fmt.Fprintln(w, "Chained entries: none")
+	} else {
+		fmt.Fprintf(w, "Chained entries: %d (sequence %d to %d)\n", r.Entries, r.FirstSequence, r.LastSequence)
+	}
+	if r.PrunedSequence > 0 {
+		fmt.Fprintf(w, "Pruned by retention: entries up to %d\n", r.PrunedSequence)
+	}
+	if r.UnchainedEntries > 0 {
+		fmt.Fprintf(w, "Unchained entries: %d (written before chaining; not verifiable)\n", r.UnchainedEntries)
+	}
+	if r.LastCheckpoint != nil {
+		fmt.Fprintf(w, "Checkpoints: %d, last at sequence %d on %s\n", r.Checkpoints, r.LastCheckpoint.Sequence, r.LastCheckpoint.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	} else {
+		fmt.Fprintln(w, "Checkpoints: none yet")
+	}
+	fmt.Fprintf(w, "Signing key: %s\n", r.KeyID)
+	if r.Valid {
+		fmt.Fprintln(w, "OK: the audit log is intact")
+		return
+	}
+	fmt.Fprintln(w, "Problems:")
+	for _, p := range r.Problems {
+		fmt.Fprintf(w, "  %-20s  %d  %s\n", p.Kind, p.Sequence, p.Detail)
+	}
+	if r.ProblemsOmitted > 0 {
+		fmt.Fprintf(w, "  ... and %d more\n", r.ProblemsOmitted)
+	}
+}
+
+// parseAuditTime reads an RFC 3339 time, a date, or an age before now such
+// as 90m or 7d. Empty means no bound.
+func parseAuditTime(raw string, now time.Time) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", raw, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid age %q", raw)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	age, err := time.ParseDuration(raw)
+	if err != nil || age < 0 {
+		return time.Time{}, fmt.Errorf("%q is not a time, date or age", raw)
+	}
+	return now.Add(-age), nil
+}
+
+func printAuditLogs(w io.Writer, logs []api.AuditLog, jsonOutput bool) error {
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		for _, l := range logs {
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, l := range logs {
+		actor := auditActor(l)
+		line := fmt.Sprintf("%s  %-24s  %-28s  %s %s", l.CreatedAt.Local().Format("2006-01-02 15:04:05"), actor, l.Action, l.ResourceType, l.ResourceID)
+		if l.IPAddress != "" {
+			line += "  " + l.IPAddress
+		}
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// auditActor names who made an entry: the agent or user name when the API
+// included it, otherwise the id.
+func auditActor(l api.AuditLog) string {
+	switch {
+	case l.Agent != nil && l.Agent.Name != "":
+		return l.Agent.Name + " (agent)"
+	case l.AgentID != "":
+		return l.AgentID + " (agent)"
+	case l.User != nil && l.User.Email != "":
+		return l.User.Email
+	case l.ActorType == "system":
+		return "envo (system)"
+	default:
+		return l.UserID
+	}
+}
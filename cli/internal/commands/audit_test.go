@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envo/cli/internal/api"
+)
+
+func TestParseAuditTime(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	for raw, want := range map[string]time.Time{
+		"":                     {},
+		"2026-03-01T08:00:00Z": time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC),
+		"90m":                  now.Add(-90 * time.Minute),
+		"7d":                   now.AddDate(0, 0, -7),
+	} {
+		got, err := parseAuditTime(raw, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseAuditTime(%q) = %v, %v, want %v", raw, got, err, want)
+		}
+	}
+	if got, err := parseAuditTime("2026-03-01", now); err != nil || got.Format("2006-01-02") != "2026-03-01" {
+		t.Errorf("parseAuditTime(date) = %v, %v", got, err)
+	}
+	for _, bad := range []string{"yesterday", "-1h", "xd"} {
+		if _, err := parseAuditTime(bad, now); err == nil {
+			t.Errorf("parseAuditTime(%q) accepted it", bad)
+		}
+	}
+}
+
+func TestPrintAuditLogsNamesActors(t *testing.T) {
+	logs := []api.AuditLog{
+		{ActorType: "agent", AgentID: "a1", Agent: &api.AgentIdentity{Name: "deploy-bot"}, Action: "secret_read", ResourceType: "environment", ResourceID: "e1", IPAddress: "203.0.113.4"},
+		{ActorType: "human", UserID: "u1", User: &api.CurrentUser{Email: "dev@example.com"}, Action: "secret_update", ResourceType: "secret", ResourceID: "s1"},
+		{ActorType: "system", Action: "agent_update", ResourceType: "agent", ResourceID: "a1"},
+	}
+	var out strings.Builder
+	if err := printAuditLogs(&out, logs, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"deploy-bot (agent)", "secret_read", "environment e1  203.0.113.4", "dev@example.com", "secret s1", "envo (system)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output %q missing %q", out.String(), want)
+		}
+	}
+
+	out.Reset()
+	if err := printAuditLogs(&out, logs, true); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 3 || !strings.Contains(out.String(), `"action":"secret_update"`) {
+		t.Fatalf("JSON output = %q", out.String())
+	}
+}
+
+func TestPrintAuditChainReport(t *testing.T) {
+	var out strings.Builder
+	printAuditChainReport(&out, &api.AuditChainReport{Valid: true, Entries: 3, FirstSequence: 4, LastSequence: 6, PrunedSequence: 3, KeyID: "k1"})
+	if !strings.Contains(out.String(), "sequence 4 to 6") || !strings.Contains(out.String(), "entries up to 3") || !strings.Contains(out.String(), "OK: the audit log is intact") {
+		t.Fatalf("output = %q", out.String())
+	}
+
+	out.Reset()
+	printAuditChainReport(&out, &api.AuditChainReport{
+		Entries:         4,
+		Problems:        []api.AuditChainProblem{{Sequence: 2, Kind: "gap", Detail: "entry 2 is missing"}},
+		ProblemsOmitted: 5,
+	})
+	for _, want := range []string{"Problems:", "gap", "entry 2 is missing", "and 5 more"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output = %q, missing %q", out.String(), want)
+		}
+	}
+	if strings.Contains(out.String(), "OK:") {
+		t.Fatalf("output = %q, reports OK for a broken chain", out.String())
+	}
+}
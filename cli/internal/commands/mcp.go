@@ -0,0 +1,429 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/envo/cli/internal/api"
+	"github.com/envo/cli/internal/mcp"
+	"github.com/spf13/cobra"
+)
+
+const mcpInstructions = `Envo brokers secrets for this workspace. You never see secret values: ` +
+	`list_available_secret_keys and check_access return key names only, and run_with_secrets ` +
+	`runs a command with the secrets in its environment and returns its output with the values redacted. ` +
+	`Reference secrets by environment variable name in commands instead of asking for them.`
+
+// Limits on run_with_secrets. Tests shorten them.
+var (
+	mcpMaxOutputBytes  = 64 << 10
+	mcpDefaultTimeout  = 10 * time.Minute
+	mcpMaxTimeout      = time.Hour
+	minRedactionLength = 4
+)
+
+func newMCPCmd(deps *rootDeps) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mcp",
+		Short: "Model Context Protocol server for coding agents",
+	}
+
+	var tools mcpTools
+	serve := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve secret-brokering tools to an MCP client over stdio (requires ENVO_TOKEN)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if deps.cfg.AgentToken == "" {
+				return fmt.Errorf("ENVO_TOKEN is not set")
+			}
+			tools.client = api.NewAgentClient(deps.cfg.APIBaseURL, deps.cfg.AgentToken)
+			tools.warn = cmd.ErrOrStderr()
+			server := mcp.NewServer("envo", Version, mcpInstructions, tools.list()...)
+			return server.Serve(cmd.Context(), cmd.InOrStdin(), cmd.OutOrStdout())
+		},
+	}
+	serve.Flags().StringVar(&tools.project, "project", "", "Default project when a tool call does not name one")
+	serve.Flags().StringVar(&tools.env, "env", "", "Default environment when a tool call does not name one")
+	serve.Flags().StringVar(&tools.dir, "dir", "", "Working directory for commands (default: current directory)")
+	serve.Flags().StringVar(&tools.purpose, "purpose", "mcp", "Audit purpose recorded with each secret request")
+	cmd.AddCommand(serve)
+	return cmd
+}
+
+// mcpTools are the tools served by `envo mcp serve`. Every secret value
+// stays inside this process: results carry key names, and command output
+// is redacted before it is returned.
+type mcpTools struct {
+	client  *api.Client
+	warn    io.Writer
+	project string
+	env     string
+	dir     string
+	purpose string
+}
+
+var mcpTargetSchema = map[string]any{
+	"project":     map[string]any{"type": "string", "description": "Project name or ID"},
+	"environment": map[string]any{"type": "string", "description": "Environment name or ID"},
+}
+
+func mcpSchema(required []string, extra map[string]any) map[string]any {
+	properties := map[string]any{}
+	for k, v := range mcpTargetSchema {
+		properties[k] = v
+	}
+	for k, v := range extra {
+		properties[k] = v
+	}
+	schema := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var mcpKeysSchema = map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Exact secret key names; omit for every key the agent may use"}
+
+func (t *mcpTools) list() []mcp.Tool {
+	return []mcp.Tool{
+		{
+			Name:        "list_available_secret_keys",
+			Description: "List the names of the secrets this agent may use in an environment. Values are never returned.",
+			InputSchema: mcpSchema(nil, nil),
+			Handler:     t.listKeys,
+		},
+		{
+			Name:        "check_access",
+			Description: "Check whether this agent may use the given secret keys in an environment, and which of them are set. Values are never returned.",
+			InputSchema: mcpSchema(nil, map[string]any{"keys": mcpKeysSchema}),
+			Handler:     t.checkAccess,
+		},
+		{
+			Name: "run_with_secrets",
+			Description: "Run a command with secrets injected as environment variables and return its exit code and output. " +
+				"Secret values in the output are replaced with [REDACTED:KEY]. The command runs without a shell; pass [\"sh\", \"-c\", \"...\"] for shell syntax.",
+			InputSchema: mcpSchema([]string{"command"}, map[string]any{
+				"command":         map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "minItems": 1, "description": "Program and arguments"},
+				"keys":            mcpKeysSchema,
+				"cwd":             map[string]any{"type": "string", "description": "Working directory, relative to the server's directory"},
+				"timeout_seconds": map[string]any{"type": "integer", "minimum": 1, "description": "Kill the command after this long (default 600, at most 3600)"},
+			}),
+			Handler: t.runWithSecrets,
+		},
+	}
+}
+
+type mcpTarget struct {
+	Project     string   `json:"project"`
+	Environment string   `json:"environment"`
+	Keys        []string `json:"keys"`
+}
+
+func (t *mcpTools) target(in mcpTarget) (mcpTarget, error) {
+	if in.Project == "" {
+		in.Project = t.project
+	}
+	if in.Environment == "" {
+		in.Environment = t.env
+	}
+	if in.Project == "" || in.Environment == "" {
+		return in, fmt.Errorf("project and environment are required (or start the server with --project and --env)")
+	}
+	return in, nil
+}
+
+// resolve asks the broker for secrets. Callers that only report key names
+// release the lease straight away.
+func (t *mcpTools) resolve(ctx context.Context, target mcpTarget) (*api.ResolveAgentSecretsResponse, error) {
+	return t.client.ResolveAgentSecrets(ctx, api.ResolveAgentSecretsRequest{
+		Project: target.Project, Environment: target.Environment, Keys: target.Keys, Purpose: t.purpose,
+		SessionID: fmt.Sprintf("envo-mcp-%d", os.Getpid()),
+	})
+}
+
+func decodeArguments(arguments json.RawMessage, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(arguments))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func sortedKeys(secrets map[string]string) []string {
+	keys := make([]string, 0, len(secrets))
+	for k := range secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func skippedKeys(skipped []api.SkippedSecret) []string {
+	keys := make([]string, 0, len(skipped))
+	for _, s := range skipped {
+		keys = append(keys, s.Key)
+	}
+	return keys
+}
+
+// listKeys and checkAccess use the access introspection endpoint, so
+// nothing is decrypted and no lease or database role is created.
+func (t *mcpTools) listKeys(ctx context.Context, arguments json.RawMessage) (*mcp.ToolResult, error) {
+	var in mcpTarget
+	if err := decodeArguments(arguments, &in); err != nil {
+		return nil, err
+	}
+	target, err := t.target(in)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := t.client.GetAgentKeys(ctx, target.Project, target.Environment, nil)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.JSONResult(map[string]any{
+		"project":      keys.Project,
+		"environment":  keys.Environment,
+		"keys":         keys.Keys,
+		"dynamic_keys": keys.DynamicKeys,
+		"expired_keys": keys.ExpiredKeys,
+		"denied_keys":  keys.DeniedKeys,
+	})
+}
+
+func (t *mcpTools) checkAccess(ctx context.Context, arguments json.RawMessage) (*mcp.ToolResult, error) {
+	var in mcpTarget
+	if err := decodeArguments(arguments, &in); err != nil {
+		return nil, err
+	}
+	target, err := t.target(in)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := t.client.GetAgentKeys(ctx, target.Project, target.Environment, target.Keys)
+	if errors.Is(err, api.ErrAgentForbidden) {
+		return mcp.JSONResult(map[string]any{
+			"allowed": false,
+			"reason":  "this agent has no live grant covering every requested key; ask a human to grant access or file an access request with `envo run --request-access`",
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A resolve naming a key an access policy denies is refused outright.
+	denied := map[string]bool{}
+	for _, key := range keys.DeniedKeys {
+		denied[key] = true
+	}
+	requestedDenied := []string{}
+	for _, key := range target.Keys {
+		if denied[key] {
+			requestedDenied = append(requestedDenied, key)
+		}
+	}
+	if len(requestedDenied) > 0 {
+		return mcp.JSONResult(map[string]any{
+			"allowed":     false,
+			"reason":      "an access policy denies these keys to this agent; ask a human to review the org's access policies",
+			"denied_keys": requestedDenied,
+		})
+	}
+	available := map[string]bool{}
+	for _, key := range append(keys.Keys, keys.DynamicKeys...) {
+		available[key] = true
+	}
+	notSet := []string{}
+	for _, key := range target.Keys {
+		if !available[key] {
+			notSet = append(notSet, key)
+		}
+	}
+	return mcp.JSONResult(map[string]any{
+		"allowed":      true,
+		"available":    keys.Keys,
+		"dynamic_keys": keys.DynamicKeys,
+		"not_set":      notSet,
+		"expired_keys": keys.ExpiredKeys,
+		"denied_keys":  keys.DeniedKeys,
+	})
+}
+
+type mcpRunArguments struct {
+	mcpTarget
+	Command        []string `json:"command"`
+	Cwd            string   `json:"cwd"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+}
+
+func (t *mcpTools) runWithSecrets(ctx context.Context, arguments json.RawMessage) (*mcp.ToolResult, error) {
+	var in mcpRunArguments
+	if err := decodeArguments(arguments, &in); err != nil {
+		return nil, err
+	}
+	if len(in.Command) == 0 || in.Command[0] == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	timeout := mcpDefaultTimeout
+	if in.TimeoutSeconds > 0 {
+		timeout = min(time.Duration(in.TimeoutSeconds)*time.Second, mcpMaxTimeout)
+	}
+	target, err := t.target(in.mcpTarget)
+	if err != nil {
+		return nil, err
+	}
+	dir := t.dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	if in.Cwd != "" {
+		if filepath.IsAbs(in.Cwd) {
+			dir = in.Cwd
+		} else {
+			dir = filepath.Join(dir, in.Cwd)
+		}
+	}
+
+	resolved, err := t.resolve(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	release := holdLease(ctx, t.client, resolved, t.warn)
+	defer release()
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	child := exec.CommandContext(runCtx, in.Command[0], in.Command[1:]...)
+	child.Dir = dir
+	// Stdin and stdout of this process carry the protocol, so the child
+	// gets neither.
+	child.Stdin = nil
+	stdout := &cappedBuffer{max: mcpMaxOutputBytes}
+	stderr := &cappedBuffer{max: mcpMaxOutputBytes}
+	child.Stdout, child.Stderr = stdout, stderr
+	child.Env = withoutEnvKey(os.Environ(), "ENVO_TOKEN")
+	for k, v := range resolved.Secrets {
+		child.Env = append(child.Env, k+"="+v)
+	}
+
+	runErr := child.Run()
+	exitCode := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(runErr, &exitErr):
+		exitCode = exitErr.ExitCode()
+	case runErr != nil:
+		return nil, fmt.Errorf("failed to start %s: %w", in.Command[0], runErr)
+	}
+
+	redactor := newSecretRedactor(resolved.Secrets)
+	result := map[string]any{
+		"exit_code":     exitCode,
+		"stdout":        redactor.redactOutput(stdout),
+		"stderr":        redactor.redactOutput(stderr),
+		"injected_keys": sortedKeys(resolved.Secrets),
+	}
+	if stdout.truncated || stderr.truncated {
+		result["truncated"] = true
+	}
+	if runCtx.Err() == context.DeadlineExceeded {
+		result["timed_out"] = true
+	}
+	if skipped := skippedKeys(resolved.Skipped); len(skipped) > 0 {
+		result["skipped_keys"] = skipped
+	}
+	return mcp.JSONResult(result)
+}
+
+// cappedBuffer keeps the first max bytes written to it.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// secretRedactor replaces secret values, and their URL-escaped and base64
+// forms, with the key name. Values shorter than minRedactionLength are left
+// alone: redacting "1" or "on" would garble output without protecting much.
+type secretRedactor struct {
+	replacer *strings.Replacer
+	forms    []string
+}
+
+func newSecretRedactor(secrets map[string]string) *secretRedactor {
+	type pair struct{ form, key string }
+	var pairs []pair
+	seen := map[string]bool{}
+	for key, value := range secrets {
+		if len(value) < minRedactionLength {
+			continue
+		}
+		for _, form := range []string{value, url.QueryEscape(value), base64.StdEncoding.EncodeToString([]byte(value))} {
+			if !seen[form] {
+				seen[form] = true
+				pairs = append(pairs, pair{form, key})
+			}
+		}
+	}
+	// Longer forms first, so a value containing another is replaced whole.
+	sort.Slice(pairs, func(i, j int) bool {
+		if len(pairs[i].form) != len(pairs[j].form) {
+			return len(pairs[i].form) > len(pairs[j].form)
+		}
+		return pairs[i].form < pairs[j].form
+	})
+	r := &secretRedactor{}
+	args := make([]string, 0, 2*len(pairs))
+	for _, p := range pairs {
+		args = append(args, p.form, "[REDACTED:"+p.key+"]")
+		r.forms = append(r.forms, p.form)
+	}
+	r.replacer = strings.NewReplacer(args...)
+	return r
+}
+
+func (r *secretRedactor) redact(s string) string {
+	return r.replacer.Replace(s)
+}
+
+// redactOutput redacts captured output. When the capture was cut off, a
+// value may have been cut with it, so a trailing prefix of any value is
+// dropped as well.
+func (r *secretRedactor) redactOutput(b *cappedBuffer) string {
+	out := b.buf.String()
+	if b.truncated {
+		for _, form := range r.forms {
+			for n := len(form) - 1; n >= minRedactionLength; n-- {
+				if strings.HasSuffix(out, form[:n]) {
+					out = out[:len(out)-n]
+					break
+				}
+			}
+		}
+	}
+	return r.redact(out)
+}
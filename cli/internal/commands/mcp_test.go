@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/envo/cli/internal/api"
+)
+
+func TestSecretRedactorReplacesValuesAndEncodings(t *testing.T) {
+	r := newSecretRedactor(map[string]string{"API_KEY": "s3cr3t+value", "PORT": "80", "URL": "https://u:s3cr3t+value@db"})
+	in := "key=s3cr3t+value escaped=s3cr3t%2Bvalue b64=czNjcjN0K3ZhbHVl url=https://u:s3cr3t+value@db port=80"
+	got := r.redact(in)
+	want := "key=[REDACTED:API_KEY] escaped=[REDACTED:API_KEY] b64=[REDACTED:API_KEY] url=[REDACTED:URL] port=80"
+	if got != want {
+		t.Fatalf("redact() = %q, want %q", got, want)
+	}
+}
+
+func TestRedactOutputDropsValueCutOffByTruncation(t *testing.T) {
+	r := newSecretRedactor(map[string]string{"TOKEN": "abcdefghij"})
+	b := &cappedBuffer{max: 12}
+	_, _ = b.Write([]byte("ok abcdefghij and more"))
+	if !b.truncated || b.buf.String() != "ok abcdefghi" {
+		t.Fatalf("buffer = %q, truncated = %v", b.buf.String(), b.truncated)
+	}
+	if got := r.redactOutput(b); got != "ok " {
+		t.Fatalf("redactOutput() = %q", got)
+	}
+}
+
+// brokerServer answers resolve and key listing with secrets and records
+// every call.
+func brokerServer(t *testing.T, secrets map[string]string, status int) (*api.Client, *[]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/agent/secrets/resolve":
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"error":"Agent is not authorized"}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"lease_id": "l1", "expires_at": "2099-01-01T00:00:00Z", "secrets": secrets})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/agent/access/keys":
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"error":"Agent is not authorized"}`))
+				return
+			}
+			keys := []string{}
+			for k := range secrets {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			_ = json.NewEncoder(w).Encode(map[string]any{"project": r.URL.Query().Get("project"), "environment": r.URL.Query().Get("environment"), "keys": keys})
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/agent/leases/l1":
+			_, _ = w.Write([]byte(`{"lease_id":"l1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return api.NewAgentClient(server.URL, "envo_agent_test"), &calls
+}
+
+func TestMCPRunWithSecretsRedactsOutputAndReleasesLease(t *testing.T) {
+	client, calls := brokerServer(t, map[string]string{"API_KEY": "sk_live_12345"}, http.StatusOK)
+	tools := &mcpTools{client: client, warn: &strings.Builder{}, project: "api", env: "development"}
+	result, err := tools.runWithSecrets(context.Background(), json.RawMessage(`{"command":["sh","-c","echo key=$API_KEY; echo token=${ENVO_TOKEN:-unset} >&2; exit 3"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := result.StructuredContent.(map[string]any)
+	if out["stdout"] != "key=[REDACTED:API_KEY]\n" || out["stderr"] != "token=unset\n" || out["exit_code"] != 3 {
+		t.Fatalf("result = %v", out)
+	}
+	if strings.Contains(result.Content[0].Text, "sk_live_12345") {
+		t.Fatalf("result text leaks the value: %s", result.Content[0].Text)
+	}
+	if got := *calls; len(got) != 2 || got[1] != "DELETE /api/v1/agent/leases/l1" {
+		t.Fatalf("calls = %v", got)
+	}
+}
+
+func TestMCPListKeysReturnsNamesOnly(t *testing.T) {
+	client, calls := brokerServer(t, map[string]string{"B_KEY": "value-b", "A_KEY": "value-a"}, http.StatusOK)
+	tools := &mcpTools{client: client, warn: &strings.Builder{}}
+	result, err := tools.listKeys(context.Background(), json.RawMessage(`{"project":"api","environment":"staging"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := result.Content[0].Text; strings.Contains(text, "value-") || !strings.Contains(text, `"A_KEY",`) {
+		t.Fatalf("result = %s", text)
+	}
+	if got := *calls; len(got) != 1 || !strings.HasPrefix(got[0], "GET /api/v1/agent/access/keys") {
+		t.Fatalf("calls = %v, want only the key listing", got)
+	}
+}
+
+func TestMCPCheckAccessReportsMissingGrant(t *testing.T) {
+	client, _ := brokerServer(t, nil, http.StatusForbidden)
+	tools := &mcpTools{client: client, warn: &strings.Builder{}, project: "api", env: "production"}
+	result, err := tools.checkAccess(context.Background(), json.RawMessage(`{"keys":["DATABASE_URL"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StructuredContent.(map[string]any)["allowed"] != false {
+		t.Fatalf("result = %v", result.StructuredContent)
+	}
+}
+
+func TestMCPToolsRequireTarget(t *testing.T) {
+	tools := &mcpTools{}
+	if _, err := tools.listKeys(context.Background(), json.RawMessage(`{}`)); err == nil {
+		t.Fatal("expected an error without project and environment")
+	}
+	if _, err := tools.listKeys(context.Background(), json.RawMessage(`{"project":"api","environment":"dev","value":true}`)); err == nil {
+		t.Fatal("expected an error for unknown arguments")
+	}
+}
+
+func TestMCPCheckAccessReportsKeysNotSet(t *testing.T) {
+	client, _ := brokerServer(t, map[string]string{"API_KEY": "value"}, http.StatusOK)
+	tools := &mcpTools{client: client, warn: &strings.Builder{}, project: "api", env: "production"}
+	result, err := tools.checkAccess(context.Background(), json.RawMessage(`{"keys":["API_KEY","NEW_KEY"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := result.StructuredContent.(map[string]any)
+	if out["allowed"] != true || len(out["not_set"].([]string)) != 1 || out["not_set"].([]string)[0] != "NEW_KEY" {
+		t.Fatalf("result = %v", out)
+	}
+}
+
+func TestMCPCheckAccessReportsPolicyDeniedKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"project":"api","environment":"production","keys":[],"denied_keys":["PROD_DB_URL"]}`))
+	}))
+	t.Cleanup(server.Close)
+	tools := &mcpTools{client: api.NewAgentClient(server.URL, "envo_agent_test"), warn: &strings.Builder{}, project: "api", env: "production"}
+	result, err := tools.checkAccess(context.Background(), json.RawMessage(`{"keys":["PROD_DB_URL"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := result.StructuredContent.(map[string]any)
+	if out["allowed"] != false || len(out["denied_keys"].([]string)) != 1 {
+		t.Fatalf("result = %v", out)
+	}
+}
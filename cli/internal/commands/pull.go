@@ -3,6 +3,7 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +21,7 @@ func newPullCmd(deps *rootDeps) *cobra.Command {
 		projectSel string
 		envSel     string
 		outDir     string
+		strict     bool
 	)
 
 	cmd := &cobra.Command{
@@ -56,10 +58,13 @@ func newPullCmd(deps *rootDeps) *cobra.Command {
 				return err
 			}
 
-			secrets, err := client.ExportEnvironmentSecrets(ctx, envID)
+			secrets, skipped, err := client.ExportEnvironmentSecrets(ctx, envID)
 			if err != nil {
 				return err
 			}
+			if err := reportSkippedSecrets(os.Stderr, skipped, strict); err != nil {
+				return err
+			}
 
 			if outDir == "" {
 				// Prefer ENVO_CALLER_DIR (set by wrapper scripts that cd into cli/)
@@ -90,12 +95,51 @@ func newPullCmd(deps *rootDeps) *cobra.Command {
 	cmd.Flags().StringVar(&projectSel, "project", "", "Project id or name (required)")
 	cmd.Flags().StringVar(&envSel, "env", "", "Environment id or name (required)")
 	cmd.Flags().StringVar(&outDir, "dir", "", "Directory to write .env into (default: current directory)")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail instead of writing a partial .env when any secret cannot be decrypted")
 	_ = cmd.MarkFlagRequired("project")
 	_ = cmd.MarkFlagRequired("env")
 
 	return cmd
 }
 
+// reportSkippedSecrets warns about keys the server left out because they
+// could not be decrypted, have expired, or were withheld by an access policy.
+// In strict mode a partial result is an error so CI never runs with missing
+// keys.
+func reportSkippedSecrets(w io.Writer, skipped []api.SkippedSecret, strict bool) error {
+	if len(skipped) == 0 {
+		return nil
+	}
+	var undecryptable, expired, withheld []string
+	for _, s := range skipped {
+		switch s.Reason {
+		case "expired":
+			expired = append(expired, s.Key)
+		case "policy_denied":
+			withheld = append(withheld, s.Key)
+		default:
+			undecryptable = append(undecryptable, s.Key)
+		}
+	}
+	var problems []string
+	if len(undecryptable) > 0 {
+		problems = append(problems, fmt.Sprintf("%d secrets could not be decrypted: %s", len(undecryptable), strings.Join(undecryptable, ", ")))
+	}
+	if len(expired) > 0 {
+		problems = append(problems, fmt.Sprintf("%d secrets have expired: %s", len(expired), strings.Join(expired, ", ")))
+	}
+	if len(withheld) > 0 {
+		problems = append(problems, fmt.Sprintf("%d secrets were withheld by an access policy: %s", len(withheld), strings.Join(withheld, ", ")))
+	}
+	if strict {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(w, "envo: warning: %s (skipped)\n", problem)
+	}
+	return nil
+}
+
 func resolveOrgID(ctx context.Context, c *api.Client, sel string) (string, error) {
 	sel = strings.TrimSpace(sel)
 	orgs, err := c.ListOrgs(ctx)
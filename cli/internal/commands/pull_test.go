@@ -69,3 +69,45 @@ func TestResolveOrgIDExplainsMissingPersonalWorkspace(t *testing.T) {
 		t.Fatalf("resolveOrgID error = %v, want missing personal workspace error", err)
 	}
 }
+
+func TestReportSkippedSecretsWarnsOrFailsInStrictMode(t *testing.T) {
+	skipped := []api.SkippedSecret{{Key: "DATABASE_URL", Reason: "decrypt_failed"}, {Key: "STRIPE_KEY", Reason: "decrypt_failed"}}
+
+	var warning strings.Builder
+	if err := reportSkippedSecrets(&warning, skipped, false); err != nil {
+		t.Fatalf("non-strict reportSkippedSecrets returned %v", err)
+	}
+	if !strings.Contains(warning.String(), "DATABASE_URL, STRIPE_KEY") {
+		t.Fatalf("warning = %q, want skipped key names", warning.String())
+	}
+
+	err := reportSkippedSecrets(&strings.Builder{}, skipped, true)
+	if err == nil || !strings.Contains(err.Error(), "2 secrets could not be decrypted") {
+		t.Fatalf("strict reportSkippedSecrets error = %v", err)
+	}
+
+	if err := reportSkippedSecrets(&strings.Builder{}, nil, true); err != nil {
+		t.Fatalf("strict mode with nothing skipped returned %v", err)
+	}
+}
+
+func TestReportSkippedSecretsSeparatesExpiredKeys(t *testing.T) {
+	skipped := []api.SkippedSecret{{Key: "OLD_TOKEN", Reason: "expired"}, {Key: "DATABASE_URL", Reason: "decrypt_failed"}}
+
+	var warning strings.Builder
+	if err := reportSkippedSecrets(&warning, skipped, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warning.String(), "1 secrets have expired: OLD_TOKEN") || !strings.Contains(warning.String(), "1 secrets could not be decrypted: DATABASE_URL") {
+		t.Fatalf("warning = %q", warning.String())
+	}
+}
+
+func TestReportSkippedSecretsNamesPolicyWithheldKeys(t *testing.T) {
+	skipped := []api.SkippedSecret{{Key: "DATABASE_URL", Reason: "policy_denied"}}
+
+	err := reportSkippedSecrets(&strings.Builder{}, skipped, true)
+	if err == nil || !strings.Contains(err.Error(), "1 secrets were withheld by an access policy: DATABASE_URL") {
+		t.Fatalf("strict reportSkippedSecrets error = %v", err)
+	}
+}
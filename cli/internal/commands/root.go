@@ -49,7 +49,11 @@ func newRootCmd() (*cobra.Command, *rootDeps) {
 	cmd.AddCommand(newPullCmd(deps))
 	cmd.AddCommand(newRunCmd(deps))
 	cmd.AddCommand(newSyncCmd(deps))
+	cmd.AddCommand(newValidateCmd(deps))
 	cmd.AddCommand(newAgentCmd(deps))
+	cmd.AddCommand(newAccessRequestsCmd(deps))
+	cmd.AddCommand(newAuditCmd(deps))
+	cmd.AddCommand(newMCPCmd(deps))
 
 	return cmd, deps
 }
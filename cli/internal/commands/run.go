@@ -2,7 +2,9 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -21,6 +23,11 @@ func newRunCmd(deps *rootDeps) *cobra.Command {
 		dir        string
 		keys       []string
 		purpose    string
+		strict     bool
+
+		requestAccess  bool
+		accessDuration time.Duration
+		accessWait     time.Duration
 	)
 
 	cmd := &cobra.Command{
@@ -43,16 +50,39 @@ func newRunCmd(deps *rootDeps) *cobra.Command {
 			defer cancel()
 
 			var secrets map[string]string
+			var lease *api.ResolveAgentSecretsResponse
+			agentClient := api.NewAgentClient(deps.cfg.APIBaseURL, deps.cfg.AgentToken)
 			if agentMode {
-				client := api.NewAgentClient(deps.cfg.APIBaseURL, deps.cfg.AgentToken)
-				resolved, err := client.ResolveAgentSecrets(ctx, api.ResolveAgentSecretsRequest{
+				resolveReq := api.ResolveAgentSecretsRequest{
 					Project: projectSel, Environment: envSel, Keys: keys, Purpose: purpose,
 					SessionID: fmt.Sprintf("envo-run-%d", os.Getpid()),
-				})
+				}
+				resolved, err := agentClient.ResolveAgentSecrets(ctx, resolveReq)
+				if errors.Is(err, api.ErrAgentForbidden) {
+					if !requestAccess {
+						return fmt.Errorf("%w\nrerun with --request-access to ask for approval", err)
+					}
+					waitCtx, cancelWait := context.WithTimeout(cmd.Context(), accessWait)
+					err = waitForAccessApproval(waitCtx, agentClient, api.CreateAccessRequestReq{
+						Project: projectSel, Environment: envSel, Keys: keys, AllSecrets: len(keys) == 0,
+						Purpose: purpose, DurationSeconds: int(accessDuration / time.Second),
+					}, os.Stderr)
+					cancelWait()
+					if err != nil {
+						return err
+					}
+					retryCtx, cancelRetry := context.WithTimeout(cmd.Context(), 90*time.Second)
+					defer cancelRetry()
+					resolved, err = agentClient.ResolveAgentSecrets(retryCtx, resolveReq)
+				}
 				if err != nil {
 					return err
 				}
 				secrets = resolved.Secrets
+				lease = resolved
+				if err := reportSkippedSecrets(os.Stderr, resolved.Skipped, strict); err != nil {
+					return err
+				}
 			} else {
 				client := api.NewClient(deps.cfg.APIBaseURL, deps.tokens)
 				t, err := client.EnsureAccessToken(ctx)
@@ -74,10 +104,14 @@ func newRunCmd(deps *rootDeps) *cobra.Command {
 					return err
 				}
 
-				secrets, err = client.ExportEnvironmentSecrets(ctx, envID)
+				var skipped []api.SkippedSecret
+				secrets, skipped, err = client.ExportEnvironmentSecrets(ctx, envID)
 				if err != nil {
 					return err
 				}
+				if err := reportSkippedSecrets(os.Stderr, skipped, strict); err != nil {
+					return err
+				}
 			}
 
 			// Inject secrets directly into the child process env — never write to disk
@@ -97,7 +131,15 @@ func newRunCmd(deps *rootDeps) *cobra.Command {
 			}
 
 			fmt.Fprintf(os.Stderr, "envo: injecting %d secrets into %s\n", len(secrets), args[0])
-			return child.Run()
+			if lease == nil || lease.LeaseID == "" {
+				return child.Run()
+			}
+
+			// Hold the lease only while the child runs.
+			release := holdLease(cmd.Context(), agentClient, lease, os.Stderr)
+			runErr := child.Run()
+			release()
+			return runErr
 		},
 	}
 
@@ -106,13 +148,122 @@ func newRunCmd(deps *rootDeps) *cobra.Command {
 	cmd.Flags().StringVar(&envSel, "env", "", "Environment id or name (required)")
 	cmd.Flags().StringVar(&dir, "dir", "", "Working directory (default: current directory)")
 	cmd.Flags().StringSliceVar(&keys, "keys", nil, "Only request these secret keys (agent tokens only)")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail instead of starting the command when any secret cannot be decrypted")
 	cmd.Flags().StringVar(&purpose, "purpose", "coding-agent", "Audit purpose for this secret request (agent tokens only)")
+	cmd.Flags().BoolVar(&requestAccess, "request-access", false, "When the agent has no grant, ask a human for access and wait for approval (agent tokens only)")
+	cmd.Flags().DurationVar(&accessDuration, "access-duration", time.Hour, "How long to ask for with --request-access")
+	cmd.Flags().DurationVar(&accessWait, "access-wait", 15*time.Minute, "How long to wait for a decision with --request-access")
 	_ = cmd.MarkFlagRequired("project")
 	_ = cmd.MarkFlagRequired("env")
 
 	return cmd
 }
 
+// accessRequestPollInterval is how often a pending access request is
+// checked. Tests shorten it.
+var accessRequestPollInterval = 5 * time.Second
+
+// waitForAccessApproval files an access request and polls it until a human
+// decides or ctx ends. Only approval returns nil.
+func waitForAccessApproval(ctx context.Context, client *api.Client, req api.CreateAccessRequestReq, progress io.Writer) error {
+	request, err := client.CreateAgentAccessRequest(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to request access: %w", err)
+	}
+	fmt.Fprintf(progress, "envo: access request %s sent; waiting for approval\n", request.ID)
+	for {
+		switch request.Status {
+		case "approved":
+			fmt.Fprintln(progress, "envo: access approved")
+			return nil
+		case "denied":
+			if request.DecisionNote != "" {
+				return fmt.Errorf("access request denied: %s", request.DecisionNote)
+			}
+			return fmt.Errorf("access request denied")
+		case "expired":
+			return fmt.Errorf("access request expired without a decision")
+		}
+		timer := time.NewTimer(accessRequestPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("gave up waiting for access request %s: %w", request.ID, ctx.Err())
+		case <-timer.C:
+		}
+		request, err = client.GetAgentAccessRequest(ctx, request.ID)
+		if err != nil {
+			return fmt.Errorf("failed to check access request: %w", err)
+		}
+	}
+}
+
+// holdLease renews lease until the returned function is called, which then
+// releases it so reviewers see it end.
+func holdLease(ctx context.Context, client *api.Client, lease *api.ResolveAgentSecretsResponse, warn io.Writer) func() {
+	leaseCtx, stopRenewing := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		keepLeaseAlive(leaseCtx, lease.ExpiresAt, warn, func(ctx context.Context) (time.Time, error) {
+			r, err := client.RenewAgentLease(ctx, lease.LeaseID)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return r.ExpiresAt, nil
+		})
+	}()
+	return func() {
+		stopRenewing()
+		<-renewed
+		releaseLease(client, lease, warn)
+	}
+}
+
+// releaseLease ends a lease, even when the command's context is done.
+func releaseLease(client *api.Client, lease *api.ResolveAgentSecretsResponse, warn io.Writer) {
+	if lease.LeaseID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.ReleaseAgentLease(ctx, lease.LeaseID); err != nil {
+		fmt.Fprintf(warn, "envo: failed to release lease %s: %v\n", lease.LeaseID, err)
+	}
+}
+
+// minLeaseRenewWait keeps a lease that is about to expire from being
+// renewed in a tight loop. Tests shorten it.
+var minLeaseRenewWait = 5 * time.Second
+
+// keepLeaseAlive renews a lease halfway to each expiry until ctx is done. A
+// failed renewal is reported once and ends the loop; the lease then lapses.
+func keepLeaseAlive(ctx context.Context, expiresAt time.Time, warn io.Writer, renew func(context.Context) (time.Time, error)) {
+	for {
+		wait := time.Until(expiresAt) / 2
+		if wait < minLeaseRenewWait {
+			wait = minLeaseRenewWait
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		renewCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		next, err := renew(renewCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(warn, "envo: lease renewal failed, the lease will lapse at %s: %v\n", expiresAt.Local().Format(time.Kitchen), err)
+			}
+			return
+		}
+		expiresAt = next
+	}
+}
+
 func withoutEnvKey(environment []string, key string) []string {
 	prefix := key + "="
 	filtered := make([]string, 0, len(environment))
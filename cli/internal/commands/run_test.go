@@ -1,6 +1,18 @@
 package commands
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envo/cli/internal/api"
+)
 
 func TestWithoutEnvKeyRemovesOnlyExactVariable(t *testing.T) {
 	got := withoutEnvKey([]string{"PATH=/bin", "ENVO_TOKEN=secret", "ENVO_TOKEN_BACKUP=keep"}, "ENVO_TOKEN")
@@ -8,3 +20,127 @@ func TestWithoutEnvKeyRemovesOnlyExactVariable(t *testing.T) {
 		t.Fatalf("withoutEnvKey() = %v", got)
 	}
 }
+
+func shortenLeaseRenewWait(t *testing.T) {
+	previous := minLeaseRenewWait
+	minLeaseRenewWait = 10 * time.Millisecond
+	t.Cleanup(func() { minLeaseRenewWait = previous })
+}
+
+func TestKeepLeaseAliveRenewsUntilCancelled(t *testing.T) {
+	shortenLeaseRenewWait(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	var warn bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// An already-expired lease waits the minimum before each renewal.
+		keepLeaseAlive(ctx, time.Now(), &warn, func(context.Context) (time.Time, error) {
+			calls++
+			if calls == 2 {
+				cancel()
+				return time.Time{}, context.Canceled
+			}
+			return time.Now(), nil
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepLeaseAlive did not stop after cancellation")
+	}
+	if calls != 2 || warn.Len() != 0 {
+		t.Fatalf("calls = %d, warning = %q", calls, warn.String())
+	}
+}
+
+func TestKeepLeaseAliveWarnsOnceOnFailure(t *testing.T) {
+	shortenLeaseRenewWait(t)
+	var warn bytes.Buffer
+	keepLeaseAlive(context.Background(), time.Now(), &warn, func(context.Context) (time.Time, error) {
+		return time.Time{}, errors.New("grant revoked")
+	})
+	if !strings.Contains(warn.String(), "grant revoked") {
+		t.Fatalf("warning = %q", warn.String())
+	}
+}
+
+func accessRequestServer(t *testing.T, statuses ...string) (*api.Client, *api.CreateAccessRequestReq) {
+	t.Helper()
+	previous := accessRequestPollInterval
+	accessRequestPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { accessRequestPollInterval = previous })
+
+	var created api.CreateAccessRequestReq
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/agent/access-requests":
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id":"req-1","status":"pending"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/agent/access-requests/req-1":
+			status := statuses[len(statuses)-1]
+			if polls < len(statuses) {
+				status = statuses[polls]
+			}
+			polls++
+			_ = json.NewEncoder(w).Encode(map[string]string{"id": "req-1", "status": status, "decision_note": "not today"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return api.NewAgentClient(server.URL, "envo_agent_test"), &created
+}
+
+func TestWaitForAccessApprovalPollsUntilApproved(t *testing.T) {
+	client, created := accessRequestServer(t, "pending", "approved")
+	var progress bytes.Buffer
+	err := waitForAccessApproval(context.Background(), client, api.CreateAccessRequestReq{
+		Project: "api", Environment: "production", Keys: []string{"DATABASE_URL"}, Purpose: "migrate", DurationSeconds: 1800,
+	}, &progress)
+	if err != nil {
+		t.Fatalf("waitForAccessApproval() error = %v", err)
+	}
+	if created.Project != "api" || created.DurationSeconds != 1800 || len(created.Keys) != 1 {
+		t.Fatalf("created request = %+v", created)
+	}
+	if !strings.Contains(progress.String(), "req-1") || !strings.Contains(progress.String(), "approved") {
+		t.Fatalf("progress = %q", progress.String())
+	}
+}
+
+func TestWaitForAccessApprovalReportsDenial(t *testing.T) {
+	client, _ := accessRequestServer(t, "denied")
+	err := waitForAccessApproval(context.Background(), client, api.CreateAccessRequestReq{Project: "api", Environment: "production", AllSecrets: true, Purpose: "debug"}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "not today") {
+		t.Fatalf("error = %v, want denial with note", err)
+	}
+}
+
+func TestWaitForAccessApprovalGivesUp(t *testing.T) {
+	client, _ := accessRequestServer(t, "pending")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := waitForAccessApproval(ctx, client, api.CreateAccessRequestReq{Project: "api", Environment: "production", AllSecrets: true, Purpose: "debug"}, &bytes.Buffer{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestPrintAccessRequests(t *testing.T) {
+	var out bytes.Buffer
+	printAccessRequests(&out, []api.AccessRequest{{
+		ID: "req-1", Status: "pending", Keys: []string{"DATABASE_URL"}, Purpose: "migrate", DurationSeconds: 3600,
+		Agent:       &api.AgentIdentity{Name: "deploy-bot"},
+		Environment: &api.Environment{Name: "production", Project: &api.Project{Name: "api"}},
+	}})
+	want := "req-1  pending   deploy-bot -> api/production [DATABASE_URL] for 1h0m0s: migrate\n"
+	if out.String() != want {
+		t.Fatalf("printAccessRequests() = %q, want %q", out.String(), want)
+	}
+}
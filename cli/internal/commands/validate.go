@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/envo/cli/internal/api"
+	"github.com/envo/cli/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func newValidateCmd(deps *rootDeps) *cobra.Command {
+	var (
+		orgSel     string
+		projectSel string
+		envSel     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check secrets against the project schema (exits non-zero on violations)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if deps.tokens == nil {
+				return fmt.Errorf("not logged in; run `envo login`")
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), 60*time.Second)
+			defer cancel()
+
+			client := api.NewClient(deps.cfg.APIBaseURL, deps.tokens)
+			t, err := client.EnsureAccessToken(ctx)
+			if err != nil {
+				return err
+			}
+			_ = store.SaveTokens(*t)
+
+			orgID, err := resolveOrgID(ctx, client, orgSel)
+			if err != nil {
+				return err
+			}
+
+			projectID, err := resolveProjectID(ctx, client, orgID, projectSel)
+			if err != nil {
+				return err
+			}
+
+			envID := ""
+			if strings.TrimSpace(envSel) != "" {
+				envID, err = resolveEnvID(ctx, client, projectID, envSel)
+				if err != nil {
+					return err
+				}
+			}
+
+			report, err := client.ValidateProjectSecrets(ctx, projectID, envID)
+			if err != nil {
+				return err
+			}
+			return printSchemaReport(os.Stdout, report)
+		},
+	}
+
+	cmd.Flags().StringVar(&orgSel, "org", "", "Organization id or name (default: personal vault)")
+	cmd.Flags().StringVar(&projectSel, "project", "", "Project id or name (required)")
+	cmd.Flags().StringVar(&envSel, "env", "", "Environment id or name (default: all environments)")
+	_ = cmd.MarkFlagRequired("project")
+
+	return cmd
+}
+
+// printSchemaReport writes one block per environment and returns an error
+// naming the failing environments so the process exits non-zero.
+func printSchemaReport(w io.Writer, report *api.SchemaReport) error {
+	var failed []string
+	for _, env := range report.Environments {
+		if env.Valid {
+			fmt.Fprintf(w, "ok    %s\n", env.EnvironmentName)
+			continue
+		}
+		failed = append(failed, env.EnvironmentName)
+		fmt.Fprintf(w, "FAIL  %s\n", env.EnvironmentName)
+		for _, key := range env.Missing {
+			fmt.Fprintf(w, "      %s: required but not set\n", key)
+		}
+		for _, v := range env.Invalid {
+			fmt.Fprintf(w, "      %s: %s\n", v.Key, v.Message)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("schema validation failed for %d environment(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/envo/cli/internal/api"
+)
+
+func TestPrintSchemaReportFailsOnInvalidEnvironments(t *testing.T) {
+	report := &api.SchemaReport{Environments: []api.SchemaEnvironmentReport{
+		{EnvironmentName: "development", Valid: true},
+		{
+			EnvironmentName: "production",
+			Missing:         []string{"DATABASE_URL"},
+			Invalid:         []api.SchemaViolation{{Key: "PORT", Rule: "type", Message: "must be an integer"}},
+		},
+	}}
+
+	var out strings.Builder
+	err := printSchemaReport(&out, report)
+	if err == nil || !strings.Contains(err.Error(), "1 environment(s): production") {
+		t.Fatalf("printSchemaReport error = %v", err)
+	}
+	for _, want := range []string{"ok    development", "FAIL  production", "DATABASE_URL: required but not set", "PORT: must be an integer"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output %q missing %q", out.String(), want)
+		}
+	}
+
+	if err := printSchemaReport(&strings.Builder{}, &api.SchemaReport{Valid: true}); err != nil {
+		t.Fatalf("valid report returned %v", err)
+	}
+}
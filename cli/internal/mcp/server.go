@@ -0,0 +1,248 @@
+// Package mcp is a minimal Model Context Protocol server for the stdio
+// transport: newline-delimited JSON-RPC 2.0 messages on stdin and stdout.
+// It implements the lifecycle handshake, ping, cancellation and tools; the
+// caller registers the tools.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// LatestProtocolVersion is offered to clients that ask for a version this
+// server does not know.
+const LatestProtocolVersion = "2025-06-18"
+
+var supportedProtocolVersions = []string{"2024-11-05", "2025-03-26", LatestProtocolVersion}
+
+// maxMessageBytes bounds one incoming message.
+const maxMessageBytes = 4 << 20
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// Tool is one callable tool. InputSchema is a JSON Schema object describing
+// the arguments.
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+
+	// Handler runs the tool. An error is reported to the model as a failed
+	// tool call rather than a protocol error, so it should be readable.
+	Handler func(ctx context.Context, arguments json.RawMessage) (*ToolResult, error) `json:"-"`
+}
+
+// ToolResult is what a tool call returns to the model.
+type ToolResult struct {
+	Content           []Content `json:"content"`
+	StructuredContent any       `json:"structuredContent,omitempty"`
+	IsError           bool      `json:"isError,omitempty"`
+}
+
+// Content is one block of a tool result. Only text is produced here.
+type Content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// TextResult returns a result with one text block.
+func TextResult(text string) *ToolResult {
+	return &ToolResult{Content: []Content{{Type: "text", Text: text}}}
+}
+
+// JSONResult returns structured content along with its JSON text, for
+// clients that only read text blocks.
+func JSONResult(v any) (*ToolResult, error) {
+	text, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &ToolResult{Content: []Content{{Type: "text", Text: string(text)}}, StructuredContent: v}, nil
+}
+
+// Server serves tools to one client.
+type Server struct {
+	name         string
+	version      string
+	instructions string
+	tools        []Tool
+
+	writeMu  sync.Mutex
+	out      io.Writer
+	mu       sync.Mutex
+	inFlight map[string]context.CancelFunc
+}
+
+// NewServer creates a server that identifies itself as name and version.
+// instructions are passed to the client to tell the model how to use it.
+func NewServer(name, version, instructions string, tools ...Tool) *Server {
+	return &Server{name: name, version: version, instructions: instructions, tools: tools, inFlight: map[string]context.CancelFunc{}}
+}
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// Serve reads requests from in until it is closed or ctx ends. Requests are
+// handled concurrently so a long tool call does not block pings or
+// cancellations; Serve waits for them before returning.
+func (s *Server) Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	s.out = out
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), maxMessageBytes)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var msg message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			s.reply(nil, nil, &rpcError{Code: codeParseError, Message: "invalid JSON"})
+			continue
+		}
+		if msg.JSONRPC != "2.0" || msg.Method == "" {
+			if msg.ID != nil && msg.Method == "" {
+				continue // a response to a request we never send
+			}
+			s.reply(msg.ID, nil, &rpcError{Code: codeInvalidRequest, Message: "invalid request"})
+			continue
+		}
+		if msg.ID == nil {
+			s.notification(msg)
+			continue
+		}
+		reqCtx, cancelReq := context.WithCancel(ctx)
+		s.track(msg.ID, cancelReq)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.untrack(msg.ID)
+			result, rpcErr := s.handle(reqCtx, msg)
+			s.reply(msg.ID, result, rpcErr)
+		}()
+	}
+	return scanner.Err()
+}
+
+func (s *Server) notification(msg message) {
+	if msg.Method != "notifications/cancelled" {
+		return // initialized and anything unknown need no action
+	}
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if json.Unmarshal(msg.Params, &params) == nil && params.RequestID != nil {
+		s.mu.Lock()
+		if cancel, ok := s.inFlight[string(params.RequestID)]; ok {
+			cancel()
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) track(id json.RawMessage, cancel context.CancelFunc) {
+	s.mu.Lock()
+	s.inFlight[string(id)] = cancel
+	s.mu.Unlock()
+}
+
+func (s *Server) untrack(id json.RawMessage) {
+	s.mu.Lock()
+	if cancel, ok := s.inFlight[string(id)]; ok {
+		cancel()
+		delete(s.inFlight, string(id))
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) handle(ctx context.Context, msg message) (any, *rpcError) {
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(msg.Params, &params)
+		version := LatestProtocolVersion
+		if slices.Contains(supportedProtocolVersions, params.ProtocolVersion) {
+			version = params.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
+			"serverInfo":      map[string]string{"name": s.name, "version": s.version},
+			"instructions":    s.instructions,
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": s.tools}, nil
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "invalid tool call"}
+		}
+		i := slices.IndexFunc(s.tools, func(t Tool) bool { return t.Name == params.Name })
+		if i < 0 {
+			return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool %q", params.Name)}
+		}
+		if len(params.Arguments) == 0 {
+			params.Arguments = json.RawMessage("{}")
+		}
+		result, err := s.tools[i].Handler(ctx, params.Arguments)
+		if err != nil {
+			result = TextResult(err.Error())
+			result.IsError = true
+		}
+		return result, nil
+	default:
+		return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not found", msg.Method)}
+	}
+}
+
+func (s *Server) reply(id json.RawMessage, result any, rpcErr *rpcError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	encoded, err := json.Marshal(response{JSONRPC: "2.0", ID: id, Result: result, Error: rpcErr})
+	if err != nil {
+		encoded, _ = json.Marshal(response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: codeInternalError, Message: "failed to encode result"}})
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, _ = s.out.Write(append(encoded, '\n'))
+}
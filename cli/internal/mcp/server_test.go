@@ -0,0 +1,154 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// session runs a server over pipes and exchanges messages with it.
+type session struct {
+	in    *io.PipeWriter
+	out   *bufio.Scanner
+	done  chan error
+	close func()
+}
+
+func startSession(t *testing.T, tools ...Tool) *session {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := &session{in: inW, out: bufio.NewScanner(outR), done: make(chan error, 1)}
+	go func() {
+		s.done <- NewServer("envo", "test", "use the tools", tools...).Serve(context.Background(), inR, outW)
+		_ = outW.Close()
+	}()
+	s.close = func() { _ = inW.Close() }
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *session) send(t *testing.T, msg string) {
+	t.Helper()
+	if _, err := io.WriteString(s.in, msg+"\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *session) receive(t *testing.T) map[string]any {
+	t.Helper()
+	if !s.out.Scan() {
+		t.Fatalf("no response: %v", s.out.Err())
+	}
+	var msg map[string]any
+	if err := json.Unmarshal(s.out.Bytes(), &msg); err != nil {
+		t.Fatalf("response %q: %v", s.out.Text(), err)
+	}
+	return msg
+}
+
+func echoTool() Tool {
+	return Tool{
+		Name:        "echo",
+		Description: "Echo the text argument",
+		InputSchema: map[string]any{"type": "object"},
+		Handler: func(_ context.Context, arguments json.RawMessage) (*ToolResult, error) {
+			var in struct{ Text string }
+			_ = json.Unmarshal(arguments, &in)
+			return TextResult(in.Text), nil
+		},
+	}
+}
+
+func TestServerHandshakeAndTools(t *testing.T) {
+	s := startSession(t, echoTool())
+
+	s.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	init := s.receive(t)
+	result := init["result"].(map[string]any)
+	if result["protocolVersion"] != "2025-03-26" || result["serverInfo"].(map[string]any)["name"] != "envo" {
+		t.Fatalf("initialize = %v", init)
+	}
+	s.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	s.send(t, `{"jsonrpc":"2.0","id":"list","method":"tools/list"}`)
+	list := s.receive(t)
+	tools := list["result"].(map[string]any)["tools"].([]any)
+	if list["id"] != "list" || len(tools) != 1 || tools[0].(map[string]any)["name"] != "echo" {
+		t.Fatalf("tools/list = %v", list)
+	}
+
+	s.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`)
+	call := s.receive(t)
+	content := call["result"].(map[string]any)["content"].([]any)
+	if content[0].(map[string]any)["text"] != "hi" {
+		t.Fatalf("tools/call = %v", call)
+	}
+
+	s.send(t, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"missing"}}`)
+	if e := s.receive(t)["error"].(map[string]any); e["code"] != float64(codeInvalidParams) {
+		t.Fatalf("unknown tool error = %v", e)
+	}
+	s.send(t, `{"jsonrpc":"2.0","id":4,"method":"resources/list"}`)
+	if e := s.receive(t)["error"].(map[string]any); e["code"] != float64(codeMethodNotFound) {
+		t.Fatalf("unknown method error = %v", e)
+	}
+	s.send(t, `not json`)
+	if e := s.receive(t)["error"].(map[string]any); e["code"] != float64(codeParseError) {
+		t.Fatalf("parse error = %v", e)
+	}
+}
+
+func TestServerOffersLatestVersionForUnknownVersions(t *testing.T) {
+	s := startSession(t)
+	s.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`)
+	if got := s.receive(t)["result"].(map[string]any)["protocolVersion"]; got != LatestProtocolVersion {
+		t.Fatalf("protocolVersion = %v", got)
+	}
+}
+
+func TestServerReportsToolFailuresAsResults(t *testing.T) {
+	failing := Tool{Name: "fail", InputSchema: map[string]any{"type": "object"}, Handler: func(context.Context, json.RawMessage) (*ToolResult, error) {
+		return nil, io.ErrUnexpectedEOF
+	}}
+	s := startSession(t, failing)
+	s.send(t, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"fail"}}`)
+	result := s.receive(t)["result"].(map[string]any)
+	if result["isError"] != true || !strings.Contains(result["content"].([]any)[0].(map[string]any)["text"].(string), "unexpected EOF") {
+		t.Fatalf("result = %v", result)
+	}
+}
+
+func TestServerCancelsInFlightCalls(t *testing.T) {
+	started := make(chan struct{})
+	slow := Tool{Name: "slow", InputSchema: map[string]any{"type": "object"}, Handler: func(ctx context.Context, _ json.RawMessage) (*ToolResult, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return TextResult("finished"), nil
+		}
+	}}
+	s := startSession(t, slow)
+	s.send(t, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"slow"}}`)
+	<-started
+	// A ping is answered while the call is still running.
+	s.send(t, `{"jsonrpc":"2.0","id":8,"method":"ping"}`)
+	if got := s.receive(t); got["id"] != float64(8) {
+		t.Fatalf("expected the ping answer first, got %v", got)
+	}
+	s.send(t, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7}}`)
+	result := s.receive(t)
+	if result["id"] != float64(7) || result["result"].(map[string]any)["isError"] != true {
+		t.Fatalf("cancelled call = %v", result)
+	}
+	s.close()
+	if err := <-s.done; err != nil {
+		t.Fatal(err)
+	}
+}